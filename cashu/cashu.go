@@ -0,0 +1,166 @@
+package cashu
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// HEADER carries the Cashu token paying for a request, as in NUT-24
+	HEADER = "X-Cashu"
+
+	TOKEN_V3_PREFIX = "cashuA"
+	TOKEN_V4_PREFIX = "cashuB"
+	UNIT_SAT        = "sat"
+
+	MELT_STATE_PAID = "PAID"
+)
+
+// Proof is a single ecash note of a mint
+type Proof struct {
+	Amount int64  `json:"amount"`
+	Id     string `json:"id"`
+	Secret string `json:"secret"`
+	C      string `json:"C"`
+}
+
+type TokenEntry struct {
+	Mint   string  `json:"mint"`
+	Proofs []Proof `json:"proofs"`
+}
+
+// Token is a V3 Cashu token
+type Token struct {
+	Token []TokenEntry `json:"token"`
+	Unit  string       `json:"unit,omitempty"`
+	Memo  string       `json:"memo,omitempty"`
+}
+
+// DecodeToken decodes a cashuA token. cashuB tokens are CBOR encoded and
+// not supported, wallets can export V3 tokens instead.
+func DecodeToken(token string) (*Token, error) {
+	token = strings.TrimSpace(token)
+	if strings.HasPrefix(token, TOKEN_V4_PREFIX) {
+		return nil, fmt.Errorf("Cashu V4 tokens are not supported, send a %s token", TOKEN_V3_PREFIX)
+	}
+	if !strings.HasPrefix(token, TOKEN_V3_PREFIX) {
+		return nil, fmt.Errorf("Not a Cashu token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimPrefix(token, TOKEN_V3_PREFIX), "="))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Cashu token: %s", err.Error())
+	}
+	decoded := &Token{}
+	if err := json.Unmarshal(payload, decoded); err != nil {
+		return nil, fmt.Errorf("Invalid Cashu token: %s", err.Error())
+	}
+	if len(decoded.Token) == 0 {
+		return nil, fmt.Errorf("Cashu token has no proofs")
+	}
+	return decoded, nil
+}
+
+// Amount returns the sum of the proofs of the token.
+func (token *Token) Amount() int64 {
+	amount := int64(0)
+	for _, entry := range token.Token {
+		for _, proof := range entry.Proofs {
+			amount += proof.Amount
+		}
+	}
+	return amount
+}
+
+// Mint returns the mint of the token, tokens spanning several mints can't
+// be melted at once.
+func (token *Token) Mint() (string, error) {
+	mint := strings.TrimSuffix(token.Token[0].Mint, "/")
+	for _, entry := range token.Token[1:] {
+		if strings.TrimSuffix(entry.Mint, "/") != mint {
+			return "", fmt.Errorf("Cashu token spans several mints")
+		}
+	}
+	return mint, nil
+}
+
+// Proofs returns the proofs of all entries of the token.
+func (token *Token) Proofs() []Proof {
+	proofs := []Proof{}
+	for _, entry := range token.Token {
+		proofs = append(proofs, entry.Proofs...)
+	}
+	return proofs
+}
+
+type MeltQuote struct {
+	Quote      string `json:"quote"`
+	Amount     int64  `json:"amount"`
+	FeeReserve int64  `json:"fee_reserve"`
+	State      string `json:"state"`
+}
+
+type MeltResponse struct {
+	State           string `json:"state"`
+	PaymentPreimage string `json:"payment_preimage"`
+}
+
+// Mint talks to the NUT-05 melt endpoints of a mint, which pays a
+// lightning invoice with ecash
+type Mint struct {
+	URL    string
+	Client *http.Client
+}
+
+// MeltQuote asks the mint for the amount and fee reserve of paying invoice.
+func (mint *Mint) MeltQuote(ctx context.Context, invoice string) (*MeltQuote, error) {
+	quote := &MeltQuote{}
+	return quote, mint.post(ctx, "/v1/melt/quote/bolt11", map[string]string{
+		"request": invoice,
+		"unit":    UNIT_SAT,
+	}, quote)
+}
+
+// Melt pays the invoice of quote with proofs, the proofs are spent once the
+// mint accepted them.
+func (mint *Mint) Melt(ctx context.Context, quote string, proofs []Proof) (*MeltResponse, error) {
+	res := &MeltResponse{}
+	return res, mint.post(ctx, "/v1/melt/bolt11", map[string]interface{}{
+		"quote":  quote,
+		"inputs": proofs,
+	}, res)
+}
+
+func (mint *Mint) post(ctx context.Context, path string, reqBody interface{}, resBody interface{}) error {
+	payload, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(mint.URL, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := mint.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Cashu mint %s returned %d: %s", path, res.StatusCode, string(resBytes))
+	}
+	return json.Unmarshal(resBytes, resBody)
+}
@@ -0,0 +1,35 @@
+package cashu
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeToken(t *testing.T) {
+	payload := `{"token":[{"mint":"https://mint.example.com/","proofs":[{"amount":2,"id":"a","secret":"s1","C":"c1"},{"amount":8,"id":"a","secret":"s2","C":"c2"}]}],"unit":"sat"}`
+	token, err := DecodeToken(TOKEN_V3_PREFIX + base64.RawURLEncoding.EncodeToString([]byte(payload)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), token.Amount())
+	assert.Len(t, token.Proofs(), 2)
+	mint, err := token.Mint()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://mint.example.com", mint)
+
+	// Padded tokens are accepted
+	token, err = DecodeToken(TOKEN_V3_PREFIX + base64.URLEncoding.EncodeToString([]byte(payload)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), token.Amount())
+
+	token.Token = append(token.Token, TokenEntry{Mint: "https://other.example.com"})
+	_, err = token.Mint()
+	assert.EqualError(t, err, "Cashu token spans several mints")
+
+	_, err = DecodeToken(TOKEN_V4_PREFIX + "o2F0")
+	assert.EqualError(t, err, "Cashu V4 tokens are not supported, send a cashuA token")
+	_, err = DecodeToken("not a token")
+	assert.EqualError(t, err, "Not a Cashu token")
+	_, err = DecodeToken(TOKEN_V3_PREFIX + base64.RawURLEncoding.EncodeToString([]byte(`{"token":[]}`)))
+	assert.EqualError(t, err, "Cashu token has no proofs")
+}
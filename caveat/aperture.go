@@ -0,0 +1,202 @@
+package caveat
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Caveats in the format used by Aperture, so tokens can be verified by other
+// LSAT services: services=loop:0,pool:0, loop_capabilities=loop_out,loop_in
+// and loop_valid_until=<unix timestamp>.
+const (
+	SERVICES                   = "services"
+	CAPABILITIES_SUFFIX        = "_capabilities"
+	VALID_UNTIL_SUFFIX         = "_valid_until"
+	BASE_TIER           uint32 = 0
+)
+
+// Service is a service a token grants access to at a tier
+type Service struct {
+	Name string
+	Tier uint32
+}
+
+func (service Service) String() string {
+	return fmt.Sprintf("%s:%d", service.Name, service.Tier)
+}
+
+func NewServices(services ...Service) Caveat {
+	values := []string{}
+	for _, service := range services {
+		values = append(values, service.String())
+	}
+	return New(SERVICES, strings.Join(values, ","))
+}
+
+func ParseServices(value string) ([]Service, error) {
+	services := []Service{}
+	for _, serviceString := range strings.Split(value, ",") {
+		splitted := strings.SplitN(serviceString, ":", 2)
+		if len(splitted) != 2 || splitted[0] == "" {
+			return nil, fmt.Errorf("Invalid %s caveat: %s", SERVICES, value)
+		}
+		tier, err := strconv.ParseUint(splitted[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s caveat: %s", SERVICES, value)
+		}
+		services = append(services, Service{
+			Name: splitted[0],
+			Tier: uint32(tier),
+		})
+	}
+	return services, nil
+}
+
+func NewCapabilities(service string, capabilities ...string) Caveat {
+	return New(service+CAPABILITIES_SUFFIX, strings.Join(capabilities, ","))
+}
+
+func ParseCapabilities(value string) []string {
+	capabilities := []string{}
+	for _, capability := range strings.Split(value, ",") {
+		if capability = strings.TrimSpace(capability); capability != "" {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+func NewValidUntil(service string, validUntil time.Time) Caveat {
+	return New(service+VALID_UNTIL_SUFFIX, strconv.FormatInt(validUntil.Unix(), 10))
+}
+
+func (builder *Builder) Services(services ...Service) *Builder {
+	builder.caveats = append(builder.caveats, NewServices(services...))
+	return builder
+}
+
+func (builder *Builder) Capabilities(service string, capabilities ...string) *Builder {
+	builder.caveats = append(builder.caveats, NewCapabilities(service, capabilities...))
+	return builder
+}
+
+func (builder *Builder) ValidUntil(service string, validUntil time.Time) *Builder {
+	builder.caveats = append(builder.caveats, NewValidUntil(service, validUntil))
+	return builder
+}
+
+// Services returns the services granted by every services caveat of the
+// set at the lowest of their tiers, an attenuated token can only drop
+// services or lower their tier.
+func (set Set) Services() ([]Service, bool) {
+	var services []Service
+	found := false
+	for _, caveat := range set {
+		if caveat.Condition != SERVICES {
+			continue
+		}
+		parsed, err := ParseServices(caveat.Value)
+		if err != nil {
+			return nil, false
+		}
+		if !found {
+			services, found = parsed, true
+			continue
+		}
+		granted := []Service{}
+		for _, service := range services {
+			for _, narrowed := range parsed {
+				if narrowed.Name != service.Name {
+					continue
+				}
+				if narrowed.Tier < service.Tier {
+					service.Tier = narrowed.Tier
+				}
+				granted = append(granted, service)
+				break
+			}
+		}
+		services = granted
+	}
+	return services, found
+}
+
+// ServiceTier returns the tier of service granted by the services caveats,
+// see Services.
+func (set Set) ServiceTier(service string) (uint32, bool) {
+	services, ok := set.Services()
+	if !ok {
+		return 0, false
+	}
+	for _, s := range services {
+		if s.Name == service {
+			return s.Tier, true
+		}
+	}
+	return 0, false
+}
+
+// Capabilities returns the capabilities of service granted by every
+// capabilities caveat of the service.
+func (set Set) Capabilities(service string) ([]string, bool) {
+	return set.grantedCapabilities(service + CAPABILITIES_SUFFIX)
+}
+
+// ApertureService verifies the Aperture caveats of the service protected by
+// the middleware. Caveats of other services are rejected unless their
+// checkers are added as well.
+type ApertureService struct {
+	Name string
+	// CapabilityFunc returns the capability a request requires, no capability
+	// is required when it is nil or returns ""
+	CapabilityFunc func(req *http.Request) string
+	// MinTierFunc returns the lowest tier of the service a request is
+	// served for, any tier is accepted when it is nil
+	MinTierFunc func(req *http.Request) uint32
+}
+
+func (apertureService *ApertureService) Checkers() map[string]Checker {
+	return map[string]Checker{
+		SERVICES: apertureService.checkServices,
+		apertureService.Name + CAPABILITIES_SUFFIX: apertureService.checkCapabilities,
+		apertureService.Name + VALID_UNTIL_SUFFIX:  CheckExpiresAt,
+	}
+}
+
+func (apertureService *ApertureService) checkServices(req *http.Request, value string) error {
+	services, err := ParseServices(value)
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		if service.Name != apertureService.Name {
+			continue
+		}
+		if apertureService.MinTierFunc != nil {
+			if minTier := apertureService.MinTierFunc(req); service.Tier < minTier {
+				return fmt.Errorf("Token is valid for tier %d of service %s, tier %d is required", service.Tier, apertureService.Name, minTier)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Token is not valid for service %s", apertureService.Name)
+}
+
+func (apertureService *ApertureService) checkCapabilities(req *http.Request, value string) error {
+	if apertureService.CapabilityFunc == nil {
+		return nil
+	}
+	required := apertureService.CapabilityFunc(req)
+	if required == "" {
+		return nil
+	}
+	for _, capability := range ParseCapabilities(value) {
+		if capability == required {
+			return nil
+		}
+	}
+	return fmt.Errorf("Token does not grant capability %s of service %s", required, apertureService.Name)
+}
@@ -0,0 +1,37 @@
+package caveat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttenuatedServicesOnlyNarrow(t *testing.T) {
+	set := Set{
+		NewServices(Service{Name: "weather", Tier: 1}, Service{Name: "maps", Tier: 0}),
+		// Appended by the holder
+		NewServices(Service{Name: "weather", Tier: 2}, Service{Name: "billing", Tier: 2}),
+	}
+	tier, ok := set.ServiceTier("weather")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), tier)
+	_, ok = set.ServiceTier("billing")
+	assert.False(t, ok)
+	_, ok = set.ServiceTier("maps")
+	assert.False(t, ok)
+
+	set = append(set, NewServices(Service{Name: "weather", Tier: 0}))
+	tier, ok = set.ServiceTier("weather")
+	assert.True(t, ok)
+	assert.Equal(t, BASE_TIER, tier)
+}
+
+func TestAttenuatedCapabilitiesOnlyNarrow(t *testing.T) {
+	set := Set{
+		NewCapabilities("weather", "forecast"),
+		NewCapabilities("weather", "forecast", "history"),
+	}
+	capabilities, ok := set.Capabilities("weather")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"forecast"}, capabilities)
+}
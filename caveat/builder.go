@@ -0,0 +1,330 @@
+package caveat
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EXPIRES_AT = "expires_at"
+	PATH       = "path"
+	TIER       = "tier"
+	MAX_USES   = "max_uses"
+	MEMBER     = "member"
+)
+
+// Builder builds the caveats of a token at mint time, e.g.
+// caveat.NewBuilder().Expiry(24*time.Hour).Path("/api/v1/*").Tier("pro").Build()
+type Builder struct {
+	caveats []Caveat
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		caveats: []Caveat{},
+	}
+}
+
+func (builder *Builder) Add(condition string, value string) *Builder {
+	builder.caveats = append(builder.caveats, New(condition, value))
+	return builder
+}
+
+// Expiry limits the token to ttl from now.
+func (builder *Builder) Expiry(ttl time.Duration) *Builder {
+	return builder.ExpiresAt(time.Now().Add(ttl))
+}
+
+func (builder *Builder) ExpiresAt(expiresAt time.Time) *Builder {
+	return builder.Add(EXPIRES_AT, strconv.FormatInt(expiresAt.Unix(), 10))
+}
+
+// Path limits the token to request paths matching pattern, a trailing /*
+// matches all paths below the prefix.
+func (builder *Builder) Path(pattern string) *Builder {
+	return builder.Add(PATH, pattern)
+}
+
+// Tier records the service tier the token was bought for, handlers can read
+// it from the verified caveats.
+func (builder *Builder) Tier(tier string) *Builder {
+	return builder.Add(TIER, tier)
+}
+
+func (builder *Builder) Member(member string) *Builder {
+	return builder.Add(MEMBER, member)
+}
+
+// IP binds the token to an address or CIDR network, e.g. 203.0.113.0/24.
+func (builder *Builder) IP(network string) *Builder {
+	return builder.Add(IP, network)
+}
+
+func (builder *Builder) Param(name string, value string) *Builder {
+	builder.caveats = append(builder.caveats, NewParam(name, value))
+	return builder
+}
+
+// MaxUses limits the number of requests served with the token, enforced
+// with the payment store of the middleware.
+func (builder *Builder) MaxUses(maxUses int64) *Builder {
+	return builder.Add(MAX_USES, strconv.FormatInt(maxUses, 10))
+}
+
+// RequestsPerMinute limits the rate of requests served with the token,
+// enforced with the rate limit store of the middleware.
+func (builder *Builder) RequestsPerMinute(requestsPerMinute int64) *Builder {
+	return builder.Add(REQUESTS_PER_MINUTE, strconv.FormatInt(requestsPerMinute, 10))
+}
+
+// MaxResponseBytes caps the total response bytes served with the token,
+// e.g. for paid file or tile servers, metered by the middleware.
+func (builder *Builder) MaxResponseBytes(maxResponseBytes int64) *Builder {
+	return builder.Add(MAX_RESPONSE_BYTES, strconv.FormatInt(maxResponseBytes, 10))
+}
+
+// MaxConnections limits the simultaneous requests served with the token,
+// e.g. open SSE or WebSocket streams, tracked by the middleware.
+func (builder *Builder) MaxConnections(maxConnections int64) *Builder {
+	return builder.Add(MAX_CONNECTIONS, strconv.FormatInt(maxConnections, 10))
+}
+
+// RouteCapabilities limits the token to routes requiring only capabilities,
+// e.g. RouteCapabilities("read", "write").
+func (builder *Builder) RouteCapabilities(capabilities ...string) *Builder {
+	return builder.Add(CAPABILITIES, strings.Join(capabilities, ","))
+}
+
+func (builder *Builder) MaxBodyBytes(maxBodyBytes int64) *Builder {
+	return builder.Add(MAX_BODY_BYTES, strconv.FormatInt(maxBodyBytes, 10))
+}
+
+func (builder *Builder) Resource(resourceId string) *Builder {
+	return builder.Add(RESOURCE, resourceId)
+}
+
+func (builder *Builder) User(userId string) *Builder {
+	return builder.Add(USER, userId)
+}
+
+func (builder *Builder) Build() []Caveat {
+	caveats := make([]Caveat, len(builder.caveats))
+	copy(caveats, builder.caveats)
+	return caveats
+}
+
+// Set holds the caveats of a verified token
+type Set []Caveat
+
+func ParseSet(caveatStrings []string) (Set, error) {
+	set := Set{}
+	for _, caveatString := range caveatStrings {
+		caveat, err := Parse(caveatString)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, caveat)
+	}
+	return set, nil
+}
+
+// Get returns the value of the first caveat with condition, the one the
+// token was minted with. Caveats added when attenuating are verified like
+// the minted ones but can't change the value granted to the token, e.g. an
+// appended tier=pro doesn't upgrade a basic token.
+func (set Set) Get(condition string) (string, bool) {
+	for _, caveat := range set {
+		if caveat.Condition == condition {
+			return caveat.Value, true
+		}
+	}
+	return "", false
+}
+
+// ExpiresAt returns the earliest expires_at caveat of the set, an
+// attenuated token can only expire sooner.
+func (set Set) ExpiresAt() (time.Time, bool) {
+	var expiresAt time.Time
+	found := false
+	for _, caveat := range set {
+		if caveat.Condition != EXPIRES_AT {
+			continue
+		}
+		parsed, err := ParseExpiresAt(caveat.Value)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if !found || parsed.Before(expiresAt) {
+			expiresAt, found = parsed, true
+		}
+	}
+	return expiresAt, found
+}
+
+func (set Set) Path() (string, bool) {
+	return set.Get(PATH)
+}
+
+// Tier returns the tier the token was minted with. Mint every token of a
+// tiered API with a tier caveat, a holder can add one to a token without.
+func (set Set) Tier() (string, bool) {
+	return set.Get(TIER)
+}
+
+func (set Set) Member() (string, bool) {
+	return set.Get(MEMBER)
+}
+
+// MaxUses returns the lowest max_uses caveat of the set.
+func (set Set) MaxUses() (int64, bool) {
+	return set.lowest(MAX_USES)
+}
+
+// RequestsPerMinute returns the lowest requests_per_minute caveat of the
+// set.
+func (set Set) RequestsPerMinute() (int64, bool) {
+	return set.lowest(REQUESTS_PER_MINUTE)
+}
+
+// MaxResponseBytes returns the lowest max_response_bytes caveat of the set.
+func (set Set) MaxResponseBytes() (int64, bool) {
+	return set.lowest(MAX_RESPONSE_BYTES)
+}
+
+// MaxConnections returns the lowest max_connections caveat of the set.
+func (set Set) MaxConnections() (int64, bool) {
+	return set.lowest(MAX_CONNECTIONS)
+}
+
+// RouteCapabilities returns the capabilities granted by every capabilities
+// caveat of the set, an attenuated token only keeps those of all its
+// caveats.
+func (set Set) RouteCapabilities() ([]string, bool) {
+	return set.grantedCapabilities(CAPABILITIES)
+}
+
+// grantedCapabilities returns the capabilities listed by every caveat with
+// condition.
+func (set Set) grantedCapabilities(condition string) ([]string, bool) {
+	var capabilities []string
+	found := false
+	for _, caveat := range set {
+		if caveat.Condition != condition {
+			continue
+		}
+		parsed := ParseCapabilities(caveat.Value)
+		if !found {
+			capabilities, found = parsed, true
+			continue
+		}
+		granted := []string{}
+		for _, capability := range capabilities {
+			if containsCapability(parsed, capability) {
+				granted = append(granted, capability)
+			}
+		}
+		capabilities = granted
+	}
+	return capabilities, found
+}
+
+// lowest returns the lowest integer value of the caveats with condition,
+// the most restrictive one when a token was attenuated.
+func (set Set) lowest(condition string) (int64, bool) {
+	var lowest int64
+	found := false
+	for _, caveat := range set {
+		if caveat.Condition != condition {
+			continue
+		}
+		value, err := strconv.ParseInt(caveat.Value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if !found || value < lowest {
+			lowest = value
+			found = true
+		}
+	}
+	return lowest, found
+}
+
+func ParseExpiresAt(value string) (time.Time, error) {
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s caveat: %s", EXPIRES_AT, value)
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// CheckExpiresAt rejects tokens that have expired.
+func CheckExpiresAt(req *http.Request, value string) error {
+	expiresAt, err := ParseExpiresAt(value)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(expiresAt) {
+		return fmt.Errorf("Token expired at %s", expiresAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CheckMaxUses only validates the caveat, the uses are counted by the
+// middleware.
+func CheckMaxUses(req *http.Request, value string) error {
+	maxUses, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxUses < 0 {
+		return fmt.Errorf("Invalid %s caveat: %s", MAX_USES, value)
+	}
+	return nil
+}
+
+// CheckPath rejects requests outside of the path the token was bought for.
+func CheckPath(req *http.Request, value string) error {
+	if !MatchPath(value, req.URL.Path) {
+		return fmt.Errorf("Token is not valid for path %s", req.URL.Path)
+	}
+	return nil
+}
+
+// CheckTier accepts any tier, tiers are enforced by the handlers with
+// Set.Tier.
+func CheckTier(req *http.Request, value string) error {
+	return nil
+}
+
+// CheckMember accepts any member, it identifies the team member a sub-token
+// was delegated to.
+func CheckMember(req *http.Request, value string) error {
+	return nil
+}
+
+// RoutePattern returns the path pattern matching the paths of a gin route,
+// e.g. /articles/* for /articles/:id and /files/* for /files/*filepath.
+func RoutePattern(route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "*"
+		}
+		// A catch-all param matches everything below
+		if strings.HasPrefix(segment, "*") {
+			segments = append(segments[:i], "*")
+			break
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func MatchPath(pattern string, requestPath string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		prefix := strings.TrimSuffix(pattern, "*")
+		return strings.HasPrefix(requestPath, prefix) || requestPath == strings.TrimSuffix(prefix, "/")
+	}
+	matched, err := path.Match(pattern, requestPath)
+	return err == nil && matched
+}
@@ -0,0 +1,26 @@
+package caveat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetReportsMintedTier(t *testing.T) {
+	set := Set{New(TIER, "basic"), New(TIER, "pro")}
+	tier, ok := set.Tier()
+	assert.True(t, ok)
+	assert.Equal(t, "basic", tier)
+}
+
+func TestSetReportsEarliestExpiry(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	set := Set{}
+	for _, expiresAt := range []time.Time{now.Add(time.Hour), now.Add(time.Minute), now.Add(24 * time.Hour)} {
+		set = append(set, NewBuilder().ExpiresAt(expiresAt).Build()...)
+	}
+	expiresAt, ok := set.ExpiresAt()
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Minute).Unix(), expiresAt.Unix())
+}
@@ -0,0 +1,290 @@
+package caveat
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kiwiidb/gin-lsat/utils"
+)
+
+const (
+	MAX_BODY_BYTES = "max_body_bytes"
+	PARAM          = "param"
+	USER           = "user"
+	CLIENT_CERT    = "client_cert"
+	RESOURCE       = "resource"
+	// IP is the address or CIDR network a token can be used from
+	IP = "ip"
+	// PREIMAGE carries the preimage of tokens sent in the Macaroon headers
+	// of Aperture
+	PREIMAGE = "preimage"
+	// OFFER_ID is the BOLT12 offer a token can be paid with instead of the
+	// invoice of its payment hash
+	OFFER_ID = "offer_id"
+	// ONCHAIN_ADDRESS is the on-chain address a token can be paid to instead
+	// of its invoice
+	ONCHAIN_ADDRESS = "onchain_address"
+	// REQUESTS_PER_MINUTE limits the rate of requests served with a token
+	REQUESTS_PER_MINUTE = "requests_per_minute"
+	// MAX_RESPONSE_BYTES caps the response bytes served with a token
+	MAX_RESPONSE_BYTES = "max_response_bytes"
+	// MAX_CONNECTIONS limits the simultaneous requests of a token, e.g. SSE
+	// or WebSocket streams
+	MAX_CONNECTIONS = "max_connections"
+	// CAPABILITIES lists the comma separated capabilities a token was
+	// bought with, e.g. capabilities=read,write
+	CAPABILITIES = "capabilities"
+)
+
+// Caveat is a first-party caveat of the form condition=value
+type Caveat struct {
+	Condition string
+	Value     string
+}
+
+func New(condition string, value string) Caveat {
+	return Caveat{
+		Condition: condition,
+		Value:     value,
+	}
+}
+
+func (caveat Caveat) String() string {
+	return fmt.Sprintf("%s=%s", caveat.Condition, caveat.Value)
+}
+
+func Parse(caveatString string) (Caveat, error) {
+	splitted := strings.SplitN(caveatString, "=", 2)
+	if len(splitted) != 2 {
+		return Caveat{}, fmt.Errorf("Caveat does not have the right format: %s", caveatString)
+	}
+	condition := strings.TrimSpace(splitted[0])
+	value := strings.TrimSpace(splitted[1])
+	if condition == "" {
+		return Caveat{}, fmt.Errorf("Caveat condition is empty: %s", caveatString)
+	}
+	return New(condition, value), nil
+}
+
+// Checker verifies the value of a caveat against the incoming request
+type Checker func(req *http.Request, value string) error
+
+func BuiltinCheckers() map[string]Checker {
+	return map[string]Checker{
+		MAX_BODY_BYTES:      CheckMaxBodyBytes,
+		PARAM:               CheckParam,
+		USER:                CheckUser,
+		CLIENT_CERT:         CheckClientCert,
+		RESOURCE:            CheckResource,
+		EXPIRES_AT:          CheckExpiresAt,
+		PATH:                CheckPath,
+		TIER:                CheckTier,
+		MAX_USES:            CheckMaxUses,
+		MEMBER:              CheckMember,
+		OFFER_ID:            CheckOfferId,
+		ONCHAIN_ADDRESS:     CheckOnchainAddress,
+		IP:                  CheckIP,
+		PREIMAGE:            CheckPreimage,
+		REQUESTS_PER_MINUTE: CheckRequestsPerMinute,
+		MAX_RESPONSE_BYTES:  CheckMaxResponseBytes,
+		MAX_CONNECTIONS:     CheckMaxConnections,
+		CAPABILITIES:        CheckCapabilities,
+	}
+}
+
+// Check returns a function verifying caveats against req, using checkers
+// before falling back to the builtin checkers. Unknown caveats are rejected.
+func Check(req *http.Request, checkers map[string]Checker) func(caveatString string) error {
+	builtinCheckers := BuiltinCheckers()
+	return func(caveatString string) error {
+		caveat, err := Parse(caveatString)
+		if err != nil {
+			return err
+		}
+		checker, ok := checkers[caveat.Condition]
+		if !ok {
+			checker, ok = builtinCheckers[caveat.Condition]
+		}
+		if !ok {
+			return fmt.Errorf("Caveat condition not recognized: %s", caveat.Condition)
+		}
+		return checker(req, caveat.Value)
+	}
+}
+
+// CheckMaxBodyBytes rejects requests with a body larger than value. Bodies of
+// unknown length are capped while they are read.
+func CheckMaxBodyBytes(req *http.Request, value string) error {
+	maxBodyBytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid %s caveat: %s", MAX_BODY_BYTES, value)
+	}
+	if req.ContentLength > maxBodyBytes {
+		return fmt.Errorf("Request body of %d bytes exceeds the %d bytes paid for", req.ContentLength, maxBodyBytes)
+	}
+	if req.ContentLength < 0 && req.Body != nil {
+		req.Body = http.MaxBytesReader(nil, req.Body, maxBodyBytes)
+	}
+	return nil
+}
+
+// NewParam returns a caveat binding the token to a query or route param value.
+func NewParam(name string, value string) Caveat {
+	return New(PARAM, fmt.Sprintf("%s:%s", name, value))
+}
+
+// CheckParam rejects requests where the query or route param differs from
+// the value that was paid for.
+func CheckParam(req *http.Request, value string) error {
+	splitted := strings.SplitN(value, ":", 2)
+	if len(splitted) != 2 {
+		return fmt.Errorf("Invalid %s caveat: %s", PARAM, value)
+	}
+	name, paidValue := splitted[0], splitted[1]
+	if requestValue := utils.GetRequestParam(req, name); requestValue != paidValue {
+		return fmt.Errorf("Param %s=%s does not match the paid for value %s", name, requestValue, paidValue)
+	}
+	return nil
+}
+
+// CheckUser rejects requests of another user than the one the token was
+// bought by.
+func CheckUser(req *http.Request, value string) error {
+	if userId := utils.GetUserId(req); userId != value {
+		return fmt.Errorf("Token is bound to another user than %s", userId)
+	}
+	return nil
+}
+
+// CheckClientCert rejects requests that are not authenticated with the mTLS
+// client certificate the token was bought with.
+func CheckClientCert(req *http.Request, value string) error {
+	if fingerprint := utils.GetClientCertFingerprint(req); fingerprint != value {
+		return fmt.Errorf("Token is bound to another client certificate")
+	}
+	return nil
+}
+
+// CheckIP rejects requests from outside the address or CIDR network the
+// token was bought from.
+func CheckIP(req *http.Request, value string) error {
+	clientIP := net.ParseIP(utils.GetClientIP(req))
+	if clientIP == nil {
+		return fmt.Errorf("Token is bound to an IP address, the client address is unknown")
+	}
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		if !network.Contains(clientIP) {
+			return fmt.Errorf("Token is bound to another network than %s", clientIP)
+		}
+		return nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return fmt.Errorf("Invalid %s caveat: %s", IP, value)
+	}
+	if !ip.Equal(clientIP) {
+		return fmt.Errorf("Token is bound to another IP address than %s", clientIP)
+	}
+	return nil
+}
+
+// IPNetwork returns the CIDR network of ip with prefix length bits, or ip
+// itself when bits covers the whole address.
+func IPNetwork(ip net.IP, bits int) string {
+	size := net.IPv6len * 8
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip, size = ipv4, net.IPv4len*8
+	}
+	if bits <= 0 || bits >= size {
+		return ip.String()
+	}
+	network := &net.IPNet{
+		IP:   ip.Mask(net.CIDRMask(bits, size)),
+		Mask: net.CIDRMask(bits, size),
+	}
+	return network.String()
+}
+
+// CheckPreimage accepts every request, the preimage is verified against the
+// payment hash of the token.
+func CheckPreimage(req *http.Request, value string) error {
+	return nil
+}
+
+// CheckOfferId accepts every request, the offer payment is verified by the
+// middleware against the offer recorded with the payment, not against this
+// caveat.
+func CheckOfferId(req *http.Request, value string) error {
+	return nil
+}
+
+// CheckOnchainAddress accepts every request, the on-chain payment is
+// verified by the middleware against the address recorded with the payment,
+// not against this caveat.
+func CheckOnchainAddress(req *http.Request, value string) error {
+	return nil
+}
+
+// CheckResource rejects requests for another resource than the one the
+// token was bought for.
+func CheckResource(req *http.Request, value string) error {
+	if resourceId := utils.GetResourceId(req); resourceId != value {
+		return fmt.Errorf("Token is not valid for resource %s", resourceId)
+	}
+	return nil
+}
+
+// CheckRequestsPerMinute only validates the caveat, the requests are
+// counted by the middleware.
+func CheckRequestsPerMinute(req *http.Request, value string) error {
+	requestsPerMinute, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || requestsPerMinute <= 0 {
+		return fmt.Errorf("Invalid %s caveat: %s", REQUESTS_PER_MINUTE, value)
+	}
+	return nil
+}
+
+// CheckMaxResponseBytes only validates the caveat, the response bytes are
+// metered by the middleware.
+func CheckMaxResponseBytes(req *http.Request, value string) error {
+	maxResponseBytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxResponseBytes < 0 {
+		return fmt.Errorf("Invalid %s caveat: %s", MAX_RESPONSE_BYTES, value)
+	}
+	return nil
+}
+
+// CheckMaxConnections only validates the caveat, the connections are
+// tracked by the middleware.
+func CheckMaxConnections(req *http.Request, value string) error {
+	maxConnections, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxConnections <= 0 {
+		return fmt.Errorf("Invalid %s caveat: %s", MAX_CONNECTIONS, value)
+	}
+	return nil
+}
+
+// CheckCapabilities rejects requests needing a capability the token was not
+// bought with, see utils.WithRequiredCapabilities. The value is parsed like
+// the Aperture capabilities caveats.
+func CheckCapabilities(req *http.Request, value string) error {
+	capabilities := ParseCapabilities(value)
+	for _, required := range utils.GetRequiredCapabilities(req) {
+		if !containsCapability(capabilities, required) {
+			return fmt.Errorf("Token lacks the %s capability", required)
+		}
+	}
+	return nil
+}
+
+func containsCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package challenge
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/macaroon.v2"
+)
+
+const (
+	// Media type agents negotiate through the Accept header
+	MEDIA_TYPE = "application/vnd.lsat.challenge.v1+json"
+	VERSION    = "1"
+)
+
+// Document is a stable, versioned challenge designed to be consumed by
+// autonomous agents and SDKs.
+type Document struct {
+	Version   string `json:"version"`
+	Scheme    string `json:"scheme"`
+	Price     Price  `json:"price"`
+	Invoice   string `json:"invoice"`
+	Macaroon  string `json:"macaroon"`
+	ExpiresAt string `json:"expires_at"`
+	Scope     Scope  `json:"scope"`
+	Retry     Retry  `json:"retry"`
+	// Server signature over the macaroon and invoice, see lsat.VerifyChallengeSignature
+	Signature string `json:"signature,omitempty"`
+}
+
+type Price struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Scope describes what the token unlocks once paid
+type Scope struct {
+	Method  string   `json:"method"`
+	Path    string   `json:"path"`
+	Caveats []string `json:"caveats"`
+}
+
+// Retry instructs the client how to present the paid token
+type Retry struct {
+	Header       string `json:"header"`
+	Format       string `json:"format"`
+	Instructions string `json:"instructions"`
+}
+
+func NewDocument(req *http.Request, scheme string, amount int64, mac *macaroon.Macaroon, macaroonString string, invoice string, expiry time.Duration) *Document {
+	caveats := []string{}
+	for _, caveat := range mac.Caveats() {
+		if caveat.Location == "" {
+			caveats = append(caveats, string(caveat.Id))
+		}
+	}
+	return &Document{
+		Version: VERSION,
+		Scheme:  scheme,
+		Price: Price{
+			Amount:   amount,
+			Currency: "sat",
+		},
+		Invoice:   invoice,
+		Macaroon:  macaroonString,
+		ExpiresAt: time.Now().Add(expiry).UTC().Format(time.RFC3339),
+		Scope: Scope{
+			Method:  req.Method,
+			Path:    req.URL.Path,
+			Caveats: caveats,
+		},
+		Retry: Retry{
+			Header:       "Authorization",
+			Format:       scheme + " <macaroon>:<preimage>",
+			Instructions: "Pay the invoice, then repeat the request with the macaroon and the hex encoded preimage of the payment in the Authorization header.",
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package concurrency
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLimitReached is returned by Acquire when all slots are taken
+var ErrLimitReached = errors.New("Concurrency limit reached")
+
+// Store tracks the open connections of tokens by key. Shared stores must
+// implement Acquire atomically and expire the slots of replicas that died
+// holding them, e.g. with a Redis sorted set of connection ids scored by
+// their last heartbeat.
+type Store interface {
+	// Acquire takes one of limit slots of key and returns the number of
+	// slots taken, ErrLimitReached when none is free
+	Acquire(key string, limit int64) (int64, error)
+	// Release frees a slot of key taken with Acquire
+	Release(key string) error
+}
+
+// MemoryStore tracks connections in process, limits are enforced per
+// replica
+type MemoryStore struct {
+	mu          sync.Mutex
+	connections map[string]int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		connections: map[string]int64{},
+	}
+}
+
+func (store *MemoryStore) Acquire(key string, limit int64) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.connections[key] >= limit {
+		return store.connections[key], ErrLimitReached
+	}
+	store.connections[key]++
+	return store.connections[key], nil
+}
+
+func (store *MemoryStore) Release(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.connections[key] <= 1 {
+		delete(store.connections, key)
+		return nil
+	}
+	store.connections[key]--
+	return nil
+}
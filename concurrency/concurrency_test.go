@@ -0,0 +1,24 @@
+package concurrency
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreSlots(t *testing.T) {
+	store := NewMemoryStore()
+	taken, err := store.Acquire("token", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), taken)
+	taken, err = store.Acquire("token", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), taken)
+	_, err = store.Acquire("token", 2)
+	assert.Equal(t, ErrLimitReached, err)
+
+	assert.NoError(t, store.Release("token"))
+	taken, err = store.Acquire("token", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), taken)
+}
@@ -0,0 +1,124 @@
+package envoy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kiwiidb/gin-lsat/ginlsat"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// AuthorizationServer implements the Envoy external authorization gRPC API.
+// Paid requests are allowed with an X-Lsat-Type header added upstream,
+// unpaid requests are denied with the 402 challenge.
+type AuthorizationServer struct {
+	Middleware *ginlsat.GinLsatMiddleware
+}
+
+func NewAuthorizationServer(lsatmiddleware *ginlsat.GinLsatMiddleware) *AuthorizationServer {
+	return &AuthorizationServer{
+		Middleware: lsatmiddleware,
+	}
+}
+
+// Register registers the authorization server on grpcServer.
+func (authorizationServer *AuthorizationServer) Register(grpcServer *grpc.Server) {
+	authv3.RegisterAuthorizationServer(grpcServer, authorizationServer)
+}
+
+func (authorizationServer *AuthorizationServer) Check(ctx context.Context, checkReq *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq, err := newHttpRequest(ctx, checkReq.GetAttributes().GetRequest().GetHttp())
+	if err != nil {
+		return deniedResponse(codes.InvalidArgument, http.StatusBadRequest, http.Header{}, nil), nil
+	}
+	authorization := authorizationServer.Middleware.Authorize(httpReq)
+	if !authorization.IsAuthorized() {
+		code := codes.PermissionDenied
+		if authorization.Status == http.StatusPaymentRequired {
+			code = codes.Unauthenticated
+		}
+		return deniedResponse(code, authorization.Status, authorization.Header, authorization.Body), nil
+	}
+	upstreamHeaders := []*corev3.HeaderValueOption{
+		headerValueOption(ginlsat.LSAT_TYPE_HEADER, authorization.LsatInfo.Type),
+	}
+	authorization.Header.Del(ginlsat.LSAT_TYPE_HEADER)
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{
+			Code: int32(codes.OK),
+		},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers:              upstreamHeaders,
+				ResponseHeadersToAdd: headerValueOptions(authorization.Header),
+			},
+		},
+	}, nil
+}
+
+func deniedResponse(code codes.Code, status int, header http.Header, body []byte) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{
+			Code: int32(code),
+		},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{
+					Code: typev3.StatusCode(status),
+				},
+				Headers: headerValueOptions(header),
+				Body:    string(body),
+			},
+		},
+	}
+}
+
+func headerValueOption(key string, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header: &corev3.HeaderValue{
+			Key:   key,
+			Value: value,
+		},
+	}
+}
+
+func headerValueOptions(header http.Header) []*corev3.HeaderValueOption {
+	options := []*corev3.HeaderValueOption{}
+	for key := range header {
+		options = append(options, headerValueOption(key, header.Get(key)))
+	}
+	return options
+}
+
+// newHttpRequest rebuilds the request Envoy is authorizing from its
+// attributes, Envoy passes header names in lower case.
+func newHttpRequest(ctx context.Context, attributes *authv3.AttributeContext_HttpRequest) (*http.Request, error) {
+	requestUrl, err := url.ParseRequestURI(attributes.GetPath())
+	if err != nil {
+		return nil, err
+	}
+	requestUrl.Scheme = attributes.GetScheme()
+	requestUrl.Host = attributes.GetHost()
+	httpReq, err := http.NewRequestWithContext(ctx, attributes.GetMethod(), requestUrl.String(), strings.NewReader(attributes.GetBody()))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range attributes.GetHeaders() {
+		if strings.HasPrefix(key, ":") {
+			continue
+		}
+		httpReq.Header.Set(key, value)
+	}
+	httpReq.Host = attributes.GetHost()
+	httpReq.ContentLength = attributes.GetSize()
+	httpReq.RequestURI = attributes.GetPath()
+	return httpReq, nil
+}
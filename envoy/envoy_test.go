@@ -0,0 +1,142 @@
+package envoy
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/ginlsat"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/rootkey"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// fakeLNClient issues invoices and remembers their preimages
+type fakeLNClient struct {
+	mu        sync.Mutex
+	preimages map[lntypes.Hash]lntypes.Preimage
+}
+
+func (client *fakeLNClient) AddInvoice(ctx context.Context, lnReq *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	preimage := lntypes.Preimage{}
+	if _, err := rand.Read(preimage[:]); err != nil {
+		return nil, err
+	}
+	hash := preimage.Hash()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.preimages[hash] = preimage
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          hash[:],
+		PaymentRequest: "lnbcrt" + hash.String(),
+	}, nil
+}
+
+// newTestClient serves an AuthorizationServer over gRPC
+func newTestClient(t *testing.T, lnClient *fakeLNClient) authv3.AuthorizationClient {
+	lsatmiddleware := &ginlsat.GinLsatMiddleware{
+		AmountFunc: func(req *http.Request) int64 {
+			return 10
+		},
+		LNClient:        lnClient,
+		RootKeyProvider: rootkey.NewMemoryProvider([]byte("envoy-test-root-key")),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	NewAuthorizationServer(lsatmiddleware).Register(grpcServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return authv3.NewAuthorizationClient(conn)
+}
+
+func checkRequest(path string, headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:  http.MethodGet,
+					Scheme:  "https",
+					Host:    "api.example.com",
+					Path:    path,
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+func responseHeader(options []*corev3.HeaderValueOption, key string) string {
+	for _, option := range options {
+		if http.CanonicalHeaderKey(option.GetHeader().GetKey()) == http.CanonicalHeaderKey(key) {
+			return option.GetHeader().GetValue()
+		}
+	}
+	return ""
+}
+
+func TestCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lnClient := &fakeLNClient{
+		preimages: map[lntypes.Hash]lntypes.Preimage{},
+	}
+	client := newTestClient(t, lnClient)
+	ctx := context.Background()
+
+	// Unpaid requests are denied with the challenge
+	res, err := client.Check(ctx, checkRequest("/protected?page=1", map[string]string{
+		":authority": "api.example.com",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.Unauthenticated), res.GetStatus().GetCode())
+	denied := res.GetDeniedResponse()
+	assert.Equal(t, http.StatusPaymentRequired, int(denied.GetStatus().GetCode()))
+	lsatChallenge, err := lsat.ParseChallenge(responseHeader(denied.GetHeaders(), "WWW-Authenticate"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, lsatChallenge.Invoice)
+
+	// Paid requests are allowed with the token type for the upstream
+	token, err := lsatChallenge.Token()
+	assert.NoError(t, err)
+	lnClient.mu.Lock()
+	token.Preimage = lnClient.preimages[token.PaymentHash()]
+	lnClient.mu.Unlock()
+	authorization, err := token.MarshalHeader()
+	assert.NoError(t, err)
+	res, err = client.Check(ctx, checkRequest("/protected?page=1", map[string]string{
+		"authorization": authorization,
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), res.GetStatus().GetCode())
+	assert.Equal(t, ginlsat.LSAT_TYPE_PAID, responseHeader(res.GetOkResponse().GetHeaders(), ginlsat.LSAT_TYPE_HEADER))
+
+	// Tokens with a wrong preimage are denied
+	token.Preimage = lntypes.Preimage{1}
+	authorization, err = token.MarshalHeader()
+	assert.NoError(t, err)
+	res, err = client.Check(ctx, checkRequest("/protected", map[string]string{
+		"authorization": authorization,
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.PermissionDenied), res.GetStatus().GetCode())
+	assert.Equal(t, http.StatusForbidden, int(res.GetDeniedResponse().GetStatus().GetCode()))
+
+	res, err = client.Check(ctx, checkRequest("not a path", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.InvalidArgument), res.GetStatus().GetCode())
+}
@@ -62,11 +62,55 @@ func main() {
 	lnClientConfig := &ln.LNClientConfig{
 		LNClientType: os.Getenv("LN_CLIENT_TYPE"),
 		LNDConfig: ln.LNDoptions{
-			Address:     os.Getenv("LND_ADDRESS"),
-			MacaroonHex: os.Getenv("MACAROON_HEX"),
+			Address:       os.Getenv("LND_ADDRESS"),
+			MacaroonHex:   os.Getenv("MACAROON_HEX"),
+			LndconnectURI: os.Getenv("LNDCONNECT_URI"),
 		},
 		LNURLConfig: ln.LNURLoptions{
-			Address: os.Getenv("LNURL_ADDRESS"),
+			Address:  os.Getenv("LNURL_ADDRESS"),
+			ProxyUrl: os.Getenv("LNURL_PROXY_URL"),
+		},
+		CLNConfig: ln.CLNoptions{
+			Address: os.Getenv("CLN_ADDRESS"),
+			Rune:    os.Getenv("CLN_RUNE"),
+		},
+		LNbitsConfig: ln.LNbitsOptions{
+			Address:    os.Getenv("LNBITS_ADDRESS"),
+			InvoiceKey: os.Getenv("LNBITS_INVOICE_KEY"),
+		},
+		LNDhubConfig: ln.LNDhubOptions{
+			Address:  os.Getenv("LNDHUB_ADDRESS"),
+			Login:    os.Getenv("LNDHUB_LOGIN"),
+			Password: os.Getenv("LNDHUB_PASSWORD"),
+		},
+		BTCPayConfig: ln.BTCPayOptions{
+			Address: os.Getenv("BTCPAY_ADDRESS"),
+			StoreId: os.Getenv("BTCPAY_STORE_ID"),
+			APIKey:  os.Getenv("BTCPAY_API_KEY"),
+		},
+		EclairConfig: ln.EclairOptions{
+			Address:  os.Getenv("ECLAIR_ADDRESS"),
+			Password: os.Getenv("ECLAIR_PASSWORD"),
+		},
+		OpenNodeConfig: ln.OpenNodeOptions{
+			APIKey: os.Getenv("OPENNODE_API_KEY"),
+		},
+		ZebedeeConfig: ln.ZebedeeOptions{
+			APIKey: os.Getenv("ZEBEDEE_API_KEY"),
+		},
+		PhoenixdConfig: ln.PhoenixdOptions{
+			Address:  os.Getenv("PHOENIXD_ADDRESS"),
+			Password: os.Getenv("PHOENIXD_PASSWORD"),
+		},
+		LNPayConfig: ln.LNPayOptions{
+			APIKey:    os.Getenv("LNPAY_API_KEY"),
+			WalletKey: os.Getenv("LNPAY_WALLET_KEY"),
+		},
+		TapdConfig: ln.TapdOptions{
+			Address:     os.Getenv("TAPD_ADDRESS"),
+			MacaroonHex: os.Getenv("TAPD_MACAROON_HEX"),
+			CertHex:     os.Getenv("TAPD_CERT_HEX"),
+			PeerPubkey:  os.Getenv("TAPD_PEER_PUBKEY"),
 		},
 	}
 	fr := &FiatRateConfig{
@@ -0,0 +1,133 @@
+package ginlsat
+
+import (
+	"github.com/kiwiidb/gin-lsat/caveat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"gopkg.in/macaroon.v2"
+)
+
+// admission is what a verified token is entitled to for the current
+// request, taken by admit
+type admission struct {
+	paymentHash lntypes.Hash
+	// accountHash is the payment hash uses and limits are counted under
+	accountHash      lntypes.Hash
+	mac              *macaroon.Macaroon
+	verifiedCaveats  caveat.Set
+	quota            *Quota
+	maxResponseBytes int64
+	// releaseConnection releases the connection slot, nil for tokens
+	// without a max_connections caveat
+	releaseConnection func()
+}
+
+// admit applies the caveats shared by every payment method to a token
+// verified with verifiedCaveats and paid with paymentHash: revocation,
+// refunds, capabilities, rate limit, bandwidth, connections and uses. Throttled
+// requests don't consume uses of the token. The admission must be
+// released once the request is served.
+func (lsatmiddleware *GinLsatMiddleware) admit(c *gin.Context, mac *macaroon.Macaroon, paymentHash lntypes.Hash, verifiedCaveats caveat.Set) (*admission, error) {
+	if err := lsatmiddleware.checkRevoked(mac); err != nil {
+		return nil, err
+	}
+	if err := lsatmiddleware.checkRefunded(paymentHash); err != nil {
+		return nil, err
+	}
+	if err := checkCapabilities(c.Request, verifiedCaveats); err != nil {
+		return nil, err
+	}
+	accountHash := lsatmiddleware.accountHash(paymentHash)
+	if err := lsatmiddleware.checkRateLimit(c, accountHash, verifiedCaveats); err != nil {
+		return nil, err
+	}
+	maxResponseBytes, err := lsatmiddleware.checkBandwidth(c, accountHash, verifiedCaveats)
+	if err != nil {
+		return nil, err
+	}
+	releaseConnection, err := lsatmiddleware.acquireConnection(accountHash, verifiedCaveats)
+	if err != nil {
+		return nil, err
+	}
+	admitted := &admission{
+		paymentHash:       paymentHash,
+		accountHash:       accountHash,
+		mac:               mac,
+		verifiedCaveats:   verifiedCaveats,
+		maxResponseBytes:  maxResponseBytes,
+		releaseConnection: releaseConnection,
+	}
+	admitted.quota, err = lsatmiddleware.useQuota(paymentHash, accountHash, verifiedCaveats)
+	if err != nil {
+		admitted.release()
+		return nil, err
+	}
+	return admitted, nil
+}
+
+// accountHash returns the payment hash the uses and limits of the token paid
+// with paymentHash are counted under: that of the token it upgraded, so an
+// upgrade doesn't buy a second quota, or paymentHash itself.
+func (lsatmiddleware *GinLsatMiddleware) accountHash(paymentHash lntypes.Hash) lntypes.Hash {
+	if lsatmiddleware.Payments == nil {
+		return paymentHash
+	}
+	p, err := lsatmiddleware.Payments.Get(paymentHash)
+	if err != nil || p.UpgradedFrom == (lntypes.Hash{}) {
+		return paymentHash
+	}
+	return p.UpgradedFrom
+}
+
+// release gives back the connection slot of the admission.
+func (admitted *admission) release() {
+	if admitted.releaseConnection != nil {
+		admitted.releaseConnection()
+	}
+}
+
+// isHeld returns true when the request has to be served before the
+// middleware returns, to meter its response or hold its connection slot.
+func (admitted *admission) isHeld() bool {
+	return admitted.maxResponseBytes > 0 || admitted.releaseConnection != nil
+}
+
+// serveAdmitted records the use of the admitted token, sets lsatInfo and
+// serves the request, refunding it when it fails. Streams hold their
+// connection slot until the handler returns.
+func (lsatmiddleware *GinLsatMiddleware) serveAdmitted(c *gin.Context, admitted *admission, lsatInfo *LsatInfo) {
+	defer admitted.release()
+	// Limited tokens are counted by useQuota
+	if admitted.quota == nil {
+		if err := lsatmiddleware.recordPaymentUsage(admitted.paymentHash); err != nil {
+			c.Error(err)
+		}
+	}
+	if err := lsatmiddleware.recordPurchase(c.Request, admitted.mac, admitted.verifiedCaveats); err != nil {
+		c.Error(err)
+	}
+	lsatmiddleware.setStatusHeaders(c, admitted.verifiedCaveats)
+	setQuotaHeaders(c, admitted.quota)
+	lsatInfo.Caveats = admitted.verifiedCaveats
+	if lsatmiddleware.Payments != nil {
+		if p, err := lsatmiddleware.Payments.Get(admitted.paymentHash); err == nil {
+			lsatInfo.Amount, lsatInfo.AmountMsat = p.Amount, p.AmountMsat
+		}
+	}
+	c.Set("LSAT", lsatInfo)
+	lsatmiddleware.serveWithRefund(c, admitted.paymentHash)
+	if admitted.isHeld() {
+		lsatmiddleware.serveMetered(c, admitted)
+	}
+}
+
+// serveMetered runs the protected handler and adds the response bytes of
+// tokens with a max_response_bytes caveat to their consumption.
+func (lsatmiddleware *GinLsatMiddleware) serveMetered(c *gin.Context, admitted *admission) {
+	if admitted.maxResponseBytes > 0 {
+		lsatmiddleware.meterBandwidth(c, admitted.accountHash)
+		return
+	}
+	c.Next()
+}
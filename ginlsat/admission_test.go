@@ -0,0 +1,56 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+// paymentMethods pay a token of path with each payment method and return
+// the Authorization header presenting it
+var paymentMethods = map[string]func(t *testing.T, lsatmiddleware *GinLsatMiddleware, client *fakeLNClient, router http.Handler, path string) http.Header{
+	"invoice": func(t *testing.T, lsatmiddleware *GinLsatMiddleware, client *fakeLNClient, router http.Handler, path string) http.Header {
+		return authorization(t, paidToken(t, client, router, path))
+	},
+	"hold invoice": func(t *testing.T, lsatmiddleware *GinLsatMiddleware, client *fakeLNClient, router http.Handler, path string) http.Header {
+		lsatmiddleware.ChargePolicy = CHARGE_ON_SUCCESS
+		token := challengeToken(t, requestChallenge(t, router, path), lntypes.Preimage{})
+		client.accept(token.PaymentHash())
+		return macaroonAuthorization(t, token)
+	},
+	"AMP invoice": func(t *testing.T, lsatmiddleware *GinLsatMiddleware, client *fakeLNClient, router http.Handler, path string) http.Header {
+		lsatmiddleware.AMPBearer = true
+		token := challengeToken(t, requestChallenge(t, router, path), lntypes.Preimage{})
+		client.pay(token.PaymentHash())
+		return macaroonAuthorization(t, token)
+	},
+	"on-chain": func(t *testing.T, lsatmiddleware *GinLsatMiddleware, client *fakeLNClient, router http.Handler, path string) http.Header {
+		lsatmiddleware.Onchain = &OnchainConfig{
+			MinAmount: TEST_AMOUNT,
+		}
+		lsatChallenge := requestChallenge(t, router, path)
+		client.fund(lsatChallenge.Address, TEST_AMOUNT)
+		return macaroonAuthorization(t, challengeToken(t, lsatChallenge, lntypes.Preimage{}))
+	},
+}
+
+func TestCapabilitiesApplyToEveryPaymentMethod(t *testing.T) {
+	for name, pay := range paymentMethods {
+		t.Run(name, func(t *testing.T) {
+			client := newFakeLNClient()
+			lsatmiddleware := newTestMiddleware(client)
+			router := testRouter(lsatmiddleware, "/reports")
+			router.POST("/items", lsatmiddleware.RequireCapabilities("write"), lsatmiddleware.Handler, respondWithLsatInfo)
+
+			// Bought without capabilities on a route requiring none
+			header := pay(t, lsatmiddleware, client, router, "/reports")
+			response := decodeResponse(t, serve(router, http.MethodPost, "/items", header))
+			assert.NotEqual(t, LSAT_TYPE_PAID, response.Type)
+			assert.Equal(t, "Token lacks the write capability", response.Error)
+			response = decodeResponse(t, serve(router, http.MethodGet, "/reports", header))
+			assert.Equal(t, LSAT_TYPE_PAID, response.Type)
+		})
+	}
+}
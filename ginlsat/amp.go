@@ -0,0 +1,100 @@
+package ginlsat
+
+import (
+	"fmt"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/payment"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// HandleAmpInvoice serves a request presenting a macaroon without preimage
+// that was issued with an AMP invoice. The payer of an AMP invoice doesn't
+// learn a preimage of its payment hash, so the invoice is looked up on the
+// LN backend instead. Nothing binds the token to the payer, the macaroon
+// alone grants access, which is why AMP is opted in with AMPBearer. Only
+// macaroons minted for an AMP invoice are accepted, tokens of regular
+// invoices still need their preimage.
+func (lsatmiddleware *GinLsatMiddleware) HandleAmpInvoice(c *gin.Context, authField string) {
+	mac, err := utils.ParseLsatMacaroonHeader(authField)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	dischargeMacaroons, err := discharges(c.Request)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	verifiedCaveats := caveat.Set{}
+	macaroonId, err := lsat.VerifyMacaroonWithDischarges(mac, rootKey, dischargeMacaroons, lsatmiddleware.checkCaveats(c.Request, &verifiedCaveats))
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+
+	if err := lsatmiddleware.checkAmp(macaroonId.PaymentHash); err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+
+	ctx, cancel := lsatmiddleware.lnContext(c.Request.Context())
+	defer cancel()
+	_, LNClientConn, err := lsatmiddleware.paymentBackend(c.Request, macaroonId.PaymentHash)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	settled, err := LNClientConn.IsInvoiceSettled(ctx, macaroonId.PaymentHash)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	if !settled {
+		lsatmiddleware.setLsatError(c, fmt.Errorf("AMP invoice for PaymentHash %s is not paid", macaroonId.PaymentHash))
+		return
+	}
+
+	admitted, err := lsatmiddleware.admit(c, mac, macaroonId.PaymentHash, verifiedCaveats)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	lsatmiddleware.serveAdmitted(c, admitted, &LsatInfo{
+		Type: LSAT_TYPE_PAID,
+	})
+}
+
+// recordAmp records that the invoice of paymentHash is an AMP invoice.
+func (lsatmiddleware *GinLsatMiddleware) recordAmp(paymentHash lntypes.Hash) error {
+	if lsatmiddleware.Payments == nil {
+		return nil
+	}
+	_, err := payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		p.AMP = true
+		return nil
+	})
+	return err
+}
+
+// checkAmp returns an error unless the payment of paymentHash was recorded
+// as an AMP invoice when its token was minted.
+func (lsatmiddleware *GinLsatMiddleware) checkAmp(paymentHash lntypes.Hash) error {
+	if lsatmiddleware.Payments == nil {
+		return fmt.Errorf("AMP tokens require a payment store")
+	}
+	p, err := lsatmiddleware.Payments.Get(paymentHash)
+	if err != nil || !p.AMP {
+		return fmt.Errorf("Macaroon was not issued for an AMP invoice")
+	}
+	return nil
+}
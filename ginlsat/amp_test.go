@@ -0,0 +1,57 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/lsat"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+// macaroonAuthorization returns the Authorization header presenting the
+// macaroon of token without preimage
+func macaroonAuthorization(t *testing.T, token *lsat.Token) http.Header {
+	macaroonString, err := token.MacaroonString()
+	assert.NoError(t, err)
+	return http.Header{
+		"Authorization": {"LSAT " + macaroonString},
+	}
+}
+
+func TestAmpTokensAreOptIn(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	router := testRouter(lsatmiddleware, "/protected")
+
+	token := challengeToken(t, requestChallenge(t, router, "/protected"), lntypes.Preimage{})
+	client.pay(token.PaymentHash())
+	header := macaroonAuthorization(t, token)
+	res := serve(router, http.MethodGet, "/protected", header)
+	assert.NotEqual(t, LSAT_TYPE_PAID, tokenType(t, res))
+
+	lsatmiddleware.AMPBearer = true
+	token = challengeToken(t, requestChallenge(t, router, "/protected"), lntypes.Preimage{})
+	header = macaroonAuthorization(t, token)
+	res = serve(router, http.MethodGet, "/protected", header)
+	assert.NotEqual(t, LSAT_TYPE_PAID, tokenType(t, res))
+
+	client.pay(token.PaymentHash())
+	res = serve(router, http.MethodGet, "/protected", header)
+	assert.Equal(t, LSAT_TYPE_PAID, tokenType(t, res))
+}
+
+func TestAmpTokensRequireAmpInvoice(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	router := testRouter(lsatmiddleware, "/protected")
+
+	// Minted for a regular invoice before AMP was enabled
+	token := challengeToken(t, requestChallenge(t, router, "/protected"), lntypes.Preimage{})
+	client.pay(token.PaymentHash())
+	lsatmiddleware.AMPBearer = true
+	response := decodeResponse(t, serve(router, http.MethodGet, "/protected", macaroonAuthorization(t, token)))
+	assert.NotEqual(t, LSAT_TYPE_PAID, response.Type)
+	assert.Equal(t, "Macaroon was not issued for an AMP invoice", response.Error)
+}
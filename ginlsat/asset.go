@@ -0,0 +1,60 @@
+package ginlsat
+
+import (
+	"net/http"
+
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/payment"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// TAPROOT_ASSET_KEY holds the asset amount of the challenge in the gin
+// context
+const TAPROOT_ASSET_KEY = "LSAT_TAPROOT_ASSET"
+
+// asset returns the asset amount req is priced in, nil for sats.
+func (lsatmiddleware *GinLsatMiddleware) asset(req *http.Request) *ln.AssetAmount {
+	if lsatmiddleware.AssetFunc == nil {
+		return nil
+	}
+	asset := lsatmiddleware.AssetFunc(req)
+	if asset == nil || asset.AssetId == "" || asset.Amount == 0 {
+		return nil
+	}
+	return asset
+}
+
+// challengeAsset returns the asset amount of the challenge being written,
+// nil for sats.
+func challengeAsset(c *gin.Context) *ln.AssetAmount {
+	value, ok := c.Get(TAPROOT_ASSET_KEY)
+	if !ok {
+		return nil
+	}
+	return value.(*ln.AssetAmount)
+}
+
+// recordAsset records that the invoice of paymentHash is paid in asset.
+func (lsatmiddleware *GinLsatMiddleware) recordAsset(paymentHash lntypes.Hash, asset *ln.AssetAmount) error {
+	if lsatmiddleware.Payments == nil {
+		return nil
+	}
+	_, err := payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		p.AssetId, p.AssetAmount = asset.AssetId, asset.Amount
+		return nil
+	})
+	return err
+}
+
+// isAssetPayment returns true if the invoice of paymentHash was issued in a
+// Taproot Asset, its settlement is then confirmed with tapd whether or not
+// RequireSettlement is set.
+func (lsatmiddleware *GinLsatMiddleware) isAssetPayment(paymentHash lntypes.Hash) bool {
+	if lsatmiddleware.Payments == nil {
+		return false
+	}
+	p, err := lsatmiddleware.Payments.Get(paymentHash)
+	return err == nil && p.AssetId != ""
+}
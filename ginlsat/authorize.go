@@ -0,0 +1,54 @@
+package ginlsat
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authorization is the outcome of authorizing a request outside of a gin
+// router, e.g. for proxy and serverless adapters
+type Authorization struct {
+	LsatInfo *LsatInfo
+	// Status is 200 for authorized requests, 402 for challenges and 403 for
+	// invalid tokens
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+func (authorization *Authorization) IsAuthorized() bool {
+	return authorization.Status == http.StatusOK
+}
+
+// Authorize runs the middleware for req like ForwardAuthHandler does, unpaid
+// requests receive a challenge.
+func (lsatmiddleware *GinLsatMiddleware) Authorize(req *http.Request) *Authorization {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = req
+	lsatInfo := lsatmiddleware.authorize(c)
+	if c.IsAborted() {
+		return &Authorization{
+			LsatInfo: lsatInfo,
+			Status:   c.Writer.Status(),
+			Header:   recorder.Header(),
+			Body:     recorder.Body.Bytes(),
+		}
+	}
+	if lsatInfo.Error != nil {
+		return &Authorization{
+			LsatInfo: lsatInfo,
+			Status:   http.StatusForbidden,
+			Header:   http.Header{},
+		}
+	}
+	header := recorder.Header()
+	header.Set(LSAT_TYPE_HEADER, lsatInfo.Type)
+	return &Authorization{
+		LsatInfo: lsatInfo,
+		Status:   http.StatusOK,
+		Header:   header,
+	}
+}
@@ -0,0 +1,140 @@
+package ginlsat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/ln"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// DEFAULT_BACKEND is the name of the LNClient of the middleware
+const DEFAULT_BACKEND = "default"
+
+// AddBackend initializes an additional LN backend that BackendFunc can
+// select by name.
+func (lsatmiddleware *GinLsatMiddleware) AddBackend(name string, lnClientConfig *ln.LNClientConfig) error {
+	lnClient, err := InitLnClient(lnClientConfig)
+	if err != nil {
+		return err
+	}
+	if lsatmiddleware.Backends == nil {
+		lsatmiddleware.Backends = map[string]ln.LNClient{}
+	}
+	lsatmiddleware.Backends[name] = lnClient
+	return nil
+}
+
+// lnClientConn returns the LN backend selected for req and its name.
+func (lsatmiddleware *GinLsatMiddleware) lnClientConn(req *http.Request) (string, *ln.LNClientConn, error) {
+	name := ""
+	if lsatmiddleware.BackendFunc != nil {
+		name = lsatmiddleware.BackendFunc(req)
+	}
+	return lsatmiddleware.backend(name)
+}
+
+// paymentBackend returns the LN backend that issued the invoice of
+// paymentHash as recorded in the payment store, or the backend selected for
+// req when it isn't recorded.
+func (lsatmiddleware *GinLsatMiddleware) paymentBackend(req *http.Request, paymentHash lntypes.Hash) (string, *ln.LNClientConn, error) {
+	if lsatmiddleware.Payments != nil {
+		p, err := lsatmiddleware.Payments.Get(paymentHash)
+		if err == nil && p.Backend != "" {
+			return lsatmiddleware.backend(p.Backend)
+		}
+	}
+	return lsatmiddleware.lnClientConn(req)
+}
+
+// backend returns the LN backend with name and its name, "" is the default.
+func (lsatmiddleware *GinLsatMiddleware) backend(name string) (string, *ln.LNClientConn, error) {
+	if name == "" || name == DEFAULT_BACKEND {
+		return DEFAULT_BACKEND, &ln.LNClientConn{
+			LNClient: lsatmiddleware.LNClient,
+			Breaker:  lsatmiddleware.circuitBreaker(DEFAULT_BACKEND),
+		}, nil
+	}
+	lnClient, ok := lsatmiddleware.Backends[name]
+	if !ok {
+		return name, nil, fmt.Errorf("LN backend not configured: %s", name)
+	}
+	return name, &ln.LNClientConn{
+		LNClient: lnClient,
+		Breaker:  lsatmiddleware.circuitBreaker(name),
+	}, nil
+}
+
+// circuitBreaker returns the circuit breaker of the backend with name, nil
+// when circuit breaking is disabled.
+func (lsatmiddleware *GinLsatMiddleware) circuitBreaker(name string) *ln.CircuitBreaker {
+	if lsatmiddleware.CircuitBreaker == nil {
+		return nil
+	}
+	breaker, _ := lsatmiddleware.breakers.LoadOrStore(name, ln.NewCircuitBreaker(lsatmiddleware.CircuitBreaker.FailureThreshold, lsatmiddleware.CircuitBreaker.CoolDown))
+	return breaker.(*ln.CircuitBreaker)
+}
+
+// lnContext returns the context for LN backend calls made while serving a
+// request with ctx, bounded by LNTimeout.
+func (lsatmiddleware *GinLsatMiddleware) lnContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if lsatmiddleware.LNTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, lsatmiddleware.LNTimeout)
+}
+
+// Health pings the default and the additional LN backends.
+func (lsatmiddleware *GinLsatMiddleware) Health(ctx context.Context) error {
+	names := []string{DEFAULT_BACKEND}
+	for name := range lsatmiddleware.Backends {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		_, lnClientConn, err := lsatmiddleware.backend(name)
+		if err != nil {
+			return err
+		}
+		err = lnClientConn.Ping(ctx)
+		if err != nil {
+			return fmt.Errorf("LN backend %s is unhealthy: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// IsHealthy reports whether all LN backends respond, e.g. for a readiness
+// probe.
+func (lsatmiddleware *GinLsatMiddleware) IsHealthy() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+	return lsatmiddleware.Health(ctx) == nil
+}
+
+// CircuitBreakerConfig stops calling a backend after FailureThreshold
+// consecutive failures to issue an invoice, for CoolDown.
+type CircuitBreakerConfig struct {
+	FailureThreshold int
+	CoolDown         time.Duration
+	// Fallback is the name of the backend issuing invoices while the
+	// circuit of the selected backend is open, "" fails the challenge
+	Fallback string
+}
+
+// BackendByPathPrefix selects the backend of the longest path prefix
+// matching the request, e.g. {"/donate": "lnurl", "/api": "lnd"}.
+func BackendByPathPrefix(prefixes map[string]string) func(req *http.Request) string {
+	return func(req *http.Request) string {
+		matched, backend := "", ""
+		for prefix, name := range prefixes {
+			if strings.HasPrefix(req.URL.Path, prefix) && len(prefix) > len(matched) {
+				matched, backend = prefix, name
+			}
+		}
+		return backend
+	}
+}
@@ -0,0 +1,57 @@
+package ginlsat
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/usage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// LSAT_BYTES_REMAINING_HEADER holds the response bytes a token with a
+// max_response_bytes caveat has left before the current response
+const LSAT_BYTES_REMAINING_HEADER = "X-Lsat-Bytes-Remaining"
+
+// bandwidthMeter returns Bandwidth, an in-process meter when it is nil.
+func (lsatmiddleware *GinLsatMiddleware) bandwidthMeter() usage.Meter {
+	if lsatmiddleware.Bandwidth != nil {
+		return lsatmiddleware.Bandwidth
+	}
+	lsatmiddleware.defaultBandwidthOnce.Do(func() {
+		lsatmiddleware.defaultBandwidth = usage.NewMemoryStore()
+	})
+	return lsatmiddleware.defaultBandwidth
+}
+
+// checkBandwidth rejects tokens with a max_response_bytes caveat that
+// consumed their bytes and returns the limit, 0 for unmetered tokens.
+func (lsatmiddleware *GinLsatMiddleware) checkBandwidth(c *gin.Context, paymentHash lntypes.Hash, verifiedCaveats caveat.Set) (int64, error) {
+	maxResponseBytes, ok := verifiedCaveats.MaxResponseBytes()
+	if !ok {
+		return 0, nil
+	}
+	consumed, err := lsatmiddleware.bandwidthMeter().Get(paymentHash.String())
+	if err != nil {
+		return 0, err
+	}
+	if consumed >= maxResponseBytes {
+		return 0, fmt.Errorf("Token consumed %d of %d response bytes", consumed, maxResponseBytes)
+	}
+	c.Writer.Header().Set(LSAT_BYTES_REMAINING_HEADER, strconv.FormatInt(maxResponseBytes-consumed, 10))
+	return maxResponseBytes, nil
+}
+
+// meterBandwidth serves the request and adds the response bytes to the
+// consumption of the token. The response crossing the limit is served in
+// full, the next one is rejected.
+func (lsatmiddleware *GinLsatMiddleware) meterBandwidth(c *gin.Context, paymentHash lntypes.Hash) {
+	c.Next()
+	if size := c.Writer.Size(); size > 0 {
+		if _, err := lsatmiddleware.bandwidthMeter().Add(paymentHash.String(), int64(size)); err != nil {
+			c.Error(err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ginlsat
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestMaxResponseBytes(t *testing.T) {
+	const tileSize = 600
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.CaveatFunc = func(req *http.Request) []caveat.Caveat {
+		return caveat.NewBuilder().MaxResponseBytes(1000).Build()
+	}
+	handler := testRouter(lsatmiddleware, "/protected")
+	handler.GET("/tiles", lsatmiddleware.Handler, func(c *gin.Context) {
+		lsatInfo := c.Value("LSAT").(*LsatInfo)
+		if lsatInfo.Type != LSAT_TYPE_PAID {
+			c.AbortWithStatusJSON(http.StatusPaymentRequired, gin.H{
+				"code":    http.StatusPaymentRequired,
+				"message": lsatInfo.Error.Error(),
+			})
+			return
+		}
+		c.String(http.StatusOK, strings.Repeat("x", tileSize))
+	})
+	header := authorization(t, paidToken(t, client, handler, "/protected"))
+	router := gofight.New()
+
+	// The response crossing the limit is served in full
+	for _, remaining := range []string{"1000", "400"} {
+		router.GET("/tiles").
+			SetHeader(gofight.H{
+				"Authorization": header.Get("Authorization"),
+			}).
+			Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusOK, res.Code)
+				assert.Equal(t, tileSize, res.Body.Len())
+				assert.Equal(t, remaining, res.HeaderMap.Get(LSAT_BYTES_REMAINING_HEADER))
+			})
+	}
+	router.GET("/tiles").
+		SetHeader(gofight.H{
+			"Authorization": header.Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			assert.Equal(t, "Token consumed 1200 of 1000 response bytes", gjson.Get(res.Body.String(), "message").String())
+		})
+}
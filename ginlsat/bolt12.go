@@ -0,0 +1,79 @@
+package ginlsat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/payment"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"gopkg.in/macaroon.v2"
+)
+
+// BOLT12_OFFER_KEY holds the offer of the challenge in the gin context
+const BOLT12_OFFER_KEY = "LSAT_BOLT12_OFFER"
+
+// createOffer issues the offer of a challenge, nil when Bolt12 is disabled
+// or the backend doesn't support offers.
+func (lsatmiddleware *GinLsatMiddleware) createOffer(ctx context.Context, LNClientConn *ln.LNClientConn, lnInvoice *lnrpc.Invoice) (*ln.Offer, error) {
+	if !lsatmiddleware.Bolt12 || !LNClientConn.SupportsOffers() {
+		return nil, nil
+	}
+	if lsatmiddleware.Payments == nil {
+		return nil, fmt.Errorf("BOLT12 offers require a payment store")
+	}
+	return LNClientConn.CreateOffer(ctx, ln.InvoiceAmountMsat(lnInvoice), lnInvoice.Memo)
+}
+
+// recordOffer records the offer the challenge of paymentHash can be paid
+// with.
+func (lsatmiddleware *GinLsatMiddleware) recordOffer(paymentHash lntypes.Hash, offerId string) error {
+	_, err := payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		p.OfferId = offerId
+		return nil
+	})
+	return err
+}
+
+// verifyOfferPayment verifies a token paid through its offer instead of its
+// invoice, the preimage is then of the invoice requested from the offer. It
+// returns the payment hash of the token.
+func (lsatmiddleware *GinLsatMiddleware) verifyOfferPayment(c *gin.Context, mac *macaroon.Macaroon, rootKey []byte, preimage lntypes.Preimage, verified *caveat.Set) (lntypes.Hash, error) {
+	dischargeMacaroons, err := discharges(c.Request)
+	if err != nil {
+		return lntypes.Hash{}, err
+	}
+	macaroonId, err := lsat.VerifyMacaroonWithDischarges(mac, rootKey, dischargeMacaroons, lsatmiddleware.checkCaveats(c.Request, verified))
+	if err != nil {
+		return lntypes.Hash{}, err
+	}
+	// Only the offer recorded with the payment counts, an offer_id caveat
+	// added when attenuating can't point the token at another paid offer
+	if lsatmiddleware.Payments == nil {
+		return lntypes.Hash{}, fmt.Errorf("BOLT12 offers require a payment store")
+	}
+	p, err := lsatmiddleware.Payments.Get(macaroonId.PaymentHash)
+	if err != nil || p.OfferId == "" {
+		return lntypes.Hash{}, fmt.Errorf("Macaroon was not issued with an offer")
+	}
+	offerId := p.OfferId
+	ctx, cancel := lsatmiddleware.lnContext(c.Request.Context())
+	defer cancel()
+	_, LNClientConn, err := lsatmiddleware.paymentBackend(c.Request, macaroonId.PaymentHash)
+	if err != nil {
+		return lntypes.Hash{}, err
+	}
+	paid, err := LNClientConn.IsOfferPaid(ctx, offerId, preimage.Hash())
+	if err != nil {
+		return lntypes.Hash{}, err
+	}
+	if !paid {
+		return lntypes.Hash{}, fmt.Errorf("Invalid Preimage %s for offer %s", preimage, offerId)
+	}
+	return macaroonId.PaymentHash, nil
+}
@@ -0,0 +1,37 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOfferPaymentIsCheckedAgainstRecordedOffer(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.Bolt12 = true
+	router := testRouter(lsatmiddleware, "/protected")
+
+	lsatChallenge := requestChallenge(t, router, "/protected")
+	assert.Equal(t, "lno1", lsatChallenge.Offer)
+	token := challengeToken(t, lsatChallenge, client.payOffer("offer1"))
+	res := serve(router, http.MethodGet, "/protected", authorization(t, token))
+	assert.Equal(t, LSAT_TYPE_PAID, tokenType(t, res))
+}
+
+func TestAttenuatedOfferIdIsIgnored(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.Bolt12 = true
+	router := testRouter(lsatmiddleware, "/protected")
+	requestChallenge(t, router, "/protected")
+	preimage := client.payOffer("offer1")
+
+	// The second token points its offer_id caveat at the paid first offer
+	token := challengeToken(t, requestChallenge(t, router, "/protected"), preimage, caveat.New(caveat.OFFER_ID, "offer1"))
+	res := serve(router, http.MethodGet, "/protected", authorization(t, token))
+	assert.NotEqual(t, LSAT_TYPE_PAID, tokenType(t, res))
+}
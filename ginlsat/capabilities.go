@@ -0,0 +1,44 @@
+package ginlsat
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireCapabilities declares the capabilities a token needs for a route,
+// e.g. router.POST("/items", lsatmiddleware.RequireCapabilities("write"), lsatmiddleware.Handler, handler).
+// Tokens minted on the route are bought with them unless CapabilitiesFunc
+// is set, tokens lacking one of them are rejected.
+func (lsatmiddleware *GinLsatMiddleware) RequireCapabilities(capabilities ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = utils.WithRequiredCapabilities(c.Request, capabilities)
+	}
+}
+
+// mintCapabilities returns the capabilities tokens minted for req are
+// bought with, nil for tokens without a capabilities caveat.
+func (lsatmiddleware *GinLsatMiddleware) mintCapabilities(req *http.Request) []string {
+	if lsatmiddleware.CapabilitiesFunc != nil {
+		return lsatmiddleware.CapabilitiesFunc(req)
+	}
+	return utils.GetRequiredCapabilities(req)
+}
+
+// checkCapabilities rejects tokens without a capabilities caveat on routes
+// requiring capabilities, the caveats of a token are checked by
+// caveat.CheckCapabilities.
+func checkCapabilities(req *http.Request, verifiedCaveats caveat.Set) error {
+	required := utils.GetRequiredCapabilities(req)
+	if len(required) == 0 {
+		return nil
+	}
+	if _, ok := verifiedCaveats.RouteCapabilities(); !ok {
+		return fmt.Errorf("Token lacks the %s capability", required[0])
+	}
+	return nil
+}
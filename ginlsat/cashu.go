@@ -0,0 +1,115 @@
+package ginlsat
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kiwiidb/gin-lsat/cashu"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// LSAT_PREIMAGE_HEADER returns the preimage of an invoice paid with Cashu,
+// so the client can present the token as a regular LSAT afterwards
+const LSAT_PREIMAGE_HEADER = "X-Lsat-Preimage"
+
+// CashuConfig accepts Cashu tokens of trusted mints in the X-Cashu header
+// next to a macaroon without preimage. The mint pays the invoice of the
+// macaroon with the token, so the ecash ends up as sats on the backend.
+type CashuConfig struct {
+	// TrustedMints are the URLs of the mints tokens are accepted from
+	TrustedMints []string
+	// Client talks to the mints, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+func (cashuConfig *CashuConfig) isTrusted(mint string) bool {
+	for _, trusted := range cashuConfig.TrustedMints {
+		if strings.TrimSuffix(trusted, "/") == mint {
+			return true
+		}
+	}
+	return false
+}
+
+// isCashuPayment returns true if req pays for its macaroon with a Cashu
+// token.
+func (lsatmiddleware *GinLsatMiddleware) isCashuPayment(req *http.Request) bool {
+	return lsatmiddleware.Cashu != nil && lsatmiddleware.ChargePolicy != CHARGE_ON_SUCCESS && req.Header.Get(cashu.HEADER) != ""
+}
+
+// redeemCashu melts the Cashu token of the request to pay the invoice of
+// the macaroon in authField and returns the preimage of the invoice.
+func (lsatmiddleware *GinLsatMiddleware) redeemCashu(c *gin.Context, authField string) (lntypes.Preimage, error) {
+	if lsatmiddleware.Payments == nil {
+		return lntypes.Preimage{}, fmt.Errorf("Cashu payments require a payment store")
+	}
+	mac, err := utils.ParseLsatMacaroonHeader(authField)
+	if err != nil {
+		return lntypes.Preimage{}, err
+	}
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		return lntypes.Preimage{}, err
+	}
+	// Caveats are checked once the invoice is paid, as for any LSAT
+	acceptAll := func(caveat string) error { return nil }
+	macaroonId, err := lsat.VerifyMacaroon(mac, rootKey, acceptAll)
+	if err != nil {
+		return lntypes.Preimage{}, err
+	}
+	if err := lsatmiddleware.checkRevoked(mac); err != nil {
+		return lntypes.Preimage{}, err
+	}
+	p, err := lsatmiddleware.Payments.Get(macaroonId.PaymentHash)
+	if err != nil || p.Invoice == "" {
+		return lntypes.Preimage{}, fmt.Errorf("Invoice for PaymentHash %s is unknown", macaroonId.PaymentHash)
+	}
+	token, err := cashu.DecodeToken(c.Request.Header.Get(cashu.HEADER))
+	if err != nil {
+		return lntypes.Preimage{}, err
+	}
+	if token.Unit != "" && token.Unit != cashu.UNIT_SAT {
+		return lntypes.Preimage{}, fmt.Errorf("Cashu token unit %s is not supported", token.Unit)
+	}
+	mintURL, err := token.Mint()
+	if err != nil {
+		return lntypes.Preimage{}, err
+	}
+	if !lsatmiddleware.Cashu.isTrusted(mintURL) {
+		return lntypes.Preimage{}, fmt.Errorf("Cashu mint %s is not trusted", mintURL)
+	}
+
+	ctx, cancel := lsatmiddleware.lnContext(c.Request.Context())
+	defer cancel()
+	mint := &cashu.Mint{
+		URL:    mintURL,
+		Client: lsatmiddleware.Cashu.Client,
+	}
+	quote, err := mint.MeltQuote(ctx, p.Invoice)
+	if err != nil {
+		return lntypes.Preimage{}, err
+	}
+	if token.Amount() < quote.Amount+quote.FeeReserve {
+		return lntypes.Preimage{}, fmt.Errorf("Cashu token of %d sats is less than the %d sats and %d sats fee reserve of the invoice", token.Amount(), quote.Amount, quote.FeeReserve)
+	}
+	melt, err := mint.Melt(ctx, quote.Quote, token.Proofs())
+	if err != nil {
+		return lntypes.Preimage{}, err
+	}
+	if melt.State != cashu.MELT_STATE_PAID {
+		return lntypes.Preimage{}, fmt.Errorf("Cashu mint did not pay the invoice: %s", melt.State)
+	}
+	preimage, err := lntypes.MakePreimageFromStr(melt.PaymentPreimage)
+	if err != nil {
+		return lntypes.Preimage{}, err
+	}
+	if preimage.Hash() != macaroonId.PaymentHash {
+		return lntypes.Preimage{}, fmt.Errorf("Cashu mint returned a wrong preimage")
+	}
+	return preimage, nil
+}
@@ -0,0 +1,120 @@
+package ginlsat
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/cashu"
+	"github.com/kiwiidb/gin-lsat/lsat"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+// CASHU_FEE_RESERVE is the fee reserve quoted by the test mint
+const CASHU_FEE_RESERVE = 2
+
+// newTestMint melts tokens by paying the invoice of paymentHash with client
+func newTestMint(client *fakeLNClient, paymentHash *lntypes.Hash) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	mint := gin.New()
+	mint.POST("/v1/melt/quote/bolt11", func(c *gin.Context) {
+		c.JSON(http.StatusOK, cashu.MeltQuote{
+			Quote:      "quote",
+			Amount:     TEST_AMOUNT,
+			FeeReserve: CASHU_FEE_RESERVE,
+		})
+	})
+	mint.POST("/v1/melt/bolt11", func(c *gin.Context) {
+		c.JSON(http.StatusOK, cashu.MeltResponse{
+			State:           cashu.MELT_STATE_PAID,
+			PaymentPreimage: client.pay(*paymentHash).String(),
+		})
+	})
+	return httptest.NewServer(mint)
+}
+
+// cashuToken returns a cashuA token of amount sats of mint
+func cashuToken(t *testing.T, mint string, amount int64) string {
+	payload, err := json.Marshal(&cashu.Token{
+		Token: []cashu.TokenEntry{{
+			Mint: mint,
+			Proofs: []cashu.Proof{{
+				Amount: amount,
+				Id:     "009a1f293253e41e",
+				Secret: "secret",
+				C:      "02bc9097997d81afb2cc7346b5e4345a9346bd2a506eb7958598a72f0cf85163ea",
+			}},
+		}},
+		Unit: cashu.UNIT_SAT,
+	})
+	assert.NoError(t, err)
+	return cashu.TOKEN_V3_PREFIX + base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func TestCashuPayment(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	handler := testRouter(lsatmiddleware, "/protected")
+	lsatChallenge := requestChallenge(t, handler, "/protected")
+	paymentHash := challengeToken(t, lsatChallenge, lntypes.Preimage{}).PaymentHash()
+	mint := newTestMint(client, &paymentHash)
+	defer mint.Close()
+	lsatmiddleware.Cashu = &CashuConfig{
+		TrustedMints: []string{mint.URL + "/"},
+	}
+	macaroonOnly := fmt.Sprintf("%s %s:", lsat.SCHEME, lsatChallenge.Macaroon)
+	router := gofight.New()
+
+	for _, rejected := range []struct {
+		token string
+		err   string
+	}{
+		{cashuToken(t, "https://mint.example.com", TEST_AMOUNT+CASHU_FEE_RESERVE), "Cashu mint https://mint.example.com is not trusted"},
+		{cashuToken(t, mint.URL, TEST_AMOUNT), "Cashu token of 10 sats is less than the 10 sats and 2 sats fee reserve of the invoice"},
+		{"cashuBo2F0gaJhaUgA", "Cashu V4 tokens are not supported, send a cashuA token"},
+	} {
+		router.GET("/protected").
+			SetHeader(gofight.H{
+				"Authorization": macaroonOnly,
+				cashu.HEADER:    rejected.token,
+			}).
+			Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusOK, res.Code)
+				assert.Equal(t, rejected.err, gjson.Get(res.Body.String(), "error").String())
+				assert.Empty(t, res.HeaderMap.Get(LSAT_PREIMAGE_HEADER))
+			})
+	}
+
+	var preimage lntypes.Preimage
+	router.GET("/protected").
+		SetHeader(gofight.H{
+			"Authorization": macaroonOnly,
+			cashu.HEADER:    cashuToken(t, mint.URL, TEST_AMOUNT+CASHU_FEE_RESERVE),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+			var err error
+			preimage, err = lntypes.MakePreimageFromStr(res.HeaderMap.Get(LSAT_PREIMAGE_HEADER))
+			assert.NoError(t, err)
+		})
+	assert.Equal(t, paymentHash, preimage.Hash())
+
+	// The token is presented as a regular LSAT afterwards
+	router.GET("/protected").
+		SetHeader(gofight.H{
+			"Authorization": authorization(t, challengeToken(t, lsatChallenge, preimage)).Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+		})
+}
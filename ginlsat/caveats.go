@@ -0,0 +1,66 @@
+package ginlsat
+
+import (
+	"github.com/kiwiidb/gin-lsat/caveat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+)
+
+// WithCaveats adds caveats to every macaroon minted, next to the caveats of
+// CaveatFunc. Caveats without a builtin checker need one registered with
+// WithCaveatChecker, tokens carrying them are rejected otherwise.
+func (lsatmiddleware *GinLsatMiddleware) WithCaveats(caveats ...caveat.Caveat) *GinLsatMiddleware {
+	lsatmiddleware.Caveats = append(lsatmiddleware.Caveats, caveats...)
+	return lsatmiddleware
+}
+
+// WithCaveatChecker verifies caveats with condition name with checker,
+// taking precedence over a builtin checker of the same condition.
+func (lsatmiddleware *GinLsatMiddleware) WithCaveatChecker(name string, checker caveat.Checker) *GinLsatMiddleware {
+	if lsatmiddleware.CaveatCheckers == nil {
+		lsatmiddleware.CaveatCheckers = map[string]caveat.Checker{}
+	}
+	lsatmiddleware.CaveatCheckers[name] = checker
+	return lsatmiddleware
+}
+
+// WithEncryptedCaveats encrypts the values of caveats with condition names
+// when tokens are minted, so they are unreadable to the holder. They are
+// decrypted before their checkers run.
+func (lsatmiddleware *GinLsatMiddleware) WithEncryptedCaveats(names ...string) *GinLsatMiddleware {
+	lsatmiddleware.EncryptCaveats = append(lsatmiddleware.EncryptCaveats, names...)
+	return lsatmiddleware
+}
+
+// encryptCaveats encrypts the caveats of the macaroon of tokenId listed in
+// EncryptCaveats with rootKey.
+func (lsatmiddleware *GinLsatMiddleware) encryptCaveats(rootKey []byte, tokenId [32]byte, caveats []string) ([]string, error) {
+	if len(lsatmiddleware.EncryptCaveats) == 0 {
+		return caveats, nil
+	}
+	encrypted := make([]string, 0, len(caveats))
+	for _, caveatString := range caveats {
+		parsed, err := caveat.Parse(caveatString)
+		if err != nil {
+			return nil, err
+		}
+		if !lsatmiddleware.isEncrypted(parsed.Condition) {
+			encrypted = append(encrypted, caveatString)
+			continue
+		}
+		encryptedCaveat, err := macaroonutils.EncryptCaveat(rootKey, tokenId, caveatString)
+		if err != nil {
+			return nil, err
+		}
+		encrypted = append(encrypted, encryptedCaveat)
+	}
+	return encrypted, nil
+}
+
+func (lsatmiddleware *GinLsatMiddleware) isEncrypted(condition string) bool {
+	for _, name := range lsatmiddleware.EncryptCaveats {
+		if name == condition {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendedTierDoesNotEscalate(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.CaveatFunc = func(req *http.Request) []caveat.Caveat {
+		return caveat.NewBuilder().Tier("basic").Build()
+	}
+	router := testRouter(lsatmiddleware, "/protected")
+
+	token := paidToken(t, client, router, "/protected", caveat.New(caveat.TIER, "pro"))
+	res := decodeResponse(t, serve(router, http.MethodGet, "/protected", authorization(t, token)))
+	assert.Equal(t, LSAT_TYPE_PAID, res.Type)
+	assert.Equal(t, "basic", res.Tier)
+}
@@ -0,0 +1,109 @@
+package ginlsat
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/challenge"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/utils"
+	"github.com/kiwiidb/gin-lsat/x402"
+
+	"github.com/gin-gonic/gin"
+)
+
+// issuedChallenge is a challenge issued by generateChallenge
+type issuedChallenge struct {
+	invoice        string
+	macaroonString string
+	// offer is the BOLT12 offer, "" for none
+	offer string
+	// onchainAddress is the on-chain address, "" for none
+	onchainAddress string
+}
+
+// encodeMacaroon returns the standard base64 macaroonString in the encoding
+// macaroons are sent to clients in.
+func (lsatmiddleware *GinLsatMiddleware) encodeMacaroon(macaroonString string) (string, error) {
+	if !lsatmiddleware.Base64URL {
+		return macaroonString, nil
+	}
+	return utils.EncodeBase64URL(macaroonString)
+}
+
+// scheme returns the scheme of the challenges, lsat.SCHEME by default.
+func (lsatmiddleware *GinLsatMiddleware) scheme() string {
+	if lsatmiddleware.Scheme == "" {
+		return lsat.SCHEME
+	}
+	return lsatmiddleware.Scheme
+}
+
+// writeChallenge responds with the 402 challenge, the body is negotiated
+// through the Accept header.
+func (lsatmiddleware *GinLsatMiddleware) writeChallenge(c *gin.Context, amount int64, macaroonString string, invoice string) {
+	// Bind the macaroon and invoice together so clients can detect a swapped invoice
+	signature := ""
+	lsatChallenge := &lsat.Challenge{
+		Macaroon: macaroonString,
+		Invoice:  invoice,
+		Offer:    c.GetString(BOLT12_OFFER_KEY),
+		Address:  c.GetString(ONCHAIN_ADDRESS_KEY),
+		Quote:    lsatmiddleware.ApertureCompat,
+		Scheme:   lsatmiddleware.scheme(),
+	}
+	asset := challengeAsset(c)
+	if asset != nil {
+		lsatChallenge.AssetId, lsatChallenge.AssetAmount = asset.AssetId, asset.Amount
+	}
+	if lsatmiddleware.ReceiptSigner != nil {
+		signature = lsatmiddleware.ReceiptSigner.SignBytes(lsat.ChallengeMessage(macaroonString, invoice))
+		lsatChallenge.Signature = signature
+	}
+	c.Writer.Header().Set("WWW-Authenticate", lsatChallenge.String())
+	// Proxy subrequests can't pass a 402 or a body to the client
+	if c.GetBool(STATUS_ONLY_CHALLENGE_KEY) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if strings.Contains(c.Request.Header.Get("Accept"), challenge.MEDIA_TYPE) {
+		mac, err := utils.GetMacaroonFromString(macaroonString)
+		if err != nil {
+			lsatmiddleware.setLsatError(c, err)
+			return
+		}
+		document := challenge.NewDocument(c.Request, lsatmiddleware.scheme(), amount, mac, macaroonString, invoice, lsatmiddleware.invoiceExpiry(c.Request, amount))
+		document.Signature = signature
+		c.Header("Content-Type", challenge.MEDIA_TYPE)
+		c.AbortWithStatusJSON(http.StatusPaymentRequired, document)
+		return
+	}
+	if lsatmiddleware.X402 && x402.IsRequested(c.Request) {
+		x402Challenge := x402.NewChallenge(PAYMENT_REQUIRED_MESSAGE, c.Request.URL.String(), amount, macaroonString, invoice, int64(lsatmiddleware.invoiceExpiry(c.Request, amount)/time.Second))
+		c.AbortWithStatusJSON(http.StatusPaymentRequired, gin.H{
+			"code":        http.StatusPaymentRequired,
+			"message":     PAYMENT_REQUIRED_MESSAGE,
+			"x402Version": x402Challenge.X402Version,
+			"error":       x402Challenge.Error,
+			"accepts":     x402Challenge.Accepts,
+		})
+		return
+	}
+	if lsatmiddleware.Teaser != nil {
+		lsatmiddleware.writeTeaser(c, http.StatusPaymentRequired)
+		return
+	}
+	body := gin.H{
+		"code":    http.StatusPaymentRequired,
+		"message": PAYMENT_REQUIRED_MESSAGE,
+	}
+	if asset != nil {
+		body["asset_id"] = asset.AssetId
+		body["asset_amount"] = asset.Amount
+	}
+	if lsatChallenge.Address != "" {
+		body["address"] = lsatChallenge.Address
+	}
+	c.AbortWithStatusJSON(http.StatusPaymentRequired, body)
+}
@@ -0,0 +1,81 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/challenge"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/receipt"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestChallengeDocument(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	handler := testRouter(lsatmiddleware, "/protected")
+	router := gofight.New()
+
+	router.GET("/protected").
+		SetHeader(gofight.H{
+			"Accept": challenge.MEDIA_TYPE,
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			assert.Equal(t, challenge.MEDIA_TYPE, res.HeaderMap.Get("Content-Type"))
+			lsatChallenge, err := lsat.ParseChallenge(res.HeaderMap.Get("WWW-Authenticate"))
+			assert.NoError(t, err)
+			body := res.Body.String()
+			assert.Equal(t, challenge.VERSION, gjson.Get(body, "version").String())
+			assert.Equal(t, lsat.SCHEME, gjson.Get(body, "scheme").String())
+			assert.Equal(t, int64(TEST_AMOUNT), gjson.Get(body, "price.amount").Int())
+			assert.Equal(t, "sat", gjson.Get(body, "price.currency").String())
+			// The document carries the challenge of the WWW-Authenticate header
+			assert.Equal(t, lsatChallenge.Invoice, gjson.Get(body, "invoice").String())
+			assert.Equal(t, lsatChallenge.Macaroon, gjson.Get(body, "macaroon").String())
+			assert.NotEmpty(t, gjson.Get(body, "expires_at").String())
+			assert.Equal(t, http.MethodGet, gjson.Get(body, "scope.method").String())
+			assert.Equal(t, "/protected", gjson.Get(body, "scope.path").String())
+			assert.Equal(t, "Authorization", gjson.Get(body, "retry.header").String())
+			assert.False(t, gjson.Get(body, "signature").Exists())
+		})
+
+	// Clients not negotiating the document get the legacy body
+	router.GET("/protected").
+		SetHeader(gofight.H{
+			"Accept": "application/vnd.lsat.v1.full+json",
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			assert.Equal(t, PAYMENT_REQUIRED_MESSAGE, gjson.Get(res.Body.String(), "message").String())
+			assert.False(t, gjson.Get(res.Body.String(), "version").Exists())
+		})
+}
+
+func TestChallengeSignature(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.ReceiptSigner = receipt.NewSignerFromSecret([]byte("gin-lsat-test-receipt"))
+	handler := testRouter(lsatmiddleware, "/protected")
+	publicKey := lsatmiddleware.ReceiptSigner.PublicKey()
+	router := gofight.New()
+
+	router.GET("/protected").
+		SetHeader(gofight.H{
+			"Accept": challenge.MEDIA_TYPE,
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			lsatChallenge, err := lsat.ParseChallenge(res.HeaderMap.Get("WWW-Authenticate"))
+			assert.NoError(t, err)
+			assert.NotEmpty(t, lsatChallenge.Signature)
+			assert.Equal(t, lsatChallenge.Signature, gjson.Get(res.Body.String(), "signature").String())
+			assert.NoError(t, lsat.VerifyChallengeSignature(publicKey, lsatChallenge.Macaroon, lsatChallenge.Invoice, lsatChallenge.Signature))
+			// A swapped invoice is detected
+			other := requestChallenge(t, handler, "/protected")
+			assert.Error(t, lsat.VerifyChallengeSignature(publicKey, lsatChallenge.Macaroon, other.Invoice, lsatChallenge.Signature))
+		})
+}
@@ -0,0 +1,42 @@
+package ginlsat
+
+import (
+	"fmt"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/concurrency"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// connectionStore returns Connections, an in-process store when it is nil.
+func (lsatmiddleware *GinLsatMiddleware) connectionStore() concurrency.Store {
+	if lsatmiddleware.Connections != nil {
+		return lsatmiddleware.Connections
+	}
+	lsatmiddleware.defaultConnectionsOnce.Do(func() {
+		lsatmiddleware.defaultConnections = concurrency.NewMemoryStore()
+	})
+	return lsatmiddleware.defaultConnections
+}
+
+// acquireConnection takes a connection slot of a token with a
+// max_connections caveat and returns the function releasing it, nil for
+// tokens without the caveat. Sub-tokens share the slots of their parent.
+func (lsatmiddleware *GinLsatMiddleware) acquireConnection(paymentHash lntypes.Hash, verifiedCaveats caveat.Set) (func(), error) {
+	maxConnections, ok := verifiedCaveats.MaxConnections()
+	if !ok {
+		return nil, nil
+	}
+	store := lsatmiddleware.connectionStore()
+	_, err := store.Acquire(paymentHash.String(), maxConnections)
+	if err == concurrency.ErrLimitReached {
+		return nil, fmt.Errorf("Token has %d connections open already", maxConnections)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		store.Release(paymentHash.String())
+	}, nil
+}
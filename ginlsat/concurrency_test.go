@@ -0,0 +1,58 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestMaxConnections(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.CaveatFunc = func(req *http.Request) []caveat.Caveat {
+		return caveat.NewBuilder().MaxConnections(1).Build()
+	}
+	opened, closeStream := make(chan bool), make(chan bool)
+	handler := testRouter(lsatmiddleware, "/protected")
+	handler.GET("/stream", lsatmiddleware.Handler, func(c *gin.Context) {
+		if c.Value("LSAT").(*LsatInfo).Type == LSAT_TYPE_PAID {
+			opened <- true
+			<-closeStream
+		}
+	}, respondWithLsatInfo)
+	header := authorization(t, paidToken(t, client, handler, "/protected"))
+	router := gofight.New()
+	request := func() *gofight.RequestConfig {
+		return router.GET("/stream").
+			SetHeader(gofight.H{
+				"Authorization": header.Get("Authorization"),
+			})
+	}
+
+	streamed := make(chan string)
+	go request().Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+		streamed <- gjson.Get(res.Body.String(), "type").String()
+	})
+	<-opened
+	request().Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+		assert.NotEqual(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+		assert.Equal(t, "Token has 1 connections open already", gjson.Get(res.Body.String(), "error").String())
+	})
+	closeStream <- true
+	assert.Equal(t, LSAT_TYPE_PAID, <-streamed)
+
+	// The slot is released once the stream is closed
+	go func() {
+		<-opened
+		closeStream <- true
+	}()
+	request().Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+		assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+	})
+}
@@ -0,0 +1,203 @@
+package ginlsat
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Verified crawlers get the full content
+	CRAWLER_ACCESS_FULL = "FULL"
+	// Verified crawlers get the teaser with a 200
+	CRAWLER_ACCESS_TEASER = "TEASER"
+)
+
+const (
+	// CRAWLER_DNS_TIMEOUT bounds the reverse and forward lookups verifying
+	// the IP of a crawler
+	CRAWLER_DNS_TIMEOUT = 2 * time.Second
+	// CRAWLER_DNS_CACHE_TTL is how long the IP of a verified crawler is
+	// trusted before it is looked up again
+	CRAWLER_DNS_CACHE_TTL = time.Hour
+	// CRAWLER_DNS_RETRY is how long an IP that failed the lookups is
+	// rejected before it is looked up again
+	CRAWLER_DNS_RETRY = time.Minute
+)
+
+// crawlerResolver resolves crawler IPs and names, tests replace it.
+var crawlerResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+} = net.DefaultResolver
+
+// Crawler is a search engine crawler that is let through the paywall. A
+// matching User-Agent alone is never trusted, the request must also be
+// verified by reverse DNS, IP range or VerifyFunc.
+type Crawler struct {
+	Name string
+	// UserAgent is matched as a substring of the User-Agent header
+	UserAgent string
+	// Domains the reverse DNS name of the client IP ends in, e.g.
+	// ".googlebot.com", confirmed by a forward lookup of the name
+	Domains []string
+	// IPRanges in CIDR notation the client IP is in
+	IPRanges []string
+	// VerifyFunc verifies the request otherwise, e.g. by a signed header
+	VerifyFunc func(req *http.Request) bool
+}
+
+var (
+	Googlebot = Crawler{
+		Name:      "Googlebot",
+		UserAgent: "Googlebot",
+		Domains:   []string{".googlebot.com", ".google.com"},
+	}
+	Bingbot = Crawler{
+		Name:      "Bingbot",
+		UserAgent: "bingbot",
+		Domains:   []string{".search.msn.com"},
+	}
+)
+
+// Verify returns true if req, sent from clientIP, comes from the crawler.
+func (crawler *Crawler) Verify(req *http.Request, clientIP string) bool {
+	return crawler.verify(req, clientIP, crawler.verifyDomain)
+}
+
+// verify checks req like Verify, the reverse DNS check is left to
+// verifyDomain.
+func (crawler *Crawler) verify(req *http.Request, clientIP string, verifyDomain func(ip net.IP) bool) bool {
+	if crawler.UserAgent != "" && !strings.Contains(req.UserAgent(), crawler.UserAgent) {
+		return false
+	}
+	if crawler.VerifyFunc != nil && crawler.VerifyFunc(req) {
+		return true
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, ipRange := range crawler.IPRanges {
+		_, network, err := net.ParseCIDR(ipRange)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	if len(crawler.Domains) == 0 {
+		return false
+	}
+	return verifyDomain(ip)
+}
+
+// verifyDomain checks the reverse DNS name of ip, and that the name
+// resolves back to ip so the PTR record can't be spoofed.
+func (crawler *Crawler) verifyDomain(ip net.IP) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), CRAWLER_DNS_TIMEOUT)
+	defer cancel()
+	names, err := crawlerResolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		return false
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		if !crawler.hasDomain(name) {
+			continue
+		}
+		addrs, err := crawlerResolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (crawler *Crawler) hasDomain(name string) bool {
+	for _, domain := range crawler.Domains {
+		if strings.HasSuffix(name, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// crawlerDomainCheck is the cached reverse DNS check of a crawler IP
+type crawlerDomainCheck struct {
+	verified  bool
+	checkedAt time.Time
+}
+
+func (check *crawlerDomainCheck) expired() bool {
+	if check.verified {
+		return time.Since(check.checkedAt) >= CRAWLER_DNS_CACHE_TTL
+	}
+	return time.Since(check.checkedAt) >= CRAWLER_DNS_RETRY
+}
+
+// verifiedCrawler returns the crawler req comes from, nil if none.
+func (lsatmiddleware *GinLsatMiddleware) verifiedCrawler(c *gin.Context) *Crawler {
+	for i := range lsatmiddleware.Crawlers {
+		crawler := &lsatmiddleware.Crawlers[i]
+		verifyDomain := func(ip net.IP) bool {
+			return lsatmiddleware.verifyCrawlerDomain(crawler, ip)
+		}
+		if crawler.verify(c.Request, c.ClientIP(), verifyDomain) {
+			return crawler
+		}
+	}
+	return nil
+}
+
+// verifyCrawlerDomain runs the reverse DNS check of crawler for ip, cached
+// per IP so crawlers and clients spoofing their User-Agent don't cost a
+// lookup per request.
+func (lsatmiddleware *GinLsatMiddleware) verifyCrawlerDomain(crawler *Crawler, ip net.IP) bool {
+	key := crawler.Name + " " + ip.String()
+	if cached, ok := lsatmiddleware.crawlerDomains.Load(key); ok && !cached.(*crawlerDomainCheck).expired() {
+		return cached.(*crawlerDomainCheck).verified
+	}
+	verified := crawler.verifyDomain(ip)
+	lsatmiddleware.pruneCrawlerDomains()
+	lsatmiddleware.crawlerDomains.Store(key, &crawlerDomainCheck{
+		verified:  verified,
+		checkedAt: time.Now(),
+	})
+	return verified
+}
+
+// pruneCrawlerDomains drops expired checks, at most once per
+// CRAWLER_DNS_RETRY.
+func (lsatmiddleware *GinLsatMiddleware) pruneCrawlerDomains() {
+	now := time.Now().UnixNano()
+	lastPruned := atomic.LoadInt64(&lsatmiddleware.crawlerDomainsPrunedAt)
+	if now-lastPruned < int64(CRAWLER_DNS_RETRY) || !atomic.CompareAndSwapInt64(&lsatmiddleware.crawlerDomainsPrunedAt, lastPruned, now) {
+		return
+	}
+	lsatmiddleware.crawlerDomains.Range(func(key, cached interface{}) bool {
+		if cached.(*crawlerDomainCheck).expired() {
+			lsatmiddleware.crawlerDomains.Delete(key)
+		}
+		return true
+	})
+}
+
+// serveCrawler lets a verified crawler through according to CrawlerAccess.
+func (lsatmiddleware *GinLsatMiddleware) serveCrawler(c *gin.Context) {
+	if lsatmiddleware.CrawlerAccess == CRAWLER_ACCESS_TEASER && lsatmiddleware.Teaser != nil {
+		lsatmiddleware.writeTeaser(c, http.StatusOK)
+		return
+	}
+	c.Set("LSAT", &LsatInfo{
+		Type: LSAT_TYPE_PAID,
+	})
+}
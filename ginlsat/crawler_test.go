@@ -0,0 +1,125 @@
+package ginlsat
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+const CLIENT_IP_HEADER = "X-Client-Ip"
+
+// fakeResolver resolves the names and IPs it was given and counts the
+// reverse lookups
+type fakeResolver struct {
+	mu      sync.Mutex
+	names   map[string][]string
+	addrs   map[string][]net.IPAddr
+	lookups int
+	// deadlines records whether the lookups had a deadline
+	deadlines []bool
+}
+
+func (resolver *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	resolver.lookups++
+	_, hasDeadline := ctx.Deadline()
+	resolver.deadlines = append(resolver.deadlines, hasDeadline)
+	names, ok := resolver.names[addr]
+	if !ok {
+		return nil, fmt.Errorf("No PTR record for %s", addr)
+	}
+	return names, nil
+}
+
+func (resolver *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	return resolver.addrs[host], nil
+}
+
+func TestCrawlerAllowlist(t *testing.T) {
+	resolver := &fakeResolver{
+		names: map[string][]string{
+			"66.249.66.1": {"crawl-66-249-66-1.googlebot.com."},
+			// PTR record pointing at Google from an IP that isn't Google's
+			"203.0.113.1": {"crawl-66-249-66-1.googlebot.com."},
+		},
+		addrs: map[string][]net.IPAddr{
+			"crawl-66-249-66-1.googlebot.com": {{IP: net.ParseIP("66.249.66.1")}},
+		},
+	}
+	defaultResolver := crawlerResolver
+	crawlerResolver = resolver
+	defer func() {
+		crawlerResolver = defaultResolver
+	}()
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.Crawlers = []Crawler{Googlebot}
+	handler := testRouter(lsatmiddleware, "/articles")
+	handler.TrustedPlatform = CLIENT_IP_HEADER
+	router := gofight.New()
+
+	for i := 0; i < 2; i++ {
+		router.GET("/articles").
+			SetHeader(gofight.H{
+				"Accept":         "application/vnd.lsat.v1.full+json",
+				"User-Agent":     "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+				CLIENT_IP_HEADER: "66.249.66.1",
+			}).
+			Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusOK, res.Code)
+				assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+			})
+	}
+	// The IP was looked up once, within a deadline
+	assert.Equal(t, 1, resolver.lookups)
+	assert.Equal(t, []bool{true}, resolver.deadlines)
+
+	// A spoofed User-Agent or PTR record is not let through, and not looked
+	// up again
+	for _, clientIP := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.2"} {
+		router.GET("/articles").
+			SetHeader(gofight.H{
+				"Accept":         "application/vnd.lsat.v1.full+json",
+				"User-Agent":     "Googlebot",
+				CLIENT_IP_HEADER: clientIP,
+			}).
+			Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			})
+	}
+	assert.Equal(t, 3, resolver.lookups)
+
+	// Other User-Agents are never looked up
+	router.GET("/articles").
+		SetHeader(gofight.H{
+			"Accept":         "application/vnd.lsat.v1.full+json",
+			CLIENT_IP_HEADER: "66.249.66.1",
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+		})
+	assert.Equal(t, 3, resolver.lookups)
+}
+
+func TestCrawlerIPRanges(t *testing.T) {
+	crawler := Crawler{
+		Name:      "Examplebot",
+		UserAgent: "Examplebot",
+		IPRanges:  []string{"192.0.2.0/24"},
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/articles", nil)
+	req.Header.Set("User-Agent", "Examplebot/1.0")
+	assert.True(t, crawler.Verify(req, "192.0.2.10"))
+	assert.False(t, crawler.Verify(req, "198.51.100.10"))
+	assert.False(t, crawler.Verify(req, "not an ip"))
+}
@@ -0,0 +1,81 @@
+package ginlsat
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Set the error in the LsatInfo and leave the response to the handler
+	DEGRADE_ERROR = "ERROR"
+	// Serve the request as LSAT_TYPE_FREE
+	DEGRADE_FREE = "FREE"
+	// Respond with 503 Service Unavailable
+	DEGRADE_UNAVAILABLE = "UNAVAILABLE"
+	// Respond with the last challenge issued for the same amount while its
+	// invoice hasn't expired, 503 when there is none
+	DEGRADE_CACHED_CHALLENGE = "CACHED_CHALLENGE"
+)
+
+// DEGRADED_RETRY_AFTER is the Retry-After header sent with a 503, in seconds
+const DEGRADED_RETRY_AFTER = "30"
+
+type cachedChallenge struct {
+	macaroonString string
+	invoice        string
+	issuedAt       time.Time
+}
+
+// cacheChallenge keeps the challenge of amount for DEGRADE_CACHED_CHALLENGE.
+func (lsatmiddleware *GinLsatMiddleware) cacheChallenge(amount int64, macaroonString string, invoice string) {
+	if lsatmiddleware.DegradationPolicy != DEGRADE_CACHED_CHALLENGE {
+		return
+	}
+	lsatmiddleware.challenges.Store(amount, &cachedChallenge{
+		macaroonString: macaroonString,
+		invoice:        invoice,
+		issuedAt:       time.Now(),
+	})
+}
+
+// degrade responds to a request whose challenge couldn't be issued because
+// of err, according to the degradation policy.
+func (lsatmiddleware *GinLsatMiddleware) degrade(c *gin.Context, amount int64, err error) {
+	switch lsatmiddleware.DegradationPolicy {
+	case DEGRADE_FREE:
+		c.Error(err)
+		c.Set("LSAT", &LsatInfo{
+			Type: LSAT_TYPE_FREE,
+		})
+		return
+	case DEGRADE_CACHED_CHALLENGE:
+		if cached, ok := lsatmiddleware.challenges.Load(amount); ok {
+			challenge := cached.(*cachedChallenge)
+			// Leave the payer time to pay before the invoice expires
+			if time.Since(challenge.issuedAt) < lsatmiddleware.invoiceExpiry(c.Request, amount)/2 {
+				c.Error(err)
+				lsatmiddleware.writeChallenge(c, amount, challenge.macaroonString, challenge.invoice)
+				return
+			}
+			lsatmiddleware.challenges.Delete(amount)
+		}
+		lsatmiddleware.setLsatError(c, err)
+		lsatmiddleware.abortUnavailable(c)
+		return
+	case DEGRADE_UNAVAILABLE:
+		lsatmiddleware.setLsatError(c, err)
+		lsatmiddleware.abortUnavailable(c)
+		return
+	}
+	lsatmiddleware.setLsatError(c, err)
+}
+
+func (lsatmiddleware *GinLsatMiddleware) abortUnavailable(c *gin.Context) {
+	c.Header("Retry-After", DEGRADED_RETRY_AFTER)
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"code":    http.StatusServiceUnavailable,
+		"message": "Payments are temporarily unavailable",
+	})
+}
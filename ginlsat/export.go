@@ -0,0 +1,65 @@
+package ginlsat
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/payment"
+
+	"github.com/gin-gonic/gin"
+)
+
+const EXPORT_DATE_FORMAT = "2006-01-02"
+
+// ExportHandler exports settled payments and their usage between the from
+// and to query params (dates or RFC3339 timestamps) as CSV (format=csv) or
+// JSON. Mount it behind the operator's authentication, e.g.
+// admin.GET("/lsat/export", lsatmiddleware.ExportHandler)
+func (lsatmiddleware *GinLsatMiddleware) ExportHandler(c *gin.Context) {
+	if lsatmiddleware.Payments == nil {
+		abortWithMessage(c, http.StatusNotFound, "Payments are not recorded")
+		return
+	}
+	from, err := parseExportTime(c.Query("from"), time.Time{})
+	if err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := parseExportTime(c.Query("to"), time.Now())
+	if err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch c.DefaultQuery("format", "json") {
+	case "csv":
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=payments-%s-%s.csv", from.Format(EXPORT_DATE_FORMAT), to.Format(EXPORT_DATE_FORMAT)))
+		c.Status(http.StatusOK)
+		err = payment.ExportCSV(lsatmiddleware.Payments, from, to, c.Writer)
+	case "json":
+		c.Header("Content-Type", "application/json")
+		c.Status(http.StatusOK)
+		err = payment.ExportJSON(lsatmiddleware.Payments, from, to, c.Writer)
+	default:
+		abortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("Export format not recognized: %s", c.Query("format")))
+		return
+	}
+	if err != nil {
+		c.Error(err)
+	}
+}
+
+func parseExportTime(value string, defaultTime time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultTime, nil
+	}
+	if t, err := time.Parse(EXPORT_DATE_FORMAT, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time %s, expected a date or RFC3339 timestamp", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,67 @@
+package ginlsat
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestExportHandler(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	handler := testRouter(lsatmiddleware, "/protected")
+	handler.GET("/lsat/export", lsatmiddleware.ExportHandler)
+	token := paidToken(t, client, handler, "/protected")
+	router := gofight.New()
+
+	router.GET("/protected").
+		SetHeader(gofight.H{
+			"Authorization": authorization(t, token).Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+		})
+
+	router.GET("/lsat/export").
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			records := gjson.Parse(res.Body.String()).Array()
+			assert.Len(t, records, 1)
+			assert.Equal(t, token.PaymentHash().String(), records[0].Get("payment_hash").String())
+			assert.Equal(t, hex.EncodeToString(token.Identifier.TokenId[:]), records[0].Get("token_id").String())
+			assert.Equal(t, int64(TEST_AMOUNT), records[0].Get("amount").Int())
+		})
+
+	router.GET("/lsat/export?format=csv").
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, "text/csv", res.HeaderMap.Get("Content-Type"))
+			lines := strings.Split(strings.TrimSpace(res.Body.String()), "\n")
+			assert.Len(t, lines, 2)
+			assert.True(t, strings.HasPrefix(lines[1], token.PaymentHash().String()))
+		})
+
+	// Payments settled before from are left out
+	router.GET("/lsat/export?from=2999-01-01").
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Empty(t, gjson.Parse(res.Body.String()).Array())
+		})
+
+	router.GET("/lsat/export?format=xml").
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+			assert.Equal(t, "Export format not recognized: xml", gjson.Get(res.Body.String(), "message").String())
+		})
+
+	router.GET("/lsat/export?from=yesterday").
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		})
+}
@@ -0,0 +1,80 @@
+package ginlsat
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	FORWARDED_METHOD_HEADER = "X-Forwarded-Method"
+	FORWARDED_URI_HEADER    = "X-Forwarded-Uri"
+	FORWARDED_HOST_HEADER   = "X-Forwarded-Host"
+	FORWARDED_PROTO_HEADER  = "X-Forwarded-Proto"
+	// LSAT_TYPE_HEADER is passed upstream by proxies with the LsatInfo type
+	LSAT_TYPE_HEADER = "X-Lsat-Type"
+)
+
+// ForwardAuthHandler implements the ForwardAuth contract of Traefik and
+// Caddy: the proxied request is described by the X-Forwarded-* headers and
+// is allowed with a 200, otherwise the 402 challenge is returned to the
+// client. Mount it with router.Any("/lsat/auth", lsatmiddleware.ForwardAuthHandler).
+// CHARGE_ON_SUCCESS is not supported: the protected handler runs after the
+// subrequest, so its status is unknown when the hold invoice is redeemed.
+func (lsatmiddleware *GinLsatMiddleware) ForwardAuthHandler(c *gin.Context) {
+	c.Request = forwardedRequest(c.Request, c.Request.Header.Get(FORWARDED_METHOD_HEADER), c.Request.Header.Get(FORWARDED_URI_HEADER), c.Request.Header.Get(FORWARDED_HOST_HEADER))
+	lsatInfo := lsatmiddleware.authorize(c)
+	if c.IsAborted() {
+		return
+	}
+	if lsatInfo.Error != nil {
+		abortWithMessage(c, http.StatusUnauthorized, lsatInfo.Error.Error())
+		return
+	}
+	c.Writer.Header().Set(LSAT_TYPE_HEADER, lsatInfo.Type)
+	c.Status(http.StatusOK)
+}
+
+// authorize runs the middleware for a request that can't be served for free,
+// clients that don't announce LSAT support are challenged as well. The
+// context is aborted when a challenge was written.
+func (lsatmiddleware *GinLsatMiddleware) authorize(c *gin.Context) *LsatInfo {
+	// Hold invoices would be settled before the protected handler responds
+	if lsatmiddleware.ChargePolicy == CHARGE_ON_SUCCESS {
+		abortWithMessage(c, http.StatusInternalServerError, "Hold invoices are not supported by auth subrequests")
+		return &LsatInfo{}
+	}
+	lsatmiddleware.Handler(c)
+	lsatInfo, ok := c.Value("LSAT").(*LsatInfo)
+	if !ok {
+		return &LsatInfo{
+			Error: fmt.Errorf("Request was not authorized"),
+		}
+	}
+	if lsatInfo.Type == LSAT_TYPE_FREE && !c.IsAborted() {
+		lsatmiddleware.SetLSATHeader(c)
+		lsatInfo, _ = c.Value("LSAT").(*LsatInfo)
+	}
+	return lsatInfo
+}
+
+// forwardedRequest returns a copy of req for the method, uri and host of the
+// request the proxy is authorizing, empty values are taken from req.
+func forwardedRequest(req *http.Request, method string, uri string, host string) *http.Request {
+	forwarded := req.Clone(req.Context())
+	if method != "" {
+		forwarded.Method = method
+	}
+	if uri != "" {
+		if forwardedUrl, err := url.ParseRequestURI(uri); err == nil {
+			forwarded.URL = forwardedUrl
+			forwarded.RequestURI = uri
+		}
+	}
+	if host != "" {
+		forwarded.Host = host
+	}
+	return forwarded
+}
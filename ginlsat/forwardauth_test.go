@@ -0,0 +1,142 @@
+package ginlsat
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/lsat"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestAuthSubrequestsRejectHoldInvoices(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.ChargePolicy = CHARGE_ON_SUCCESS
+	gin.SetMode(gin.TestMode)
+	handler := gin.New()
+	handler.GET("/lsat/forward-auth", lsatmiddleware.ForwardAuthHandler)
+	handler.GET("/lsat/nginx-auth", lsatmiddleware.NginxAuthHandler)
+	router := gofight.New()
+
+	for _, path := range []string{"/lsat/forward-auth", "/lsat/nginx-auth"} {
+		router.GET(path).
+			SetHeader(gofight.H{
+				FORWARDED_URI_HEADER: "/protected",
+				ORIGINAL_URI_HEADER:  "/protected",
+			}).
+			Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusInternalServerError, res.Code)
+				assert.Equal(t, "Hold invoices are not supported by auth subrequests", gjson.Get(res.Body.String(), "message").String())
+				assert.Empty(t, res.HeaderMap.Get("WWW-Authenticate"))
+			})
+	}
+	assert.Empty(t, client.holds)
+}
+
+func TestForwardAuthHandler(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	gin.SetMode(gin.TestMode)
+	handler := gin.New()
+	handler.GET("/lsat/forward-auth", lsatmiddleware.ForwardAuthHandler)
+	forwarded := gofight.H{
+		FORWARDED_METHOD_HEADER: http.MethodGet,
+		FORWARDED_URI_HEADER:    "/protected?page=1",
+		FORWARDED_HOST_HEADER:   "api.example.com",
+	}
+	router := gofight.New()
+
+	// Clients that don't announce LSAT support are challenged as well
+	var lsatChallenge *lsat.Challenge
+	router.GET("/lsat/forward-auth").
+		SetHeader(forwarded).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			assert.Equal(t, PAYMENT_REQUIRED_MESSAGE, gjson.Get(res.Body.String(), "message").String())
+			var err error
+			lsatChallenge, err = lsat.ParseChallenge(res.HeaderMap.Get("WWW-Authenticate"))
+			assert.NoError(t, err)
+		})
+
+	token := challengeToken(t, lsatChallenge, lsatPreimage(t, client, lsatChallenge))
+	router.GET("/lsat/forward-auth").
+		SetHeader(withAuthorization(forwarded, authorization(t, token).Get("Authorization"))).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, res.HeaderMap.Get(LSAT_TYPE_HEADER))
+		})
+
+	// Tokens presented with the wrong preimage are rejected
+	unpaid := challengeToken(t, lsatChallenge, lntypes.Preimage{1})
+	router.GET("/lsat/forward-auth").
+		SetHeader(withAuthorization(forwarded, authorization(t, unpaid).Get("Authorization"))).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusUnauthorized, res.Code)
+			assert.NotEmpty(t, gjson.Get(res.Body.String(), "message").String())
+			assert.Empty(t, res.HeaderMap.Get(LSAT_TYPE_HEADER))
+		})
+}
+
+func TestNginxAuthHandler(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	gin.SetMode(gin.TestMode)
+	handler := gin.New()
+	handler.GET("/lsat/nginx-auth", lsatmiddleware.NginxAuthHandler)
+	original := gofight.H{
+		ORIGINAL_METHOD_HEADER: http.MethodGet,
+		ORIGINAL_URI_HEADER:    "/protected",
+	}
+	router := gofight.New()
+
+	// NGINX only passes the status and headers of the subrequest on
+	var lsatChallenge *lsat.Challenge
+	router.GET("/lsat/nginx-auth").
+		SetHeader(original).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusUnauthorized, res.Code)
+			assert.Empty(t, strings.TrimSpace(res.Body.String()))
+			var err error
+			lsatChallenge, err = lsat.ParseChallenge(res.HeaderMap.Get("WWW-Authenticate"))
+			assert.NoError(t, err)
+		})
+
+	token := challengeToken(t, lsatChallenge, lsatPreimage(t, client, lsatChallenge))
+	router.GET("/lsat/nginx-auth").
+		SetHeader(withAuthorization(original, authorization(t, token).Get("Authorization"))).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, res.HeaderMap.Get(LSAT_TYPE_HEADER))
+		})
+
+	unpaid := challengeToken(t, lsatChallenge, lntypes.Preimage{1})
+	router.GET("/lsat/nginx-auth").
+		SetHeader(withAuthorization(original, authorization(t, unpaid).Get("Authorization"))).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusForbidden, res.Code)
+			assert.Empty(t, res.HeaderMap.Get(LSAT_TYPE_HEADER))
+		})
+}
+
+// lsatPreimage pays the invoice of lsatChallenge and returns its preimage
+func lsatPreimage(t *testing.T, client *fakeLNClient, lsatChallenge *lsat.Challenge) lntypes.Preimage {
+	token := challengeToken(t, lsatChallenge, lntypes.Preimage{})
+	return client.preimage(token.PaymentHash())
+}
+
+// withAuthorization returns a copy of header with the Authorization header set
+func withAuthorization(header gofight.H, value string) gofight.H {
+	withAuthorization := gofight.H{
+		"Authorization": value,
+	}
+	for key, value := range header {
+		withAuthorization[key] = value
+	}
+	return withAuthorization
+}
@@ -3,14 +3,29 @@ package ginlsat
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/challenge"
+	"github.com/kiwiidb/gin-lsat/concurrency"
 	"github.com/kiwiidb/gin-lsat/ln"
 	"github.com/kiwiidb/gin-lsat/lsat"
 	"github.com/kiwiidb/gin-lsat/macaroon"
 	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/payment"
+	"github.com/kiwiidb/gin-lsat/purchase"
+	"github.com/kiwiidb/gin-lsat/ratelimit"
+	"github.com/kiwiidb/gin-lsat/receipt"
+	"github.com/kiwiidb/gin-lsat/revocation"
+	"github.com/kiwiidb/gin-lsat/rootkey"
+	"github.com/kiwiidb/gin-lsat/usage"
 	"github.com/kiwiidb/gin-lsat/utils"
+	"github.com/kiwiidb/gin-lsat/x402"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lightningnetwork/lnd/lnrpc"
@@ -18,8 +33,18 @@ import (
 )
 
 const (
-	LND_CLIENT_TYPE   = "LND"
-	LNURL_CLIENT_TYPE = "LNURL"
+	LND_CLIENT_TYPE      = "LND"
+	LNURL_CLIENT_TYPE    = "LNURL"
+	CLN_CLIENT_TYPE      = "CLN"
+	LNBITS_CLIENT_TYPE   = "LNBITS"
+	LNDHUB_CLIENT_TYPE   = "LNDHUB"
+	BTCPAY_CLIENT_TYPE   = "BTCPAY"
+	ECLAIR_CLIENT_TYPE   = "ECLAIR"
+	OPENNODE_CLIENT_TYPE = "OPENNODE"
+	ZEBEDEE_CLIENT_TYPE  = "ZEBEDEE"
+	PHOENIXD_CLIENT_TYPE = "PHOENIXD"
+	LNPAY_CLIENT_TYPE    = "LNPAY"
+	TAPD_CLIENT_TYPE     = "TAPD"
 )
 
 const (
@@ -28,6 +53,30 @@ const (
 	LSAT_HEADER    = "application/vnd.lsat.v1.full"
 )
 
+// Expiry LND uses for invoices when none is set, in seconds
+const DEFAULT_INVOICE_EXPIRY = 3600
+
+// HEALTH_CHECK_TIMEOUT bounds pinging the LN backends
+const HEALTH_CHECK_TIMEOUT = 10 * time.Second
+
+const (
+	// Charge every request as soon as the invoice is paid
+	CHARGE_ON_REQUEST = "ON_REQUEST"
+	// Only settle the hold invoice when the protected handler succeeds
+	CHARGE_ON_SUCCESS = "ON_SUCCESS"
+)
+
+const (
+	// Bind tokens to the request path they were bought on
+	BIND_PATH_EXACT = "EXACT"
+	// Bind tokens to the gin route they were bought on, e.g. /articles/:id
+	// binds to /articles/*
+	BIND_PATH_ROUTE = "ROUTE"
+	// Bind tokens to the parent of the request path, e.g. a token bought on
+	// /api/v1/report is valid for /api/v1/*
+	BIND_PATH_PREFIX = "PREFIX"
+)
+
 const (
 	FREE_CONTENT_MESSAGE      = "Free Content"
 	PROTECTED_CONTENT_MESSAGE = "Protected Content"
@@ -39,23 +88,232 @@ type LsatInfo struct {
 	Preimage lntypes.Preimage
 	Mac      *macaroon.MacaroonIdentifier
 	Amount   int64
-	Error    error
+	// AmountMsat is the amount paid in msat, set for paid tokens recorded in
+	// the Payments store
+	AmountMsat int64
+	// Caveats the token was verified with
+	Caveats caveat.Set
+	Error   error
 }
 
 type GinLsatMiddleware struct {
 	AmountFunc func(req *http.Request) (amount int64)
-	LNClient   ln.LNClient
+	// AmountMsatFunc prices requests in msat and takes precedence over
+	// AmountFunc, e.g. for endpoints costing a fraction of a sat. Backends
+	// that only invoice whole sats round the amount up.
+	AmountMsatFunc func(req *http.Request) (amountMsat int64)
+	LNClient       ln.LNClient
+	// AssetFunc requests payment in a Taproot Asset, e.g. a stablecoin,
+	// instead of sats when it returns an asset amount. Requires a TAPD
+	// backend, hold invoices stay in sats.
+	AssetFunc func(req *http.Request) *ln.AssetAmount
+	// Backends are additional LN clients by name, see AddBackend
+	Backends map[string]ln.LNClient
+	// BackendFunc returns the name of the backend issuing invoices for req,
+	// "" selects LNClient. The name is recorded with the payment.
+	BackendFunc func(req *http.Request) string
+	// RootKeyProvider supplies the root keys tokens are signed with, read
+	// from ROOT_KEY and ROOT_KEY_<ID> when nil
+	RootKeyProvider rootkey.Provider
+	// Scheme of the WWW-Authenticate challenges, lsat.SCHEME_LSAT (default)
+	// or lsat.SCHEME_L402. Tokens are accepted with either scheme.
+	Scheme string
+	// Base64URL emits macaroons base64url encoded without padding, for
+	// clients and proxies mangling the standard encoding. Both encodings
+	// are accepted either way.
+	Base64URL bool
+	// ApertureCompat emits challenges with quoted values as Aperture does
+	// and accepts tokens in the Grpc-Metadata-Macaroon and Macaroon headers
+	ApertureCompat bool
+	// ApertureSecrets verifies tokens minted by Aperture with their
+	// per-token secrets, e.g. when replacing an Aperture deployment
+	ApertureSecrets rootkey.ApertureSecrets
+	// RootKeyIdFunc returns the id of the root key tokens for req are minted
+	// with, instead of the current key of the provider. "" selects ROOT_KEY.
+	// Rotating a key invalidates only the tokens minted with it.
+	RootKeyIdFunc func(req *http.Request) string
+	// ResourceFunc returns the id of the resource (article, dataset, video)
+	// req accesses, "" for none. Tokens are bound to the resource and with
+	// Purchases set, buyers are not charged for it again.
+	ResourceFunc func(req *http.Request) string
+	// Purchases records the resources bought by users and tokens
+	Purchases purchase.Store
+	// Liquidity checks the inbound liquidity of the backend before issuing
+	// invoices when set
+	Liquidity *LiquidityConfig
+	// LNTimeout bounds the LN backend calls made for a request on top of the
+	// request context, 0 for no deadline
+	LNTimeout time.Duration
+	// CircuitBreaker stops calling failing backends when set
+	CircuitBreaker *CircuitBreakerConfig
+	// breakers are the circuit breakers by backend name
+	breakers sync.Map
+	// DegradationPolicy decides the response when no invoice can be issued,
+	// one of DEGRADE_ERROR (default), DEGRADE_FREE, DEGRADE_UNAVAILABLE or
+	// DEGRADE_CACHED_CHALLENGE
+	DegradationPolicy string
+	// challenges are the last challenges by amount for DEGRADE_CACHED_CHALLENGE
+	challenges sync.Map
+	// Tab enables tab mode when set
+	Tab *TabConfig
+	// Upgrade lets holders upgrade paid tokens with UpgradeHandler when set
+	Upgrade *UpgradeConfig
+	// ChargePolicy is one of CHARGE_ON_REQUEST (default) or CHARGE_ON_SUCCESS
+	ChargePolicy string
+	// Bolt12 adds a single use BOLT12 offer to challenges next to the
+	// invoice when the backend supports offers, like CLN. Requires Payments
+	Bolt12 bool
+	// AMPBearer issues AMP invoices, which LND accepts in several shards of
+	// independent preimages, requires an LND backend. The payer never
+	// learns a preimage, so tokens are presented without one and accepted
+	// once the invoice is paid: anyone holding the macaroon of a paid AMP
+	// invoice is served, like with a bearer token. Requires Payments
+	AMPBearer bool
+	// HoldTimeout cancels the hold invoice when the protected handler takes
+	// longer than this, 0 disables the check
+	HoldTimeout time.Duration
+	// Onchain adds an on-chain address to challenges of large amounts when
+	// set
+	Onchain *OnchainConfig
+	// Cashu accepts Cashu tokens of trusted mints as payment when set
+	Cashu *CashuConfig
+	// Refund refunds failed requests with keysend when set
+	Refund *RefundConfig
+	// holds are the payment hashes of hold invoices being redeemed
+	holds sync.Map
+	// TokenTTL mints every macaroon with an expires_at caveat of TokenTTL
+	// from now, 0 for tokens that never expire
+	TokenTTL time.Duration
+	// Caveats are added to every macaroon minted, see WithCaveats
+	Caveats []caveat.Caveat
+	// ThirdPartyCaveats are added to every macaroon minted, tokens are only
+	// accepted with a discharge of each, see WithThirdPartyCaveat
+	ThirdPartyCaveats []macaroonutils.ThirdPartyCaveat
+	// CaveatFunc returns the caveats added to macaroons minted for req
+	CaveatFunc func(req *http.Request) []caveat.Caveat
+	// EncryptCaveats are the conditions of caveats whose values are minted
+	// encrypted with the root key, see WithEncryptedCaveats
+	EncryptCaveats []string
+	// CapabilitiesFunc returns the capabilities tokens minted for req are
+	// bought with, e.g. from the plan purchased. Defaults to the capabilities
+	// required by the route, see RequireCapabilities.
+	CapabilitiesFunc func(req *http.Request) []string
+	// CaveatCheckers verify caveats by condition, next to the builtin checkers
+	CaveatCheckers map[string]caveat.Checker
+	// UserIdFunc returns the id of the user authenticated by a preceding
+	// auth or session middleware, defaults to the gin.BasicAuth user.
+	// Tokens minted for a user are bound to that user.
+	UserIdFunc func(c *gin.Context) string
+	// BindPath binds tokens to the path they were bought on, one of
+	// BIND_PATH_EXACT, BIND_PATH_ROUTE or BIND_PATH_PREFIX. Tokens are valid
+	// on every path when empty.
+	BindPath string
+	// BindClientCert binds tokens to the fingerprint of the mTLS client
+	// certificate they were bought with
+	BindClientCert bool
+	// BindIP binds tokens to the address of the client that bought them, so
+	// they are rejected when replayed from another address
+	BindIP bool
+	// BindIPv4PrefixLength and BindIPv6PrefixLength widen BindIP to the
+	// network of the client, e.g. 24 and 64, to tolerate clients changing
+	// address within their network. The exact address is bound when 0.
+	BindIPv4PrefixLength int
+	BindIPv6PrefixLength int
+	// Payments records issued invoices and their settlement, required for receipts
+	Payments payment.Store
+	// Revocations is the revocation list of tokens cut off with Revoke
+	Revocations revocation.Store
+	// Uses counts the uses of tokens with a max_uses caveat, the payment
+	// store counts them when nil
+	Uses usage.Store
+	// RateLimits counts the requests of tokens with a requests_per_minute
+	// caveat, an in-process store when nil. Replicas need a shared store to
+	// enforce a common limit.
+	RateLimits ratelimit.Store
+	// defaultRateLimits is the in-process store used when RateLimits is nil
+	defaultRateLimits     ratelimit.Store
+	defaultRateLimitsOnce sync.Once
+	// Bandwidth meters the response bytes of tokens with a
+	// max_response_bytes caveat, in process when nil. Replicas need a shared
+	// meter to enforce a common limit.
+	Bandwidth usage.Meter
+	// defaultBandwidth is the in-process meter used when Bandwidth is nil
+	defaultBandwidth     usage.Meter
+	defaultBandwidthOnce sync.Once
+	// Connections tracks the open connections of tokens with a
+	// max_connections caveat, in process when nil. Replicas need a shared
+	// store to enforce a common limit.
+	Connections concurrency.Store
+	// defaultConnections is the in-process store used when Connections is nil
+	defaultConnections     concurrency.Store
+	defaultConnectionsOnce sync.Once
+	// RequireSettlement only accepts tokens whose invoice the LN backend
+	// reports as settled for the amount of the challenge, instead of
+	// trusting the preimage alone, and settles tabs the same way. Requires
+	// Payments, run a SettlementWatcher to avoid a lookup per new token.
+	RequireSettlement bool
+	// ReceiptSigner signs challenges and the receipts served by ReceiptHandler
+	ReceiptSigner *receipt.Signer
+	// X402 challenges clients accepting x402.MEDIA_TYPE with x402 shaped
+	// bodies and accepts payments in the X-PAYMENT header
+	X402 bool
+	// StatusHeaders sets the X-Lsat-Status and X-Lsat-Expires-At headers on
+	// verified requests
+	StatusHeaders bool
+	// MemoFunc returns the memo of the invoice of amount for req, so payers
+	// see what they paid for in their wallet. Defaults to DEFAULT_MEMO, see
+	// MemoTemplate.
+	MemoFunc func(req *http.Request, amount int64) string
+	// InvoiceExpiry is the expiry of issued invoices, defaults to
+	// DEFAULT_INVOICE_EXPIRY seconds
+	InvoiceExpiry time.Duration
+	// InvoiceExpiryFunc returns the expiry of the invoice of amount for req,
+	// 0 falls back to InvoiceExpiry. Short expiries keep volatile prices
+	// from being paid long after they were quoted.
+	InvoiceExpiryFunc func(req *http.Request, amount int64) time.Duration
+	// ExpiryWarning reports tokens expiring within this duration as expiring
+	ExpiryWarning time.Duration
+	// Teaser serves part of the content with the 402 challenge when set,
+	// see LSAT_TYPE_TEASER
+	Teaser TeaserFunc
+	// Crawlers are let through without payment so paywalled pages stay
+	// indexable, e.g. []ginlsat.Crawler{ginlsat.Googlebot, ginlsat.Bingbot}
+	Crawlers []Crawler
+	// CrawlerAccess is one of CRAWLER_ACCESS_FULL (default) or
+	// CRAWLER_ACCESS_TEASER
+	CrawlerAccess string
+	// crawlerDomains are the reverse DNS checks of crawler IPs
+	crawlerDomains sync.Map
+	// crawlerDomainsPrunedAt is when expired checks were last dropped, in
+	// unix nanoseconds
+	crawlerDomainsPrunedAt int64
 }
 
 func NewLsatMiddleware(lnClientConfig *ln.LNClientConfig,
 	amountFunc func(req *http.Request) (amount int64)) (*GinLsatMiddleware, error) {
+	return NewLsatMiddlewareWithRootKeyProvider(lnClientConfig, amountFunc, nil)
+}
+
+// NewLsatMiddlewareWithRootKeyProvider signs tokens with the root keys of
+// rootKeyProvider instead of the environment.
+func NewLsatMiddlewareWithRootKeyProvider(lnClientConfig *ln.LNClientConfig,
+	amountFunc func(req *http.Request) (amount int64), rootKeyProvider rootkey.Provider) (*GinLsatMiddleware, error) {
 	lnClient, err := InitLnClient(lnClientConfig)
 	if err != nil {
 		return nil, err
 	}
 	middleware := &GinLsatMiddleware{
-		AmountFunc: amountFunc,
-		LNClient:   lnClient,
+		AmountFunc:      amountFunc,
+		LNClient:        lnClient,
+		RootKeyProvider: rootKeyProvider,
+	}
+	if !lnClientConfig.SkipHealthCheck {
+		ctx, cancel := context.WithTimeout(context.Background(), HEALTH_CHECK_TIMEOUT)
+		defer cancel()
+		err = (&ln.LNClientConn{LNClient: lnClient}).Ping(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("LN backend is unhealthy: %s", err.Error())
+		}
 	}
 	return middleware, nil
 }
@@ -63,7 +321,11 @@ func NewLsatMiddleware(lnClientConfig *ln.LNClientConfig,
 func InitLnClient(lnClientConfig *ln.LNClientConfig) (lnClient ln.LNClient, err error) {
 	switch lnClientConfig.LNClientType {
 	case LND_CLIENT_TYPE:
-		lnClient, err = ln.NewLNDclient(lnClientConfig.LNDConfig)
+		if lnClientConfig.LNDConfig.REST {
+			lnClient, err = ln.NewLNDRESTClient(lnClientConfig.LNDConfig)
+		} else {
+			lnClient, err = ln.NewLNDclient(lnClientConfig.LNDConfig)
+		}
 		if err != nil {
 			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
 		}
@@ -72,20 +334,174 @@ func InitLnClient(lnClientConfig *ln.LNClientConfig) (lnClient ln.LNClient, err
 		if err != nil {
 			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
 		}
+	case CLN_CLIENT_TYPE:
+		lnClient, err = ln.NewCLNClient(lnClientConfig.CLNConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
+	case LNBITS_CLIENT_TYPE:
+		lnClient, err = ln.NewLNbitsClient(lnClientConfig.LNbitsConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
+	case LNDHUB_CLIENT_TYPE:
+		lnClient, err = ln.NewLNDhubClient(lnClientConfig.LNDhubConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
+	case BTCPAY_CLIENT_TYPE:
+		lnClient, err = ln.NewBTCPayClient(lnClientConfig.BTCPayConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
+	case ECLAIR_CLIENT_TYPE:
+		lnClient, err = ln.NewEclairClient(lnClientConfig.EclairConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
+	case OPENNODE_CLIENT_TYPE:
+		lnClient, err = ln.NewOpenNodeClient(lnClientConfig.OpenNodeConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
+	case ZEBEDEE_CLIENT_TYPE:
+		lnClient, err = ln.NewZebedeeClient(lnClientConfig.ZebedeeConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
+	case PHOENIXD_CLIENT_TYPE:
+		lnClient, err = ln.NewPhoenixdClient(lnClientConfig.PhoenixdConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
+	case LNPAY_CLIENT_TYPE:
+		lnClient, err = ln.NewLNPayClient(lnClientConfig.LNPayConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
+	case TAPD_CLIENT_TYPE:
+		lnClient, err = ln.NewTapdClient(lnClientConfig.TapdConfig)
+		if err != nil {
+			return lnClient, fmt.Errorf("Error initializing LN client: %s", err.Error())
+		}
 	default:
 		return lnClient, fmt.Errorf("LN Client type not recognized: %s", lnClientConfig.LNClientType)
 	}
-	return lnClient, nil
+	if len(lnClientConfig.RoundRobin) > 0 {
+		lnClients := []ln.LNClient{lnClient}
+		for _, roundRobinConfig := range lnClientConfig.RoundRobin {
+			roundRobinClient, err := InitLnClient(roundRobinConfig)
+			if err != nil {
+				return nil, err
+			}
+			lnClients = append(lnClients, roundRobinClient)
+		}
+		lnClient = ln.NewRoundRobinClient(lnClients...)
+	}
+	if len(lnClientConfig.Fallbacks) == 0 {
+		return lnClient, nil
+	}
+	lnClients := []ln.LNClient{lnClient}
+	for _, fallbackConfig := range lnClientConfig.Fallbacks {
+		fallback, err := InitLnClient(fallbackConfig)
+		if err != nil {
+			return nil, err
+		}
+		lnClients = append(lnClients, fallback)
+	}
+	return ln.NewFailoverClient(lnClientConfig.FailoverTimeout, lnClients...), nil
 }
 
 func (lsatmiddleware *GinLsatMiddleware) Handler(c *gin.Context) {
+	// Make route params available to pricing and caveat checks
+	if len(c.Params) > 0 {
+		params := map[string]string{}
+		for _, param := range c.Params {
+			params[param.Key] = param.Value
+		}
+		c.Request = utils.WithRouteParams(c.Request, params)
+	}
+	c.Request = utils.WithClientIP(c.Request, c.ClientIP())
+	if route := c.FullPath(); route != "" {
+		c.Request = utils.WithRoute(c.Request, route)
+	}
+	// Make the authenticated user available to pricing and caveat checks
+	if userId := lsatmiddleware.userId(c); userId != "" {
+		c.Request = utils.WithUserId(c.Request, userId)
+	}
+	if lsatmiddleware.ResourceFunc != nil {
+		if resourceId := lsatmiddleware.ResourceFunc(c.Request); resourceId != "" {
+			c.Request = utils.WithResourceId(c.Request, resourceId)
+		}
+	}
+	// Users are never charged twice for a resource
+	if lsatmiddleware.userPurchase(c.Request) != nil {
+		c.Set("LSAT", &LsatInfo{
+			Type: LSAT_TYPE_PAID,
+		})
+		return
+	}
+	if c.Request.Header.Get("Authorization") == "" && lsatmiddleware.verifiedCrawler(c) != nil {
+		lsatmiddleware.serveCrawler(c)
+		return
+	}
+	if lsatmiddleware.Tab != nil && isTabRequest(c.Request) {
+		lsatmiddleware.HandleTab(c)
+		return
+	}
 	//First check for presence of authorization header
 	authField := c.Request.Header.Get("Authorization")
+	x402Payment := lsatmiddleware.X402 && authField == "" && c.Request.Header.Get(x402.PAYMENT_HEADER) != ""
+	if x402Payment {
+		macaroonString, preimageString, err := x402.ParsePaymentHeader(c.Request.Header.Get(x402.PAYMENT_HEADER))
+		if err != nil {
+			lsatmiddleware.setLsatError(c, err)
+			return
+		}
+		authField = fmt.Sprintf("LSAT %s:%s", macaroonString, preimageString)
+	}
+	// gRPC clients of Aperture send the token in a Macaroon header
+	if lsatmiddleware.ApertureCompat && authField == "" {
+		if token, err := lsat.FromHeader(c.Request.Header); err == nil {
+			if authField, err = token.MarshalHeader(); err != nil {
+				lsatmiddleware.setLsatError(c, err)
+				return
+			}
+		}
+	}
+	// A macaroon without preimage is presented with a Cashu token paying
+	// its invoice
+	if lsatmiddleware.isCashuPayment(c.Request) {
+		preimage, err := lsatmiddleware.redeemCashu(c, authField)
+		if err != nil {
+			lsatmiddleware.setLsatError(c, err)
+			return
+		}
+		c.Writer.Header().Set(LSAT_PREIMAGE_HEADER, preimage.String())
+		authField = fmt.Sprintf("%s:%s", strings.TrimSuffix(strings.TrimSpace(authField), ":"), preimage.String())
+	}
 	mac, preimage, err := utils.ParseLsatHeader(authField)
 	if err != nil {
+		// A macaroon without preimage is presented for a paid hold invoice
+		if lsatmiddleware.ChargePolicy == CHARGE_ON_SUCCESS && authField != "" {
+			lsatmiddleware.HandleHoldInvoice(c, authField)
+			return
+		}
+		// A macaroon without preimage is presented for an on-chain payment
+		if lsatmiddleware.Onchain != nil && authField != "" {
+			if mac, err := utils.ParseLsatMacaroonHeader(authField); err == nil && lsatmiddleware.onchainPaymentAddress(mac) != "" {
+				lsatmiddleware.HandleOnchainPayment(c, mac)
+				return
+			}
+		}
+		// A macaroon without preimage is presented for a paid AMP invoice
+		if lsatmiddleware.AMPBearer && authField != "" {
+			lsatmiddleware.HandleAmpInvoice(c, authField)
+			return
+		}
 		// No Authorization present, check if client supports LSAT
 		acceptLsatField := c.Request.Header.Get("Accept")
-		if strings.Contains(acceptLsatField, "application/vnd.lsat.v1.full") {
+		if strings.Contains(acceptLsatField, "application/vnd.lsat.v1.full") || strings.Contains(acceptLsatField, challenge.MEDIA_TYPE) || lsatmiddleware.X402 && x402.IsRequested(c.Request) {
 			lsatmiddleware.SetLSATHeader(c)
 			return
 		}
@@ -96,8 +512,34 @@ func (lsatmiddleware *GinLsatMiddleware) Handler(c *gin.Context) {
 		return
 	}
 	//LSAT Header is present, verify it
-	err = lsat.VerifyLSAT(mac, utils.GetRootKey(), preimage)
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	dischargeMacaroons, err := discharges(c.Request)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	verifiedCaveats := caveat.Set{}
+	paymentHash := preimage.Hash()
+	err = lsat.VerifyLSATWithDischarges(mac, rootKey, dischargeMacaroons, preimage, lsatmiddleware.checkCaveats(c.Request, &verifiedCaveats))
+	// The preimage of an invoice requested from the offer of the token
+	if err != nil && lsatmiddleware.Bolt12 {
+		verifiedCaveats = caveat.Set{}
+		if offerPaymentHash, offerErr := lsatmiddleware.verifyOfferPayment(c, mac, rootKey, preimage, &verifiedCaveats); offerErr == nil {
+			paymentHash, err = offerPaymentHash, nil
+		}
+	}
 	if err != nil {
+		// Tokens stay valid for the resources they bought after expiring
+		if lsatmiddleware.tokenPurchase(c.Request, mac, preimage) != nil {
+			c.Set("LSAT", &LsatInfo{
+				Type: LSAT_TYPE_PAID,
+			})
+			return
+		}
 		//not a valid LSAT
 		c.Error(err)
 		c.Set("LSAT", &LsatInfo{
@@ -105,42 +547,273 @@ func (lsatmiddleware *GinLsatMiddleware) Handler(c *gin.Context) {
 		})
 		return
 	}
+	if lsatmiddleware.RequireSettlement || lsatmiddleware.isAssetPayment(paymentHash) {
+		if err := lsatmiddleware.confirmSettlement(c.Request, paymentHash); err != nil {
+			lsatmiddleware.setLsatError(c, err)
+			return
+		}
+	}
+	admitted, err := lsatmiddleware.admit(c, mac, paymentHash, verifiedCaveats)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	if x402Payment {
+		paymentResponse, err := x402.EncodePaymentResponse(paymentHash.String())
+		if err != nil {
+			c.Error(err)
+		}
+		c.Writer.Header().Set(x402.PAYMENT_RESPONSE_HEADER, paymentResponse)
+	}
 	//LSAT verification ok, mark client as having paid
-	c.Set("LSAT", &LsatInfo{
+	lsatmiddleware.serveAdmitted(c, admitted, &LsatInfo{
 		Type: LSAT_TYPE_PAID,
 	})
-
 }
 
 func (lsatmiddleware *GinLsatMiddleware) SetLSATHeader(c *gin.Context) {
 	// Generate invoice and token
-	ctx := context.Background()
-	lnInvoice := lnrpc.Invoice{
-		Value: lsatmiddleware.AmountFunc(c.Request),
-		Memo:  "LSAT",
-	}
-	LNClientConn := &ln.LNClientConn{
-		LNClient: lsatmiddleware.LNClient,
+	ctx, cancel := lsatmiddleware.lnContext(c.Request.Context())
+	defer cancel()
+	lnInvoice := lsatmiddleware.priceInvoice(c.Request)
+	amount := invoiceAmount(&lnInvoice)
+	lnInvoice.Memo = lsatmiddleware.memo(c.Request, amount)
+	lnInvoice.Expiry = int64(lsatmiddleware.invoiceExpiry(c.Request, amount) / time.Second)
+	lnInvoice.IsAmp = lsatmiddleware.AMPBearer
+	issued := &issuedChallenge{}
+	var asset *ln.AssetAmount
+	var err error
+	if lsatmiddleware.ChargePolicy == CHARGE_ON_SUCCESS {
+		issued.invoice, issued.macaroonString, err = lsatmiddleware.generateHoldChallenge(ctx, &lnInvoice, c.Request)
+	} else {
+		asset = lsatmiddleware.asset(c.Request)
+		issued, err = lsatmiddleware.generateChallenge(ctx, &lnInvoice, asset, c.Request)
 	}
-	invoice, paymentHash, err := LNClientConn.GenerateInvoice(ctx, lnInvoice, c.Request)
 	if err != nil {
-		c.Error(err)
-		c.Set("LSAT", &LsatInfo{
-			Error: err,
-		})
+		lsatmiddleware.degrade(c, amount, err)
 		return
 	}
-	macaroonString, err := macaroonutils.GetMacaroonAsString(paymentHash)
+	if issued.offer != "" {
+		c.Set(BOLT12_OFFER_KEY, issued.offer)
+	}
+	if issued.onchainAddress != "" {
+		c.Set(ONCHAIN_ADDRESS_KEY, issued.onchainAddress)
+	}
+	if asset != nil {
+		c.Set(TAPROOT_ASSET_KEY, asset)
+	}
+	// Cached challenges are reused by other payers, an on-chain address or
+	// asset amount would be lost
+	if asset == nil && issued.onchainAddress == "" {
+		lsatmiddleware.cacheChallenge(amount, issued.macaroonString, issued.invoice)
+	}
+	lsatmiddleware.writeChallenge(c, amount, issued.macaroonString, issued.invoice)
+}
+
+// priceInvoice returns the invoice with the price of req, in msat when
+// AmountMsatFunc is set. LND rejects invoices setting both Value and
+// ValueMsat.
+func (lsatmiddleware *GinLsatMiddleware) priceInvoice(req *http.Request) lnrpc.Invoice {
+	if lsatmiddleware.AmountMsatFunc != nil {
+		return lnrpc.Invoice{
+			ValueMsat: lsatmiddleware.AmountMsatFunc(req),
+		}
+	}
+	return lnrpc.Invoice{
+		Value: lsatmiddleware.AmountFunc(req),
+	}
+}
+
+// invoiceAmount returns the amount of lnInvoice in sats, rounded up.
+func invoiceAmount(lnInvoice *lnrpc.Invoice) int64 {
+	return (ln.InvoiceAmountMsat(lnInvoice) + ln.MSAT_PER_SAT - 1) / ln.MSAT_PER_SAT
+}
+
+// invoiceExpiry returns the expiry of the invoice of amount for req.
+func (lsatmiddleware *GinLsatMiddleware) invoiceExpiry(req *http.Request, amount int64) time.Duration {
+	if lsatmiddleware.InvoiceExpiryFunc != nil {
+		if expiry := lsatmiddleware.InvoiceExpiryFunc(req, amount); expiry > 0 {
+			return expiry
+		}
+	}
+	if lsatmiddleware.InvoiceExpiry > 0 {
+		return lsatmiddleware.InvoiceExpiry
+	}
+	return DEFAULT_INVOICE_EXPIRY * time.Second
+}
+
+// generateChallenge returns the invoice, macaroon and, with Bolt12 or
+// Onchain set, the offer and on-chain address of a challenge. The invoice is
+// paid in asset when it isn't nil.
+func (lsatmiddleware *GinLsatMiddleware) generateChallenge(ctx context.Context, lnInvoice *lnrpc.Invoice, asset *ln.AssetAmount, httpReq *http.Request) (*issuedChallenge, error) {
+	backend, LNClientConn, err := lsatmiddleware.invoicingBackend(ctx, httpReq, invoiceAmount(lnInvoice))
 	if err != nil {
-		c.Error(err)
-		c.Set("LSAT", &LsatInfo{
-			Error: err,
-		})
-		return
+		return nil, err
+	}
+	var invoice string
+	var paymentHash lntypes.Hash
+	if asset != nil {
+		invoice, paymentHash, err = LNClientConn.GenerateAssetInvoice(ctx, lnInvoice, asset)
+	} else {
+		invoice, paymentHash, err = LNClientConn.GenerateInvoice(ctx, *lnInvoice, httpReq)
+	}
+	if err != nil {
+		return nil, err
+	}
+	issued := &issuedChallenge{
+		invoice: invoice,
+	}
+	tokenId, err := macaroonutils.GenerateTokenId()
+	if err != nil {
+		return nil, err
+	}
+	caveats := lsatmiddleware.mintCaveats(httpReq)
+	offer, err := lsatmiddleware.createOffer(ctx, LNClientConn, lnInvoice)
+	if err != nil {
+		return nil, err
+	}
+	if offer != nil {
+		issued.offer = offer.Offer
+		caveats = append(caveats, caveat.New(caveat.OFFER_ID, offer.Id).String())
+	}
+	if asset == nil {
+		issued.onchainAddress, err = lsatmiddleware.onchainAddress(ctx, LNClientConn, invoiceAmount(lnInvoice))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if issued.onchainAddress != "" {
+		caveats = append(caveats, caveat.New(caveat.ONCHAIN_ADDRESS, issued.onchainAddress).String())
+	}
+	keyId, rootKey, err := lsatmiddleware.mintingRootKey(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	caveats, err = lsatmiddleware.encryptCaveats(rootKey, tokenId, caveats)
+	if err != nil {
+		return nil, err
+	}
+	issued.macaroonString, err = macaroonutils.GetMacaroonForRootKeyAsString(rootKey, keyId, paymentHash, tokenId, caveats...)
+	if err != nil {
+		return nil, err
+	}
+	issued.macaroonString, err = macaroonutils.AddThirdPartyCaveats(issued.macaroonString, lsatmiddleware.ThirdPartyCaveats...)
+	if err != nil {
+		return nil, err
+	}
+	issued.macaroonString, err = lsatmiddleware.encodeMacaroon(issued.macaroonString)
+	if err != nil {
+		return nil, err
+	}
+	if err := lsatmiddleware.recordPayment(httpReq, backend, paymentHash, tokenId, ln.InvoiceAmountMsat(lnInvoice), invoice); err != nil {
+		return nil, err
+	}
+	if asset != nil {
+		if err := lsatmiddleware.recordAsset(paymentHash, asset); err != nil {
+			return nil, err
+		}
+	} else if lnInvoice.IsAmp {
+		if err := lsatmiddleware.recordAmp(paymentHash); err != nil {
+			return nil, err
+		}
+	}
+	if offer != nil {
+		if err := lsatmiddleware.recordOffer(paymentHash, offer.Id); err != nil {
+			return nil, err
+		}
+	}
+	if issued.onchainAddress != "" {
+		if err := lsatmiddleware.recordOnchainAddress(paymentHash, issued.onchainAddress); err != nil {
+			return nil, err
+		}
+	}
+	return issued, nil
+}
+
+func (lsatmiddleware *GinLsatMiddleware) userId(c *gin.Context) string {
+	if lsatmiddleware.UserIdFunc != nil {
+		return lsatmiddleware.UserIdFunc(c)
+	}
+	return c.GetString(gin.AuthUserKey)
+}
+
+// pathPattern returns the path pattern tokens minted for req are bound to
+// according to BindPath, "" for none.
+func (lsatmiddleware *GinLsatMiddleware) pathPattern(req *http.Request) string {
+	switch lsatmiddleware.BindPath {
+	case BIND_PATH_EXACT:
+		return req.URL.Path
+	case BIND_PATH_ROUTE:
+		if route := utils.GetRoute(req); route != "" {
+			return caveat.RoutePattern(route)
+		}
+		return req.URL.Path
+	case BIND_PATH_PREFIX:
+		return strings.TrimSuffix(path.Dir(req.URL.Path), "/") + "/*"
+	}
+	return ""
+}
+
+func (lsatmiddleware *GinLsatMiddleware) mintCaveats(req *http.Request) []string {
+	caveats := []string{}
+	if userId := utils.GetUserId(req); userId != "" {
+		caveats = append(caveats, caveat.New(caveat.USER, userId).String())
+	}
+	if resourceId := utils.GetResourceId(req); resourceId != "" {
+		caveats = append(caveats, caveat.New(caveat.RESOURCE, resourceId).String())
+	}
+	if pathPattern := lsatmiddleware.pathPattern(req); pathPattern != "" {
+		caveats = append(caveats, caveat.New(caveat.PATH, pathPattern).String())
+	}
+	if lsatmiddleware.BindClientCert {
+		if fingerprint := utils.GetClientCertFingerprint(req); fingerprint != "" {
+			caveats = append(caveats, caveat.New(caveat.CLIENT_CERT, fingerprint).String())
+		}
+	}
+	if lsatmiddleware.BindIP {
+		if clientIP := net.ParseIP(utils.GetClientIP(req)); clientIP != nil {
+			bits := lsatmiddleware.BindIPv6PrefixLength
+			if clientIP.To4() != nil {
+				bits = lsatmiddleware.BindIPv4PrefixLength
+			}
+			caveats = append(caveats, caveat.New(caveat.IP, caveat.IPNetwork(clientIP, bits)).String())
+		}
+	}
+	if capabilities := lsatmiddleware.mintCapabilities(req); len(capabilities) > 0 {
+		caveats = append(caveats, caveat.New(caveat.CAPABILITIES, strings.Join(capabilities, ",")).String())
+	}
+	if lsatmiddleware.TokenTTL > 0 {
+		for _, expiry := range caveat.NewBuilder().Expiry(lsatmiddleware.TokenTTL).Build() {
+			caveats = append(caveats, expiry.String())
+		}
+	}
+	for _, caveat := range lsatmiddleware.Caveats {
+		caveats = append(caveats, caveat.String())
+	}
+	if lsatmiddleware.CaveatFunc == nil {
+		return caveats
+	}
+	for _, caveat := range lsatmiddleware.CaveatFunc(req) {
+		caveats = append(caveats, caveat.String())
+	}
+	return caveats
+}
+
+// checkCaveats verifies caveats against req and collects the verified
+// caveats in verified when it is not nil.
+func (lsatmiddleware *GinLsatMiddleware) checkCaveats(req *http.Request, verified *caveat.Set) func(caveat string) error {
+	check := caveat.Check(req, lsatmiddleware.CaveatCheckers)
+	return func(caveatString string) error {
+		if err := check(caveatString); err != nil {
+			return err
+		}
+		if verified != nil {
+			parsed, err := caveat.Parse(caveatString)
+			if err != nil {
+				return err
+			}
+			*verified = append(*verified, parsed)
+		}
+		return nil
 	}
-	c.Writer.Header().Set("WWW-Authenticate", fmt.Sprintf("LSAT macaroon=%s, invoice=%s", macaroonString, invoice))
-	c.AbortWithStatusJSON(http.StatusPaymentRequired, gin.H{
-		"code":    http.StatusPaymentRequired,
-		"message": PAYMENT_REQUIRED_MESSAGE,
-	})
 }
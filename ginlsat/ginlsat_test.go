@@ -0,0 +1,327 @@
+package ginlsat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/payment"
+	"github.com/kiwiidb/gin-lsat/rootkey"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+const TEST_AMOUNT = 10
+
+// fakeLNClient is an LN backend keeping its invoices in memory, with an
+// on-chain wallet and BOLT12 offers
+type fakeLNClient struct {
+	mu        sync.Mutex
+	preimages map[lntypes.Hash]lntypes.Preimage
+	paid      map[lntypes.Hash]bool
+	// holds are the states of hold invoices by payment hash
+	holds     map[lntypes.Hash]lnrpc.Invoice_InvoiceState
+	addresses int
+	utxos     []*lnrpc.Utxo
+	offers    int
+	// paidOffers maps offer ids to the payment hash of the invoice paid
+	paidOffers map[string]lntypes.Hash
+	// keysends are the msat paid with keysend
+	keysends []int64
+}
+
+func newFakeLNClient() *fakeLNClient {
+	return &fakeLNClient{
+		preimages:  map[lntypes.Hash]lntypes.Preimage{},
+		paid:       map[lntypes.Hash]bool{},
+		holds:      map[lntypes.Hash]lnrpc.Invoice_InvoiceState{},
+		paidOffers: map[string]lntypes.Hash{},
+	}
+}
+
+func (client *fakeLNClient) AddInvoice(ctx context.Context, lnReq *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	preimage := lntypes.Preimage{}
+	if _, err := rand.Read(preimage[:]); err != nil {
+		return nil, err
+	}
+	hash := preimage.Hash()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.preimages[hash] = preimage
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          hash[:],
+		PaymentRequest: "lnbcrt" + hash.String(),
+	}, nil
+}
+
+func (client *fakeLNClient) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	hash, err := lntypes.MakeHash(req.RHash)
+	if err != nil {
+		return nil, err
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if state, ok := client.holds[hash]; ok {
+		return &lnrpc.Invoice{
+			RHash: hash[:],
+			State: state,
+		}, nil
+	}
+	if _, ok := client.preimages[hash]; !ok {
+		return nil, fmt.Errorf("Invoice not found: %s", hash)
+	}
+	invoice := &lnrpc.Invoice{
+		RHash: hash[:],
+		State: lnrpc.Invoice_OPEN,
+	}
+	if client.paid[hash] {
+		invoice.State = lnrpc.Invoice_SETTLED
+	}
+	return invoice, nil
+}
+
+func (client *fakeLNClient) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	hash, err := lntypes.MakeHash(req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.holds[hash] = lnrpc.Invoice_OPEN
+	return &invoicesrpc.AddHoldInvoiceResp{
+		PaymentRequest: "lnbcrt" + hash.String(),
+	}, nil
+}
+
+func (client *fakeLNClient) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	preimage, err := lntypes.MakePreimage(req.Preimage)
+	if err != nil {
+		return nil, err
+	}
+	return &invoicesrpc.SettleInvoiceResp{}, client.resolveHold(preimage.Hash(), lnrpc.Invoice_SETTLED)
+}
+
+func (client *fakeLNClient) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	hash, err := lntypes.MakeHash(req.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	return &invoicesrpc.CancelInvoiceResp{}, client.resolveHold(hash, lnrpc.Invoice_CANCELED)
+}
+
+// resolveHold settles or cancels the accepted hold invoice of hash
+func (client *fakeLNClient) resolveHold(hash lntypes.Hash, state lnrpc.Invoice_InvoiceState) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.holds[hash] != lnrpc.Invoice_ACCEPTED {
+		return fmt.Errorf("Hold invoice %s is not accepted", hash)
+	}
+	client.holds[hash] = state
+	return nil
+}
+
+// accept accepts the HTLCs paying the hold invoice of paymentHash
+func (client *fakeLNClient) accept(paymentHash lntypes.Hash) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.holds[paymentHash] = lnrpc.Invoice_ACCEPTED
+}
+
+func (client *fakeLNClient) holdState(paymentHash lntypes.Hash) lnrpc.Invoice_InvoiceState {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.holds[paymentHash]
+}
+
+func (client *fakeLNClient) SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest, options ...grpc.CallOption) (*lnrpc.SendResponse, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.keysends = append(client.keysends, req.AmtMsat)
+	return &lnrpc.SendResponse{}, nil
+}
+
+func (client *fakeLNClient) NewAddress(ctx context.Context, req *lnrpc.NewAddressRequest, options ...grpc.CallOption) (*lnrpc.NewAddressResponse, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.addresses++
+	return &lnrpc.NewAddressResponse{
+		Address: fmt.Sprintf("bcrt1qaddress%d", client.addresses),
+	}, nil
+}
+
+func (client *fakeLNClient) ListUnspent(ctx context.Context, req *lnrpc.ListUnspentRequest, options ...grpc.CallOption) (*lnrpc.ListUnspentResponse, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return &lnrpc.ListUnspentResponse{
+		Utxos: client.utxos,
+	}, nil
+}
+
+func (client *fakeLNClient) CreateOffer(ctx context.Context, amountMsat int64, description string) (*ln.Offer, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.offers++
+	return &ln.Offer{
+		Id:         fmt.Sprintf("offer%d", client.offers),
+		Offer:      fmt.Sprintf("lno%d", client.offers),
+		AmountMsat: amountMsat,
+	}, nil
+}
+
+func (client *fakeLNClient) IsOfferPaid(ctx context.Context, offerId string, paymentHash lntypes.Hash) (bool, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	paid, ok := client.paidOffers[offerId]
+	return ok && paid == paymentHash, nil
+}
+
+// fund confirms an on-chain payment of amount sats to address
+func (client *fakeLNClient) fund(address string, amount int64) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.utxos = append(client.utxos, &lnrpc.Utxo{
+		Address:       address,
+		AmountSat:     amount,
+		Confirmations: 6,
+	})
+}
+
+// payOffer pays offerId with a fresh invoice and returns its preimage
+func (client *fakeLNClient) payOffer(offerId string) lntypes.Preimage {
+	preimage := lntypes.Preimage{}
+	rand.Read(preimage[:])
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.paidOffers[offerId] = preimage.Hash()
+	return preimage
+}
+
+// pay settles the invoice of paymentHash and returns its preimage
+func (client *fakeLNClient) pay(paymentHash lntypes.Hash) lntypes.Preimage {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.paid[paymentHash] = true
+	return client.preimages[paymentHash]
+}
+
+func (client *fakeLNClient) preimage(paymentHash lntypes.Hash) lntypes.Preimage {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.preimages[paymentHash]
+}
+
+func newTestMiddleware(client *fakeLNClient) *GinLsatMiddleware {
+	return &GinLsatMiddleware{
+		AmountFunc: func(req *http.Request) int64 {
+			return TEST_AMOUNT
+		},
+		LNClient:        client,
+		RootKeyProvider: rootkey.NewMemoryProvider([]byte("gin-lsat-test-root-key")),
+		Payments:        payment.NewMemoryStore(),
+	}
+}
+
+// testRouter serves path behind the middleware, responding with the type
+// and tier of the token
+func testRouter(lsatmiddleware *GinLsatMiddleware, path string, handlers ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handlers = append(handlers, lsatmiddleware.Handler, respondWithLsatInfo)
+	router.Any(path, handlers...)
+	return router
+}
+
+// respondWithLsatInfo responds with the type, tier and error of the token
+func respondWithLsatInfo(c *gin.Context) {
+	lsatInfo := c.Value("LSAT").(*LsatInfo)
+	tier, _ := lsatInfo.Caveats.Tier()
+	c.JSON(http.StatusOK, gin.H{
+		"type":  lsatInfo.Type,
+		"tier":  tier,
+		"error": fmt.Sprint(lsatInfo.Error),
+	})
+}
+
+func serve(router http.Handler, method string, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+// requestChallenge requests the 402 challenge of path
+func requestChallenge(t *testing.T, router http.Handler, path string) *lsat.Challenge {
+	res := serve(router, http.MethodGet, path, http.Header{
+		"Accept": {"application/vnd.lsat.v1.full+json"},
+	})
+	assert.Equal(t, http.StatusPaymentRequired, res.Code)
+	lsatChallenge, err := lsat.ParseChallenge(res.Header().Get("WWW-Authenticate"))
+	assert.NoError(t, err)
+	return lsatChallenge
+}
+
+// challengeToken returns the token of lsatChallenge, paid when preimage is
+// set, narrowed down by caveats
+func challengeToken(t *testing.T, lsatChallenge *lsat.Challenge, preimage lntypes.Preimage, caveats ...caveat.Caveat) *lsat.Token {
+	token, err := lsatChallenge.Token()
+	assert.NoError(t, err)
+	token.Preimage = preimage
+	if len(caveats) == 0 {
+		return token
+	}
+	token, err = token.Attenuate(caveats...)
+	assert.NoError(t, err)
+	return token
+}
+
+// authorization returns the Authorization header presenting token
+func authorization(t *testing.T, token *lsat.Token) http.Header {
+	header, err := token.MarshalHeader()
+	assert.NoError(t, err)
+	return http.Header{
+		"Authorization": {header},
+	}
+}
+
+// paidToken pays the challenge of path and returns its token narrowed down
+// by caveats
+func paidToken(t *testing.T, client *fakeLNClient, router http.Handler, path string, caveats ...caveat.Caveat) *lsat.Token {
+	lsatChallenge := requestChallenge(t, router, path)
+	token := challengeToken(t, lsatChallenge, lntypes.Preimage{})
+	return challengeToken(t, lsatChallenge, client.preimage(token.PaymentHash()), caveats...)
+}
+
+type testResponse struct {
+	Type  string `json:"type"`
+	Tier  string `json:"tier"`
+	Error string `json:"error"`
+}
+
+func decodeResponse(t *testing.T, res *httptest.ResponseRecorder) testResponse {
+	response := testResponse{}
+	if res.Code != http.StatusOK {
+		return response
+	}
+	assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+	return response
+}
+
+func tokenType(t *testing.T, res *httptest.ResponseRecorder) string {
+	return decodeResponse(t, res).Type
+}
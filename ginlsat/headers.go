@@ -0,0 +1,32 @@
+package ginlsat
+
+import (
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LSAT_EXPIRES_AT_HEADER = "X-Lsat-Expires-At"
+	LSAT_STATUS_HEADER     = "X-Lsat-Status"
+	LSAT_STATUS_VALID      = "valid"
+	LSAT_STATUS_EXPIRING   = "expiring"
+)
+
+// setStatusHeaders tells clients when their token expires, so they can
+// renew it before running into a 402.
+func (lsatmiddleware *GinLsatMiddleware) setStatusHeaders(c *gin.Context, verifiedCaveats caveat.Set) {
+	if !lsatmiddleware.StatusHeaders {
+		return
+	}
+	status := LSAT_STATUS_VALID
+	if expiresAt, ok := verifiedCaveats.ExpiresAt(); ok {
+		c.Writer.Header().Set(LSAT_EXPIRES_AT_HEADER, expiresAt.UTC().Format(time.RFC3339))
+		if time.Until(expiresAt) < lsatmiddleware.ExpiryWarning {
+			status = LSAT_STATUS_EXPIRING
+		}
+	}
+	c.Writer.Header().Set(LSAT_STATUS_HEADER, status)
+}
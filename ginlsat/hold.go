@@ -0,0 +1,211 @@
+package ginlsat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/payment"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// derivePreimage derives the preimage of a hold invoice from the token id,
+// so it never has to be stored.
+func derivePreimage(rootKey []byte, tokenId [32]byte) lntypes.Preimage {
+	mac := hmac.New(sha256.New, rootKey)
+	mac.Write(tokenId[:])
+	var preimage lntypes.Preimage
+	copy(preimage[:], mac.Sum(nil))
+	return preimage
+}
+
+func (lsatmiddleware *GinLsatMiddleware) generateHoldChallenge(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request) (string, string, error) {
+	tokenId, err := macaroonutils.GenerateTokenId()
+	if err != nil {
+		return "", "", err
+	}
+	keyId, rootKey, err := lsatmiddleware.mintingRootKey(httpReq)
+	if err != nil {
+		return "", "", err
+	}
+	preimage := derivePreimage(rootKey, tokenId)
+	paymentHash := preimage.Hash()
+	backend, LNClientConn, err := lsatmiddleware.invoicingBackend(ctx, httpReq, invoiceAmount(lnInvoice))
+	if err != nil {
+		return "", "", err
+	}
+	invoice, err := LNClientConn.GenerateHoldInvoice(ctx, lnInvoice, paymentHash)
+	if err != nil {
+		return "", "", err
+	}
+	caveats, err := lsatmiddleware.encryptCaveats(rootKey, tokenId, lsatmiddleware.mintCaveats(httpReq))
+	if err != nil {
+		return "", "", err
+	}
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, keyId, paymentHash, tokenId, caveats...)
+	if err != nil {
+		return "", "", err
+	}
+	macaroonString, err = macaroonutils.AddThirdPartyCaveats(macaroonString, lsatmiddleware.ThirdPartyCaveats...)
+	if err != nil {
+		return "", "", err
+	}
+	macaroonString, err = lsatmiddleware.encodeMacaroon(macaroonString)
+	if err != nil {
+		return "", "", err
+	}
+	if err := lsatmiddleware.recordPayment(httpReq, backend, paymentHash, tokenId, ln.InvoiceAmountMsat(lnInvoice), invoice); err != nil {
+		return "", "", err
+	}
+	return invoice, macaroonString, nil
+}
+
+// HandleHoldInvoice serves a request paid with a hold invoice and only
+// settles it when the protected handler responds with a 2xx status.
+// The invoice is cancelled on any other status or when the handler
+// exceeds HoldTimeout.
+func (lsatmiddleware *GinLsatMiddleware) HandleHoldInvoice(c *gin.Context, authField string) {
+	mac, err := utils.ParseLsatMacaroonHeader(authField)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	dischargeMacaroons, err := discharges(c.Request)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	verifiedCaveats := caveat.Set{}
+	macaroonId, err := lsat.VerifyMacaroonWithDischarges(mac, rootKey, dischargeMacaroons, lsatmiddleware.checkCaveats(c.Request, &verifiedCaveats))
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	preimage := derivePreimage(rootKey, macaroonId.TokenId)
+	if preimage.Hash() != macaroonId.PaymentHash {
+		lsatmiddleware.setLsatError(c, fmt.Errorf("Macaroon was not issued for a hold invoice"))
+		return
+	}
+
+	ctx, cancel := lsatmiddleware.lnContext(c.Request.Context())
+	defer cancel()
+	_, LNClientConn, err := lsatmiddleware.paymentBackend(c.Request, macaroonId.PaymentHash)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	// A hold invoice pays for a single response, concurrent requests would
+	// be served before it is settled. Take the slot before checking the
+	// invoice, so it can't be settled by a request in between.
+	if _, redeeming := lsatmiddleware.holds.LoadOrStore(macaroonId.PaymentHash, true); redeeming {
+		lsatmiddleware.setLsatError(c, fmt.Errorf("Hold invoice for PaymentHash %s is already being redeemed", macaroonId.PaymentHash))
+		return
+	}
+	defer lsatmiddleware.holds.Delete(macaroonId.PaymentHash)
+	if err := lsatmiddleware.checkHoldRedeemed(macaroonId.PaymentHash); err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	accepted, err := LNClientConn.IsInvoiceAccepted(ctx, macaroonId.PaymentHash)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	if !accepted {
+		lsatmiddleware.setLsatError(c, fmt.Errorf("Hold invoice for PaymentHash %s is not paid", macaroonId.PaymentHash))
+		return
+	}
+	admitted, err := lsatmiddleware.admit(c, mac, macaroonId.PaymentHash, verifiedCaveats)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	defer admitted.release()
+
+	c.Set("LSAT", &LsatInfo{
+		Type:    LSAT_TYPE_PAID,
+		Caveats: verifiedCaveats,
+	})
+	lsatmiddleware.setStatusHeaders(c, verifiedCaveats)
+	setQuotaHeaders(c, admitted.quota)
+	// Settle or cancel even when the client went away meanwhile
+	ctx, cancel = lsatmiddleware.lnContext(context.Background())
+	defer cancel()
+	// Release the payment when the handler panics instead of locking it up
+	// until the invoice expires
+	defer func() {
+		if r := recover(); r != nil {
+			if err := LNClientConn.CancelHoldInvoice(ctx, macaroonId.PaymentHash); err != nil {
+				c.Error(err)
+			}
+			panic(r)
+		}
+	}()
+	start := time.Now()
+	lsatmiddleware.serveMetered(c, admitted)
+
+	status := c.Writer.Status()
+	timedOut := lsatmiddleware.HoldTimeout > 0 && time.Since(start) > lsatmiddleware.HoldTimeout
+	if status >= 200 && status < 300 && !timedOut && c.Request.Context().Err() == nil {
+		err = LNClientConn.SettleHoldInvoice(ctx, preimage)
+		if err == nil {
+			err = lsatmiddleware.markHoldRedeemed(macaroonId.PaymentHash)
+		}
+		// Limited tokens are counted by useQuota
+		if err == nil && admitted.quota == nil {
+			err = lsatmiddleware.recordPaymentUsage(macaroonId.PaymentHash)
+		}
+	} else {
+		err = LNClientConn.CancelHoldInvoice(ctx, macaroonId.PaymentHash)
+	}
+	if err != nil {
+		c.Error(err)
+	}
+}
+
+// checkHoldRedeemed returns an error when the hold invoice of paymentHash
+// was settled already, so its token can't be replayed on another replica.
+func (lsatmiddleware *GinLsatMiddleware) checkHoldRedeemed(paymentHash lntypes.Hash) error {
+	if lsatmiddleware.Payments == nil {
+		return nil
+	}
+	p, err := lsatmiddleware.Payments.Get(paymentHash)
+	if err != nil {
+		return nil
+	}
+	if !p.ConfirmedAt.IsZero() {
+		return fmt.Errorf("Hold invoice for PaymentHash %s was already redeemed", paymentHash)
+	}
+	return nil
+}
+
+// markHoldRedeemed records the settlement of the hold invoice of
+// paymentHash in the payment store.
+func (lsatmiddleware *GinLsatMiddleware) markHoldRedeemed(paymentHash lntypes.Hash) error {
+	if lsatmiddleware.Payments == nil {
+		return nil
+	}
+	_, err := payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		if p.ConfirmedAt.IsZero() {
+			p.ConfirmedAt = time.Now()
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,50 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldInvoiceIsRedeemedOnce(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.ChargePolicy = CHARGE_ON_SUCCESS
+	entered, finish := make(chan bool), make(chan bool)
+	router := gin.New()
+	router.GET("/reports", lsatmiddleware.Handler, func(c *gin.Context) {
+		if c.Value("LSAT").(*LsatInfo).Type == LSAT_TYPE_PAID {
+			entered <- true
+			<-finish
+		}
+	}, respondWithLsatInfo)
+
+	token := challengeToken(t, requestChallenge(t, router, "/reports"), lntypes.Preimage{})
+	client.accept(token.PaymentHash())
+	served := make(chan testResponse)
+	go func() {
+		served <- decodeResponse(t, serve(router, http.MethodGet, "/reports", macaroonAuthorization(t, token)))
+	}()
+	<-entered
+	// The invoice is still accepted while the first request is served
+	response := decodeResponse(t, serve(router, http.MethodGet, "/reports", macaroonAuthorization(t, token)))
+	assert.NotEqual(t, LSAT_TYPE_PAID, response.Type)
+	assert.Contains(t, response.Error, "is already being redeemed")
+	finish <- true
+	assert.Equal(t, LSAT_TYPE_PAID, (<-served).Type)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, client.holdState(token.PaymentHash()))
+	p, err := lsatmiddleware.Payments.Get(token.PaymentHash())
+	assert.NoError(t, err)
+	assert.False(t, p.ConfirmedAt.IsZero())
+
+	// A replica whose backend still reports the invoice accepted rejects
+	// the settled hold invoice
+	client.accept(token.PaymentHash())
+	response = decodeResponse(t, serve(router, http.MethodGet, "/reports", macaroonAuthorization(t, token)))
+	assert.NotEqual(t, LSAT_TYPE_PAID, response.Type)
+	assert.Contains(t, response.Error, "was already redeemed")
+}
@@ -0,0 +1,89 @@
+package ginlsat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/kiwiidb/gin-lsat/ln"
+)
+
+const (
+	// Issue the invoice anyway, OnInsufficient is still called
+	LIQUIDITY_POLICY_WARN = "WARN"
+	// Fail the challenge instead of issuing an unpayable invoice
+	LIQUIDITY_POLICY_REJECT = "REJECT"
+	// Issue the invoice with the first fallback backend that has enough
+	// liquidity, fail the challenge when none has
+	LIQUIDITY_POLICY_FALLBACK = "FALLBACK"
+)
+
+// LiquidityConfig checks the inbound liquidity of the backend before issuing
+// an invoice. Backends that don't report their liquidity, like LNURL, are
+// assumed to have enough.
+type LiquidityConfig struct {
+	// Policy is one of LIQUIDITY_POLICY_WARN (default), LIQUIDITY_POLICY_REJECT
+	// or LIQUIDITY_POLICY_FALLBACK
+	Policy string
+	// RequireSingleChannel requires a channel that can receive the full amount,
+	// for payers that don't support multi-part payments
+	RequireSingleChannel bool
+	// Fallbacks are the backend names tried in order by LIQUIDITY_POLICY_FALLBACK
+	Fallbacks []string
+	// OnInsufficient is called when a backend lacks the liquidity to receive
+	// amount, e.g. to increment a metric
+	OnInsufficient func(backend string, amount int64, liquidity *ln.InboundLiquidity)
+}
+
+// invoicingBackend returns the backend issuing an invoice of amount for req
+// according to the liquidity policy.
+func (lsatmiddleware *GinLsatMiddleware) invoicingBackend(ctx context.Context, req *http.Request, amount int64) (string, *ln.LNClientConn, error) {
+	name, lnClientConn, err := lsatmiddleware.lnClientConn(req)
+	if err == nil && lnClientConn.Breaker != nil && lnClientConn.Breaker.IsOpen() && lsatmiddleware.CircuitBreaker.Fallback != "" {
+		name, lnClientConn, err = lsatmiddleware.backend(lsatmiddleware.CircuitBreaker.Fallback)
+	}
+	if err != nil || lsatmiddleware.Liquidity == nil {
+		return name, lnClientConn, err
+	}
+	if lsatmiddleware.hasLiquidity(ctx, name, lnClientConn, amount) {
+		return name, lnClientConn, nil
+	}
+	switch lsatmiddleware.Liquidity.Policy {
+	case LIQUIDITY_POLICY_REJECT:
+		return name, nil, fmt.Errorf("LN backend %s lacks the inbound liquidity to receive %d sats", name, amount)
+	case LIQUIDITY_POLICY_FALLBACK:
+		for _, fallback := range lsatmiddleware.Liquidity.Fallbacks {
+			fallbackName, fallbackConn, err := lsatmiddleware.backend(fallback)
+			if err != nil {
+				continue
+			}
+			if lsatmiddleware.hasLiquidity(ctx, fallbackName, fallbackConn, amount) {
+				return fallbackName, fallbackConn, nil
+			}
+		}
+		return name, nil, fmt.Errorf("No LN backend has the inbound liquidity to receive %d sats", amount)
+	}
+	return name, lnClientConn, nil
+}
+
+func (lsatmiddleware *GinLsatMiddleware) hasLiquidity(ctx context.Context, name string, lnClientConn *ln.LNClientConn, amount int64) bool {
+	if _, ok := lnClientConn.LNClient.(ln.LiquidityClient); !ok {
+		return true
+	}
+	liquidity, err := lnClientConn.InboundLiquidity(ctx)
+	if err != nil {
+		// Don't block challenges when the node can't be asked
+		return true
+	}
+	receivable := liquidity.Total
+	if lsatmiddleware.Liquidity.RequireSingleChannel {
+		receivable = liquidity.MaxChannel
+	}
+	if receivable >= amount {
+		return true
+	}
+	if lsatmiddleware.Liquidity.OnInsufficient != nil {
+		lsatmiddleware.Liquidity.OnInsufficient(name, amount, liquidity)
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package ginlsat
+
+import (
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// DEFAULT_MEMO is the memo of invoices when no MemoFunc is set
+const DEFAULT_MEMO = "LSAT"
+
+// MemoData is passed to memo templates
+type MemoData struct {
+	Method string
+	Host   string
+	Path   string
+	Amount int64
+}
+
+// MemoTemplate returns a MemoFunc executing the text/template text, e.g.
+// "{{.Amount}} sats for {{.Method}} {{.Path}}". Memos that fail to render
+// fall back to DEFAULT_MEMO.
+func MemoTemplate(text string) (func(req *http.Request, amount int64) string, error) {
+	memoTemplate, err := template.New("memo").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request, amount int64) string {
+		memo := &strings.Builder{}
+		err := memoTemplate.Execute(memo, &MemoData{
+			Method: req.Method,
+			Host:   req.Host,
+			Path:   req.URL.Path,
+			Amount: amount,
+		})
+		if err != nil {
+			return DEFAULT_MEMO
+		}
+		return memo.String()
+	}, nil
+}
+
+// memo returns the memo of the invoice of amount for req.
+func (lsatmiddleware *GinLsatMiddleware) memo(req *http.Request, amount int64) string {
+	if lsatmiddleware.MemoFunc == nil {
+		return DEFAULT_MEMO
+	}
+	if memo := lsatmiddleware.MemoFunc(req, amount); memo != "" {
+		return memo
+	}
+	return DEFAULT_MEMO
+}
@@ -0,0 +1,36 @@
+package ginlsat
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ORIGINAL_METHOD_HEADER = "X-Original-Method"
+	ORIGINAL_URI_HEADER    = "X-Original-URI"
+	// STATUS_ONLY_CHALLENGE_KEY makes the middleware respond to unpaid
+	// requests with a 401 carrying only the WWW-Authenticate header
+	STATUS_ONLY_CHALLENGE_KEY = "LSAT_STATUS_ONLY_CHALLENGE"
+)
+
+// NginxAuthHandler implements the auth_request subrequest of NGINX, which
+// only looks at the status: 200 for paid requests with an X-Lsat-Type
+// header, 401 with the WWW-Authenticate challenge for unpaid requests and
+// 403 for invalid tokens. The proxied request is described by the
+// X-Original-Method and X-Original-URI headers. Like ForwardAuthHandler it
+// doesn't support CHARGE_ON_SUCCESS.
+func (lsatmiddleware *GinLsatMiddleware) NginxAuthHandler(c *gin.Context) {
+	c.Request = forwardedRequest(c.Request, c.Request.Header.Get(ORIGINAL_METHOD_HEADER), c.Request.Header.Get(ORIGINAL_URI_HEADER), "")
+	c.Set(STATUS_ONLY_CHALLENGE_KEY, true)
+	lsatInfo := lsatmiddleware.authorize(c)
+	if c.IsAborted() {
+		return
+	}
+	if lsatInfo.Error != nil {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	c.Writer.Header().Set(LSAT_TYPE_HEADER, lsatInfo.Type)
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,153 @@
+package ginlsat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/payment"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"gopkg.in/macaroon.v2"
+)
+
+// ONCHAIN_ADDRESS_KEY holds the on-chain address of the challenge in the
+// gin context
+const ONCHAIN_ADDRESS_KEY = "LSAT_ONCHAIN_ADDRESS"
+
+const DEFAULT_ONCHAIN_CONFIRMATIONS = 1
+
+// OnchainConfig adds an on-chain address of the backend's wallet to
+// challenges of large amounts, for payers whose invoice exceeds the inbound
+// liquidity of the node. Tokens paid on-chain are presented without
+// preimage and accepted once the payment is confirmed.
+type OnchainConfig struct {
+	// MinAmount is the amount in sats from which challenges include an
+	// address
+	MinAmount int64
+	// Confirmations required before a token is accepted, defaults to
+	// DEFAULT_ONCHAIN_CONFIRMATIONS
+	Confirmations int32
+}
+
+// onchainAddress returns a fresh address of the backend to pay amount to,
+// "" when Onchain is disabled, amount is too small or the backend has no
+// on-chain wallet.
+func (lsatmiddleware *GinLsatMiddleware) onchainAddress(ctx context.Context, LNClientConn *ln.LNClientConn, amount int64) (string, error) {
+	if lsatmiddleware.Onchain == nil || amount < lsatmiddleware.Onchain.MinAmount || !LNClientConn.SupportsOnchain() {
+		return "", nil
+	}
+	if lsatmiddleware.Payments == nil {
+		return "", fmt.Errorf("On-chain payments require a payment store")
+	}
+	return LNClientConn.NewOnchainAddress(ctx)
+}
+
+// recordOnchainAddress records the on-chain address the challenge of
+// paymentHash can be paid to.
+func (lsatmiddleware *GinLsatMiddleware) recordOnchainAddress(paymentHash lntypes.Hash, address string) error {
+	_, err := payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		p.OnchainAddress = address
+		return nil
+	})
+	return err
+}
+
+// onchainPaymentAddress returns the on-chain address recorded for the
+// payment of mac, "" for tokens issued without one. The onchain_address
+// caveat is only informative, a holder can add one when attenuating.
+func (lsatmiddleware *GinLsatMiddleware) onchainPaymentAddress(mac *macaroon.Macaroon) string {
+	if lsatmiddleware.Payments == nil {
+		return ""
+	}
+	macaroonId, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return ""
+	}
+	p, err := lsatmiddleware.Payments.Get(macaroonId.PaymentHash)
+	if err != nil {
+		return ""
+	}
+	return p.OnchainAddress
+}
+
+// HandleOnchainPayment serves a request presenting a macaroon without
+// preimage that was paid to its on-chain address. The wallet is only asked
+// until the payment is confirmed.
+func (lsatmiddleware *GinLsatMiddleware) HandleOnchainPayment(c *gin.Context, mac *macaroon.Macaroon) {
+	if lsatmiddleware.Payments == nil {
+		lsatmiddleware.setLsatError(c, fmt.Errorf("On-chain payments require a payment store"))
+		return
+	}
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	dischargeMacaroons, err := discharges(c.Request)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	verifiedCaveats := caveat.Set{}
+	macaroonId, err := lsat.VerifyMacaroonWithDischarges(mac, rootKey, dischargeMacaroons, lsatmiddleware.checkCaveats(c.Request, &verifiedCaveats))
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	p, err := lsatmiddleware.Payments.Get(macaroonId.PaymentHash)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, fmt.Errorf("Payment %s is unknown", macaroonId.PaymentHash))
+		return
+	}
+	if p.OnchainAddress == "" {
+		lsatmiddleware.setLsatError(c, fmt.Errorf("Payment %s has no on-chain address", macaroonId.PaymentHash))
+		return
+	}
+
+	if p.ConfirmedAt.IsZero() {
+		ctx, cancel := lsatmiddleware.lnContext(c.Request.Context())
+		defer cancel()
+		_, LNClientConn, err := lsatmiddleware.paymentBackend(c.Request, macaroonId.PaymentHash)
+		if err != nil {
+			lsatmiddleware.setLsatError(c, err)
+			return
+		}
+		confirmations := lsatmiddleware.Onchain.Confirmations
+		if confirmations == 0 {
+			confirmations = DEFAULT_ONCHAIN_CONFIRMATIONS
+		}
+		received, err := LNClientConn.ReceivedOnchain(ctx, p.OnchainAddress, confirmations)
+		if err != nil {
+			lsatmiddleware.setLsatError(c, err)
+			return
+		}
+		if received < p.Amount {
+			lsatmiddleware.setLsatError(c, fmt.Errorf("On-chain payment for PaymentHash %s is not confirmed, %d of %d sats received", macaroonId.PaymentHash, received, p.Amount))
+			return
+		}
+		_, err = payment.Update(lsatmiddleware.Payments, macaroonId.PaymentHash, func(p *payment.Payment) error {
+			if p.ConfirmedAt.IsZero() {
+				p.ConfirmedAt = time.Now()
+			}
+			return nil
+		})
+		if err != nil {
+			c.Error(err)
+		}
+	}
+
+	admitted, err := lsatmiddleware.admit(c, mac, macaroonId.PaymentHash, verifiedCaveats)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	lsatmiddleware.serveAdmitted(c, admitted, &LsatInfo{
+		Type: LSAT_TYPE_PAID,
+	})
+}
@@ -0,0 +1,53 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnchainPaymentIsCheckedAgainstRecordedAddress(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.Onchain = &OnchainConfig{
+		MinAmount: TEST_AMOUNT,
+	}
+	router := testRouter(lsatmiddleware, "/protected")
+
+	lsatChallenge := requestChallenge(t, router, "/protected")
+	assert.NotEmpty(t, lsatChallenge.Address)
+	token := challengeToken(t, lsatChallenge, lntypes.Preimage{})
+	res := serve(router, http.MethodGet, "/protected", authorization(t, token))
+	assert.NotEqual(t, LSAT_TYPE_PAID, tokenType(t, res))
+
+	client.fund(lsatChallenge.Address, TEST_AMOUNT)
+	res = serve(router, http.MethodGet, "/protected", authorization(t, token))
+	assert.Equal(t, LSAT_TYPE_PAID, tokenType(t, res))
+}
+
+func TestAttenuatedOnchainAddressIsIgnored(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.Onchain = &OnchainConfig{
+		MinAmount: TEST_AMOUNT,
+	}
+	router := testRouter(lsatmiddleware, "/protected")
+	funded := requestChallenge(t, router, "/protected")
+	client.fund(funded.Address, TEST_AMOUNT)
+
+	// Minted below MinAmount, without an address
+	lsatmiddleware.Onchain.MinAmount = TEST_AMOUNT + 1
+	token := challengeToken(t, requestChallenge(t, router, "/protected"), lntypes.Preimage{}, caveat.New(caveat.ONCHAIN_ADDRESS, funded.Address))
+	res := serve(router, http.MethodGet, "/protected", authorization(t, token))
+	assert.NotEqual(t, LSAT_TYPE_PAID, tokenType(t, res))
+
+	// Minted with an unpaid address of its own
+	lsatmiddleware.Onchain.MinAmount = TEST_AMOUNT
+	token = challengeToken(t, requestChallenge(t, router, "/protected"), lntypes.Preimage{}, caveat.New(caveat.ONCHAIN_ADDRESS, funded.Address))
+	res = serve(router, http.MethodGet, "/protected", authorization(t, token))
+	assert.NotEqual(t, LSAT_TYPE_PAID, tokenType(t, res))
+}
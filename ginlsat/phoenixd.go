@@ -0,0 +1,37 @@
+package ginlsat
+
+import (
+	"net/http"
+
+	"github.com/kiwiidb/gin-lsat/ln"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// PhoenixdWebhookHandler records settlements posted by phoenixd, e.g.
+// router.POST("/lsat/phoenixd", lsatmiddleware.PhoenixdWebhookHandler(os.Getenv("PHOENIXD_WEBHOOK_SECRET")))
+// with webhook=https://example.com/lsat/phoenixd in phoenix.conf.
+func (lsatmiddleware *GinLsatMiddleware) PhoenixdWebhookHandler(webhookSecret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		event, err := ln.ParsePhoenixdEvent(c.Request, webhookSecret)
+		if err != nil {
+			abortWithMessage(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if event.Type != ln.PHOENIXD_EVENT_PAYMENT_RECEIVED {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		paymentHash, err := lntypes.MakeHashFromStr(event.PaymentHash)
+		if err != nil {
+			abortWithMessage(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := lsatmiddleware.markPaymentSettled(paymentHash); err != nil {
+			abortWithMessage(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
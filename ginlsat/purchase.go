@@ -0,0 +1,114 @@
+package ginlsat
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/purchase"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"gopkg.in/macaroon.v2"
+)
+
+func userOwner(userId string) string {
+	return "user:" + userId
+}
+
+func tokenOwner(tokenId [32]byte) string {
+	return "token:" + hex.EncodeToString(tokenId[:])
+}
+
+// userPurchase returns the purchase of the requested resource by the
+// authenticated user, nil when there is none.
+func (lsatmiddleware *GinLsatMiddleware) userPurchase(req *http.Request) *purchase.Purchase {
+	resourceId, userId := utils.GetResourceId(req), utils.GetUserId(req)
+	if lsatmiddleware.Purchases == nil || resourceId == "" || userId == "" {
+		return nil
+	}
+	p, err := lsatmiddleware.Purchases.Get(userOwner(userId), resourceId)
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+// tokenPurchase accepts a token for a resource it was bought for even when
+// the token expired, nil when it wasn't.
+func (lsatmiddleware *GinLsatMiddleware) tokenPurchase(req *http.Request, mac *macaroon.Macaroon, preimage lntypes.Preimage) *purchase.Purchase {
+	resourceId := utils.GetResourceId(req)
+	if lsatmiddleware.Purchases == nil || resourceId == "" {
+		return nil
+	}
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		return nil
+	}
+	check := lsatmiddleware.checkCaveats(req, nil)
+	skipExpiry := func(caveatString string) error {
+		if parsed, err := caveat.Parse(caveatString); err == nil && parsed.Condition == caveat.EXPIRES_AT {
+			return nil
+		}
+		return check(caveatString)
+	}
+	dischargeMacaroons, err := discharges(req)
+	if err != nil {
+		return nil
+	}
+	if err := lsat.VerifyLSATWithDischarges(mac, rootKey, dischargeMacaroons, preimage, skipExpiry); err != nil {
+		return nil
+	}
+	if err := lsatmiddleware.checkRevoked(mac); err != nil {
+		return nil
+	}
+	macaroonId, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return nil
+	}
+	p, err := lsatmiddleware.Purchases.Get(tokenOwner(macaroonId.TokenId), resourceId)
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+// recordPurchase records the purchase of the resource a verified token is
+// bound to, for the token and for the authenticated user.
+func (lsatmiddleware *GinLsatMiddleware) recordPurchase(req *http.Request, mac *macaroon.Macaroon, verifiedCaveats caveat.Set) error {
+	resourceId, ok := verifiedCaveats.Get(caveat.RESOURCE)
+	if lsatmiddleware.Purchases == nil || !ok {
+		return nil
+	}
+	macaroonId, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return err
+	}
+	owners := []string{tokenOwner(macaroonId.TokenId)}
+	if userId := utils.GetUserId(req); userId != "" {
+		owners = append(owners, userOwner(userId))
+	}
+	for _, owner := range owners {
+		existing, err := lsatmiddleware.Purchases.Get(owner, resourceId)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+		err = lsatmiddleware.Purchases.Save(&purchase.Purchase{
+			Owner:       owner,
+			ResourceId:  resourceId,
+			PaymentHash: macaroonId.PaymentHash,
+			PurchasedAt: time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("Error recording purchase of %s: %s", resourceId, err.Error())
+		}
+	}
+	return nil
+}
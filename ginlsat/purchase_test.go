@@ -0,0 +1,92 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/purchase"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestPurchasesAreNotChargedAgain(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.ResourceFunc = func(req *http.Request) string {
+		return utils.GetRouteParam(req, "articleId")
+	}
+	lsatmiddleware.UserIdFunc = func(c *gin.Context) string {
+		return c.GetHeader("X-User-Id")
+	}
+	lsatmiddleware.Purchases = purchase.NewMemoryStore()
+	gin.SetMode(gin.TestMode)
+	handler := gin.New()
+	handler.GET("/articles/:articleId", lsatmiddleware.Handler, respondUnauthorized, respondWithLsatInfo)
+	token := paidToken(t, client, handler, "/articles/1")
+	router := gofight.New()
+
+	router.GET("/articles/1").
+		SetHeader(gofight.H{
+			"Authorization": authorization(t, token).Get("Authorization"),
+			"X-User-Id":     "alice",
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+		})
+
+	// The buyer is not challenged for the article again
+	router.GET("/articles/1").
+		SetHeader(gofight.H{
+			"Accept":    "application/vnd.lsat.v1.full+json",
+			"X-User-Id": "alice",
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+		})
+
+	// Other users and other articles are still charged for
+	for _, unpaid := range []struct {
+		path   string
+		userId string
+	}{
+		{"/articles/1", "bob"},
+		{"/articles/2", "alice"},
+	} {
+		router.GET(unpaid.path).
+			SetHeader(gofight.H{
+				"Accept":    "application/vnd.lsat.v1.full+json",
+				"X-User-Id": unpaid.userId,
+			}).
+			Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			})
+	}
+
+	// The token stays valid for the article after it expired
+	expired, err := token.Attenuate(caveat.NewBuilder().ExpiresAt(time.Now().Add(-time.Hour)).Build()...)
+	assert.NoError(t, err)
+	router.GET("/articles/1").
+		SetHeader(gofight.H{
+			"Authorization": authorization(t, expired).Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+		})
+
+	router.GET("/articles/2").
+		SetHeader(gofight.H{
+			"Authorization": authorization(t, expired).Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusUnauthorized, res.Code)
+		})
+}
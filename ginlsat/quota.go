@@ -0,0 +1,101 @@
+package ginlsat
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/payment"
+	"github.com/kiwiidb/gin-lsat/usage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+const (
+	RATE_LIMIT_LIMIT_HEADER     = "X-RateLimit-Limit"
+	RATE_LIMIT_REMAINING_HEADER = "X-RateLimit-Remaining"
+	RATE_LIMIT_RESET_HEADER     = "X-RateLimit-Reset"
+)
+
+// Quota is the remaining entitlement of a token after the current request
+type Quota struct {
+	Limit     int64
+	Remaining int64
+	// Reset is zero when the quota does not reset
+	Reset time.Time
+}
+
+// useQuota consumes a use of a token with a max_uses caveat and returns the
+// quota left after this request, nil for unlimited tokens. The use is
+// counted under accountHash in the Uses store when set, in the payment store
+// otherwise, with compare-and-set, so concurrent requests on several
+// replicas can't both consume the last use.
+func (lsatmiddleware *GinLsatMiddleware) useQuota(paymentHash lntypes.Hash, accountHash lntypes.Hash, verifiedCaveats caveat.Set) (*Quota, error) {
+	maxUses, ok := verifiedCaveats.MaxUses()
+	if !ok {
+		return nil, nil
+	}
+	var uses int64
+	if lsatmiddleware.Uses != nil {
+		var err error
+		uses, err = lsatmiddleware.Uses.Use(accountHash.String(), maxUses)
+		if err == usage.ErrLimitReached {
+			return nil, fmt.Errorf("Token has been used %d of %d times", uses, maxUses)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Keep the usage statistics of the payment store
+		if err := lsatmiddleware.recordPaymentUsage(paymentHash); err != nil {
+			return nil, err
+		}
+	} else {
+		if lsatmiddleware.Payments == nil {
+			return nil, fmt.Errorf("Caveat %s requires a payment store or a usage store", caveat.MAX_USES)
+		}
+		p, err := payment.Update(lsatmiddleware.Payments, accountHash, func(p *payment.Payment) error {
+			if p.Requests >= maxUses {
+				return fmt.Errorf("Token has been used %d of %d times", p.Requests, maxUses)
+			}
+			markUsed(p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		uses = p.Requests
+		// Keep the usage statistics of the payment of an upgraded token
+		if accountHash != paymentHash {
+			if err := lsatmiddleware.recordPaymentUsage(paymentHash); err != nil {
+				return nil, err
+			}
+		}
+	}
+	quota := &Quota{
+		Limit:     maxUses,
+		Remaining: maxUses - uses,
+	}
+	if expiresAt, ok := verifiedCaveats.ExpiresAt(); ok {
+		quota.Reset = expiresAt
+	}
+	return quota, nil
+}
+
+// setQuotaHeaders lets clients throttle themselves before running out of
+// their token's entitlement.
+func setQuotaHeaders(c *gin.Context, quota *Quota) {
+	if quota == nil {
+		return
+	}
+	c.Writer.Header().Set(RATE_LIMIT_LIMIT_HEADER, strconv.FormatInt(quota.Limit, 10))
+	c.Writer.Header().Set(RATE_LIMIT_REMAINING_HEADER, strconv.FormatInt(quota.Remaining, 10))
+	if !quota.Reset.IsZero() {
+		reset := int64(time.Until(quota.Reset).Seconds())
+		if reset < 0 {
+			reset = 0
+		}
+		c.Writer.Header().Set(RATE_LIMIT_RESET_HEADER, strconv.FormatInt(reset, 10))
+	}
+}
@@ -0,0 +1,43 @@
+package ginlsat
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/usage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxUsesUnderConcurrentRequests(t *testing.T) {
+	const maxUses = 5
+	for name, uses := range map[string]usage.Store{
+		"payment store": nil,
+		"uses store":    usage.NewMemoryStore(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newFakeLNClient()
+			lsatmiddleware := newTestMiddleware(client)
+			lsatmiddleware.Uses = uses
+			router := testRouter(lsatmiddleware, "/protected")
+			header := authorization(t, paidToken(t, client, router, "/protected", caveat.NewBuilder().MaxUses(maxUses).Build()...))
+
+			var wg sync.WaitGroup
+			var served int64
+			for i := 0; i < 4*maxUses; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if tokenType(t, serve(router, http.MethodGet, "/protected", header)) == LSAT_TYPE_PAID {
+						atomic.AddInt64(&served, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, int64(maxUses), served)
+		})
+	}
+}
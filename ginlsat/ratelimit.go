@@ -0,0 +1,44 @@
+package ginlsat
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// RATE_LIMIT_WINDOW is the window requests_per_minute caveats are counted in
+const RATE_LIMIT_WINDOW = time.Minute
+
+// rateLimitStore returns RateLimits, an in-process store when it is nil.
+func (lsatmiddleware *GinLsatMiddleware) rateLimitStore() ratelimit.Store {
+	if lsatmiddleware.RateLimits != nil {
+		return lsatmiddleware.RateLimits
+	}
+	lsatmiddleware.defaultRateLimitsOnce.Do(func() {
+		lsatmiddleware.defaultRateLimits = ratelimit.NewMemoryStore()
+	})
+	return lsatmiddleware.defaultRateLimits
+}
+
+// checkRateLimit counts the request of a token with a requests_per_minute
+// caveat and rejects it once the token made that many requests in the
+// current minute. Sub-tokens share the limit of their parent.
+func (lsatmiddleware *GinLsatMiddleware) checkRateLimit(c *gin.Context, paymentHash lntypes.Hash, verifiedCaveats caveat.Set) error {
+	requestsPerMinute, ok := verifiedCaveats.RequestsPerMinute()
+	if !ok {
+		return nil
+	}
+	_, resetAt, err := lsatmiddleware.rateLimitStore().Allow(paymentHash.String(), requestsPerMinute, RATE_LIMIT_WINDOW)
+	if err == ratelimit.ErrLimitReached {
+		retryAfter := int64(time.Until(resetAt).Seconds()) + 1
+		c.Writer.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+		return fmt.Errorf("Token exceeded %d requests per minute", requestsPerMinute)
+	}
+	return err
+}
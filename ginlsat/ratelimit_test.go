@@ -0,0 +1,66 @@
+package ginlsat
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+// respondTooManyRequests responds to throttled requests with a 429, the
+// way applications handle the Retry-After header set by the middleware
+func respondTooManyRequests(c *gin.Context) {
+	lsatInfo := c.Value("LSAT").(*LsatInfo)
+	if lsatInfo.Error != nil && c.Writer.Header().Get("Retry-After") != "" {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"code":    http.StatusTooManyRequests,
+			"message": lsatInfo.Error.Error(),
+		})
+	}
+}
+
+func TestRequestsPerMinute(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.CaveatFunc = func(req *http.Request) []caveat.Caveat {
+		return caveat.NewBuilder().RequestsPerMinute(2).MaxUses(10).Build()
+	}
+	handler := testRouter(lsatmiddleware, "/protected")
+	handler.GET("/limited", lsatmiddleware.Handler, respondTooManyRequests, respondWithLsatInfo)
+	token := paidToken(t, client, handler, "/protected")
+	header := authorization(t, token)
+	router := gofight.New()
+
+	for i := 0; i < 2; i++ {
+		router.GET("/limited").
+			SetHeader(gofight.H{
+				"Authorization": header.Get("Authorization"),
+			}).
+			Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusOK, res.Code)
+				assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+			})
+	}
+	router.GET("/limited").
+		SetHeader(gofight.H{
+			"Authorization": header.Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusTooManyRequests, res.Code)
+			assert.Equal(t, "Token exceeded 2 requests per minute", gjson.Get(res.Body.String(), "message").String())
+			retryAfter, err := strconv.Atoi(res.HeaderMap.Get("Retry-After"))
+			assert.NoError(t, err)
+			assert.True(t, retryAfter >= 1 && retryAfter <= 61)
+		})
+
+	// Throttled requests don't consume uses
+	p, err := lsatmiddleware.Payments.Get(token.PaymentHash())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), p.Requests)
+}
@@ -0,0 +1,144 @@
+package ginlsat
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/payment"
+	"github.com/kiwiidb/gin-lsat/receipt"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+func (lsatmiddleware *GinLsatMiddleware) recordPayment(req *http.Request, backend string, paymentHash lntypes.Hash, tokenId [32]byte, amountMsat int64, invoice string) error {
+	if lsatmiddleware.Payments == nil {
+		return nil
+	}
+	return lsatmiddleware.Payments.Save(&payment.Payment{
+		PaymentHash: paymentHash,
+		TokenId:     hex.EncodeToString(tokenId[:]),
+		Amount:      (amountMsat + ln.MSAT_PER_SAT - 1) / ln.MSAT_PER_SAT,
+		AmountMsat:  amountMsat,
+		Route:       fmt.Sprintf("%s %s", req.Method, req.URL.Path),
+		Invoice:     invoice,
+		Backend:     backend,
+		CreatedAt:   time.Now(),
+	})
+}
+
+// markPaymentSettled records the settlement of a payment the first time its
+// preimage is presented, unknown payments are ignored.
+func (lsatmiddleware *GinLsatMiddleware) markPaymentSettled(paymentHash lntypes.Hash) error {
+	if lsatmiddleware.Payments == nil {
+		return nil
+	}
+	if p, err := lsatmiddleware.Payments.Get(paymentHash); err != nil || p.IsSettled() {
+		return nil
+	}
+	_, err := payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		if !p.IsSettled() {
+			p.SettledAt = time.Now()
+		}
+		return nil
+	})
+	return err
+}
+
+// recordPaymentUsage counts a request served with the token of a payment.
+func (lsatmiddleware *GinLsatMiddleware) recordPaymentUsage(paymentHash lntypes.Hash) error {
+	if lsatmiddleware.Payments == nil {
+		return nil
+	}
+	if _, err := lsatmiddleware.Payments.Get(paymentHash); err != nil {
+		return nil
+	}
+	_, err := payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		markUsed(p)
+		return nil
+	})
+	return err
+}
+
+func markUsed(p *payment.Payment) {
+	now := time.Now()
+	if !p.IsSettled() {
+		p.SettledAt = now
+	}
+	p.Requests++
+	p.LastUsedAt = now
+}
+
+// ReceiptHandler serves a signed receipt for the LSAT in the Authorization
+// header, e.g. router.GET("/lsat/receipt", lsatmiddleware.ReceiptHandler)
+func (lsatmiddleware *GinLsatMiddleware) ReceiptHandler(c *gin.Context) {
+	if lsatmiddleware.Payments == nil || lsatmiddleware.ReceiptSigner == nil {
+		abortWithMessage(c, http.StatusNotFound, "Receipts are not enabled")
+		return
+	}
+	mac, preimage, err := utils.ParseLsatHeader(c.Request.Header.Get("Authorization"))
+	if err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	// Caveats restrict the use of the token, not the receipt of its payment
+	acceptAll := func(caveat string) error { return nil }
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	dischargeMacaroons, err := discharges(c.Request)
+	if err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := lsat.VerifyLSATWithDischarges(mac, rootKey, dischargeMacaroons, preimage, acceptAll); err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err := lsatmiddleware.markPaymentSettled(preimage.Hash()); err != nil {
+		abortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := lsatmiddleware.Payments.Get(preimage.Hash())
+	if err != nil {
+		abortWithMessage(c, http.StatusNotFound, err.Error())
+		return
+	}
+	signedReceipt, err := lsatmiddleware.ReceiptSigner.Sign(receipt.Receipt{
+		PaymentHash: p.PaymentHash.String(),
+		Amount:      p.Amount,
+		Timestamp:   p.SettledAt.Unix(),
+		Route:       p.Route,
+		TokenId:     p.TokenId,
+	})
+	if err != nil {
+		abortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, signedReceipt)
+}
+
+// ReceiptPublicKeyHandler publishes the key receipts are verified with
+func (lsatmiddleware *GinLsatMiddleware) ReceiptPublicKeyHandler(c *gin.Context) {
+	if lsatmiddleware.ReceiptSigner == nil {
+		abortWithMessage(c, http.StatusNotFound, "Receipts are not enabled")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"public_key": lsatmiddleware.ReceiptSigner.PublicKey(),
+	})
+}
+
+func abortWithMessage(c *gin.Context, code int, message string) {
+	c.AbortWithStatusJSON(code, gin.H{
+		"code":    code,
+		"message": message,
+	})
+}
@@ -0,0 +1,60 @@
+package ginlsat
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/receipt"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestReceiptHandler(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.ReceiptSigner = receipt.NewSignerFromSecret([]byte("gin-lsat-test-receipt"))
+	handler := testRouter(lsatmiddleware, "/protected")
+	handler.GET("/lsat/receipt", lsatmiddleware.ReceiptHandler)
+	handler.GET("/lsat/receipt/key", lsatmiddleware.ReceiptPublicKeyHandler)
+	router := gofight.New()
+
+	var publicKey string
+	router.GET("/lsat/receipt/key").
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			publicKey = gjson.Get(res.Body.String(), "public_key").String()
+		})
+
+	token := paidToken(t, client, handler, "/protected")
+	router.GET("/lsat/receipt").
+		SetHeader(gofight.H{
+			"Authorization": authorization(t, token).Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			signedReceipt := &receipt.SignedReceipt{}
+			assert.NoError(t, json.Unmarshal(res.Body.Bytes(), signedReceipt))
+			assert.NoError(t, receipt.Verify(publicKey, signedReceipt))
+			assert.Equal(t, token.PaymentHash().String(), signedReceipt.Receipt.PaymentHash)
+			assert.Equal(t, int64(TEST_AMOUNT), signedReceipt.Receipt.Amount)
+			assert.Equal(t, "GET /protected", signedReceipt.Receipt.Route)
+
+			// Tampered receipts don't verify
+			signedReceipt.Receipt.Amount++
+			assert.Error(t, receipt.Verify(publicKey, signedReceipt))
+		})
+
+	// A token presented without the preimage of its payment
+	unpaid := challengeToken(t, requestChallenge(t, handler, "/protected"), lntypes.Preimage{1})
+	router.GET("/lsat/receipt").
+		SetHeader(gofight.H{
+			"Authorization": authorization(t, unpaid).Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusUnauthorized, res.Code)
+		})
+}
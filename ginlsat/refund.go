@@ -0,0 +1,96 @@
+package ginlsat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/payment"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// LSAT_REFUND_HEADER carries the node pubkey a failed request is refunded
+// to with keysend
+const LSAT_REFUND_HEADER = "X-Lsat-Refund-Pubkey"
+
+// RefundConfig refunds paid requests the protected handler failed to serve
+// with a keysend payment to the node in the LSAT_REFUND_HEADER of the
+// request. Refunds need the Payments store and a backend supporting keysend,
+// like LND. Only tokens used for a single request are refunded, refunded
+// tokens are rejected afterwards.
+type RefundConfig struct {
+	// Policy returns true when the served request is refunded, defaults to
+	// responses with a 5xx status
+	Policy func(c *gin.Context) bool
+	// OnRefund is called with the result of every refund
+	OnRefund func(paymentHash lntypes.Hash, amountMsat int64, err error)
+}
+
+// serveWithRefund runs the protected handler and refunds the payment of
+// paymentHash when the refund policy asks for it.
+func (lsatmiddleware *GinLsatMiddleware) serveWithRefund(c *gin.Context, paymentHash lntypes.Hash) {
+	pubkey := c.Request.Header.Get(LSAT_REFUND_HEADER)
+	if lsatmiddleware.Refund == nil || lsatmiddleware.Payments == nil || pubkey == "" {
+		return
+	}
+	c.Next()
+	if lsatmiddleware.Refund.Policy != nil {
+		if !lsatmiddleware.Refund.Policy(c) {
+			return
+		}
+	} else if c.Writer.Status() < http.StatusInternalServerError {
+		return
+	}
+	amountMsat, err := lsatmiddleware.refund(c, paymentHash, pubkey)
+	if err != nil {
+		c.Error(err)
+	}
+	if lsatmiddleware.Refund.OnRefund != nil {
+		lsatmiddleware.Refund.OnRefund(paymentHash, amountMsat, err)
+	}
+}
+
+func (lsatmiddleware *GinLsatMiddleware) refund(c *gin.Context, paymentHash lntypes.Hash, pubkey string) (int64, error) {
+	// Mark the payment refunded before paying, so it is refunded once and
+	// its token is rejected from now on
+	p, err := payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		if !p.RefundedAt.IsZero() {
+			return fmt.Errorf("Payment %s was already refunded", paymentHash)
+		}
+		if p.Requests > 1 {
+			return fmt.Errorf("Payment %s paid for %d requests and is not refunded", paymentHash, p.Requests)
+		}
+		p.RefundedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	_, LNClientConn, err := lsatmiddleware.paymentBackend(c.Request, paymentHash)
+	if err != nil {
+		return p.AmountMsat, err
+	}
+	// Refund even when the client went away meanwhile
+	ctx, cancel := lsatmiddleware.lnContext(context.Background())
+	defer cancel()
+	// Refund what was paid, msat priced tokens cost a fraction of a sat
+	return p.AmountMsat, LNClientConn.Keysend(ctx, pubkey, p.AmountMsat)
+}
+
+// checkRefunded rejects tokens whose payment was refunded.
+func (lsatmiddleware *GinLsatMiddleware) checkRefunded(paymentHash lntypes.Hash) error {
+	if lsatmiddleware.Payments == nil {
+		return nil
+	}
+	p, err := lsatmiddleware.Payments.Get(paymentHash)
+	if err != nil {
+		return nil
+	}
+	if !p.RefundedAt.IsZero() {
+		return fmt.Errorf("Payment %s was refunded", paymentHash)
+	}
+	return nil
+}
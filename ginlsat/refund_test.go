@@ -0,0 +1,50 @@
+package ginlsat
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefundedTokenIsRejected(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	// A fraction of a sat is refunded as paid
+	lsatmiddleware.AmountMsatFunc = func(req *http.Request) int64 {
+		return 1500
+	}
+	refunds := []int64{}
+	lsatmiddleware.Refund = &RefundConfig{
+		OnRefund: func(paymentHash lntypes.Hash, amountMsat int64, err error) {
+			assert.NoError(t, err)
+			refunds = append(refunds, amountMsat)
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/failing", lsatmiddleware.Handler, func(c *gin.Context) {
+		if c.Value("LSAT").(*LsatInfo).Type == LSAT_TYPE_PAID {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		respondWithLsatInfo(c)
+	})
+
+	header := authorization(t, paidToken(t, client, router, "/failing"))
+	header.Set(LSAT_REFUND_HEADER, "02"+strings.Repeat("ab", 32))
+	res := serve(router, http.MethodGet, "/failing", header)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, []int64{1500}, refunds)
+	assert.Equal(t, []int64{1500}, client.keysends)
+
+	// The token is not served for free after its refund
+	res = serve(router, http.MethodGet, "/failing", header)
+	response := decodeResponse(t, res)
+	assert.NotEqual(t, LSAT_TYPE_PAID, response.Type)
+	assert.Contains(t, response.Error, "was refunded")
+	assert.Len(t, client.keysends, 1)
+}
@@ -0,0 +1,74 @@
+package ginlsat
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/revocation"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/macaroon.v2"
+)
+
+type RevokeRequest struct {
+	TokenId string `json:"token_id"`
+	Reason  string `json:"reason"`
+}
+
+// Revoke cuts off the token with the hex encoded tokenId and its sub-tokens,
+// e.g. when it is abused, before its caveats expire.
+func (lsatmiddleware *GinLsatMiddleware) Revoke(tokenId string, reason string) error {
+	if lsatmiddleware.Revocations == nil {
+		return fmt.Errorf("Revocation requires a revocation store")
+	}
+	if decoded, err := hex.DecodeString(tokenId); err != nil || len(decoded) != 32 {
+		return fmt.Errorf("Invalid token id: %s", tokenId)
+	}
+	return lsatmiddleware.Revocations.Revoke(&revocation.Revocation{
+		TokenId:   tokenId,
+		Reason:    reason,
+		RevokedAt: time.Now(),
+	})
+}
+
+// RevokeHandler revokes the token in the JSON body. Mount it behind the
+// operator's authentication, e.g.
+// admin.POST("/lsat/revoke", lsatmiddleware.RevokeHandler)
+func (lsatmiddleware *GinLsatMiddleware) RevokeHandler(c *gin.Context) {
+	if lsatmiddleware.Revocations == nil {
+		abortWithMessage(c, http.StatusNotFound, "Revocation is not enabled")
+		return
+	}
+	revokeReq := &RevokeRequest{}
+	if err := c.ShouldBindJSON(revokeReq); err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := lsatmiddleware.Revoke(revokeReq.TokenId, revokeReq.Reason); err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// checkRevoked rejects macaroons whose token id is on the revocation list.
+func (lsatmiddleware *GinLsatMiddleware) checkRevoked(mac *macaroon.Macaroon) error {
+	if lsatmiddleware.Revocations == nil {
+		return nil
+	}
+	macaroonId, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return err
+	}
+	revoked, err := lsatmiddleware.Revocations.Get(hex.EncodeToString(macaroonId.TokenId[:]))
+	if err != nil {
+		return err
+	}
+	if revoked != nil {
+		return fmt.Errorf("Token was revoked")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package ginlsat
+
+import (
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/revocation"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+// respondUnauthorized responds to requests with an invalid token with a 401
+func respondUnauthorized(c *gin.Context) {
+	lsatInfo := c.Value("LSAT").(*LsatInfo)
+	if lsatInfo.Error != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"code":    http.StatusUnauthorized,
+			"message": lsatInfo.Error.Error(),
+		})
+	}
+}
+
+func TestRevokedTokenIsRejected(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.Revocations = revocation.NewMemoryStore()
+	handler := testRouter(lsatmiddleware, "/protected")
+	handler.GET("/reports", lsatmiddleware.Handler, respondUnauthorized, respondWithLsatInfo)
+	handler.POST("/lsat/revoke", lsatmiddleware.RevokeHandler)
+	token := paidToken(t, client, handler, "/protected")
+	// Sub-tokens are revoked with their parent
+	subToken, err := token.Attenuate(caveat.NewBuilder().Tier("basic").Build()...)
+	assert.NoError(t, err)
+	router := gofight.New()
+
+	router.GET("/reports").
+		SetHeader(gofight.H{
+			"Authorization": authorization(t, token).Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+		})
+
+	router.POST("/lsat/revoke").
+		SetJSON(gofight.D{
+			"token_id": hex.EncodeToString(token.Identifier.TokenId[:]),
+			"reason":   "abuse",
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusNoContent, res.Code)
+		})
+
+	for _, revoked := range []string{authorization(t, token).Get("Authorization"), authorization(t, subToken).Get("Authorization")} {
+		router.GET("/reports").
+			SetHeader(gofight.H{
+				"Authorization": revoked,
+			}).
+			Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusUnauthorized, res.Code)
+				assert.Equal(t, "Token was revoked", gjson.Get(res.Body.String(), "message").String())
+			})
+	}
+
+	router.POST("/lsat/revoke").
+		SetJSON(gofight.D{
+			"token_id": "not a token id",
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		})
+}
@@ -0,0 +1,61 @@
+package ginlsat
+
+import (
+	"net/http"
+
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/rootkey"
+
+	"gopkg.in/macaroon.v2"
+)
+
+// rootKeys returns the provider of the root keys, ROOT_KEY and
+// ROOT_KEY_<ID> when RootKeyProvider is nil.
+func (lsatmiddleware *GinLsatMiddleware) rootKeys() rootkey.Provider {
+	if lsatmiddleware.RootKeyProvider == nil {
+		return rootkey.NewEnvProvider()
+	}
+	return lsatmiddleware.RootKeyProvider
+}
+
+// rootKeyId returns the id of the root key tokens for req are minted with,
+// RootKeyIdFunc takes precedence over the current key of the provider.
+func (lsatmiddleware *GinLsatMiddleware) rootKeyId(req *http.Request) (string, error) {
+	if lsatmiddleware.RootKeyIdFunc == nil {
+		return lsatmiddleware.rootKeys().CurrentKeyId()
+	}
+	return lsatmiddleware.RootKeyIdFunc(req), nil
+}
+
+// mintingRootKey returns the id and root key tokens for req are minted with.
+func (lsatmiddleware *GinLsatMiddleware) mintingRootKey(req *http.Request) (string, []byte, error) {
+	keyId, err := lsatmiddleware.rootKeyId(req)
+	if err != nil {
+		return "", nil, err
+	}
+	rootKey, err := lsatmiddleware.rootKeys().GetRootKey(keyId)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyId, rootKey, nil
+}
+
+// verificationRootKey returns the root key selected by the key id in the
+// identifier of mac, or the Aperture secret of its token id.
+func (lsatmiddleware *GinLsatMiddleware) verificationRootKey(mac *macaroon.Macaroon) ([]byte, error) {
+	macaroonId, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return nil, err
+	}
+	// Aperture signs each token with its own secret instead of a key id
+	if lsatmiddleware.ApertureSecrets != nil && macaroonId.KeyId == "" {
+		secret, err := lsatmiddleware.ApertureSecrets.GetSecret(rootkey.ApertureSecretId(macaroonId.TokenId))
+		if err != nil {
+			return nil, err
+		}
+		if secret != nil {
+			return secret, nil
+		}
+	}
+	return lsatmiddleware.rootKeys().GetRootKey(macaroonId.KeyId)
+}
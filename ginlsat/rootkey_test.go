@@ -0,0 +1,47 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/lsat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForgedKeyIdIsRejected(t *testing.T) {
+	t.Setenv("ROOT_KEY", "root_key")
+	t.Setenv("ROOT_KEY_V2", "root_key_v2")
+	t.Setenv("KEY_IDS", "V2")
+	t.Setenv("CURRENT_KEY_ID", "V2")
+	// A leftover of the former current key id setting, public in every
+	// identifier minted with it
+	t.Setenv("ROOT_KEY_ID", "V2")
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.RootKeyProvider = nil
+	router := testRouter(lsatmiddleware, "/protected")
+
+	token := paidToken(t, client, router, "/protected")
+	assert.Equal(t, "V2", token.Identifier.KeyId)
+	res := serve(router, http.MethodGet, "/protected", authorization(t, token))
+	assert.Equal(t, LSAT_TYPE_PAID, tokenType(t, res))
+
+	// Signed with the public current key id, selecting ROOT_KEY_ID
+	tokenId, err := macaroonutils.GenerateTokenId()
+	assert.NoError(t, err)
+	preimage := lntypes.Preimage(tokenId)
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString([]byte("V2"), "id", preimage.Hash(), tokenId)
+	assert.NoError(t, err)
+	mac, err := utils.GetMacaroonFromString(macaroonString)
+	assert.NoError(t, err)
+	forged := &lsat.Token{
+		Macaroon: mac,
+		Preimage: preimage,
+	}
+	res = serve(router, http.MethodGet, "/protected", authorization(t, forged))
+	assert.NotEqual(t, LSAT_TYPE_PAID, tokenType(t, res))
+}
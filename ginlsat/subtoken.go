@@ -0,0 +1,163 @@
+package ginlsat
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// SubTokenRequest asks for a sub-token for a team member, narrowed down by
+// caveats like "path=/api/v1/reports/*" or "expires_at=1700000000"
+type SubTokenRequest struct {
+	Member  string   `json:"member"`
+	Caveats []string `json:"caveats"`
+}
+
+type SubTokenResponse struct {
+	Macaroon string `json:"macaroon"`
+	// Authorization header value the member sends with the sub-token
+	Authorization string `json:"authorization"`
+}
+
+// SubTokenHandler lets the holder of a paid token delegate attenuated
+// sub-tokens to team members, e.g. router.POST("/lsat/subtokens", lsatmiddleware.SubTokenHandler).
+// Sub-tokens share the payment of the parent token, so its max_uses quota
+// is drawn from by all members.
+func (lsatmiddleware *GinLsatMiddleware) SubTokenHandler(c *gin.Context) {
+	mac, preimage, err := utils.ParseLsatHeader(c.Request.Header.Get("Authorization"))
+	if err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	// The parent is used on other routes, only its expiry applies here
+	checkExpiry := checkExpiryOnly(c.Request, nil)
+	dischargeMacaroons, err := discharges(c.Request)
+	if err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := lsat.VerifyLSATWithDischarges(mac, rootKey, dischargeMacaroons, preimage, checkExpiry); err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err := lsatmiddleware.checkRevoked(mac); err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	subTokenReq := &SubTokenRequest{}
+	if err := c.ShouldBindJSON(subTokenReq); err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	caveats, err := lsatmiddleware.subTokenCaveats(subTokenReq)
+	if err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	macaroonString, err := macaroonutils.Attenuate(mac, caveats...)
+	if err == nil {
+		macaroonString, err = lsatmiddleware.encodeMacaroon(macaroonString)
+	}
+	if err != nil {
+		abortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, &SubTokenResponse{
+		Macaroon:      macaroonString,
+		Authorization: fmt.Sprintf("%s %s:%s", lsatmiddleware.scheme(), macaroonString, preimage),
+	})
+}
+
+// checkExpiryOnly verifies the expiry of a token presented outside of the
+// routes it is used on and collects its caveats in verified when it is not
+// nil.
+func checkExpiryOnly(req *http.Request, verified *caveat.Set) func(caveatString string) error {
+	return func(caveatString string) error {
+		parsed, err := caveat.Parse(caveatString)
+		if err != nil {
+			return err
+		}
+		if parsed.Condition == caveat.EXPIRES_AT {
+			if err := caveat.CheckExpiresAt(req, parsed.Value); err != nil {
+				return err
+			}
+		}
+		if verified != nil {
+			*verified = append(*verified, parsed)
+		}
+		return nil
+	}
+}
+
+// subTokenCaveats rejects caveats the middleware can't verify, they would
+// make the sub-token unusable.
+func (lsatmiddleware *GinLsatMiddleware) subTokenCaveats(subTokenReq *SubTokenRequest) ([]string, error) {
+	caveats := []string{}
+	if subTokenReq.Member != "" {
+		caveats = append(caveats, caveat.New(caveat.MEMBER, subTokenReq.Member).String())
+	}
+	for _, caveatString := range subTokenReq.Caveats {
+		parsed, err := caveat.Parse(caveatString)
+		if err != nil {
+			return nil, err
+		}
+		if err := lsatmiddleware.checkRecognized(parsed); err != nil {
+			return nil, err
+		}
+		caveats = append(caveats, parsed.String())
+	}
+	if len(caveats) == 0 {
+		return nil, fmt.Errorf("Sub-token has no member or caveats")
+	}
+	return caveats, nil
+}
+
+func (lsatmiddleware *GinLsatMiddleware) checkRecognized(parsed caveat.Caveat) error {
+	_, known := lsatmiddleware.CaveatCheckers[parsed.Condition]
+	if _, builtin := caveat.BuiltinCheckers()[parsed.Condition]; !known && !builtin {
+		return fmt.Errorf("Caveat condition not recognized: %s", parsed.Condition)
+	}
+	return nil
+}
+
+// Attenuate narrows token down by caveats on behalf of its holder and
+// returns the Authorization header value of the attenuated token, in the
+// scheme and encoding of the challenges. Holders can attenuate without the
+// server with lsat.Token.Attenuate.
+func (lsatmiddleware *GinLsatMiddleware) Attenuate(token *lsat.Token, caveats ...caveat.Caveat) (string, error) {
+	if len(caveats) == 0 {
+		return "", fmt.Errorf("Attenuated token has no caveats")
+	}
+	for _, attenuation := range caveats {
+		if err := lsatmiddleware.checkRecognized(attenuation); err != nil {
+			return "", err
+		}
+	}
+	attenuated, err := token.Attenuate(caveats...)
+	if err != nil {
+		return "", err
+	}
+	macaroonString, err := attenuated.MacaroonString()
+	if err == nil {
+		macaroonString, err = lsatmiddleware.encodeMacaroon(macaroonString)
+	}
+	if err != nil {
+		return "", err
+	}
+	if attenuated.Preimage == (lntypes.Preimage{}) {
+		return fmt.Sprintf("%s %s", lsatmiddleware.scheme(), macaroonString), nil
+	}
+	return fmt.Sprintf("%s %s:%s", lsatmiddleware.scheme(), macaroonString, attenuated.Preimage), nil
+}
@@ -0,0 +1,413 @@
+package ginlsat
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/tab"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+const (
+	LSAT_TYPE_TAB   = "TAB"
+	LSAT_TAB_HEADER = "X-Lsat-Tab"
+)
+
+const (
+	TAB_UNPAID_MESSAGE       = "Tab Settlement Required"
+	CREDIT_EXHAUSTED_MESSAGE = "Credit Limit Exceeded"
+)
+
+// DEFAULT_PAYMENT_GRACE_PERIOD in seconds blocks tabs whose unpaid balance
+// is bounded by neither MaxUnpaidBalance nor a credit limit
+const DEFAULT_PAYMENT_GRACE_PERIOD = 600
+
+// TabConfig enables tab mode: requests are charged against a client tab
+// and settled periodically with a single invoice for the accumulated amount.
+type TabConfig struct {
+	Store tab.Store
+	// Issue a settlement invoice once the balance reaches this amount
+	SettlementAmount int64
+	// Issue a settlement invoice once this much time passed since the last settlement
+	SettlementInterval time.Duration
+	// Block requests when the balance exceeds this amount while a settlement
+	// invoice is outstanding, 0 disables the check
+	MaxUnpaidBalance int64
+	// Block requests when a settlement invoice is outstanding for longer
+	// than this. 0 disables the check when MaxUnpaidBalance or a credit
+	// limit is set, DEFAULT_PAYMENT_GRACE_PERIOD applies otherwise
+	PaymentGracePeriod time.Duration
+	// Credit granted to every client before payment is required. When a
+	// credit limit is set, settlement invoices are only issued once the
+	// limit is crossed.
+	CreditLimit tab.CreditLimit
+	// CreditLimitFunc overrides CreditLimit per client
+	CreditLimitFunc func(req *http.Request, tabId string) tab.CreditLimit
+}
+
+func (tabConfig *TabConfig) creditLimit(req *http.Request, tabId string) tab.CreditLimit {
+	if tabConfig.CreditLimitFunc != nil {
+		return tabConfig.CreditLimitFunc(req, tabId)
+	}
+	return tabConfig.CreditLimit
+}
+
+func (tabConfig *TabConfig) isSettlementDue(t *tab.Tab, credit tab.CreditLimit) bool {
+	if t.HasOutstandingInvoice() || t.Balance <= 0 || credit.IsSet() {
+		return false
+	}
+	if tabConfig.SettlementAmount > 0 && t.Balance >= tabConfig.SettlementAmount {
+		return true
+	}
+	lastSettlement := t.SettledAt
+	if lastSettlement.IsZero() {
+		lastSettlement = t.OpenedAt
+	}
+	return tabConfig.SettlementInterval > 0 && time.Since(lastSettlement) >= tabConfig.SettlementInterval
+}
+
+func (tabConfig *TabConfig) isBlocked(t *tab.Tab, credit tab.CreditLimit) bool {
+	if !t.HasOutstandingInvoice() {
+		return false
+	}
+	if tabConfig.MaxUnpaidBalance > 0 && t.Balance > tabConfig.MaxUnpaidBalance {
+		return true
+	}
+	gracePeriod := tabConfig.paymentGracePeriod(credit)
+	return gracePeriod > 0 && time.Since(t.InvoicedAt) > gracePeriod
+}
+
+// paymentGracePeriod returns how long a settlement invoice may stay unpaid,
+// 0 when the unpaid balance is bounded otherwise.
+func (tabConfig *TabConfig) paymentGracePeriod(credit tab.CreditLimit) time.Duration {
+	if tabConfig.PaymentGracePeriod > 0 {
+		return tabConfig.PaymentGracePeriod
+	}
+	if tabConfig.MaxUnpaidBalance > 0 || credit.IsSet() {
+		return 0
+	}
+	return DEFAULT_PAYMENT_GRACE_PERIOD * time.Second
+}
+
+// isTabRequest returns true if the client presents the identity token of a
+// tab it opened.
+func isTabRequest(req *http.Request) bool {
+	return req.Header.Get(LSAT_TAB_HEADER) != ""
+}
+
+// OpenTab opens a new tab and returns the identity token the client
+// presents in the X-Lsat-Tab header.
+func (lsatmiddleware *GinLsatMiddleware) OpenTab(req *http.Request) (string, error) {
+	if lsatmiddleware.Tab == nil {
+		return "", fmt.Errorf("Tab mode is not enabled")
+	}
+	tokenId, err := macaroonutils.GenerateTokenId()
+	if err != nil {
+		return "", err
+	}
+	keyId, rootKey, err := lsatmiddleware.mintingRootKey(req)
+	if err != nil {
+		return "", err
+	}
+	token, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, keyId, lntypes.ZeroHash, tokenId)
+	if err != nil {
+		return "", err
+	}
+	token, err = lsatmiddleware.encodeMacaroon(token)
+	if err != nil {
+		return "", err
+	}
+	if err := lsatmiddleware.Tab.Store.Save(tab.New(hex.EncodeToString(tokenId[:]))); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// OpenTabHandler opens a tab and returns its identity token in the
+// X-Lsat-Tab header and the JSON body. Every tab is granted credit, so
+// mount it behind the operator's authentication, e.g.
+// account.POST("/lsat/tab", lsatmiddleware.OpenTabHandler)
+func (lsatmiddleware *GinLsatMiddleware) OpenTabHandler(c *gin.Context) {
+	if lsatmiddleware.Tab == nil {
+		abortWithMessage(c, http.StatusNotFound, "Tab mode is not enabled")
+		return
+	}
+	token, err := lsatmiddleware.OpenTab(c.Request)
+	if err != nil {
+		abortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header(LSAT_TAB_HEADER, token)
+	c.JSON(http.StatusCreated, gin.H{
+		"tab": token,
+	})
+}
+
+func (lsatmiddleware *GinLsatMiddleware) HandleTab(c *gin.Context) {
+	tabId, err := lsatmiddleware.getTabId(c)
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	lnInvoice := lsatmiddleware.priceInvoice(c.Request)
+	amountMsat := ln.InvoiceAmountMsat(&lnInvoice)
+	credit := lsatmiddleware.Tab.creditLimit(c.Request, tabId)
+
+	// LN lookups and invoices happen outside of the update, its retries
+	// would repeat them
+	var settled lntypes.Hash
+	if authField := c.Request.Header.Get("Authorization"); authField != "" {
+		settled, err = lsatmiddleware.verifyTabSettlement(c.Request, tabId, authField)
+		if err != nil {
+			lsatmiddleware.setLsatError(c, err)
+			return
+		}
+	}
+
+	// Outcome of the last attempt of the update
+	var challengeMessage string
+	var invoiceDue bool
+	t, err := tab.Update(lsatmiddleware.Tab.Store, tabId, func(t *tab.Tab) error {
+		challengeMessage = ""
+		invoiceDue = false
+
+		// Concurrent requests may have settled the invoice already
+		if settled != (lntypes.Hash{}) && t.HasOutstandingInvoice() && t.PaymentHash == settled {
+			if err := t.Settle(settled); err != nil {
+				return err
+			}
+		}
+
+		if lsatmiddleware.Tab.isBlocked(t, credit) {
+			challengeMessage = TAB_UNPAID_MESSAGE
+			return nil
+		}
+
+		if credit.ExceedsMsat(t, amountMsat) {
+			if !t.HasOutstandingInvoice() {
+				if t.BalanceMsat <= 0 {
+					return fmt.Errorf("Price of %d msat exceeds the credit limit of tab %s", amountMsat, t.ID)
+				}
+				invoiceDue = true
+			}
+			challengeMessage = CREDIT_EXHAUSTED_MESSAGE
+			return nil
+		}
+		t.ChargeMsat(amountMsat)
+		invoiceDue = lsatmiddleware.Tab.isSettlementDue(t, credit)
+		return nil
+	})
+	if err != nil {
+		lsatmiddleware.setLsatError(c, err)
+		return
+	}
+	if invoiceDue {
+		t, err = lsatmiddleware.invoiceTab(c, t)
+		if err != nil {
+			lsatmiddleware.setLsatError(c, err)
+			return
+		}
+	}
+	if challengeMessage != "" {
+		lsatmiddleware.abortWithTabChallenge(c, t, challengeMessage)
+		return
+	}
+	if invoiceDue {
+		c.Writer.Header().Set("WWW-Authenticate", lsatmiddleware.tabChallenge(t))
+	}
+	c.Set("LSAT", &LsatInfo{
+		Type:       LSAT_TYPE_TAB,
+		Amount:     invoiceAmount(&lnInvoice),
+		AmountMsat: amountMsat,
+	})
+}
+
+// verifyTabSettlement verifies that the LSAT in authField pays the
+// outstanding invoice of the tab with tabId and returns its payment hash,
+// the zero hash when nothing is invoiced.
+func (lsatmiddleware *GinLsatMiddleware) verifyTabSettlement(req *http.Request, tabId string, authField string) (lntypes.Hash, error) {
+	t, err := lsatmiddleware.Tab.Store.Get(tabId)
+	if err != nil {
+		return lntypes.Hash{}, err
+	}
+	if !t.HasOutstandingInvoice() {
+		return lntypes.Hash{}, nil
+	}
+	mac, preimage, err := utils.ParseLsatHeader(authField)
+	if err != nil {
+		return lntypes.Hash{}, err
+	}
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		return lntypes.Hash{}, err
+	}
+	if err := lsat.VerifyLSAT(mac, rootKey, preimage); err != nil {
+		return lntypes.Hash{}, err
+	}
+	if preimage.Hash() != t.PaymentHash {
+		return lntypes.Hash{}, fmt.Errorf("PaymentHash %s does not match outstanding invoice of tab %s", preimage.Hash(), t.ID)
+	}
+	if lsatmiddleware.RequireSettlement {
+		if err := lsatmiddleware.confirmTabSettlement(req, t); err != nil {
+			return lntypes.Hash{}, err
+		}
+	}
+	return t.PaymentHash, nil
+}
+
+// getTabId returns the id of the tab whose identity token the client
+// presented.
+func (lsatmiddleware *GinLsatMiddleware) getTabId(c *gin.Context) (string, error) {
+	mac, err := utils.GetMacaroonFromString(c.Request.Header.Get(LSAT_TAB_HEADER))
+	if err != nil {
+		return "", err
+	}
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		return "", err
+	}
+	macaroonId, err := lsat.VerifyIdentity(mac, rootKey)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(macaroonId.TokenId[:]), nil
+}
+
+// tabChallenge returns the WWW-Authenticate challenge of the outstanding
+// invoice of t.
+func (lsatmiddleware *GinLsatMiddleware) tabChallenge(t *tab.Tab) string {
+	lsatChallenge := &lsat.Challenge{
+		Macaroon: t.Macaroon,
+		Invoice:  t.Invoice,
+		Quote:    lsatmiddleware.ApertureCompat,
+		Scheme:   lsatmiddleware.scheme(),
+	}
+	return lsatChallenge.String()
+}
+
+// confirmTabSettlement checks that the outstanding invoice of t was settled
+// for its amount according to the LN backend that issued it.
+func (lsatmiddleware *GinLsatMiddleware) confirmTabSettlement(req *http.Request, t *tab.Tab) error {
+	ctx, cancel := lsatmiddleware.lnContext(req.Context())
+	defer cancel()
+	_, LNClientConn, err := lsatmiddleware.backend(t.Backend)
+	if err != nil {
+		return err
+	}
+	settled, amountPaidMsat, err := LNClientConn.LookupSettlement(ctx, t.PaymentHash)
+	if err != nil {
+		return err
+	}
+	if !settled {
+		return fmt.Errorf("Invoice for PaymentHash %s is not settled", t.PaymentHash)
+	}
+	if amountPaidMsat > 0 && amountPaidMsat < t.InvoicedAmountMsat {
+		return fmt.Errorf("Invoice for PaymentHash %s was paid %d of %d msat", t.PaymentHash, amountPaidMsat, t.InvoicedAmountMsat)
+	}
+	return nil
+}
+
+// settlementInvoice is a settlement invoice issued for the balance of a tab
+type settlementInvoice struct {
+	invoice     string
+	macaroon    string
+	paymentHash lntypes.Hash
+	amountMsat  int64
+	requests    int64
+	backend     string
+}
+
+// invoiceTab issues a settlement invoice for the balance of t and attaches
+// it to the tab, unless a concurrent request invoiced the tab meanwhile.
+func (lsatmiddleware *GinLsatMiddleware) invoiceTab(c *gin.Context, t *tab.Tab) (*tab.Tab, error) {
+	issued, err := lsatmiddleware.issueSettlementInvoice(c, t)
+	if err != nil {
+		return nil, err
+	}
+	return tab.Update(lsatmiddleware.Tab.Store, t.ID, func(t *tab.Tab) error {
+		if t.HasOutstandingInvoice() || t.BalanceMsat < issued.amountMsat {
+			return nil
+		}
+		t.Invoice = issued.invoice
+		t.Macaroon = issued.macaroon
+		t.PaymentHash = issued.paymentHash
+		t.InvoicedAmountMsat = issued.amountMsat
+		t.InvoicedAmount = (issued.amountMsat + ln.MSAT_PER_SAT - 1) / ln.MSAT_PER_SAT
+		t.InvoicedRequests = issued.requests
+		t.InvoicedAt = time.Now()
+		t.Backend = issued.backend
+		return nil
+	})
+}
+
+// issueSettlementInvoice issues an invoice for the balance of t.
+func (lsatmiddleware *GinLsatMiddleware) issueSettlementInvoice(c *gin.Context, t *tab.Tab) (*settlementInvoice, error) {
+	ctx, cancel := lsatmiddleware.lnContext(c.Request.Context())
+	defer cancel()
+	lnInvoice := lnrpc.Invoice{
+		ValueMsat: t.BalanceMsat,
+		Memo:      lsatmiddleware.memo(c.Request, t.Balance),
+		Expiry:    int64(lsatmiddleware.invoiceExpiry(c.Request, t.Balance) / time.Second),
+	}
+	backend, LNClientConn, err := lsatmiddleware.lnClientConn(c.Request)
+	if err != nil {
+		return nil, err
+	}
+	invoice, paymentHash, err := LNClientConn.GenerateInvoice(ctx, lnInvoice, c.Request)
+	if err != nil {
+		return nil, err
+	}
+	tokenId, err := macaroonutils.GenerateTokenId()
+	if err != nil {
+		return nil, err
+	}
+	keyId, rootKey, err := lsatmiddleware.mintingRootKey(c.Request)
+	if err != nil {
+		return nil, err
+	}
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, keyId, paymentHash, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	macaroonString, err = lsatmiddleware.encodeMacaroon(macaroonString)
+	if err != nil {
+		return nil, err
+	}
+	return &settlementInvoice{
+		invoice:     invoice,
+		macaroon:    macaroonString,
+		paymentHash: paymentHash,
+		amountMsat:  t.BalanceMsat,
+		requests:    t.UnsettledRequests,
+		backend:     backend,
+	}, nil
+}
+
+// abortWithTabChallenge responds with the outstanding settlement invoice of the tab.
+func (lsatmiddleware *GinLsatMiddleware) abortWithTabChallenge(c *gin.Context, t *tab.Tab, message string) {
+	c.Writer.Header().Set("WWW-Authenticate", lsatmiddleware.tabChallenge(t))
+	if c.GetBool(STATUS_ONLY_CHALLENGE_KEY) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusPaymentRequired, gin.H{
+		"code":    http.StatusPaymentRequired,
+		"message": message,
+	})
+}
+
+func (lsatmiddleware *GinLsatMiddleware) setLsatError(c *gin.Context, err error) {
+	c.Error(err)
+	c.Set("LSAT", &LsatInfo{
+		Error: err,
+	})
+}
@@ -0,0 +1,208 @@
+package ginlsat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/tab"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTabMiddleware(client *fakeLNClient, credit tab.CreditLimit) *GinLsatMiddleware {
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.Tab = &TabConfig{
+		Store:       tab.NewMemoryStore(),
+		CreditLimit: credit,
+	}
+	return lsatmiddleware
+}
+
+func openTab(t *testing.T, lsatmiddleware *GinLsatMiddleware) http.Header {
+	token, err := lsatmiddleware.OpenTab(httptest.NewRequest(http.MethodPost, "/lsat/tab", nil))
+	assert.NoError(t, err)
+	return http.Header{
+		LSAT_TAB_HEADER: {token},
+	}
+}
+
+func TestTabRequiresOpenedTab(t *testing.T) {
+	lsatmiddleware := newTabMiddleware(newFakeLNClient(), tab.CreditLimit{Requests: 10})
+	router := testRouter(lsatmiddleware, "/protected")
+
+	// Without a tab the client gets the per-request challenge
+	lsatChallenge := requestChallenge(t, router, "/protected")
+	assert.NotEmpty(t, lsatChallenge.Invoice)
+	res := serve(router, http.MethodGet, "/protected", http.Header{
+		"Accept": {"application/vnd.lsat.v1.full+json"},
+	})
+	assert.Empty(t, res.Header().Get(LSAT_TAB_HEADER))
+	res = serve(router, http.MethodGet, "/protected", nil)
+	assert.Equal(t, LSAT_TYPE_FREE, tokenType(t, res))
+
+	// A valid identity token of a tab that was never opened
+	header := openTab(t, lsatmiddleware)
+	lsatmiddleware.Tab.Store = tab.NewMemoryStore()
+	res = serve(router, http.MethodGet, "/protected", header)
+	assert.NotEqual(t, LSAT_TYPE_TAB, tokenType(t, res))
+
+	header = openTab(t, lsatmiddleware)
+	res = serve(router, http.MethodGet, "/protected", header)
+	assert.Equal(t, LSAT_TYPE_TAB, tokenType(t, res))
+}
+
+func TestOpenTabHandler(t *testing.T) {
+	lsatmiddleware := newTabMiddleware(newFakeLNClient(), tab.CreditLimit{Requests: 10})
+	router := testRouter(lsatmiddleware, "/protected")
+	router.POST("/lsat/tab", lsatmiddleware.OpenTabHandler)
+
+	res := serve(router, http.MethodPost, "/lsat/tab", nil)
+	assert.Equal(t, http.StatusCreated, res.Code)
+	res = serve(router, http.MethodGet, "/protected", http.Header{
+		LSAT_TAB_HEADER: {res.Header().Get(LSAT_TAB_HEADER)},
+	})
+	assert.Equal(t, LSAT_TYPE_TAB, tokenType(t, res))
+}
+
+func TestTabCreditLimitAppliesToFirstRequest(t *testing.T) {
+	lsatmiddleware := newTabMiddleware(newFakeLNClient(), tab.CreditLimit{Amount: TEST_AMOUNT - 1})
+	router := testRouter(lsatmiddleware, "/protected")
+
+	res := serve(router, http.MethodGet, "/protected", openTab(t, lsatmiddleware))
+	assert.NotEqual(t, LSAT_TYPE_TAB, tokenType(t, res))
+}
+
+func TestTabCreditLimitUnderConcurrentRequests(t *testing.T) {
+	const limit = 5
+	lsatmiddleware := newTabMiddleware(newFakeLNClient(), tab.CreditLimit{Requests: limit})
+	router := testRouter(lsatmiddleware, "/protected")
+	header := openTab(t, lsatmiddleware)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	served := 0
+	for i := 0; i < 4*limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := serve(router, http.MethodGet, "/protected", header)
+			if res.Code == http.StatusOK && tokenType(t, res) == LSAT_TYPE_TAB {
+				mu.Lock()
+				served++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, limit, served)
+}
+
+func TestTabSettlementIsConfirmedByIssuingBackend(t *testing.T) {
+	client := newFakeLNClient()
+	issuing := newFakeLNClient()
+	lsatmiddleware := newTabMiddleware(client, tab.CreditLimit{Requests: 1})
+	lsatmiddleware.RequireSettlement = true
+	lsatmiddleware.Backends = map[string]ln.LNClient{
+		"issuing": issuing,
+	}
+	backend := "issuing"
+	lsatmiddleware.BackendFunc = func(req *http.Request) string {
+		return backend
+	}
+	router := testRouter(lsatmiddleware, "/protected")
+	header := openTab(t, lsatmiddleware)
+
+	res := serve(router, http.MethodGet, "/protected", header)
+	assert.Equal(t, LSAT_TYPE_TAB, tokenType(t, res))
+	res = serve(router, http.MethodGet, "/protected", header)
+	assert.Equal(t, http.StatusPaymentRequired, res.Code)
+	lsatChallenge, err := lsat.ParseChallenge(res.Header().Get("WWW-Authenticate"))
+	assert.NoError(t, err)
+
+	// Requests are routed to another backend by the time the client settles
+	backend = ""
+	token := challengeToken(t, lsatChallenge, lntypes.Preimage{})
+	token = challengeToken(t, lsatChallenge, issuing.pay(token.PaymentHash()))
+	for key, values := range authorization(t, token) {
+		header[key] = values
+	}
+	res = serve(router, http.MethodGet, "/protected", header)
+	assert.Equal(t, LSAT_TYPE_TAB, tokenType(t, res))
+}
+
+// tabId returns the id of the tab of header
+func tabId(t *testing.T, lsatmiddleware *GinLsatMiddleware, header http.Header) string {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/protected", nil)
+	c.Request.Header = header
+	id, err := lsatmiddleware.getTabId(c)
+	assert.NoError(t, err)
+	return id
+}
+
+func TestTabChargesMsat(t *testing.T) {
+	lsatmiddleware := newTabMiddleware(newFakeLNClient(), tab.CreditLimit{Requests: 2})
+	lsatmiddleware.AmountFunc = nil
+	lsatmiddleware.AmountMsatFunc = func(req *http.Request) int64 {
+		return 1500
+	}
+	router := testRouter(lsatmiddleware, "/protected")
+	header := openTab(t, lsatmiddleware)
+
+	for i := 0; i < 2; i++ {
+		res := serve(router, http.MethodGet, "/protected", header)
+		assert.Equal(t, LSAT_TYPE_TAB, tokenType(t, res))
+	}
+	res := serve(router, http.MethodGet, "/protected", header)
+	assert.Equal(t, http.StatusPaymentRequired, res.Code)
+	stored, err := lsatmiddleware.Tab.Store.Get(tabId(t, lsatmiddleware, header))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3000), stored.InvoicedAmountMsat)
+	assert.Equal(t, int64(3), stored.InvoicedAmount)
+}
+
+func TestTabBlocksUnpaidSettlement(t *testing.T) {
+	for name, config := range map[string]*TabConfig{
+		"max unpaid balance": {
+			MaxUnpaidBalance: 2 * TEST_AMOUNT,
+		},
+		"grace period": {
+			MaxUnpaidBalance:   100 * TEST_AMOUNT,
+			PaymentGracePeriod: time.Minute,
+		},
+		"default grace period": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lsatmiddleware := newTestMiddleware(newFakeLNClient())
+			config.Store = tab.NewMemoryStore()
+			config.SettlementAmount = TEST_AMOUNT
+			lsatmiddleware.Tab = config
+			router := testRouter(lsatmiddleware, "/protected")
+			header := openTab(t, lsatmiddleware)
+
+			res := serve(router, http.MethodGet, "/protected", header)
+			assert.Equal(t, LSAT_TYPE_TAB, tokenType(t, res))
+			assert.NotEmpty(t, res.Header().Get("WWW-Authenticate"))
+			_, err := tab.Update(config.Store, tabId(t, lsatmiddleware, header), func(t *tab.Tab) error {
+				t.InvoicedAt = t.InvoicedAt.Add(-time.Hour)
+				return nil
+			})
+			assert.NoError(t, err)
+
+			blocked := false
+			for i := 0; i < 3 && !blocked; i++ {
+				res = serve(router, http.MethodGet, "/protected", header)
+				blocked = res.Code == http.StatusPaymentRequired
+			}
+			assert.True(t, blocked)
+			assert.Contains(t, res.Body.String(), TAB_UNPAID_MESSAGE)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package ginlsat
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LSAT_TYPE_TEASER is set while the protected handler renders the content
+// a teaser is cut from, handlers serve the full content for it.
+const LSAT_TYPE_TEASER = "TEASER"
+
+// TeaserFunc cuts the teaser served to unpaid requests from the full
+// response body.
+type TeaserFunc func(req *http.Request, body []byte) ([]byte, error)
+
+// teaserWriter buffers the response of the protected handler.
+type teaserWriter struct {
+	gin.ResponseWriter
+	body   *bytes.Buffer
+	status int
+}
+
+func (writer *teaserWriter) WriteHeader(code int) {
+	writer.status = code
+}
+
+// WriteHeaderNow is called by c.AbortWithStatus, the status is only written
+// with the teaser.
+func (writer *teaserWriter) WriteHeaderNow() {}
+
+func (writer *teaserWriter) Status() int {
+	return writer.status
+}
+
+func (writer *teaserWriter) Write(data []byte) (int, error) {
+	return writer.body.Write(data)
+}
+
+func (writer *teaserWriter) WriteString(s string) (int, error) {
+	return writer.body.WriteString(s)
+}
+
+// writeTeaser runs the protected handler and responds with status and the
+// teaser of its response, or a bare 402 when the handler fails.
+func (lsatmiddleware *GinLsatMiddleware) writeTeaser(c *gin.Context, status int) {
+	c.Set("LSAT", &LsatInfo{
+		Type: LSAT_TYPE_TEASER,
+	})
+	writer := &teaserWriter{
+		ResponseWriter: c.Writer,
+		body:           &bytes.Buffer{},
+		status:         http.StatusOK,
+	}
+	c.Writer = writer
+	c.Next()
+	c.Writer = writer.ResponseWriter
+	if writer.status < 200 || writer.status >= 300 {
+		abortWithMessage(c, http.StatusPaymentRequired, PAYMENT_REQUIRED_MESSAGE)
+		return
+	}
+	teaser, err := lsatmiddleware.Teaser(c.Request, writer.body.Bytes())
+	if err != nil {
+		c.Error(err)
+		abortWithMessage(c, http.StatusPaymentRequired, PAYMENT_REQUIRED_MESSAGE)
+		return
+	}
+	c.Data(status, c.Writer.Header().Get("Content-Type"), teaser)
+	c.Abort()
+}
+
+// TruncateJSONArray serves the first n items of a JSON array response.
+func TruncateJSONArray(n int) TeaserFunc {
+	return func(req *http.Request, body []byte) ([]byte, error) {
+		items := []json.RawMessage{}
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, err
+		}
+		if len(items) > n {
+			items = items[:n]
+		}
+		return json.Marshal(items)
+	}
+}
+
+// TruncateParagraphs serves the first n paragraphs of a text response,
+// paragraphs are separated by blank lines.
+func TruncateParagraphs(n int) TeaserFunc {
+	return func(req *http.Request, body []byte) ([]byte, error) {
+		paragraphs := strings.SplitAfterN(string(body), "\n\n", n+1)
+		if len(paragraphs) > n {
+			paragraphs = paragraphs[:n]
+		}
+		return []byte(strings.TrimRight(strings.Join(paragraphs, ""), "\n")), nil
+	}
+}
@@ -0,0 +1,71 @@
+package ginlsat
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+// respondWithArticles serves the full content to paid and teaser requests
+func respondWithArticles(c *gin.Context) {
+	lsatInfo := c.Value("LSAT").(*LsatInfo)
+	if lsatInfo.Type == LSAT_TYPE_PAID || lsatInfo.Type == LSAT_TYPE_TEASER {
+		c.JSON(http.StatusOK, []string{"first", "second", "third"})
+	}
+}
+
+func TestTeaser(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.Teaser = TruncateJSONArray(1)
+	gin.SetMode(gin.TestMode)
+	handler := gin.New()
+	handler.GET("/articles", lsatmiddleware.Handler, respondWithArticles)
+	handler.GET("/broken", lsatmiddleware.Handler, func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+	router := gofight.New()
+
+	router.GET("/articles").
+		SetHeader(gofight.H{
+			"Accept": "application/vnd.lsat.v1.full+json",
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			assert.NotEmpty(t, res.HeaderMap.Get("WWW-Authenticate"))
+			assert.Equal(t, `["first"]`, res.Body.String())
+		})
+
+	token := paidToken(t, client, handler, "/articles")
+	router.GET("/articles").
+		SetHeader(gofight.H{
+			"Authorization": authorization(t, token).Get("Authorization"),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Len(t, gjson.Parse(res.Body.String()).Array(), 3)
+		})
+
+	// No teaser is cut from failed responses
+	router.GET("/broken").
+		SetHeader(gofight.H{
+			"Accept": "application/vnd.lsat.v1.full+json",
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			assert.Equal(t, PAYMENT_REQUIRED_MESSAGE, gjson.Get(res.Body.String(), "message").String())
+		})
+}
+
+func TestTruncateParagraphs(t *testing.T) {
+	teaser, err := TruncateParagraphs(2)(nil, []byte("one\n\ntwo\n\nthree"))
+	assert.NoError(t, err)
+	assert.Equal(t, "one\n\ntwo", string(teaser))
+	teaser, err = TruncateParagraphs(2)(nil, []byte("one"))
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(teaser))
+}
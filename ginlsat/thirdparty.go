@@ -0,0 +1,31 @@
+package ginlsat
+
+import (
+	"net/http"
+
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"gopkg.in/macaroon.v2"
+)
+
+// LSAT_DISCHARGE_HEADER carries the discharges of the third-party caveats
+// of the token, comma separated and bound to the token
+const LSAT_DISCHARGE_HEADER = "X-Lsat-Discharge"
+
+// WithThirdPartyCaveat requires a discharge of the service at location,
+// approving condition, for every macaroon minted. The service derives the
+// discharge root key from secret with macaroon.DischargeRootKey.
+func (lsatmiddleware *GinLsatMiddleware) WithThirdPartyCaveat(location string, condition string, secret []byte) *GinLsatMiddleware {
+	lsatmiddleware.ThirdPartyCaveats = append(lsatmiddleware.ThirdPartyCaveats, macaroonutils.ThirdPartyCaveat{
+		Location:  location,
+		Condition: condition,
+		Secret:    secret,
+	})
+	return lsatmiddleware
+}
+
+// discharges returns the discharge macaroons presented with req.
+func discharges(req *http.Request) ([]*macaroon.Macaroon, error) {
+	return utils.ParseDischargeHeader(req.Header.Get(LSAT_DISCHARGE_HEADER))
+}
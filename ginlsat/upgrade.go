@@ -0,0 +1,180 @@
+package ginlsat
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/payment"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// UpgradeConfig lets holders of a paid token upgrade it, e.g. to a higher
+// tier or a longer expiry, by paying the difference instead of buying a new
+// token from scratch.
+type UpgradeConfig struct {
+	// UpgradeFunc returns the amount in sats of upgrading a token verified
+	// with caveats to tier and the caveats replacing those of the same
+	// condition in the upgraded token, e.g. a tier and expires_at caveat.
+	// An error refuses the upgrade.
+	UpgradeFunc func(req *http.Request, caveats caveat.Set, tier string) (amount int64, upgraded []caveat.Caveat, err error)
+}
+
+// UpgradeRequest asks for the upgrade of the presented token to Tier
+type UpgradeRequest struct {
+	Tier string `json:"tier"`
+}
+
+// upgradePaymentConditions are the caveats of the payment of the presented
+// token, they don't apply to the invoice of the upgrade
+var upgradePaymentConditions = map[string]bool{
+	caveat.OFFER_ID:        true,
+	caveat.ONCHAIN_ADDRESS: true,
+	caveat.PREIMAGE:        true,
+}
+
+// upgradedCaveats returns the caveats of the upgraded token: the verified
+// caveats of the presented token, including those its holder added, with
+// the conditions of upgraded replaced.
+func upgradedCaveats(verifiedCaveats caveat.Set, upgraded []caveat.Caveat) []string {
+	replaced := map[string]bool{}
+	for _, upgradedCaveat := range upgraded {
+		replaced[upgradedCaveat.Condition] = true
+	}
+	caveats := []string{}
+	for _, carried := range verifiedCaveats {
+		if !replaced[carried.Condition] && !upgradePaymentConditions[carried.Condition] {
+			caveats = append(caveats, carried.String())
+		}
+	}
+	for _, upgradedCaveat := range upgraded {
+		caveats = append(caveats, upgradedCaveat.String())
+	}
+	return caveats
+}
+
+// UpgradeHandler re-mints a paid token with upgraded caveats, e.g.
+// router.POST("/lsat/upgrade", lsatmiddleware.UpgradeHandler). It responds
+// with a 402 challenge for the price of the upgrade, the macaroon of the
+// challenge is the upgraded token once its invoice is paid. The presented
+// token stays valid with its own caveats, both tokens share the uses and
+// limits of the presented one. Requires Payments.
+func (lsatmiddleware *GinLsatMiddleware) UpgradeHandler(c *gin.Context) {
+	if lsatmiddleware.Upgrade == nil || lsatmiddleware.Upgrade.UpgradeFunc == nil {
+		abortWithMessage(c, http.StatusNotFound, "Token upgrades are not enabled")
+		return
+	}
+	if lsatmiddleware.Payments == nil {
+		abortWithMessage(c, http.StatusInternalServerError, "Token upgrades require a payment store")
+		return
+	}
+	mac, preimage, err := utils.ParseLsatHeader(c.Request.Header.Get("Authorization"))
+	if err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	rootKey, err := lsatmiddleware.verificationRootKey(mac)
+	if err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	dischargeMacaroons, err := discharges(c.Request)
+	if err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	// The token is used on other routes, only its expiry applies here
+	verifiedCaveats := caveat.Set{}
+	if err := lsat.VerifyLSATWithDischarges(mac, rootKey, dischargeMacaroons, preimage, checkExpiryOnly(c.Request, &verifiedCaveats)); err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err := lsatmiddleware.checkRevoked(mac); err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	upgradeReq := &UpgradeRequest{}
+	if err := c.ShouldBindJSON(upgradeReq); err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	amount, upgraded, err := lsatmiddleware.Upgrade.UpgradeFunc(c.Request, verifiedCaveats, upgradeReq.Tier)
+	if err != nil {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	// An invoice without amount could be paid with anything
+	if amount <= 0 {
+		abortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("Upgrade to tier %s has no price", upgradeReq.Tier))
+		return
+	}
+	macaroonString, invoice, err := lsatmiddleware.generateUpgradeChallenge(c.Request, lsatmiddleware.accountHash(preimage.Hash()), amount, upgradedCaveats(verifiedCaveats, upgraded))
+	if err != nil {
+		abortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	lsatmiddleware.writeChallenge(c, amount, macaroonString, invoice)
+}
+
+// generateUpgradeChallenge mints the upgraded token with caveats for the
+// invoice of amount, counting its uses and limits under accountHash.
+func (lsatmiddleware *GinLsatMiddleware) generateUpgradeChallenge(req *http.Request, accountHash lntypes.Hash, amount int64, caveats []string) (string, string, error) {
+	ctx, cancel := lsatmiddleware.lnContext(req.Context())
+	defer cancel()
+	backend, LNClientConn, err := lsatmiddleware.invoicingBackend(ctx, req, amount)
+	if err != nil {
+		return "", "", err
+	}
+	lnInvoice := lnrpc.Invoice{
+		Value:  amount,
+		Memo:   lsatmiddleware.memo(req, amount),
+		Expiry: int64(lsatmiddleware.invoiceExpiry(req, amount) / time.Second),
+	}
+	invoice, paymentHash, err := LNClientConn.GenerateInvoice(ctx, lnInvoice, req)
+	if err != nil {
+		return "", "", err
+	}
+	tokenId, err := macaroonutils.GenerateTokenId()
+	if err != nil {
+		return "", "", err
+	}
+	keyId, rootKey, err := lsatmiddleware.mintingRootKey(req)
+	if err != nil {
+		return "", "", err
+	}
+	caveats, err = lsatmiddleware.encryptCaveats(rootKey, tokenId, caveats)
+	if err != nil {
+		return "", "", err
+	}
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, keyId, paymentHash, tokenId, caveats...)
+	if err != nil {
+		return "", "", err
+	}
+	macaroonString, err = macaroonutils.AddThirdPartyCaveats(macaroonString, lsatmiddleware.ThirdPartyCaveats...)
+	if err != nil {
+		return "", "", err
+	}
+	macaroonString, err = lsatmiddleware.encodeMacaroon(macaroonString)
+	if err != nil {
+		return "", "", err
+	}
+	if err := lsatmiddleware.recordPayment(req, backend, paymentHash, tokenId, amount*ln.MSAT_PER_SAT, invoice); err != nil {
+		return "", "", err
+	}
+	_, err = payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		p.UpgradedFrom = accountHash
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return macaroonString, invoice, nil
+}
@@ -0,0 +1,84 @@
+package ginlsat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/lsat"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+// upgradeToken upgrades token to tier and pays the upgrade
+func upgradeToken(t *testing.T, client *fakeLNClient, router http.Handler, token *lsat.Token, tier string) *lsat.Token {
+	req := httptest.NewRequest(http.MethodPost, "/lsat/upgrade", strings.NewReader(`{"tier": "`+tier+`"}`))
+	req.Header = authorization(t, token)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusPaymentRequired, res.Code)
+	lsatChallenge, err := lsat.ParseChallenge(res.Header().Get("WWW-Authenticate"))
+	assert.NoError(t, err)
+	upgraded := challengeToken(t, lsatChallenge, lntypes.Preimage{})
+	return challengeToken(t, lsatChallenge, client.preimage(upgraded.PaymentHash()))
+}
+
+func TestUpgradeKeepsRestrictions(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.CaveatFunc = func(req *http.Request) []caveat.Caveat {
+		return caveat.NewBuilder().Tier("basic").Build()
+	}
+	lsatmiddleware.Upgrade = &UpgradeConfig{
+		UpgradeFunc: func(req *http.Request, caveats caveat.Set, tier string) (int64, []caveat.Caveat, error) {
+			return TEST_AMOUNT, caveat.NewBuilder().Tier(tier).Build(), nil
+		},
+	}
+	router := testRouter(lsatmiddleware, "/api/*resource")
+	router.POST("/lsat/upgrade", lsatmiddleware.UpgradeHandler)
+
+	token := paidToken(t, client, router, "/api/reports", caveat.New(caveat.PATH, "/api/reports"))
+	upgraded := upgradeToken(t, client, router, token, "pro")
+
+	response := decodeResponse(t, serve(router, http.MethodGet, "/api/reports", authorization(t, upgraded)))
+	assert.Equal(t, LSAT_TYPE_PAID, response.Type)
+	assert.Equal(t, "pro", response.Tier)
+	response = decodeResponse(t, serve(router, http.MethodGet, "/api/billing", authorization(t, upgraded)))
+	assert.NotEqual(t, LSAT_TYPE_PAID, response.Type)
+}
+
+func TestUpgradeSharesUses(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.CaveatFunc = func(req *http.Request) []caveat.Caveat {
+		return caveat.NewBuilder().Tier("basic").MaxUses(2).Build()
+	}
+	lsatmiddleware.Upgrade = &UpgradeConfig{
+		UpgradeFunc: func(req *http.Request, caveats caveat.Set, tier string) (int64, []caveat.Caveat, error) {
+			return TEST_AMOUNT, caveat.NewBuilder().Tier(tier).Build(), nil
+		},
+	}
+	router := testRouter(lsatmiddleware, "/reports")
+	router.POST("/lsat/upgrade", lsatmiddleware.UpgradeHandler)
+
+	token := paidToken(t, client, router, "/reports")
+	response := decodeResponse(t, serve(router, http.MethodGet, "/reports", authorization(t, token)))
+	assert.Equal(t, LSAT_TYPE_PAID, response.Type)
+	upgraded := upgradeToken(t, client, router, token, "pro")
+	response = decodeResponse(t, serve(router, http.MethodGet, "/reports", authorization(t, upgraded)))
+	assert.Equal(t, LSAT_TYPE_PAID, response.Type)
+	assert.Equal(t, "pro", response.Tier)
+
+	// Both tokens used up the 2 uses of the presented token
+	response = decodeResponse(t, serve(router, http.MethodGet, "/reports", authorization(t, upgraded)))
+	assert.NotEqual(t, LSAT_TYPE_PAID, response.Type)
+	response = decodeResponse(t, serve(router, http.MethodGet, "/reports", authorization(t, token)))
+	assert.NotEqual(t, LSAT_TYPE_PAID, response.Type)
+	// Upgrading the upgraded token doesn't buy new uses either
+	upgraded = upgradeToken(t, client, router, upgraded, "enterprise")
+	response = decodeResponse(t, serve(router, http.MethodGet, "/reports", authorization(t, upgraded)))
+	assert.NotEqual(t, LSAT_TYPE_PAID, response.Type)
+}
@@ -0,0 +1,239 @@
+package ginlsat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/lease"
+	"github.com/kiwiidb/gin-lsat/payment"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+const (
+	// SETTLEMENT_WATCHER_LEASE is the lease the watchers of all replicas
+	// compete for
+	SETTLEMENT_WATCHER_LEASE = "settlement-watcher"
+	DEFAULT_LEASE_TTL        = 30 * time.Second
+	// MIN_RESUBSCRIBE_DELAY doubles up to MAX_RESUBSCRIBE_DELAY while the
+	// subscription keeps failing without settlements
+	MIN_RESUBSCRIBE_DELAY = time.Second
+	MAX_RESUBSCRIBE_DELAY = time.Minute
+)
+
+// SettlementWatcher records settlements of the invoices issued by the
+// default backend as they happen, instead of when the preimage is first
+// presented. With several replicas against the same node, only the replica
+// holding the lease watches.
+type SettlementWatcher struct {
+	Middleware *GinLsatMiddleware
+	Leases     lease.Store
+	// Holder identifies this replica, the hostname when empty
+	Holder   string
+	LeaseTTL time.Duration
+	// OnSettled is called once per settled payment over all replicas, e.g.
+	// to send a webhook
+	OnSettled func(p *payment.Payment)
+	// OnError is called with the errors of the lease, the subscription and
+	// the settlements, e.g. to log them. Failed subscriptions are retried
+	// with backoff.
+	OnError func(err error)
+	// settleIndex is the settle index of the last settlement seen, so
+	// settlements missed while resubscribing are replayed. It is only kept
+	// in memory: a replica taking over the lease watches settlements from
+	// then on, earlier ones are confirmed by the lookup of
+	// confirmSettlement when their token is presented.
+	settleIndex uint64
+}
+
+// Run watches settlements while this replica holds the lease, until ctx is
+// done. The lease is renewed every third of its ttl, a replica that fails
+// to renew stops watching before another one can take over.
+func (watcher *SettlementWatcher) Run(ctx context.Context) error {
+	if watcher.Middleware.Payments == nil {
+		return fmt.Errorf("Settlement watcher requires a payment store")
+	}
+	holder := watcher.Holder
+	if holder == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		holder = hostname
+	}
+	ttl := watcher.LeaseTTL
+	if ttl == 0 {
+		ttl = DEFAULT_LEASE_TTL
+	}
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	var stopWatching context.CancelFunc
+	stop := func() {
+		if stopWatching != nil {
+			stopWatching()
+			stopWatching = nil
+		}
+	}
+	defer stop()
+	defer watcher.Leases.Release(SETTLEMENT_WATCHER_LEASE, holder)
+	for {
+		leader, err := watcher.Leases.Acquire(SETTLEMENT_WATCHER_LEASE, holder, ttl)
+		if err != nil {
+			watcher.reportError(err)
+		}
+		if err != nil || !leader {
+			stop()
+		} else if stopWatching == nil {
+			watchCtx, cancel := context.WithCancel(ctx)
+			stopWatching = cancel
+			go watcher.watch(watchCtx)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// watch resubscribes when the subscription fails until ctx is done,
+// replaying the settlements missed in between.
+func (watcher *SettlementWatcher) watch(ctx context.Context) {
+	delay := MIN_RESUBSCRIBE_DELAY
+	for {
+		settleIndex := atomic.LoadUint64(&watcher.settleIndex)
+		err := watcher.track(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			watcher.reportError(err)
+		}
+		if atomic.LoadUint64(&watcher.settleIndex) != settleIndex {
+			delay = MIN_RESUBSCRIBE_DELAY
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > MAX_RESUBSCRIBE_DELAY {
+			delay = MAX_RESUBSCRIBE_DELAY
+		}
+	}
+}
+
+// track subscribes to the settlements of the default backend until ctx is
+// done or the subscription fails.
+func (watcher *SettlementWatcher) track(ctx context.Context) error {
+	_, lnClientConn, err := watcher.Middleware.backend(DEFAULT_BACKEND)
+	if err != nil {
+		return err
+	}
+	return lnClientConn.TrackSettlements(ctx, atomic.LoadUint64(&watcher.settleIndex), func(paymentHash lntypes.Hash, amountPaidMsat int64, settleIndex uint64) error {
+		if err := watcher.settle(paymentHash, amountPaidMsat); err != nil {
+			return err
+		}
+		atomic.StoreUint64(&watcher.settleIndex, settleIndex)
+		return nil
+	})
+}
+
+func (watcher *SettlementWatcher) reportError(err error) {
+	if watcher.OnError != nil {
+		watcher.OnError(err)
+	}
+}
+
+// settle records a settlement of amountPaidMsat, payments issued by other
+// services on the node and underpaid invoices are ignored. The
+// compare-and-set on NotifiedAt keeps OnSettled from firing twice when
+// leadership changes.
+func (watcher *SettlementWatcher) settle(paymentHash lntypes.Hash, amountPaidMsat int64) error {
+	p, err := watcher.Middleware.Payments.Get(paymentHash)
+	if err != nil {
+		return nil
+	}
+	if err := checkAmountPaid(p, amountPaidMsat); err != nil {
+		watcher.reportError(err)
+		return nil
+	}
+	notify := false
+	p, err = payment.Update(watcher.Middleware.Payments, paymentHash, func(p *payment.Payment) error {
+		now := time.Now()
+		if !p.IsSettled() {
+			p.SettledAt = now
+		}
+		if p.ConfirmedAt.IsZero() {
+			p.ConfirmedAt = now
+		}
+		notify = p.NotifiedAt.IsZero()
+		if notify {
+			p.NotifiedAt = now
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if notify && watcher.OnSettled != nil {
+		watcher.OnSettled(p)
+	}
+	return nil
+}
+
+// confirmSettlement checks that the invoice of paymentHash was settled
+// according to the LN backend. Settlements recorded by the watcher are
+// trusted, others are looked up once, e.g. when the token is presented
+// before the watcher saw the settlement.
+func (lsatmiddleware *GinLsatMiddleware) confirmSettlement(req *http.Request, paymentHash lntypes.Hash) error {
+	if lsatmiddleware.Payments == nil {
+		return fmt.Errorf("Settlement confirmation requires a payment store")
+	}
+	p, err := lsatmiddleware.Payments.Get(paymentHash)
+	if err != nil {
+		return fmt.Errorf("Payment %s is unknown", paymentHash)
+	}
+	if !p.ConfirmedAt.IsZero() {
+		return nil
+	}
+	ctx, cancel := lsatmiddleware.lnContext(req.Context())
+	defer cancel()
+	_, lnClientConn, err := lsatmiddleware.paymentBackend(req, paymentHash)
+	if err != nil {
+		return err
+	}
+	settled, amountPaidMsat, err := lnClientConn.LookupSettlement(ctx, paymentHash)
+	if err != nil {
+		return err
+	}
+	if !settled {
+		return fmt.Errorf("Invoice for PaymentHash %s is not settled", paymentHash)
+	}
+	if err := checkAmountPaid(p, amountPaidMsat); err != nil {
+		return err
+	}
+	_, err = payment.Update(lsatmiddleware.Payments, paymentHash, func(p *payment.Payment) error {
+		if p.ConfirmedAt.IsZero() {
+			p.ConfirmedAt = time.Now()
+		}
+		return nil
+	})
+	return err
+}
+
+// checkAmountPaid returns an error when the invoice of p was settled for
+// less than the challenge asked for, e.g. a crafted invoice. Asset invoices
+// are paid in units of the asset.
+func checkAmountPaid(p *payment.Payment, amountPaidMsat int64) error {
+	if amountPaidMsat > 0 && p.AssetId == "" && amountPaidMsat < p.AmountMsat {
+		return fmt.Errorf("Invoice for PaymentHash %s was paid %d of %d msat", p.PaymentHash, amountPaidMsat, p.AmountMsat)
+	}
+	return nil
+}
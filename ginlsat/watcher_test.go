@@ -0,0 +1,72 @@
+package ginlsat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/lease"
+	"github.com/kiwiidb/gin-lsat/ln"
+	"github.com/kiwiidb/gin-lsat/payment"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherIgnoresUnderpaidSettlements(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	router := testRouter(lsatmiddleware, "/protected")
+	settled := []lntypes.Hash{}
+	watcher := &SettlementWatcher{
+		Middleware: lsatmiddleware,
+		Leases:     lease.NewMemoryStore(),
+		OnSettled: func(p *payment.Payment) {
+			settled = append(settled, p.PaymentHash)
+		},
+	}
+
+	token := challengeToken(t, requestChallenge(t, router, "/protected"), lntypes.Preimage{})
+	assert.NoError(t, watcher.settle(token.PaymentHash(), TEST_AMOUNT*ln.MSAT_PER_SAT-1))
+	p, err := lsatmiddleware.Payments.Get(token.PaymentHash())
+	assert.NoError(t, err)
+	assert.True(t, p.ConfirmedAt.IsZero())
+	assert.Empty(t, settled)
+
+	assert.NoError(t, watcher.settle(token.PaymentHash(), TEST_AMOUNT*ln.MSAT_PER_SAT))
+	assert.NoError(t, watcher.settle(token.PaymentHash(), TEST_AMOUNT*ln.MSAT_PER_SAT))
+	p, err = lsatmiddleware.Payments.Get(token.PaymentHash())
+	assert.NoError(t, err)
+	assert.False(t, p.ConfirmedAt.IsZero())
+	assert.Equal(t, []lntypes.Hash{token.PaymentHash()}, settled)
+}
+
+func TestWatcherReportsSubscriptionErrors(t *testing.T) {
+	lsatmiddleware := newTestMiddleware(newFakeLNClient())
+	errs := make(chan error, 10)
+	watcher := &SettlementWatcher{
+		Middleware: lsatmiddleware,
+		Leases:     lease.NewMemoryStore(),
+		Holder:     "replica",
+		OnError: func(err error) {
+			errs <- err
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+
+	// The fake backend doesn't support subscriptions
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, "LN client does not support invoice subscriptions")
+	case <-time.After(time.Second):
+		t.Fatal("Subscription error was not reported")
+	}
+	// The retry waits for the backoff
+	select {
+	case <-errs:
+		t.Fatal("Subscription was retried without backoff")
+	case <-time.After(MIN_RESUBSCRIBE_DELAY / 2):
+	}
+}
@@ -0,0 +1,84 @@
+package ginlsat
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/lsat"
+	"github.com/kiwiidb/gin-lsat/x402"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+// x402PaymentHeader returns the X-PAYMENT header paying the challenge with
+// macaroonString with preimage
+func x402PaymentHeader(t *testing.T, macaroonString string, preimage lntypes.Preimage) string {
+	payload, err := json.Marshal(&x402.PaymentPayload{
+		X402Version: x402.X402_VERSION,
+		Scheme:      x402.SCHEME_LSAT,
+		Network:     x402.NETWORK_LIGHTNING,
+		Payload: map[string]string{
+			"macaroon": macaroonString,
+			"preimage": preimage.String(),
+		},
+	})
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(payload)
+}
+
+func TestX402ChallengesOnlyX402Clients(t *testing.T) {
+	client := newFakeLNClient()
+	lsatmiddleware := newTestMiddleware(client)
+	lsatmiddleware.X402 = true
+	handler := testRouter(lsatmiddleware, "/protected")
+	router := gofight.New()
+
+	router.GET("/protected").
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_FREE, gjson.Get(res.Body.String(), "type").String())
+		})
+
+	router.GET("/protected").
+		SetHeader(gofight.H{
+			"Accept": "application/vnd.lsat.v1.full+json",
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			assert.Equal(t, PAYMENT_REQUIRED_MESSAGE, gjson.Get(res.Body.String(), "message").String())
+			assert.False(t, gjson.Get(res.Body.String(), "x402Version").Exists())
+		})
+
+	var macaroonString string
+	router.GET("/protected").
+		SetHeader(gofight.H{
+			"Accept": x402.MEDIA_TYPE,
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusPaymentRequired, res.Code)
+			assert.Equal(t, int64(x402.X402_VERSION), gjson.Get(res.Body.String(), "x402Version").Int())
+			assert.Equal(t, x402.SCHEME_LSAT, gjson.Get(res.Body.String(), "accepts.0.scheme").String())
+			macaroonString = gjson.Get(res.Body.String(), "accepts.0.extra.macaroon").String()
+			assert.NotEmpty(t, macaroonString)
+		})
+
+	lsatChallenge := &lsat.Challenge{
+		Macaroon: macaroonString,
+	}
+	token, err := lsatChallenge.Token()
+	assert.NoError(t, err)
+	router.GET("/protected").
+		SetHeader(gofight.H{
+			x402.PAYMENT_HEADER: x402PaymentHeader(t, macaroonString, client.preimage(token.PaymentHash())),
+		}).
+		Run(handler, func(res gofight.HTTPResponse, req gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, LSAT_TYPE_PAID, gjson.Get(res.Body.String(), "type").String())
+			assert.NotEmpty(t, res.HeaderMap.Get(x402.PAYMENT_RESPONSE_HEADER))
+		})
+}
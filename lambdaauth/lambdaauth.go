@@ -0,0 +1,141 @@
+package lambdaauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+const (
+	POLICY_VERSION = "2012-10-17"
+	EFFECT_ALLOW   = "Allow"
+	EFFECT_DENY    = "Deny"
+)
+
+// ErrUnauthorized makes API Gateway respond with a 401, configure its
+// UNAUTHORIZED gateway response to point clients to the issuer.
+var ErrUnauthorized = errors.New("Unauthorized")
+
+// Authorizer is an API Gateway Lambda request authorizer accepting LSATs
+// minted by a central issuer sharing the root keys (ROOT_KEY and
+// ROOT_KEY_<ID> of the ids in KEY_IDS). It is stateless, caveats that need a store like max_uses
+// can't be enforced.
+type Authorizer struct {
+	// CaveatCheckers verify caveats by condition, next to the builtin checkers
+	CaveatCheckers map[string]caveat.Checker
+}
+
+// Handler is the Lambda handler, e.g. lambda.Start((&lambdaauth.Authorizer{}).Handler).
+// Requests without LSAT are rejected with ErrUnauthorized, invalid LSATs get
+// a Deny policy and paid requests an Allow policy with the payment hash as
+// principal and the token in the authorizer context.
+func (authorizer *Authorizer) Handler(ctx context.Context, event events.APIGatewayCustomAuthorizerRequestTypeRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+	authField := header(event.Headers, "Authorization")
+	if authField == "" {
+		return events.APIGatewayCustomAuthorizerResponse{}, ErrUnauthorized
+	}
+	mac, preimage, err := utils.ParseLsatHeader(authField)
+	if err != nil {
+		return events.APIGatewayCustomAuthorizerResponse{}, ErrUnauthorized
+	}
+	macaroonId, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return policy("anonymous", EFFECT_DENY, event.MethodArn, nil), nil
+	}
+	rootKey := utils.GetRootKeyById(macaroonId.KeyId)
+	if len(rootKey) == 0 {
+		return policy("anonymous", EFFECT_DENY, event.MethodArn, nil), nil
+	}
+	verifiedCaveats := caveat.Set{}
+	check := caveat.Check(newHttpRequest(ctx, event), authorizer.CaveatCheckers)
+	err = lsat.VerifyLSATWithCaveats(mac, rootKey, preimage, func(caveatString string) error {
+		if err := check(caveatString); err != nil {
+			return err
+		}
+		parsed, err := caveat.Parse(caveatString)
+		if err != nil {
+			return err
+		}
+		verifiedCaveats = append(verifiedCaveats, parsed)
+		return nil
+	})
+	if err != nil {
+		return policy("anonymous", EFFECT_DENY, event.MethodArn, nil), nil
+	}
+	return policy(macaroonId.PaymentHash.String(), EFFECT_ALLOW, event.MethodArn, authorizerContext(macaroonId.PaymentHash, verifiedCaveats)), nil
+}
+
+func policy(principalId string, effect string, resource string, authContext map[string]interface{}) events.APIGatewayCustomAuthorizerResponse {
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID: principalId,
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version: POLICY_VERSION,
+			Statement: []events.IAMPolicyStatement{
+				{
+					Action:   []string{"execute-api:Invoke"},
+					Effect:   effect,
+					Resource: []string{resource},
+				},
+			},
+		},
+		Context: authContext,
+	}
+}
+
+// authorizerContext is passed to the integration, e.g. as
+// $context.authorizer.lsat_type. Caveats are added by condition.
+func authorizerContext(paymentHash lntypes.Hash, verifiedCaveats caveat.Set) map[string]interface{} {
+	authContext := map[string]interface{}{
+		"lsat_type":    "PAID",
+		"payment_hash": paymentHash.String(),
+	}
+	for _, verifiedCaveat := range verifiedCaveats {
+		authContext["caveat_"+verifiedCaveat.Condition] = verifiedCaveat.Value
+	}
+	return authContext
+}
+
+// header returns a header of the event, API Gateway doesn't normalize the
+// case of header names.
+func header(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// newHttpRequest rebuilds the request for caveat checks.
+func newHttpRequest(ctx context.Context, event events.APIGatewayCustomAuthorizerRequestTypeRequest) *http.Request {
+	query := url.Values{}
+	for key, value := range event.QueryStringParameters {
+		query.Set(key, value)
+	}
+	httpReq := &http.Request{
+		Method: event.HTTPMethod,
+		URL: &url.URL{
+			Path:     event.Path,
+			RawQuery: query.Encode(),
+		},
+		Header: http.Header{},
+	}
+	for key, value := range event.Headers {
+		httpReq.Header.Set(key, value)
+	}
+	httpReq = httpReq.WithContext(ctx)
+	if len(event.PathParameters) > 0 {
+		httpReq = utils.WithRouteParams(httpReq, event.PathParameters)
+	}
+	return httpReq
+}
@@ -0,0 +1,91 @@
+package lambdaauth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/lsat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+const TEST_METHOD_ARN = "arn:aws:execute-api:eu-west-1:123456789012:api/prod/GET/articles/42"
+
+// testAuthorization mints a paid token with rootKey and returns its
+// Authorization header
+func testAuthorization(t *testing.T, rootKey []byte, keyId string, caveats ...string) (string, lntypes.Hash) {
+	tokenId, err := macaroonutils.GenerateTokenId()
+	assert.NoError(t, err)
+	preimage := lntypes.Preimage(tokenId)
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, keyId, preimage.Hash(), tokenId, caveats...)
+	assert.NoError(t, err)
+	mac, err := utils.GetMacaroonFromString(macaroonString)
+	assert.NoError(t, err)
+	token := &lsat.Token{
+		Macaroon: mac,
+		Preimage: preimage,
+	}
+	authorization, err := token.MarshalHeader()
+	assert.NoError(t, err)
+	return authorization, preimage.Hash()
+}
+
+func testEvent(authorization string) events.APIGatewayCustomAuthorizerRequestTypeRequest {
+	return events.APIGatewayCustomAuthorizerRequestTypeRequest{
+		MethodArn:  TEST_METHOD_ARN,
+		HTTPMethod: "GET",
+		Path:       "/articles/42",
+		Headers: map[string]string{
+			"authorization": authorization,
+		},
+		PathParameters: map[string]string{
+			"articleId": "42",
+		},
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Setenv("ROOT_KEY", "root_key")
+	t.Setenv("ROOT_KEY_V2", "root_key_v2")
+	t.Setenv("KEY_IDS", "V2")
+	authorizer := &Authorizer{}
+	ctx := context.Background()
+
+	authorization, paymentHash := testAuthorization(t, []byte("root_key_v2"), "V2", caveat.New(caveat.PATH, "/articles/*").String())
+	res, err := authorizer.Handler(ctx, testEvent(authorization))
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash.String(), res.PrincipalID)
+	assert.Equal(t, EFFECT_ALLOW, res.PolicyDocument.Statement[0].Effect)
+	assert.Equal(t, []string{TEST_METHOD_ARN}, res.PolicyDocument.Statement[0].Resource)
+	assert.Equal(t, "PAID", res.Context["lsat_type"])
+	assert.Equal(t, paymentHash.String(), res.Context["payment_hash"])
+	assert.Equal(t, "/articles/*", res.Context["caveat_path"])
+
+	for name, authorization := range map[string]string{
+		"other path":       first(testAuthorization(t, []byte("root_key_v2"), "V2", caveat.New(caveat.PATH, "/reports/*").String())),
+		"wrong root key":   first(testAuthorization(t, []byte("root_key"), "V2")),
+		"unlisted key id":  first(testAuthorization(t, []byte("V2"), "id")),
+		"unknown caveat":   first(testAuthorization(t, []byte("root_key"), "", "unknown=1")),
+		"missing preimage": authorization[:len(authorization)-len(":")-64],
+	} {
+		res, err := authorizer.Handler(ctx, testEvent(authorization))
+		if err != nil {
+			assert.ErrorIs(t, err, ErrUnauthorized, name)
+			continue
+		}
+		assert.Equal(t, EFFECT_DENY, res.PolicyDocument.Statement[0].Effect, name)
+		assert.Equal(t, "anonymous", res.PrincipalID, name)
+	}
+
+	_, err = authorizer.Handler(ctx, testEvent(""))
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func first(authorization string, paymentHash lntypes.Hash) string {
+	return authorization
+}
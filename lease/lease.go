@@ -0,0 +1,58 @@
+package lease
+
+import (
+	"sync"
+	"time"
+)
+
+// Store grants time-limited leases, replicas sharing a store elect a leader
+// by acquiring the same lease. Shared stores must implement Acquire
+// atomically, e.g. with Redis SET NX PX or a Postgres
+// INSERT ... ON CONFLICT ... WHERE expires_at < now() OR holder = $2.
+type Store interface {
+	// Acquire grants the lease name to holder or renews it until ttl from
+	// now, false when another holder has an unexpired lease
+	Acquire(name string, holder string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if holder has it
+	Release(name string, holder string) error
+}
+
+type lease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+type MemoryStore struct {
+	mu     sync.Mutex
+	leases map[string]lease
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		leases: map[string]lease{},
+	}
+}
+
+func (store *MemoryStore) Acquire(name string, holder string, ttl time.Duration) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	current, ok := store.leases[name]
+	if ok && current.holder != holder && now.Before(current.expiresAt) {
+		return false, nil
+	}
+	store.leases[name] = lease{
+		holder:    holder,
+		expiresAt: now.Add(ttl),
+	}
+	return true, nil
+}
+
+func (store *MemoryStore) Release(name string, holder string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if current, ok := store.leases[name]; ok && current.holder == holder {
+		delete(store.leases, name)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package ln
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of calling a backend that keeps failing
+var ErrCircuitOpen = errors.New("LN backend is unavailable, circuit is open")
+
+// CircuitBreaker stops calls to a backend after FailureThreshold
+// consecutive failures. After CoolDown a single trial call is let through,
+// closing the circuit again when it succeeds.
+type CircuitBreaker struct {
+	FailureThreshold int
+	CoolDown         time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(failureThreshold int, coolDown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: failureThreshold,
+		CoolDown:         coolDown,
+	}
+}
+
+// Allow returns false while the circuit is open.
+func (breaker *CircuitBreaker) Allow() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if breaker.failures < breaker.FailureThreshold {
+		return true
+	}
+	if time.Since(breaker.openedAt) < breaker.CoolDown {
+		return false
+	}
+	// Let one trial call through and keep the others out for another cool-down
+	breaker.openedAt = time.Now()
+	return true
+}
+
+// IsOpen returns true while calls are stopped.
+func (breaker *CircuitBreaker) IsOpen() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return breaker.failures >= breaker.FailureThreshold && time.Since(breaker.openedAt) < breaker.CoolDown
+}
+
+func (breaker *CircuitBreaker) Success() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.failures = 0
+}
+
+func (breaker *CircuitBreaker) Failure() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.failures++
+	if breaker.failures >= breaker.FailureThreshold {
+		breaker.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,30 @@
+package ln
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(2, 20*time.Millisecond)
+	breaker.Failure()
+	assert.True(t, breaker.Allow())
+	// Failures must be consecutive
+	breaker.Success()
+	breaker.Failure()
+	assert.False(t, breaker.IsOpen())
+
+	breaker.Failure()
+	assert.True(t, breaker.IsOpen())
+	assert.False(t, breaker.Allow())
+
+	// A single trial call is let through after the cool-down
+	time.Sleep(30 * time.Millisecond)
+	assert.False(t, breaker.IsOpen())
+	assert.True(t, breaker.Allow())
+	assert.False(t, breaker.Allow())
+	breaker.Success()
+	assert.True(t, breaker.Allow())
+}
@@ -0,0 +1,200 @@
+package ln
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"google.golang.org/grpc"
+)
+
+const (
+	BTCPAY_INVOICE_STATUS_PAID    = "Paid"
+	BTCPAY_INVOICE_STATUS_EXPIRED = "Expired"
+)
+
+// BTCPayOptions configure the Lightning node of a BTCPay Server store
+// through the Greenfield API. The API key needs the
+// btcpay.store.cancreatelightninginvoice and btcpay.store.canviewlightninginvoice
+// permissions, on-chain payments also btcpay.store.canmodifystoresettings.
+type BTCPayOptions struct {
+	Address string
+	StoreId string
+	APIKey  string
+	// Client is used for all requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type BTCPayWrapper struct {
+	options BTCPayOptions
+	// invoiceIds maps payment hashes to BTCPay invoice ids, which differ
+	// from the payment hash for some node implementations
+	invoiceIds sync.Map
+}
+
+type btcpayCreateInvoiceRequest struct {
+	Amount      string `json:"amount"`
+	Description string `json:"description"`
+	Expiry      int64  `json:"expiry,omitempty"`
+}
+
+type btcpayAddressResponse struct {
+	Address string `json:"address"`
+}
+
+type btcpayUtxo struct {
+	Address       string `json:"address"`
+	Amount        string `json:"amount"`
+	Confirmations int64  `json:"confirmations"`
+}
+
+type btcpayInvoiceResponse struct {
+	Id          string `json:"id"`
+	Status      string `json:"status"`
+	BOLT11      string `json:"BOLT11"`
+	PaymentHash string `json:"paymentHash"`
+	Preimage    string `json:"preimage"`
+	Amount      string `json:"amount"`
+	PaidAt      int64  `json:"paidAt"`
+}
+
+func NewBTCPayClient(btcpayOptions BTCPayOptions) (*BTCPayWrapper, error) {
+	if btcpayOptions.Address == "" || btcpayOptions.StoreId == "" {
+		return nil, fmt.Errorf("BTCPay address or store id is missing")
+	}
+	if btcpayOptions.APIKey == "" {
+		return nil, fmt.Errorf("BTCPay API key is missing")
+	}
+	btcpayOptions.Address = strings.TrimSuffix(btcpayOptions.Address, "/")
+	return &BTCPayWrapper{
+		options: btcpayOptions,
+	}, nil
+}
+
+func (wrapper *BTCPayWrapper) call(ctx context.Context, method string, path string, reqBody interface{}, resBody interface{}) error {
+	header := http.Header{}
+	header.Set("Authorization", "token "+wrapper.options.APIKey)
+	url := fmt.Sprintf("%s/api/v1/stores/%s/lightning/BTC%s", wrapper.options.Address, wrapper.options.StoreId, path)
+	return doJSONRequest(ctx, wrapper.options.Client, method, url, header, reqBody, resBody)
+}
+
+func (wrapper *BTCPayWrapper) callOnchain(ctx context.Context, path string, resBody interface{}) error {
+	header := http.Header{}
+	header.Set("Authorization", "token "+wrapper.options.APIKey)
+	url := fmt.Sprintf("%s/api/v1/stores/%s/payment-methods/onchain/BTC/wallet%s", wrapper.options.Address, wrapper.options.StoreId, path)
+	return doJSONRequest(ctx, wrapper.options.Client, http.MethodGet, url, header, nil, resBody)
+}
+
+func (wrapper *BTCPayWrapper) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	invoiceRes := &btcpayInvoiceResponse{}
+	err := wrapper.call(ctx, http.MethodPost, "/invoices", &btcpayCreateInvoiceRequest{
+		Amount:      strconv.FormatInt(InvoiceAmountMsat(lnInvoice), 10),
+		Description: lnInvoice.Memo,
+		Expiry:      lnInvoice.Expiry,
+	}, invoiceRes)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := lntypes.MakeHashFromStr(invoiceRes.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	wrapper.invoiceIds.Store(paymentHash, invoiceRes.Id)
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          paymentHash[:],
+		PaymentRequest: invoiceRes.BOLT11,
+	}, nil
+}
+
+// LookupInvoice looks up invoices issued by this process by their BTCPay
+// id, others by payment hash, which is the id for LND backed stores.
+func (wrapper *BTCPayWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	invoiceId := hex.EncodeToString(req.RHash)
+	if paymentHash, err := lntypes.MakeHash(req.RHash); err == nil {
+		if id, ok := wrapper.invoiceIds.Load(paymentHash); ok {
+			invoiceId = id.(string)
+		}
+	}
+	invoiceRes := &btcpayInvoiceResponse{}
+	if err := wrapper.call(ctx, http.MethodGet, "/invoices/"+invoiceId, nil, invoiceRes); err != nil {
+		return nil, err
+	}
+	amountMsat, _ := strconv.ParseInt(invoiceRes.Amount, 10, 64)
+	invoice := &lnrpc.Invoice{
+		RHash:          req.RHash,
+		PaymentRequest: invoiceRes.BOLT11,
+		ValueMsat:      amountMsat,
+		Value:          amountMsat / MSAT_PER_SAT,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	switch invoiceRes.Status {
+	case BTCPAY_INVOICE_STATUS_PAID:
+		invoice.State = lnrpc.Invoice_SETTLED
+		invoice.SettleDate = invoiceRes.PaidAt
+		invoice.RPreimage, _ = hex.DecodeString(invoiceRes.Preimage)
+	case BTCPAY_INVOICE_STATUS_EXPIRED:
+		invoice.State = lnrpc.Invoice_CANCELED
+	}
+	return invoice, nil
+}
+
+func (wrapper *BTCPayWrapper) Ping(ctx context.Context) error {
+	return wrapper.call(ctx, http.MethodGet, "/info", nil, nil)
+}
+
+func (wrapper *BTCPayWrapper) NewAddress(ctx context.Context, req *lnrpc.NewAddressRequest, options ...grpc.CallOption) (*lnrpc.NewAddressResponse, error) {
+	addressRes := &btcpayAddressResponse{}
+	if err := wrapper.callOnchain(ctx, "/address?forceGenerate=true", addressRes); err != nil {
+		return nil, err
+	}
+	return &lnrpc.NewAddressResponse{
+		Address: addressRes.Address,
+	}, nil
+}
+
+func (wrapper *BTCPayWrapper) ListUnspent(ctx context.Context, req *lnrpc.ListUnspentRequest, options ...grpc.CallOption) (*lnrpc.ListUnspentResponse, error) {
+	utxos := []btcpayUtxo{}
+	if err := wrapper.callOnchain(ctx, "/utxos", &utxos); err != nil {
+		return nil, err
+	}
+	res := &lnrpc.ListUnspentResponse{}
+	for _, utxo := range utxos {
+		if utxo.Confirmations < int64(req.MinConfs) || utxo.Confirmations > int64(req.MaxConfs) {
+			continue
+		}
+		amountSat, err := parseBtcAmount(utxo.Amount)
+		if err != nil {
+			return nil, err
+		}
+		res.Utxos = append(res.Utxos, &lnrpc.Utxo{
+			Address:       utxo.Address,
+			AmountSat:     amountSat,
+			Confirmations: utxo.Confirmations,
+		})
+	}
+	return res, nil
+}
+
+// parseBtcAmount parses a decimal BTC amount like "0.00012345" into sats
+// without floating point rounding.
+func parseBtcAmount(amount string) (int64, error) {
+	splitted := strings.SplitN(amount, ".", 2)
+	fraction := ""
+	if len(splitted) == 2 {
+		fraction = splitted[1]
+	}
+	if len(fraction) > 8 {
+		return 0, fmt.Errorf("Invalid BTC amount: %s", amount)
+	}
+	sats, err := strconv.ParseInt(splitted[0]+fraction+strings.Repeat("0", 8-len(fraction)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid BTC amount: %s", amount)
+	}
+	return sats, nil
+}
@@ -0,0 +1,95 @@
+package ln
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBTCPayClient(t *testing.T) {
+	paymentHash := testPreimage.Hash()
+	server := newTestServer(t, map[string]interface{}{
+		"POST /api/v1/stores/store/lightning/BTC/invoices": map[string]interface{}{
+			"id":          "invoiceid",
+			"status":      "Unpaid",
+			"BOLT11":      "lnbc100n1btcpay",
+			"paymentHash": paymentHash.String(),
+			"amount":      "10000",
+		},
+		"GET /api/v1/stores/store/lightning/BTC/invoices/invoiceid": map[string]interface{}{
+			"id":          "invoiceid",
+			"status":      BTCPAY_INVOICE_STATUS_PAID,
+			"BOLT11":      "lnbc100n1btcpay",
+			"paymentHash": paymentHash.String(),
+			"preimage":    testPreimage.String(),
+			"amount":      "10000",
+			"paidAt":      1656000000,
+		},
+		"GET /api/v1/stores/store/payment-methods/onchain/BTC/wallet/address": map[string]interface{}{
+			"address": "bc1qaddress",
+		},
+		"GET /api/v1/stores/store/payment-methods/onchain/BTC/wallet/utxos": []map[string]interface{}{
+			{"address": "bc1qaddress", "amount": "0.00012345", "confirmations": 3},
+			{"address": "bc1qaddress", "amount": "1", "confirmations": 0},
+		},
+	})
+	client, err := NewBTCPayClient(BTCPayOptions{
+		Address: server.URL,
+		StoreId: "store",
+		APIKey:  "apikey",
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT", Expiry: 600}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, "lnbc100n1btcpay", invoiceRes.PaymentRequest)
+	req := server.request(t, "POST /api/v1/stores/store/lightning/BTC/invoices")
+	assert.Equal(t, "token apikey", req.Header.Get("Authorization"))
+	assert.Equal(t, map[string]interface{}{
+		"amount":      "10000",
+		"description": "LSAT",
+		"expiry":      float64(600),
+	}, req.JSON(t))
+
+	// Looked up by the BTCPay id of the invoice
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+	assert.Equal(t, int64(10000), invoice.ValueMsat)
+	assert.Equal(t, testPreimage[:], invoice.RPreimage)
+	assert.Equal(t, int64(1656000000), invoice.SettleDate)
+
+	addressRes, err := client.NewAddress(ctx, &lnrpc.NewAddressRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "bc1qaddress", addressRes.Address)
+	assert.Equal(t, "forceGenerate=true", server.request(t, "GET /api/v1/stores/store/payment-methods/onchain/BTC/wallet/address").Query)
+
+	unspentRes, err := client.ListUnspent(ctx, &lnrpc.ListUnspentRequest{MinConfs: 1, MaxConfs: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, []*lnrpc.Utxo{{
+		Address:       "bc1qaddress",
+		AmountSat:     12345,
+		Confirmations: 3,
+	}}, unspentRes.Utxos)
+}
+
+func TestParseBtcAmount(t *testing.T) {
+	for amount, sats := range map[string]int64{
+		"1":           100000000,
+		"0.00012345":  12345,
+		"0.1":         10000000,
+		"21.00000001": 2100000001,
+	} {
+		parsed, err := parseBtcAmount(amount)
+		assert.NoError(t, err, amount)
+		assert.Equal(t, sats, parsed, amount)
+	}
+	for _, amount := range []string{"0.000000001", "1e-8", "0.-1"} {
+		_, err := parseBtcAmount(amount)
+		assert.Error(t, err, amount)
+	}
+}
@@ -0,0 +1,199 @@
+package ln
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"google.golang.org/grpc"
+)
+
+const (
+	CLN_INVOICE_STATUS_PAID    = "paid"
+	CLN_INVOICE_STATUS_EXPIRED = "expired"
+)
+
+// CLNoptions configure a Core Lightning node through the clnrest plugin,
+// which executes commands authorized by a rune like commando does.
+type CLNoptions struct {
+	// Address of clnrest, e.g. https://localhost:3010
+	Address string
+	// Rune restricted to at least the invoice and listinvoices methods
+	Rune string
+	// Client is used for all requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type CLNWrapper struct {
+	options CLNoptions
+}
+
+type clnInvoiceRequest struct {
+	AmountMsat  int64  `json:"amount_msat"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Expiry      int64  `json:"expiry,omitempty"`
+}
+
+type clnInvoiceResponse struct {
+	Bolt11      string `json:"bolt11"`
+	PaymentHash string `json:"payment_hash"`
+}
+
+type clnListInvoicesRequest struct {
+	PaymentHash string `json:"payment_hash"`
+}
+
+type clnOfferRequest struct {
+	Amount      string `json:"amount"`
+	Description string `json:"description"`
+	SingleUse   bool   `json:"single_use"`
+}
+
+type clnOfferResponse struct {
+	OfferId string `json:"offer_id"`
+	Bolt12  string `json:"bolt12"`
+}
+
+type clnListOfferInvoicesRequest struct {
+	OfferId string `json:"offer_id"`
+}
+
+type clnListInvoicesResponse struct {
+	Invoices []struct {
+		PaymentHash     string `json:"payment_hash"`
+		Status          string `json:"status"`
+		Bolt11          string `json:"bolt11"`
+		AmountMsat      int64  `json:"amount_msat"`
+		PaymentPreimage string `json:"payment_preimage"`
+		PaidAt          int64  `json:"paid_at"`
+	} `json:"invoices"`
+}
+
+func NewCLNClient(clnOptions CLNoptions) (*CLNWrapper, error) {
+	if clnOptions.Address == "" {
+		return nil, fmt.Errorf("CLN address is missing")
+	}
+	if clnOptions.Rune == "" {
+		return nil, fmt.Errorf("CLN rune is missing")
+	}
+	clnOptions.Address = strings.TrimSuffix(clnOptions.Address, "/")
+	return &CLNWrapper{
+		options: clnOptions,
+	}, nil
+}
+
+func (wrapper *CLNWrapper) call(ctx context.Context, method string, reqBody interface{}, resBody interface{}) error {
+	header := http.Header{}
+	header.Set("Rune", wrapper.options.Rune)
+	url := fmt.Sprintf("%s/v1/%s", wrapper.options.Address, method)
+	return doJSONRequest(ctx, wrapper.options.Client, http.MethodPost, url, header, reqBody, resBody)
+}
+
+func (wrapper *CLNWrapper) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	label, err := newInvoiceLabel()
+	if err != nil {
+		return nil, err
+	}
+	invoiceRes := &clnInvoiceResponse{}
+	err = wrapper.call(ctx, "invoice", &clnInvoiceRequest{
+		AmountMsat:  InvoiceAmountMsat(lnInvoice),
+		Label:       label,
+		Description: lnInvoice.Memo,
+		Expiry:      lnInvoice.Expiry,
+	}, invoiceRes)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := lntypes.MakeHashFromStr(invoiceRes.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          paymentHash[:],
+		PaymentRequest: invoiceRes.Bolt11,
+	}, nil
+}
+
+func (wrapper *CLNWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	listInvoicesRes := &clnListInvoicesResponse{}
+	err := wrapper.call(ctx, "listinvoices", &clnListInvoicesRequest{
+		PaymentHash: hex.EncodeToString(req.RHash),
+	}, listInvoicesRes)
+	if err != nil {
+		return nil, err
+	}
+	if len(listInvoicesRes.Invoices) == 0 {
+		return nil, fmt.Errorf("Invoice not found: %x", req.RHash)
+	}
+	clnInvoice := listInvoicesRes.Invoices[0]
+	invoice := &lnrpc.Invoice{
+		RHash:          req.RHash,
+		PaymentRequest: clnInvoice.Bolt11,
+		ValueMsat:      clnInvoice.AmountMsat,
+		Value:          clnInvoice.AmountMsat / MSAT_PER_SAT,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	switch clnInvoice.Status {
+	case CLN_INVOICE_STATUS_PAID:
+		invoice.State = lnrpc.Invoice_SETTLED
+		invoice.SettleDate = clnInvoice.PaidAt
+		invoice.RPreimage, _ = hex.DecodeString(clnInvoice.PaymentPreimage)
+	case CLN_INVOICE_STATUS_EXPIRED:
+		invoice.State = lnrpc.Invoice_CANCELED
+	}
+	return invoice, nil
+}
+
+// newInvoiceLabel returns a unique label, CLN requires one per invoice.
+func newInvoiceLabel() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return "lsat-" + hex.EncodeToString(random), nil
+}
+
+func (wrapper *CLNWrapper) Ping(ctx context.Context) error {
+	return wrapper.call(ctx, "getinfo", struct{}{}, nil)
+}
+
+// CreateOffer creates a single use BOLT12 offer of amountMsat, the rune
+// must allow the offer method.
+func (wrapper *CLNWrapper) CreateOffer(ctx context.Context, amountMsat int64, description string) (*Offer, error) {
+	offerRes := &clnOfferResponse{}
+	err := wrapper.call(ctx, "offer", &clnOfferRequest{
+		Amount:      fmt.Sprintf("%dmsat", amountMsat),
+		Description: description,
+		SingleUse:   true,
+	}, offerRes)
+	if err != nil {
+		return nil, err
+	}
+	return &Offer{
+		Id:         offerRes.OfferId,
+		Offer:      offerRes.Bolt12,
+		AmountMsat: amountMsat,
+	}, nil
+}
+
+func (wrapper *CLNWrapper) IsOfferPaid(ctx context.Context, offerId string, paymentHash lntypes.Hash) (bool, error) {
+	listInvoicesRes := &clnListInvoicesResponse{}
+	err := wrapper.call(ctx, "listinvoices", &clnListOfferInvoicesRequest{
+		OfferId: offerId,
+	}, listInvoicesRes)
+	if err != nil {
+		return false, err
+	}
+	for _, clnInvoice := range listInvoicesRes.Invoices {
+		if clnInvoice.PaymentHash == paymentHash.String() && clnInvoice.Status == CLN_INVOICE_STATUS_PAID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
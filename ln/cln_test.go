@@ -0,0 +1,82 @@
+package ln
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCLNClient(t *testing.T) {
+	paymentHash := testPreimage.Hash()
+	server := newTestServer(t, map[string]interface{}{
+		"POST /v1/invoice": map[string]interface{}{
+			"bolt11":       "lnbc100n1cln",
+			"payment_hash": paymentHash.String(),
+		},
+		"POST /v1/listinvoices": map[string]interface{}{
+			"invoices": []map[string]interface{}{{
+				"payment_hash":     paymentHash.String(),
+				"status":           CLN_INVOICE_STATUS_PAID,
+				"bolt11":           "lnbc100n1cln",
+				"amount_msat":      10000,
+				"payment_preimage": testPreimage.String(),
+				"paid_at":          1656000000,
+			}},
+		},
+		"POST /v1/offer": map[string]interface{}{
+			"offer_id": "offerid",
+			"bolt12":   "lno1offer",
+		},
+	})
+	client, err := NewCLNClient(CLNoptions{
+		Address: server.URL + "/",
+		Rune:    "rune",
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT", Expiry: 600}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, "lnbc100n1cln", invoiceRes.PaymentRequest)
+	req := server.request(t, "POST /v1/invoice")
+	assert.Equal(t, "rune", req.Header.Get("Rune"))
+	body := req.JSON(t)
+	assert.Equal(t, float64(10000), body["amount_msat"])
+	assert.Equal(t, "LSAT", body["description"])
+	assert.Equal(t, float64(600), body["expiry"])
+	assert.True(t, strings.HasPrefix(body["label"].(string), "lsat-"))
+
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+	assert.Equal(t, int64(10000), invoice.ValueMsat)
+	assert.Equal(t, int64(10), invoice.Value)
+	assert.Equal(t, testPreimage[:], invoice.RPreimage)
+	assert.Equal(t, int64(1656000000), invoice.SettleDate)
+	assert.Equal(t, paymentHash.String(), server.request(t, "POST /v1/listinvoices").JSON(t)["payment_hash"])
+
+	offer, err := client.CreateOffer(ctx, 10000, "LSAT")
+	assert.NoError(t, err)
+	assert.Equal(t, &Offer{Id: "offerid", Offer: "lno1offer", AmountMsat: 10000}, offer)
+	body = server.request(t, "POST /v1/offer").JSON(t)
+	assert.Equal(t, "10000msat", body["amount"])
+	assert.Equal(t, true, body["single_use"])
+
+	paid, err := client.IsOfferPaid(ctx, "offerid", paymentHash)
+	assert.NoError(t, err)
+	assert.True(t, paid)
+	assert.Equal(t, "offerid", server.request(t, "POST /v1/listinvoices").JSON(t)["offer_id"])
+	paid, err = client.IsOfferPaid(ctx, "offerid", lntypes.Hash{4, 5, 6})
+	assert.NoError(t, err)
+	assert.False(t, paid)
+
+	// Errors of clnrest are surfaced
+	assert.Error(t, client.Ping(ctx))
+	_, err = NewCLNClient(CLNoptions{Address: server.URL})
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package ln
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"google.golang.org/grpc"
+)
+
+const (
+	ECLAIR_STATUS_RECEIVED = "received"
+	ECLAIR_STATUS_EXPIRED  = "expired"
+)
+
+type EclairOptions struct {
+	// Address of the Eclair API, e.g. http://localhost:8080
+	Address string
+	// Password of the API, eclair.api.password
+	Password string
+	// Client is used for all requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type EclairWrapper struct {
+	options EclairOptions
+}
+
+type eclairInvoiceResponse struct {
+	Serialized  string `json:"serialized"`
+	PaymentHash string `json:"paymentHash"`
+}
+
+type eclairReceivedInfoResponse struct {
+	PaymentRequest struct {
+		Serialized string `json:"serialized"`
+		Amount     int64  `json:"amount"`
+	} `json:"paymentRequest"`
+	PaymentPreimage string `json:"paymentPreimage"`
+	Status          struct {
+		Type       string `json:"type"`
+		ReceivedAt struct {
+			Unix int64 `json:"unix"`
+		} `json:"receivedAt"`
+	} `json:"status"`
+}
+
+func NewEclairClient(eclairOptions EclairOptions) (*EclairWrapper, error) {
+	if eclairOptions.Address == "" {
+		return nil, fmt.Errorf("Eclair address is missing")
+	}
+	if eclairOptions.Password == "" {
+		return nil, fmt.Errorf("Eclair password is missing")
+	}
+	eclairOptions.Address = strings.TrimSuffix(eclairOptions.Address, "/")
+	return &EclairWrapper{
+		options: eclairOptions,
+	}, nil
+}
+
+// call posts a form to an API method, authenticated with an empty user.
+func (wrapper *EclairWrapper) call(ctx context.Context, method string, form url.Values, resBody interface{}) error {
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+wrapper.options.Password)))
+	return doFormRequest(ctx, wrapper.options.Client, fmt.Sprintf("%s/%s", wrapper.options.Address, method), header, form, resBody)
+}
+
+func (wrapper *EclairWrapper) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	form := url.Values{}
+	form.Set("amountMsat", strconv.FormatInt(InvoiceAmountMsat(lnInvoice), 10))
+	form.Set("description", lnInvoice.Memo)
+	if lnInvoice.Expiry > 0 {
+		form.Set("expireIn", strconv.FormatInt(lnInvoice.Expiry, 10))
+	}
+	invoiceRes := &eclairInvoiceResponse{}
+	if err := wrapper.call(ctx, "createinvoice", form, invoiceRes); err != nil {
+		return nil, err
+	}
+	paymentHash, err := lntypes.MakeHashFromStr(invoiceRes.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          paymentHash[:],
+		PaymentRequest: invoiceRes.Serialized,
+	}, nil
+}
+
+func (wrapper *EclairWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	form := url.Values{}
+	form.Set("paymentHash", hex.EncodeToString(req.RHash))
+	receivedInfoRes := &eclairReceivedInfoResponse{}
+	if err := wrapper.call(ctx, "getreceivedinfo", form, receivedInfoRes); err != nil {
+		return nil, err
+	}
+	amountMsat := receivedInfoRes.PaymentRequest.Amount
+	invoice := &lnrpc.Invoice{
+		RHash:          req.RHash,
+		PaymentRequest: receivedInfoRes.PaymentRequest.Serialized,
+		ValueMsat:      amountMsat,
+		Value:          amountMsat / MSAT_PER_SAT,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	switch receivedInfoRes.Status.Type {
+	case ECLAIR_STATUS_RECEIVED:
+		invoice.State = lnrpc.Invoice_SETTLED
+		invoice.SettleDate = receivedInfoRes.Status.ReceivedAt.Unix
+		invoice.RPreimage, _ = hex.DecodeString(receivedInfoRes.PaymentPreimage)
+	case ECLAIR_STATUS_EXPIRED:
+		invoice.State = lnrpc.Invoice_CANCELED
+	}
+	return invoice, nil
+}
+
+func (wrapper *EclairWrapper) Ping(ctx context.Context) error {
+	return wrapper.call(ctx, "getinfo", url.Values{}, nil)
+}
@@ -0,0 +1,66 @@
+package ln
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEclairClient(t *testing.T) {
+	paymentHash := testPreimage.Hash()
+	server := newTestServer(t, map[string]interface{}{
+		"POST /createinvoice": map[string]interface{}{
+			"serialized":  "lnbc100n1eclair",
+			"paymentHash": paymentHash.String(),
+		},
+		"POST /getreceivedinfo": map[string]interface{}{
+			"paymentRequest": map[string]interface{}{
+				"serialized": "lnbc100n1eclair",
+				"amount":     10000,
+			},
+			"paymentPreimage": testPreimage.String(),
+			"status": map[string]interface{}{
+				"type": ECLAIR_STATUS_RECEIVED,
+				"receivedAt": map[string]interface{}{
+					"unix": 1656000000,
+				},
+			},
+		},
+	})
+	client, err := NewEclairClient(EclairOptions{
+		Address:  server.URL,
+		Password: "password",
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT", Expiry: 600}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, "lnbc100n1eclair", invoiceRes.PaymentRequest)
+	req := server.request(t, "POST /createinvoice")
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte(":password")), req.Header.Get("Authorization"))
+	form, err := url.ParseQuery(string(req.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"amountMsat":  {"10000"},
+		"description": {"LSAT"},
+		"expireIn":    {"600"},
+	}, form)
+
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+	assert.Equal(t, int64(10000), invoice.ValueMsat)
+	assert.Equal(t, testPreimage[:], invoice.RPreimage)
+	assert.Equal(t, int64(1656000000), invoice.SettleDate)
+	form, err = url.ParseQuery(string(server.request(t, "POST /getreceivedinfo").Body))
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash.String(), form.Get("paymentHash"))
+
+	assert.Error(t, client.Ping(ctx))
+}
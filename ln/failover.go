@@ -0,0 +1,87 @@
+package ln
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+// FailoverClient issues invoices on the first of its clients that succeeds,
+// so an outage of the primary node doesn't take the paid API down.
+type FailoverClient struct {
+	Clients []LNClient
+	// Timeout of a single attempt, 0 for none
+	Timeout time.Duration
+}
+
+func NewFailoverClient(timeout time.Duration, clients ...LNClient) *FailoverClient {
+	return &FailoverClient{
+		Clients: clients,
+		Timeout: timeout,
+	}
+}
+
+func (failoverClient *FailoverClient) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if failoverClient.Timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, failoverClient.Timeout)
+}
+
+func (failoverClient *FailoverClient) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	errs := []string{}
+	for _, lnClient := range failoverClient.Clients {
+		attemptCtx, cancel := failoverClient.attemptContext(ctx)
+		lnClientInvoice, err := lnClient.AddInvoice(attemptCtx, lnInvoice, httpReq, options...)
+		cancel()
+		if err == nil {
+			return lnClientInvoice, nil
+		}
+		errs = append(errs, err.Error())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("All LN backends failed: %s", strings.Join(errs, "; "))
+}
+
+// LookupInvoice asks every client that can look up invoices, as any of
+// them may have issued the invoice.
+func (failoverClient *FailoverClient) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	errs := []string{}
+	for _, lnClient := range failoverClient.Clients {
+		invoiceLookupClient, ok := lnClient.(InvoiceLookupClient)
+		if !ok {
+			continue
+		}
+		attemptCtx, cancel := failoverClient.attemptContext(ctx)
+		invoice, err := invoiceLookupClient.LookupInvoice(attemptCtx, req, options...)
+		cancel()
+		if err == nil {
+			return invoice, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("LN client does not support invoice lookups")
+	}
+	return nil, fmt.Errorf("Invoice not found on any LN backend: %s", strings.Join(errs, "; "))
+}
+
+// Ping succeeds while any client can issue invoices.
+func (failoverClient *FailoverClient) Ping(ctx context.Context) error {
+	errs := []string{}
+	for _, lnClient := range failoverClient.Clients {
+		err := (&LNClientConn{LNClient: lnClient}).Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("All LN backends failed: %s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,86 @@
+package ln
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// fakeLNClient issues invoices of sequential payment hashes, or fails with
+// err when it is set
+type fakeLNClient struct {
+	err      error
+	delay    time.Duration
+	invoices map[lntypes.Hash]*lnrpc.Invoice
+}
+
+func newFakeLNClient() *fakeLNClient {
+	return &fakeLNClient{
+		invoices: map[lntypes.Hash]*lnrpc.Invoice{},
+	}
+}
+
+func (fake *fakeLNClient) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	select {
+	case <-time.After(fake.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if fake.err != nil {
+		return nil, fake.err
+	}
+	paymentHash := lntypes.Hash{byte(len(fake.invoices) + 1)}
+	fake.invoices[paymentHash] = lnInvoice
+	return &lnrpc.AddInvoiceResponse{
+		RHash: paymentHash[:],
+	}, nil
+}
+
+func (fake *fakeLNClient) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	paymentHash, err := lntypes.MakeHash(req.RHash)
+	if err != nil {
+		return nil, err
+	}
+	invoice, ok := fake.invoices[paymentHash]
+	if !ok {
+		return nil, errors.New("Invoice not found")
+	}
+	return invoice, nil
+}
+
+func (fake *fakeLNClient) Ping(ctx context.Context) error {
+	return fake.err
+}
+
+func TestFailoverClient(t *testing.T) {
+	primary, slow, fallback := newFakeLNClient(), newFakeLNClient(), newFakeLNClient()
+	primary.err = errors.New("Primary is down")
+	slow.delay = time.Second
+	client := NewFailoverClient(10*time.Millisecond, primary, slow, fallback)
+	ctx := context.Background()
+
+	// Issued by the first client that succeeds within the timeout
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, slow.invoices, 0)
+	assert.Len(t, fallback.invoices, 1)
+
+	// Looked up on any client
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: invoiceRes.RHash})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), invoice.Value)
+	_, err = client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: make([]byte, 32)})
+	assert.Error(t, err)
+
+	assert.NoError(t, client.Ping(ctx))
+	fallback.err = errors.New("Fallback is down")
+	_, err = client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10}, nil)
+	assert.EqualError(t, err, "All LN backends failed: Primary is down; context deadline exceeded; Fallback is down")
+}
@@ -0,0 +1,120 @@
+package ln
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"google.golang.org/grpc"
+)
+
+type LNbitsOptions struct {
+	// Address of the LNbits instance, e.g. https://legend.lnbits.com
+	Address string
+	// InvoiceKey of the wallet, the admin key works as well but isn't needed
+	InvoiceKey string
+	// Client is used for all requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type LNbitsWrapper struct {
+	options LNbitsOptions
+}
+
+type lnbitsCreateInvoiceRequest struct {
+	Out    bool   `json:"out"`
+	Amount int64  `json:"amount"`
+	Memo   string `json:"memo"`
+	Expiry int64  `json:"expiry,omitempty"`
+}
+
+type lnbitsCreateInvoiceResponse struct {
+	PaymentHash    string `json:"payment_hash"`
+	PaymentRequest string `json:"payment_request"`
+	Bolt11         string `json:"bolt11"`
+}
+
+type lnbitsPaymentResponse struct {
+	Paid     bool   `json:"paid"`
+	Preimage string `json:"preimage"`
+	Details  struct {
+		Bolt11  string `json:"bolt11"`
+		Amount  int64  `json:"amount"`
+		Expired bool   `json:"expired"`
+	} `json:"details"`
+}
+
+func NewLNbitsClient(lnbitsOptions LNbitsOptions) (*LNbitsWrapper, error) {
+	if lnbitsOptions.Address == "" {
+		return nil, fmt.Errorf("LNbits address is missing")
+	}
+	if lnbitsOptions.InvoiceKey == "" {
+		return nil, fmt.Errorf("LNbits invoice key is missing")
+	}
+	lnbitsOptions.Address = strings.TrimSuffix(lnbitsOptions.Address, "/")
+	return &LNbitsWrapper{
+		options: lnbitsOptions,
+	}, nil
+}
+
+func (wrapper *LNbitsWrapper) call(ctx context.Context, method string, path string, reqBody interface{}, resBody interface{}) error {
+	header := http.Header{}
+	header.Set("X-Api-Key", wrapper.options.InvoiceKey)
+	return doJSONRequest(ctx, wrapper.options.Client, method, wrapper.options.Address+path, header, reqBody, resBody)
+}
+
+func (wrapper *LNbitsWrapper) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	invoiceRes := &lnbitsCreateInvoiceResponse{}
+	err := wrapper.call(ctx, http.MethodPost, "/api/v1/payments", &lnbitsCreateInvoiceRequest{
+		Out:    false,
+		Amount: invoiceAmountSat(lnInvoice),
+		Memo:   lnInvoice.Memo,
+		Expiry: lnInvoice.Expiry,
+	}, invoiceRes)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := lntypes.MakeHashFromStr(invoiceRes.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	// Newer LNbits versions return the invoice as bolt11
+	paymentRequest := invoiceRes.PaymentRequest
+	if paymentRequest == "" {
+		paymentRequest = invoiceRes.Bolt11
+	}
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          paymentHash[:],
+		PaymentRequest: paymentRequest,
+	}, nil
+}
+
+func (wrapper *LNbitsWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	paymentRes := &lnbitsPaymentResponse{}
+	err := wrapper.call(ctx, http.MethodGet, "/api/v1/payments/"+hex.EncodeToString(req.RHash), nil, paymentRes)
+	if err != nil {
+		return nil, err
+	}
+	invoice := &lnrpc.Invoice{
+		RHash:          req.RHash,
+		PaymentRequest: paymentRes.Details.Bolt11,
+		ValueMsat:      paymentRes.Details.Amount,
+		Value:          paymentRes.Details.Amount / MSAT_PER_SAT,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	if paymentRes.Paid {
+		invoice.State = lnrpc.Invoice_SETTLED
+		invoice.RPreimage, _ = hex.DecodeString(paymentRes.Preimage)
+	} else if paymentRes.Details.Expired {
+		invoice.State = lnrpc.Invoice_CANCELED
+	}
+	return invoice, nil
+}
+
+func (wrapper *LNbitsWrapper) Ping(ctx context.Context) error {
+	return wrapper.call(ctx, http.MethodGet, "/api/v1/wallet", nil, nil)
+}
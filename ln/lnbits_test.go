@@ -0,0 +1,58 @@
+package ln
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLNbitsClient(t *testing.T) {
+	paymentHash := testPreimage.Hash()
+	server := newTestServer(t, map[string]interface{}{
+		"POST /api/v1/payments": map[string]interface{}{
+			"payment_hash": paymentHash.String(),
+			"bolt11":       "lnbc100n1lnbits",
+		},
+		"GET /api/v1/payments/" + paymentHash.String(): map[string]interface{}{
+			"paid":     true,
+			"preimage": testPreimage.String(),
+			"details": map[string]interface{}{
+				"bolt11": "lnbc100n1lnbits",
+				"amount": 10000,
+			},
+		},
+		"GET /api/v1/wallet": map[string]interface{}{},
+	})
+	client, err := NewLNbitsClient(LNbitsOptions{
+		Address:    server.URL + "/",
+		InvoiceKey: "invoicekey",
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT", Expiry: 600}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, "lnbc100n1lnbits", invoiceRes.PaymentRequest)
+	req := server.request(t, "POST /api/v1/payments")
+	assert.Equal(t, "invoicekey", req.Header.Get("X-Api-Key"))
+	assert.Equal(t, map[string]interface{}{
+		"out":    false,
+		"amount": float64(10),
+		"memo":   "LSAT",
+		"expiry": float64(600),
+	}, req.JSON(t))
+
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+	assert.Equal(t, "lnbc100n1lnbits", invoice.PaymentRequest)
+	assert.Equal(t, int64(10000), invoice.ValueMsat)
+	assert.Equal(t, testPreimage[:], invoice.RPreimage)
+
+	assert.NoError(t, client.Ping(ctx))
+	_, err = client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: make([]byte, 32)})
+	assert.Error(t, err)
+}
@@ -2,28 +2,166 @@ package ln
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/lightningnetwork/lnd/lntypes"
 	"google.golang.org/grpc"
 )
 
 type LNClientConfig struct {
-	LNClientType string
-	LNDConfig    LNDoptions
-	LNURLConfig  LNURLoptions
+	LNClientType   string
+	LNDConfig      LNDoptions
+	LNURLConfig    LNURLoptions
+	CLNConfig      CLNoptions
+	LNbitsConfig   LNbitsOptions
+	LNDhubConfig   LNDhubOptions
+	BTCPayConfig   BTCPayOptions
+	EclairConfig   EclairOptions
+	OpenNodeConfig OpenNodeOptions
+	ZebedeeConfig  ZebedeeOptions
+	PhoenixdConfig PhoenixdOptions
+	LNPayConfig    LNPayOptions
+	TapdConfig     TapdOptions
+	// RoundRobin backends issue invoices in turn with this backend
+	RoundRobin []*LNClientConfig
+	// Fallbacks issue invoices when this backend fails, in order
+	Fallbacks []*LNClientConfig
+	// FailoverTimeout bounds each attempt when Fallbacks are set, 0 for none
+	FailoverTimeout time.Duration
+	// SkipHealthCheck doesn't ping the backend when the middleware is created
+	SkipHealthCheck bool
 }
 type LNClient interface {
 	AddInvoice(ctx context.Context, lnReq *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error)
 }
 
+// HoldInvoiceClient is implemented by LN clients that support hold invoices
+type HoldInvoiceClient interface {
+	AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error)
+	SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error)
+	CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error)
+	LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error)
+}
+
+// InvoiceAmountMsat returns the amount of lnInvoice in msat, ValueMsat
+// takes precedence over Value like in LND.
+func InvoiceAmountMsat(lnInvoice *lnrpc.Invoice) int64 {
+	if lnInvoice.ValueMsat != 0 {
+		return lnInvoice.ValueMsat
+	}
+	return MSAT_PER_SAT * lnInvoice.Value
+}
+
+// invoiceAmountSat returns the amount of lnInvoice in sats, rounded up for
+// backends that only invoice whole sats.
+func invoiceAmountSat(lnInvoice *lnrpc.Invoice) int64 {
+	return (InvoiceAmountMsat(lnInvoice) + MSAT_PER_SAT - 1) / MSAT_PER_SAT
+}
+
+// Offer is a BOLT12 offer, payers request an invoice from the node
+// issuing it
+type Offer struct {
+	Id         string
+	Offer      string
+	AmountMsat int64
+}
+
+// OfferClient is implemented by LN clients that can issue BOLT12 offers
+type OfferClient interface {
+	CreateOffer(ctx context.Context, amountMsat int64, description string) (*Offer, error)
+	// IsOfferPaid returns true if the invoice of paymentHash requested from
+	// the offer is paid
+	IsOfferPaid(ctx context.Context, offerId string, paymentHash lntypes.Hash) (bool, error)
+}
+
+// AssetAmount is an amount of the Taproot Asset with the hex AssetId, in
+// its smallest unit
+type AssetAmount struct {
+	AssetId string
+	Amount  uint64
+}
+
+// AssetInvoiceClient is implemented by LN clients that can request payment
+// in a Taproot Asset
+type AssetInvoiceClient interface {
+	AddAssetInvoice(ctx context.Context, assetId string, assetAmount uint64, lnInvoice *lnrpc.Invoice) (*lnrpc.AddInvoiceResponse, error)
+}
+
+// OnchainClient is implemented by LN clients whose node has an on-chain
+// wallet
+type OnchainClient interface {
+	NewAddress(ctx context.Context, req *lnrpc.NewAddressRequest, options ...grpc.CallOption) (*lnrpc.NewAddressResponse, error)
+	ListUnspent(ctx context.Context, req *lnrpc.ListUnspentRequest, options ...grpc.CallOption) (*lnrpc.ListUnspentResponse, error)
+}
+
+// ONCHAIN_MAX_CONFS bounds the confirmations of the outputs listed to
+// check on-chain payments
+const ONCHAIN_MAX_CONFS = 1_000_000
+
+// KeysendClient is implemented by LN clients that can send spontaneous
+// payments
+type KeysendClient interface {
+	SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest, options ...grpc.CallOption) (*lnrpc.SendResponse, error)
+}
+
+// KEYSEND_RECORD is the TLV record carrying the preimage of a keysend payment
+const KEYSEND_RECORD = 5482373484
+
+// HealthChecker is implemented by LN clients that can check the connection
+// and credentials to their backend
+type HealthChecker interface {
+	Ping(ctx context.Context) error
+}
+
+// InvoiceLookupClient is implemented by LN clients that can look up the
+// state of the invoices they issued
+type InvoiceLookupClient interface {
+	LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error)
+}
+
+// LiquidityClient is implemented by LN clients that can report the inbound
+// liquidity of their channels
+type LiquidityClient interface {
+	ListChannels(ctx context.Context, req *lnrpc.ListChannelsRequest, options ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error)
+}
+
+// InvoiceSubscriber is implemented by LN clients that stream invoice updates
+type InvoiceSubscriber interface {
+	SubscribeInvoices(ctx context.Context, req *lnrpc.InvoiceSubscription, options ...grpc.CallOption) (lnrpc.Lightning_SubscribeInvoicesClient, error)
+}
+
+// InboundLiquidity is the amount in sats a node can receive
+type InboundLiquidity struct {
+	// Total over all active channels, receivable with multi-part payments
+	Total int64
+	// MaxChannel is the most a single channel can receive
+	MaxChannel int64
+}
+
 type LNClientConn struct {
 	LNClient LNClient
+	// Breaker fails GenerateInvoice fast while the backend keeps failing
+	Breaker *CircuitBreaker
 }
 
 func (lnClientConn *LNClientConn) GenerateInvoice(ctx context.Context, lnInvoice lnrpc.Invoice, httpReq *http.Request) (string, lntypes.Hash, error) {
+	if lnClientConn.Breaker != nil && !lnClientConn.Breaker.Allow() {
+		return "", lntypes.Hash{}, ErrCircuitOpen
+	}
 	lnClientInvoice, err := lnClientConn.LNClient.AddInvoice(ctx, &lnInvoice, httpReq)
+	if lnClientConn.Breaker != nil {
+		if err != nil {
+			lnClientConn.Breaker.Failure()
+		} else {
+			lnClientConn.Breaker.Success()
+		}
+	}
 	if err != nil {
 		return "", lntypes.Hash{}, err
 	}
@@ -35,3 +173,314 @@ func (lnClientConn *LNClientConn) GenerateInvoice(ctx context.Context, lnInvoice
 	}
 	return invoice, paymentHash, nil
 }
+
+// SupportsAssets returns true if the backend can request payment in a
+// Taproot Asset.
+func (lnClientConn *LNClientConn) SupportsAssets() bool {
+	_, ok := lnClientConn.LNClient.(AssetInvoiceClient)
+	return ok
+}
+
+// GenerateAssetInvoice issues an invoice paid in asset, the memo and expiry
+// are taken from lnInvoice.
+func (lnClientConn *LNClientConn) GenerateAssetInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, asset *AssetAmount) (string, lntypes.Hash, error) {
+	assetInvoiceClient, ok := lnClientConn.LNClient.(AssetInvoiceClient)
+	if !ok {
+		return "", lntypes.Hash{}, fmt.Errorf("LN client does not support Taproot Assets")
+	}
+	if lnClientConn.Breaker != nil && !lnClientConn.Breaker.Allow() {
+		return "", lntypes.Hash{}, ErrCircuitOpen
+	}
+	lnClientInvoice, err := assetInvoiceClient.AddAssetInvoice(ctx, asset.AssetId, asset.Amount, lnInvoice)
+	if lnClientConn.Breaker != nil {
+		if err != nil {
+			lnClientConn.Breaker.Failure()
+		} else {
+			lnClientConn.Breaker.Success()
+		}
+	}
+	if err != nil {
+		return "", lntypes.Hash{}, err
+	}
+	paymentHash, err := lntypes.MakeHash(lnClientInvoice.RHash)
+	if err != nil {
+		return lnClientInvoice.PaymentRequest, lntypes.Hash{}, err
+	}
+	return lnClientInvoice.PaymentRequest, paymentHash, nil
+}
+
+// SupportsOnchain returns true if the backend can receive on-chain.
+func (lnClientConn *LNClientConn) SupportsOnchain() bool {
+	_, ok := lnClientConn.LNClient.(OnchainClient)
+	return ok
+}
+
+// NewOnchainAddress returns a fresh address of the on-chain wallet.
+func (lnClientConn *LNClientConn) NewOnchainAddress(ctx context.Context) (string, error) {
+	onchainClient, ok := lnClientConn.LNClient.(OnchainClient)
+	if !ok {
+		return "", fmt.Errorf("LN client does not support on-chain payments")
+	}
+	res, err := onchainClient.NewAddress(ctx, &lnrpc.NewAddressRequest{
+		Type: lnrpc.AddressType_WITNESS_PUBKEY_HASH,
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.Address, nil
+}
+
+// ReceivedOnchain returns the sats received on address in outputs with at
+// least minConfs confirmations.
+func (lnClientConn *LNClientConn) ReceivedOnchain(ctx context.Context, address string, minConfs int32) (int64, error) {
+	onchainClient, ok := lnClientConn.LNClient.(OnchainClient)
+	if !ok {
+		return 0, fmt.Errorf("LN client does not support on-chain payments")
+	}
+	res, err := onchainClient.ListUnspent(ctx, &lnrpc.ListUnspentRequest{
+		MinConfs: minConfs,
+		MaxConfs: ONCHAIN_MAX_CONFS,
+	})
+	if err != nil {
+		return 0, err
+	}
+	received := int64(0)
+	for _, utxo := range res.Utxos {
+		if utxo.Address == address {
+			received += utxo.AmountSat
+		}
+	}
+	return received, nil
+}
+
+func (lnClientConn *LNClientConn) holdInvoiceClient() (HoldInvoiceClient, error) {
+	holdInvoiceClient, ok := lnClientConn.LNClient.(HoldInvoiceClient)
+	if !ok {
+		return nil, fmt.Errorf("LN client does not support hold invoices")
+	}
+	return holdInvoiceClient, nil
+}
+
+func (lnClientConn *LNClientConn) GenerateHoldInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, paymentHash lntypes.Hash) (string, error) {
+	holdInvoiceClient, err := lnClientConn.holdInvoiceClient()
+	if err != nil {
+		return "", err
+	}
+	holdInvoice, err := holdInvoiceClient.AddHoldInvoice(ctx, &invoicesrpc.AddHoldInvoiceRequest{
+		Memo:      lnInvoice.Memo,
+		Hash:      paymentHash[:],
+		Value:     lnInvoice.Value,
+		ValueMsat: lnInvoice.ValueMsat,
+		Expiry:    lnInvoice.Expiry,
+		Private:   lnInvoice.Private,
+	})
+	if err != nil {
+		return "", err
+	}
+	return holdInvoice.PaymentRequest, nil
+}
+
+// IsInvoiceAccepted returns true if the HTLCs of the hold invoice are
+// accepted and waiting to be settled or cancelled.
+func (lnClientConn *LNClientConn) IsInvoiceAccepted(ctx context.Context, paymentHash lntypes.Hash) (bool, error) {
+	holdInvoiceClient, err := lnClientConn.holdInvoiceClient()
+	if err != nil {
+		return false, err
+	}
+	invoice, err := holdInvoiceClient.LookupInvoice(ctx, &lnrpc.PaymentHash{
+		RHash: paymentHash[:],
+	})
+	if err != nil {
+		return false, err
+	}
+	return invoice.State == lnrpc.Invoice_ACCEPTED, nil
+}
+
+// Ping checks that the backend is reachable and accepts the credentials,
+// clients that can't check are assumed healthy.
+func (lnClientConn *LNClientConn) Ping(ctx context.Context) error {
+	healthChecker, ok := lnClientConn.LNClient.(HealthChecker)
+	if !ok {
+		return nil
+	}
+	return healthChecker.Ping(ctx)
+}
+
+// SupportsOffers returns true if the LN client can issue BOLT12 offers.
+func (lnClientConn *LNClientConn) SupportsOffers() bool {
+	_, ok := lnClientConn.LNClient.(OfferClient)
+	return ok
+}
+
+// CreateOffer issues a BOLT12 offer of amountMsat.
+func (lnClientConn *LNClientConn) CreateOffer(ctx context.Context, amountMsat int64, description string) (*Offer, error) {
+	offerClient, ok := lnClientConn.LNClient.(OfferClient)
+	if !ok {
+		return nil, fmt.Errorf("LN client does not support BOLT12 offers")
+	}
+	return offerClient.CreateOffer(ctx, amountMsat, description)
+}
+
+// IsOfferPaid returns true if the invoice of paymentHash requested from the
+// offer with offerId is paid.
+func (lnClientConn *LNClientConn) IsOfferPaid(ctx context.Context, offerId string, paymentHash lntypes.Hash) (bool, error) {
+	offerClient, ok := lnClientConn.LNClient.(OfferClient)
+	if !ok {
+		return false, fmt.Errorf("LN client does not support BOLT12 offers")
+	}
+	return offerClient.IsOfferPaid(ctx, offerId, paymentHash)
+}
+
+// Keysend pays amountMsat to the node with the hex encoded pubkey without an
+// invoice.
+func (lnClientConn *LNClientConn) Keysend(ctx context.Context, pubkey string, amountMsat int64) error {
+	keysendClient, ok := lnClientConn.LNClient.(KeysendClient)
+	if !ok {
+		return fmt.Errorf("LN client does not support keysend")
+	}
+	dest, err := hex.DecodeString(pubkey)
+	if err != nil || len(dest) != 33 {
+		return fmt.Errorf("Invalid node pubkey: %s", pubkey)
+	}
+	var preimage lntypes.Preimage
+	if _, err := rand.Read(preimage[:]); err != nil {
+		return err
+	}
+	paymentHash := preimage.Hash()
+	res, err := keysendClient.SendPaymentSync(ctx, &lnrpc.SendRequest{
+		Dest:              dest,
+		AmtMsat:           amountMsat,
+		PaymentHash:       paymentHash[:],
+		DestCustomRecords: map[uint64][]byte{KEYSEND_RECORD: preimage[:]},
+	})
+	if err != nil {
+		return err
+	}
+	if res.PaymentError != "" {
+		return fmt.Errorf("Keysend payment failed: %s", res.PaymentError)
+	}
+	return nil
+}
+
+// IsInvoiceSettled returns true if the invoice of paymentHash is paid.
+func (lnClientConn *LNClientConn) IsInvoiceSettled(ctx context.Context, paymentHash lntypes.Hash) (bool, error) {
+	settled, _, err := lnClientConn.LookupSettlement(ctx, paymentHash)
+	return settled, err
+}
+
+// LookupSettlement returns whether the invoice of paymentHash is settled and
+// the amount paid, 0 when the backend doesn't report it.
+func (lnClientConn *LNClientConn) LookupSettlement(ctx context.Context, paymentHash lntypes.Hash) (bool, int64, error) {
+	invoiceLookupClient, ok := lnClientConn.LNClient.(InvoiceLookupClient)
+	if !ok {
+		return false, 0, fmt.Errorf("LN client does not support invoice lookups")
+	}
+	invoice, err := invoiceLookupClient.LookupInvoice(ctx, &lnrpc.PaymentHash{
+		RHash: paymentHash[:],
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	// AMP invoices stay open for further payments
+	if invoice.IsAmp && invoice.ValueMsat > 0 && invoice.AmtPaidMsat >= invoice.ValueMsat {
+		return true, invoice.AmtPaidMsat, nil
+	}
+	return invoice.State == lnrpc.Invoice_SETTLED, invoice.AmtPaidMsat, nil
+}
+
+func (lnClientConn *LNClientConn) SettleHoldInvoice(ctx context.Context, preimage lntypes.Preimage) error {
+	holdInvoiceClient, err := lnClientConn.holdInvoiceClient()
+	if err != nil {
+		return err
+	}
+	_, err = holdInvoiceClient.SettleInvoice(ctx, &invoicesrpc.SettleInvoiceMsg{
+		Preimage: preimage[:],
+	})
+	return err
+}
+
+func (lnClientConn *LNClientConn) CancelHoldInvoice(ctx context.Context, paymentHash lntypes.Hash) error {
+	holdInvoiceClient, err := lnClientConn.holdInvoiceClient()
+	if err != nil {
+		return err
+	}
+	_, err = holdInvoiceClient.CancelInvoice(ctx, &invoicesrpc.CancelInvoiceMsg{
+		PaymentHash: paymentHash[:],
+	})
+	return err
+}
+
+// InboundLiquidity sums the remote balance above the channel reserve of all
+// active channels.
+func (lnClientConn *LNClientConn) InboundLiquidity(ctx context.Context) (*InboundLiquidity, error) {
+	liquidityClient, ok := lnClientConn.LNClient.(LiquidityClient)
+	if !ok {
+		return nil, fmt.Errorf("LN client does not report inbound liquidity")
+	}
+	channels, err := liquidityClient.ListChannels(ctx, &lnrpc.ListChannelsRequest{
+		ActiveOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	liquidity := &InboundLiquidity{}
+	for _, channel := range channels.Channels {
+		receivable := channel.RemoteBalance
+		if channel.RemoteConstraints != nil {
+			receivable -= int64(channel.RemoteConstraints.ChanReserveSat)
+		}
+		if receivable <= 0 {
+			continue
+		}
+		liquidity.Total += receivable
+		if receivable > liquidity.MaxChannel {
+			liquidity.MaxChannel = receivable
+		}
+	}
+	return liquidity, nil
+}
+
+// WatchSettlements calls fn with the payment hash of every invoice settled
+// from now on, until ctx is done or the subscription fails.
+func (lnClientConn *LNClientConn) WatchSettlements(ctx context.Context, fn func(paymentHash lntypes.Hash) error) error {
+	return lnClientConn.TrackSettlements(ctx, 0, func(paymentHash lntypes.Hash, amountPaidMsat int64, settleIndex uint64) error {
+		return fn(paymentHash)
+	})
+}
+
+// TrackSettlements calls fn with the payment hash, amount paid and settle
+// index of every invoice settled after settleIndex, 0 for settlements from
+// now on, until
+// ctx is done or the subscription fails. Resubscribing with the last settle
+// index replays the settlements missed in between.
+func (lnClientConn *LNClientConn) TrackSettlements(ctx context.Context, settleIndex uint64, fn func(paymentHash lntypes.Hash, amountPaidMsat int64, settleIndex uint64) error) error {
+	invoiceSubscriber, ok := lnClientConn.LNClient.(InvoiceSubscriber)
+	if !ok {
+		return fmt.Errorf("LN client does not support invoice subscriptions")
+	}
+	stream, err := invoiceSubscriber.SubscribeInvoices(ctx, &lnrpc.InvoiceSubscription{
+		SettleIndex: settleIndex,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		invoice, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if invoice.State != lnrpc.Invoice_SETTLED {
+			continue
+		}
+		paymentHash, err := lntypes.MakeHash(invoice.RHash)
+		if err != nil {
+			return err
+		}
+		if err := fn(paymentHash, invoice.AmtPaidMsat, invoice.SettleIndex); err != nil {
+			return err
+		}
+	}
+}
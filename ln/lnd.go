@@ -6,13 +6,20 @@ import (
 	"crypto/x509"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/lightningnetwork/lnd/macaroons"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 	"gopkg.in/macaroon.v2"
 )
 
@@ -22,13 +29,60 @@ type LNDoptions struct {
 	CertHex      string
 	MacaroonFile string
 	MacaroonHex  string
+	// Cert and Macaroon can also be given inline as raw bytes or base64,
+	// e.g. from environment variables or secrets in containers. The cert
+	// may be PEM or DER.
+	Cert           []byte
+	CertBase64     string
+	Macaroon       []byte
+	MacaroonBase64 string
+	// AllowAdminMacaroon accepts macaroons with more permissions than
+	// CheckMinimalMacaroon allows, needed for keysend refunds and to bake
+	// macaroons
+	AllowAdminMacaroon bool
+	// LndconnectURI configures the address, cert and macaroon from an
+	// lndconnect:// URI, e.g. exported from Zeus
+	LndconnectURI string
+	// REST connects to the REST proxy at Address instead of gRPC
+	REST bool
+	// Private adds route hints for private channels to invoices, so nodes
+	// without public channels can be paid
+	Private bool
+	// ReconnectBaseDelay and ReconnectMaxDelay bound the exponential backoff
+	// between reconnects when the gRPC connection drops, defaults to
+	// DEFAULT_RECONNECT_BASE_DELAY and DEFAULT_RECONNECT_MAX_DELAY
+	ReconnectBaseDelay time.Duration
+	ReconnectMaxDelay  time.Duration
 }
 
+const (
+	DEFAULT_RECONNECT_BASE_DELAY = time.Second
+	DEFAULT_RECONNECT_MAX_DELAY  = 30 * time.Second
+	// KEEPALIVE_INTERVAL pings LND on an idle connection to detect when it
+	// dropped
+	KEEPALIVE_INTERVAL = 30 * time.Second
+	KEEPALIVE_TIMEOUT  = 10 * time.Second
+)
+
 type LNDWrapper struct {
-	client lnrpc.LightningClient
+	client         lnrpc.LightningClient
+	invoicesClient invoicesrpc.InvoicesClient
+	conn           *grpc.ClientConn
+	cancel         context.CancelFunc
+	private        bool
+	mu             sync.RWMutex
+	state          connectivity.State
 }
 
 func NewLNDclient(lndOptions LNDoptions) (result *LNDWrapper, err error) {
+	lndOptions, err = withLndconnect(lndOptions)
+	if err != nil {
+		return nil, err
+	}
+	lndOptions, err = withInlineCredentials(lndOptions)
+	if err != nil {
+		return nil, err
+	}
 	// Get credentials either from a hex string, a file or the system's certificate store
 	var creds credentials.TransportCredentials
 	// if a hex string is provided
@@ -50,8 +104,26 @@ func NewLNDclient(lndOptions LNDoptions) (result *LNDWrapper, err error) {
 	} else {
 		creds = credentials.NewTLS(&tls.Config{})
 	}
+	if lndOptions.ReconnectBaseDelay == 0 {
+		lndOptions.ReconnectBaseDelay = DEFAULT_RECONNECT_BASE_DELAY
+	}
+	if lndOptions.ReconnectMaxDelay == 0 {
+		lndOptions.ReconnectMaxDelay = DEFAULT_RECONNECT_MAX_DELAY
+	}
+	backoffConfig := backoff.DefaultConfig
+	backoffConfig.BaseDelay = lndOptions.ReconnectBaseDelay
+	backoffConfig.MaxDelay = lndOptions.ReconnectMaxDelay
 	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(creds),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoffConfig,
+			MinConnectTimeout: 20 * time.Second,
+		}),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                KEEPALIVE_INTERVAL,
+			Timeout:             KEEPALIVE_TIMEOUT,
+			PermitWithoutStream: true,
+		}),
 	}
 
 	var macaroonData []byte
@@ -70,6 +142,11 @@ func NewLNDclient(lndOptions LNDoptions) (result *LNDWrapper, err error) {
 	} else {
 		return nil, errors.New("LND macaroon is missing")
 	}
+	if !lndOptions.AllowAdminMacaroon {
+		if err := CheckMinimalMacaroon(macaroonData); err != nil {
+			return nil, err
+		}
+	}
 
 	mac := &macaroon.Macaroon{}
 	if err := mac.UnmarshalBinary(macaroonData); err != nil {
@@ -86,11 +163,99 @@ func NewLNDclient(lndOptions LNDoptions) (result *LNDWrapper, err error) {
 		return nil, err
 	}
 
-	return &LNDWrapper{
-		client: lnrpc.NewLightningClient(conn),
-	}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	wrapper := &LNDWrapper{
+		client:         lnrpc.NewLightningClient(conn),
+		invoicesClient: invoicesrpc.NewInvoicesClient(conn),
+		conn:           conn,
+		cancel:         cancel,
+		private:        lndOptions.Private,
+		state:          conn.GetState(),
+	}
+	go wrapper.monitor(ctx)
+	return wrapper, nil
+}
+
+// monitor tracks the state of the gRPC connection and reconnects a
+// connection that went idle, e.g. after LND restarted. gRPC retries failed
+// connections with the configured backoff.
+func (wrapper *LNDWrapper) monitor(ctx context.Context) {
+	for {
+		state := wrapper.conn.GetState()
+		wrapper.mu.Lock()
+		wrapper.state = state
+		wrapper.mu.Unlock()
+		if state == connectivity.Shutdown {
+			return
+		}
+		if state == connectivity.Idle {
+			wrapper.conn.Connect()
+		}
+		if !wrapper.conn.WaitForStateChange(ctx, state) {
+			return
+		}
+	}
+}
+
+// State returns the last observed state of the gRPC connection.
+func (wrapper *LNDWrapper) State() connectivity.State {
+	wrapper.mu.RLock()
+	defer wrapper.mu.RUnlock()
+	return wrapper.state
+}
+
+// Close stops reconnecting and closes the gRPC connection.
+func (wrapper *LNDWrapper) Close() error {
+	wrapper.cancel()
+	return wrapper.conn.Close()
 }
 
 func (wrapper *LNDWrapper) AddInvoice(ctx context.Context, req *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	req.Private = req.Private || wrapper.private
 	return wrapper.client.AddInvoice(ctx, req, options...)
 }
+
+func (wrapper *LNDWrapper) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	req.Private = req.Private || wrapper.private
+	return wrapper.invoicesClient.AddHoldInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	return wrapper.invoicesClient.SettleInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	return wrapper.invoicesClient.CancelInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) ListChannels(ctx context.Context, req *lnrpc.ListChannelsRequest, options ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+	return wrapper.client.ListChannels(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	return wrapper.client.LookupInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) SubscribeInvoices(ctx context.Context, req *lnrpc.InvoiceSubscription, options ...grpc.CallOption) (lnrpc.Lightning_SubscribeInvoicesClient, error) {
+	return wrapper.client.SubscribeInvoices(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) Ping(ctx context.Context) error {
+	_, err := wrapper.client.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		return fmt.Errorf("%s (connection %s)", err.Error(), wrapper.State())
+	}
+	return nil
+}
+
+func (wrapper *LNDWrapper) NewAddress(ctx context.Context, req *lnrpc.NewAddressRequest, options ...grpc.CallOption) (*lnrpc.NewAddressResponse, error) {
+	return wrapper.client.NewAddress(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) ListUnspent(ctx context.Context, req *lnrpc.ListUnspentRequest, options ...grpc.CallOption) (*lnrpc.ListUnspentResponse, error) {
+	return wrapper.client.ListUnspent(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest, options ...grpc.CallOption) (*lnrpc.SendResponse, error) {
+	return wrapper.client.SendPaymentSync(ctx, req, options...)
+}
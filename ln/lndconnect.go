@@ -0,0 +1,79 @@
+package ln
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const LNDCONNECT_SCHEME = "lndconnect"
+
+// ParseLndconnectURI parses an lndconnect://host:port?cert=...&macaroon=...
+// URI as exported by Zeus and lndconnect into LND options. The cert, a
+// base64url encoded DER certificate, is optional for nodes with a publicly
+// trusted certificate.
+func ParseLndconnectURI(uri string) (LNDoptions, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return LNDoptions{}, err
+	}
+	if parsed.Scheme != LNDCONNECT_SCHEME {
+		return LNDoptions{}, fmt.Errorf("Not an lndconnect URI: %s", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return LNDoptions{}, fmt.Errorf("lndconnect URI is missing the host")
+	}
+	query := parsed.Query()
+	macaroonBytes, err := decodeBase64Url(query.Get("macaroon"))
+	if err != nil {
+		return LNDoptions{}, fmt.Errorf("Invalid lndconnect macaroon: %s", err.Error())
+	}
+	if len(macaroonBytes) == 0 {
+		return LNDoptions{}, fmt.Errorf("lndconnect URI is missing the macaroon")
+	}
+	lndOptions := LNDoptions{
+		Address:     parsed.Host,
+		MacaroonHex: hex.EncodeToString(macaroonBytes),
+	}
+	if cert := query.Get("cert"); cert != "" {
+		certBytes, err := decodeBase64Url(cert)
+		if err != nil {
+			return LNDoptions{}, fmt.Errorf("Invalid lndconnect cert: %s", err.Error())
+		}
+		lndOptions.CertHex = hex.EncodeToString(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: certBytes,
+		}))
+	}
+	return lndOptions, nil
+}
+
+// decodeBase64Url decodes base64url with or without padding.
+func decodeBase64Url(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// withLndconnect returns lndOptions with the address and credentials of its
+// LndconnectURI, options that are set take precedence.
+func withLndconnect(lndOptions LNDoptions) (LNDoptions, error) {
+	if lndOptions.LndconnectURI == "" {
+		return lndOptions, nil
+	}
+	lndconnectOptions, err := ParseLndconnectURI(lndOptions.LndconnectURI)
+	if err != nil {
+		return lndOptions, err
+	}
+	if lndOptions.Address == "" {
+		lndOptions.Address = lndconnectOptions.Address
+	}
+	if lndOptions.CertHex == "" && lndOptions.CertFile == "" && len(lndOptions.Cert) == 0 && lndOptions.CertBase64 == "" {
+		lndOptions.CertHex = lndconnectOptions.CertHex
+	}
+	if lndOptions.MacaroonHex == "" && lndOptions.MacaroonFile == "" && len(lndOptions.Macaroon) == 0 && lndOptions.MacaroonBase64 == "" {
+		lndOptions.MacaroonHex = lndconnectOptions.MacaroonHex
+	}
+	return lndOptions, nil
+}
@@ -0,0 +1,44 @@
+package ln
+
+import (
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLndconnectURI(t *testing.T) {
+	lndOptions, err := ParseLndconnectURI("lndconnect://node.example.com:10009?cert=AQID&macaroon=AgEDbG5k")
+	assert.NoError(t, err)
+	assert.Equal(t, "node.example.com:10009", lndOptions.Address)
+	assert.Equal(t, "0201036c6e64", lndOptions.MacaroonHex)
+	cert, err := hex.DecodeString(lndOptions.CertHex)
+	assert.NoError(t, err)
+	block, _ := pem.Decode(cert)
+	assert.Equal(t, []byte{1, 2, 3}, block.Bytes)
+
+	// The cert is optional, padding is accepted
+	lndOptions, err = ParseLndconnectURI("lndconnect://node.example.com:10009?macaroon=AgEDbG5k==")
+	assert.NoError(t, err)
+	assert.Equal(t, "", lndOptions.CertHex)
+
+	for _, uri := range []string{
+		"https://node.example.com:10009?macaroon=AgEDbG5k",
+		"lndconnect://?macaroon=AgEDbG5k",
+		"lndconnect://node.example.com:10009",
+		"lndconnect://node.example.com:10009?macaroon=!",
+	} {
+		_, err := ParseLndconnectURI(uri)
+		assert.Error(t, err, uri)
+	}
+
+	// Options that are set take precedence over the URI
+	lndOptions, err = withLndconnect(LNDoptions{
+		LndconnectURI: "lndconnect://node.example.com:10009?macaroon=AgEDbG5k",
+		MacaroonHex:   "0201",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "node.example.com:10009", lndOptions.Address)
+	assert.Equal(t, "0201", lndOptions.MacaroonHex)
+}
@@ -0,0 +1,53 @@
+package ln
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// withInlineCredentials returns lndOptions with the cert and macaroon given
+// as bytes or base64 converted to CertHex and MacaroonHex.
+func withInlineCredentials(lndOptions LNDoptions) (LNDoptions, error) {
+	cert := lndOptions.Cert
+	if len(cert) == 0 && lndOptions.CertBase64 != "" {
+		decoded, err := decodeBase64(lndOptions.CertBase64)
+		if err != nil {
+			return lndOptions, fmt.Errorf("Invalid LND cert: %s", err.Error())
+		}
+		cert = decoded
+	}
+	if len(cert) > 0 && lndOptions.CertHex == "" {
+		// Accept DER certs next to PEM
+		if block, _ := pem.Decode(cert); block == nil {
+			cert = pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: cert,
+			})
+		}
+		lndOptions.CertHex = hex.EncodeToString(cert)
+	}
+	macaroonBytes := lndOptions.Macaroon
+	if len(macaroonBytes) == 0 && lndOptions.MacaroonBase64 != "" {
+		decoded, err := decodeBase64(lndOptions.MacaroonBase64)
+		if err != nil {
+			return lndOptions, fmt.Errorf("Invalid LND macaroon: %s", err.Error())
+		}
+		macaroonBytes = decoded
+	}
+	if len(macaroonBytes) > 0 && lndOptions.MacaroonHex == "" {
+		lndOptions.MacaroonHex = hex.EncodeToString(macaroonBytes)
+	}
+	return lndOptions, nil
+}
+
+// decodeBase64 decodes standard or url safe base64, with or without padding.
+func decodeBase64(value string) ([]byte, error) {
+	value = strings.TrimRight(strings.TrimSpace(value), "=")
+	if strings.ContainsAny(value, "-_") {
+		return base64.RawURLEncoding.DecodeString(value)
+	}
+	return base64.RawStdEncoding.DecodeString(value)
+}
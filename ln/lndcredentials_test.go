@@ -0,0 +1,40 @@
+package ln
+
+import (
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithInlineCredentials(t *testing.T) {
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1, 2, 3}})
+	// DER certs are converted to PEM
+	for _, lndOptions := range []LNDoptions{
+		{Cert: pemCert, Macaroon: []byte{2, 1}},
+		{Cert: []byte{1, 2, 3}, MacaroonBase64: "AgE="},
+		{CertBase64: "AQID", MacaroonBase64: "AgE"},
+	} {
+		lndOptions, err := withInlineCredentials(lndOptions)
+		assert.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(pemCert), lndOptions.CertHex)
+		assert.Equal(t, "0201", lndOptions.MacaroonHex)
+	}
+
+	// Hex options take precedence
+	lndOptions, err := withInlineCredentials(LNDoptions{Macaroon: []byte{2, 1}, MacaroonHex: "0202"})
+	assert.NoError(t, err)
+	assert.Equal(t, "0202", lndOptions.MacaroonHex)
+
+	_, err = withInlineCredentials(LNDoptions{MacaroonBase64: "!"})
+	assert.Error(t, err)
+}
+
+func TestDecodeBase64(t *testing.T) {
+	for _, value := range []string{"+/8=", "+/8", "-_8", " +/8=\n"} {
+		decoded, err := decodeBase64(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, []byte{0xfb, 0xff}, decoded, value)
+	}
+}
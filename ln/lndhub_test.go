@@ -0,0 +1,69 @@
+package ln
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+// Example invoice of the BOLT11 specification
+const (
+	testBolt11            = "lnbc1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq8rkx3yf5tcsyz3d73gafnh3cax9rn449d9p5uxz9ezhhypd0elx87sjle52x86fux2ypatgddc6k63n7erqz25le42c4u4ecky03ylcqca784w"
+	testBolt11PaymentHash = "0001020304050607080900010203040506070809000102030405060708090102"
+)
+
+func TestLNDhubClient(t *testing.T) {
+	paymentHash, err := lntypes.MakeHashFromStr(testBolt11PaymentHash)
+	assert.NoError(t, err)
+	server := newTestServer(t, map[string]interface{}{
+		"POST /auth": map[string]interface{}{
+			"access_token": "accesstoken",
+		},
+		"POST /addinvoice": map[string]interface{}{
+			"payment_request": testBolt11,
+			// Encoded differently by each implementation
+			"r_hash": "ignored",
+		},
+		"GET /checkpayment/" + testBolt11PaymentHash: map[string]interface{}{
+			"paid": true,
+		},
+	})
+	client, err := NewLNDhubClient(LNDhubOptions{
+		Address:  server.URL,
+		Login:    "login",
+		Password: "password",
+	})
+	assert.NoError(t, err)
+	req := server.request(t, "POST /auth")
+	assert.Equal(t, "type=auth", req.Query)
+	assert.Equal(t, map[string]interface{}{
+		"login":    "login",
+		"password": "password",
+	}, req.JSON(t))
+	ctx := context.Background()
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, testBolt11, invoiceRes.PaymentRequest)
+	req = server.request(t, "POST /addinvoice")
+	assert.Equal(t, "Bearer accesstoken", req.Header.Get("Authorization"))
+	assert.Equal(t, map[string]interface{}{
+		"amt":  "10",
+		"memo": "LSAT",
+	}, req.JSON(t))
+
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+
+	_, err = NewLNDhubClient(LNDhubOptions{
+		Address:  server.URL + "/missing",
+		Login:    "login",
+		Password: "password",
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+package ln
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/protobuf/encoding/protowire"
+	"gopkg.in/macaroon.v2"
+)
+
+// INVOICE_PERMISSIONS are the permissions the middleware needs to issue and
+// look up invoices
+var INVOICE_PERMISSIONS = []*lnrpc.MacaroonPermission{
+	{Entity: "invoices", Action: "read"},
+	{Entity: "invoices", Action: "write"},
+}
+
+// WRITABLE_ENTITIES may be written by a minimal macaroon, besides reading
+// any entity. Both are allowed by LND's invoice.macaroon and can't move
+// funds.
+var WRITABLE_ENTITIES = []string{"invoices", "address"}
+
+// LND_MACAROON_ID_VERSION is the version byte in front of the protobuf
+// encoded id of LND macaroons
+const LND_MACAROON_ID_VERSION = 2
+
+// MacaroonPermissions returns the entity:action permissions of an LND
+// macaroon, read from its identifier.
+func MacaroonPermissions(macaroonBytes []byte) ([]string, error) {
+	mac := &macaroon.Macaroon{}
+	if err := mac.UnmarshalBinary(macaroonBytes); err != nil {
+		return nil, err
+	}
+	id := mac.Id()
+	if len(id) == 0 || id[0] != LND_MACAROON_ID_VERSION {
+		return nil, fmt.Errorf("Not an LND macaroon")
+	}
+	permissions := []string{}
+	err := consumeMessage(id[1:], func(num protowire.Number, value []byte) error {
+		// MacaroonId.ops
+		if num != 3 {
+			return nil
+		}
+		entity, actions := "", []string{}
+		err := consumeMessage(value, func(num protowire.Number, value []byte) error {
+			switch num {
+			case 1:
+				entity = string(value)
+			case 2:
+				actions = append(actions, string(value))
+			}
+			return nil
+		})
+		for _, action := range actions {
+			permissions = append(permissions, entity+":"+action)
+		}
+		return err
+	})
+	return permissions, err
+}
+
+// consumeMessage calls fn for every length delimited field of a protobuf
+// message, other fields are skipped.
+func consumeMessage(message []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		message = message[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, message)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			message = message[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(message)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		message = message[n:]
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckMinimalMacaroon rejects macaroons that can do more than read and
+// write invoices and addresses, like admin.macaroon, so a leaked config
+// can't be used to move funds.
+func CheckMinimalMacaroon(macaroonBytes []byte) error {
+	permissions, err := MacaroonPermissions(macaroonBytes)
+	if err != nil {
+		return err
+	}
+	for _, permission := range permissions {
+		splitted := strings.SplitN(permission, ":", 2)
+		if len(splitted) == 2 && (splitted[1] == "read" || splitted[1] == "write" && contains(WRITABLE_ENTITIES, splitted[0])) {
+			continue
+		}
+		return fmt.Errorf("LND macaroon has the %s permission, bake one with BakeInvoiceMacaroon or set AllowAdminMacaroon", permission)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// BakeInvoiceMacaroon bakes a macaroon restricted to INVOICE_PERMISSIONS and
+// permissions, returned hex encoded for MacaroonHex. The client needs the
+// macaroon:generate permission, e.g. run it once with admin.macaroon.
+func (wrapper *LNDWrapper) BakeInvoiceMacaroon(ctx context.Context, permissions ...*lnrpc.MacaroonPermission) (string, error) {
+	res, err := wrapper.client.BakeMacaroon(ctx, &lnrpc.BakeMacaroonRequest{
+		Permissions: append(append([]*lnrpc.MacaroonPermission{}, INVOICE_PERMISSIONS...), permissions...),
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := hex.DecodeString(res.Macaroon); err != nil {
+		return "", err
+	}
+	return res.Macaroon, nil
+}
@@ -0,0 +1,178 @@
+package ln
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// LNDRESTWrapper talks to LND through its REST proxy, for deployments that
+// can only reach LND over HTTP. Address is the REST address, e.g.
+// https://localhost:8080.
+type LNDRESTWrapper struct {
+	address     string
+	macaroonHex string
+	client      *http.Client
+	private     bool
+}
+
+func NewLNDRESTClient(lndOptions LNDoptions) (*LNDRESTWrapper, error) {
+	lndOptions, err := withLndconnect(lndOptions)
+	if err != nil {
+		return nil, err
+	}
+	lndOptions, err = withInlineCredentials(lndOptions)
+	if err != nil {
+		return nil, err
+	}
+	if lndOptions.Address == "" {
+		return nil, errors.New("LND address is missing")
+	}
+	tlsConfig := &tls.Config{}
+	if lndOptions.CertHex != "" || lndOptions.CertFile != "" {
+		var cert []byte
+		var err error
+		if lndOptions.CertHex != "" {
+			cert, err = hex.DecodeString(lndOptions.CertHex)
+		} else {
+			cert, err = ioutil.ReadFile(lndOptions.CertFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cp := x509.NewCertPool()
+		cp.AppendCertsFromPEM(cert)
+		tlsConfig.RootCAs = cp
+	}
+	macaroonHex := lndOptions.MacaroonHex
+	if macaroonHex == "" {
+		if lndOptions.MacaroonFile == "" {
+			return nil, errors.New("LND macaroon is missing")
+		}
+		macBytes, err := ioutil.ReadFile(lndOptions.MacaroonFile)
+		if err != nil {
+			return nil, err
+		}
+		macaroonHex = hex.EncodeToString(macBytes)
+	}
+	if !lndOptions.AllowAdminMacaroon {
+		macBytes, err := hex.DecodeString(macaroonHex)
+		if err != nil {
+			return nil, err
+		}
+		if err := CheckMinimalMacaroon(macBytes); err != nil {
+			return nil, err
+		}
+	}
+	address := lndOptions.Address
+	if !strings.HasPrefix(address, "https://") && !strings.HasPrefix(address, "http://") {
+		address = "https://" + address
+	}
+	return &LNDRESTWrapper{
+		address:     strings.TrimSuffix(address, "/"),
+		macaroonHex: macaroonHex,
+		private:     lndOptions.Private,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}, nil
+}
+
+// call sends req as protobuf JSON, nil for no body, and decodes the
+// response into res.
+func (wrapper *LNDRESTWrapper) call(ctx context.Context, method string, path string, req proto.Message, res proto.Message) error {
+	var body io.Reader
+	if req != nil {
+		payload, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, wrapper.address+path, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Grpc-Metadata-macaroon", wrapper.macaroonHex)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpRes, err := wrapper.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+	resBytes, err := ioutil.ReadAll(httpRes.Body)
+	if err != nil {
+		return err
+	}
+	if httpRes.StatusCode != http.StatusOK {
+		return fmt.Errorf("LND REST %s %s returned %d: %s", method, path, httpRes.StatusCode, string(resBytes))
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(resBytes, res)
+}
+
+func (wrapper *LNDRESTWrapper) AddInvoice(ctx context.Context, req *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	req.Private = req.Private || wrapper.private
+	res := &lnrpc.AddInvoiceResponse{}
+	return res, wrapper.call(ctx, http.MethodPost, "/v1/invoices", req, res)
+}
+
+func (wrapper *LNDRESTWrapper) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	req.Private = req.Private || wrapper.private
+	res := &invoicesrpc.AddHoldInvoiceResp{}
+	return res, wrapper.call(ctx, http.MethodPost, "/v2/invoices/hodl", req, res)
+}
+
+func (wrapper *LNDRESTWrapper) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	res := &invoicesrpc.SettleInvoiceResp{}
+	return res, wrapper.call(ctx, http.MethodPost, "/v2/invoices/settle", req, res)
+}
+
+func (wrapper *LNDRESTWrapper) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	res := &invoicesrpc.CancelInvoiceResp{}
+	return res, wrapper.call(ctx, http.MethodPost, "/v2/invoices/cancel", req, res)
+}
+
+func (wrapper *LNDRESTWrapper) ListChannels(ctx context.Context, req *lnrpc.ListChannelsRequest, options ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+	res := &lnrpc.ListChannelsResponse{}
+	return res, wrapper.call(ctx, http.MethodGet, fmt.Sprintf("/v1/channels?active_only=%t", req.ActiveOnly), nil, res)
+}
+
+func (wrapper *LNDRESTWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	res := &lnrpc.Invoice{}
+	return res, wrapper.call(ctx, http.MethodGet, "/v1/invoice/"+hex.EncodeToString(req.RHash), nil, res)
+}
+
+func (wrapper *LNDRESTWrapper) Ping(ctx context.Context) error {
+	return wrapper.call(ctx, http.MethodGet, "/v1/getinfo", nil, &lnrpc.GetInfoResponse{})
+}
+
+func (wrapper *LNDRESTWrapper) NewAddress(ctx context.Context, req *lnrpc.NewAddressRequest, options ...grpc.CallOption) (*lnrpc.NewAddressResponse, error) {
+	res := &lnrpc.NewAddressResponse{}
+	return res, wrapper.call(ctx, http.MethodGet, fmt.Sprintf("/v1/newaddress?type=%d", req.Type), nil, res)
+}
+
+func (wrapper *LNDRESTWrapper) ListUnspent(ctx context.Context, req *lnrpc.ListUnspentRequest, options ...grpc.CallOption) (*lnrpc.ListUnspentResponse, error) {
+	res := &lnrpc.ListUnspentResponse{}
+	return res, wrapper.call(ctx, http.MethodGet, fmt.Sprintf("/v1/utxos?min_confs=%d&max_confs=%d", req.MinConfs, req.MaxConfs), nil, res)
+}
+
+func (wrapper *LNDRESTWrapper) SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest, options ...grpc.CallOption) (*lnrpc.SendResponse, error) {
+	res := &lnrpc.SendResponse{}
+	return res, wrapper.call(ctx, http.MethodPost, "/v1/channels/transactions", req, res)
+}
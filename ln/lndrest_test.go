@@ -0,0 +1,60 @@
+package ln
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLNDRESTClient(t *testing.T) {
+	paymentHash := testPreimage.Hash()
+	server := newTestServer(t, map[string]interface{}{
+		"POST /v1/invoices": map[string]interface{}{
+			"r_hash":          base64.StdEncoding.EncodeToString(paymentHash[:]),
+			"payment_request": "lnbc100n1lnd",
+			"add_index":       "1",
+		},
+		"GET /v1/invoice/" + paymentHash.String(): map[string]interface{}{
+			"r_hash":     base64.StdEncoding.EncodeToString(paymentHash[:]),
+			"r_preimage": base64.StdEncoding.EncodeToString(testPreimage[:]),
+			"value_msat": "10000",
+			"state":      "SETTLED",
+			// Unknown fields of newer LND versions are ignored
+			"unknown": true,
+		},
+		"GET /v1/getinfo": map[string]interface{}{},
+	})
+	client, err := NewLNDRESTClient(LNDoptions{
+		Address:            server.URL,
+		MacaroonHex:        "0201",
+		AllowAdminMacaroon: true,
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, "lnbc100n1lnd", invoiceRes.PaymentRequest)
+	req := server.request(t, "POST /v1/invoices")
+	assert.Equal(t, "0201", req.Header.Get("Grpc-Metadata-macaroon"))
+	assert.Equal(t, map[string]interface{}{
+		"value": "10",
+		"memo":  "LSAT",
+	}, req.JSON(t))
+
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+	assert.Equal(t, int64(10000), invoice.ValueMsat)
+	assert.Equal(t, testPreimage[:], invoice.RPreimage)
+
+	assert.NoError(t, client.Ping(ctx))
+	_, err = client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: make([]byte, 32)})
+	assert.Error(t, err)
+	_, err = NewLNDRESTClient(LNDoptions{Address: server.URL})
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package ln
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"google.golang.org/grpc"
+)
+
+const LNPAY_ADDRESS = "https://api.lnpay.co"
+
+type LNPayOptions struct {
+	// Address defaults to LNPAY_ADDRESS
+	Address string
+	// APIKey is the public key of the LNPay account
+	APIKey string
+	// WalletKey is an invoice key of the wallet receiving the payments
+	WalletKey string
+	// Client is used for all requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type LNPayWrapper struct {
+	options LNPayOptions
+	// txIds maps payment hashes to the LNPay transactions of their invoices
+	txIds sync.Map
+}
+
+type lnpayInvoiceRequest struct {
+	NumSatoshis int64  `json:"num_satoshis"`
+	Memo        string `json:"memo"`
+	Expiry      int64  `json:"expiry,omitempty"`
+}
+
+type lnpayTxResponse struct {
+	Id              string `json:"id"`
+	PaymentRequest  string `json:"payment_request"`
+	NumSatoshis     int64  `json:"num_satoshis"`
+	Settled         int    `json:"settled"`
+	SettledAt       int64  `json:"settled_at"`
+	PaymentPreimage string `json:"payment_preimage"`
+}
+
+func NewLNPayClient(lnpayOptions LNPayOptions) (*LNPayWrapper, error) {
+	if lnpayOptions.APIKey == "" || lnpayOptions.WalletKey == "" {
+		return nil, fmt.Errorf("LNPay API key or wallet key is missing")
+	}
+	if lnpayOptions.Address == "" {
+		lnpayOptions.Address = LNPAY_ADDRESS
+	}
+	lnpayOptions.Address = strings.TrimSuffix(lnpayOptions.Address, "/")
+	return &LNPayWrapper{
+		options: lnpayOptions,
+	}, nil
+}
+
+func (wrapper *LNPayWrapper) call(ctx context.Context, method string, path string, reqBody interface{}, resBody interface{}) error {
+	header := http.Header{}
+	header.Set("X-Api-Key", wrapper.options.APIKey)
+	return doJSONRequest(ctx, wrapper.options.Client, method, wrapper.options.Address+path, header, reqBody, resBody)
+}
+
+func (wrapper *LNPayWrapper) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	txRes := &lnpayTxResponse{}
+	err := wrapper.call(ctx, http.MethodPost, fmt.Sprintf("/v1/wallet/%s/invoice", wrapper.options.WalletKey), &lnpayInvoiceRequest{
+		NumSatoshis: invoiceAmountSat(lnInvoice),
+		Memo:        lnInvoice.Memo,
+		Expiry:      lnInvoice.Expiry,
+	}, txRes)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodepay.Decodepay(txRes.PaymentRequest)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := lntypes.MakeHashFromStr(decoded.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	wrapper.txIds.Store(paymentHash, txRes.Id)
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          paymentHash[:],
+		PaymentRequest: txRes.PaymentRequest,
+	}, nil
+}
+
+// LookupInvoice looks up the transaction of an invoice issued by this
+// process, LNPay can't look up transactions by payment hash.
+func (wrapper *LNPayWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	paymentHash, err := lntypes.MakeHash(req.RHash)
+	if err != nil {
+		return nil, err
+	}
+	txId, ok := wrapper.txIds.Load(paymentHash)
+	if !ok {
+		return nil, fmt.Errorf("Invoice was not issued by this client: %s", paymentHash)
+	}
+	txRes := &lnpayTxResponse{}
+	if err := wrapper.call(ctx, http.MethodGet, "/v1/lntx/"+txId.(string), nil, txRes); err != nil {
+		return nil, err
+	}
+	invoice := &lnrpc.Invoice{
+		RHash:          req.RHash,
+		PaymentRequest: txRes.PaymentRequest,
+		Value:          txRes.NumSatoshis,
+		ValueMsat:      MSAT_PER_SAT * txRes.NumSatoshis,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	if txRes.Settled == 1 {
+		invoice.State = lnrpc.Invoice_SETTLED
+		invoice.SettleDate = txRes.SettledAt
+		if preimage, err := lntypes.MakePreimageFromStr(txRes.PaymentPreimage); err == nil {
+			invoice.RPreimage = preimage[:]
+		}
+	}
+	return invoice, nil
+}
+
+func (wrapper *LNPayWrapper) Ping(ctx context.Context) error {
+	return wrapper.call(ctx, http.MethodGet, fmt.Sprintf("/v1/wallet/%s", wrapper.options.WalletKey), nil, nil)
+}
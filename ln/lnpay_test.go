@@ -0,0 +1,65 @@
+package ln
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLNPayClient(t *testing.T) {
+	paymentHash, err := lntypes.MakeHashFromStr(testBolt11PaymentHash)
+	assert.NoError(t, err)
+	server := newTestServer(t, map[string]interface{}{
+		"POST /v1/wallet/walletkey/invoice": map[string]interface{}{
+			"id":              "lntx",
+			"payment_request": testBolt11,
+			"num_satoshis":    10,
+		},
+		"GET /v1/lntx/lntx": map[string]interface{}{
+			"id":               "lntx",
+			"payment_request":  testBolt11,
+			"num_satoshis":     10,
+			"settled":          1,
+			"settled_at":       1656000000,
+			"payment_preimage": testPreimage.String(),
+		},
+		"GET /v1/wallet/walletkey": map[string]interface{}{},
+	})
+	client, err := NewLNPayClient(LNPayOptions{
+		Address:   server.URL + "/",
+		APIKey:    "apikey",
+		WalletKey: "walletkey",
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT", Expiry: 600}, nil)
+	assert.NoError(t, err)
+	// The payment hash is decoded from the payment request
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, testBolt11, invoiceRes.PaymentRequest)
+	req := server.request(t, "POST /v1/wallet/walletkey/invoice")
+	assert.Equal(t, "apikey", req.Header.Get("X-Api-Key"))
+	assert.Equal(t, map[string]interface{}{
+		"num_satoshis": float64(10),
+		"memo":         "LSAT",
+		"expiry":       float64(600),
+	}, req.JSON(t))
+
+	// Looked up by the LNPay transaction of the invoice
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+	assert.Equal(t, int64(10000), invoice.ValueMsat)
+	assert.Equal(t, testPreimage[:], invoice.RPreimage)
+	assert.Equal(t, int64(1656000000), invoice.SettleDate)
+
+	assert.NoError(t, client.Ping(ctx))
+	_, err = client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: make([]byte, 32)})
+	assert.Error(t, err)
+	_, err = NewLNPayClient(LNPayOptions{APIKey: "apikey"})
+	assert.Error(t, err)
+}
@@ -2,10 +2,16 @@ package ln
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/kiwiidb/gin-lsat/utils"
 
@@ -17,8 +23,32 @@ import (
 
 const MSAT_PER_SAT = 1000
 
+const (
+	LNURL_PAY_TAG = "payRequest"
+	// Invoices returned by the LNURL provider must stay payable at least this long
+	MIN_INVOICE_EXPIRY    = 60 * time.Second
+	DEFAULT_RETRY_BACKOFF = 200 * time.Millisecond
+)
+
 type LNURLoptions struct {
+	// Address is a lightning address (user@domain) or a bech32 encoded LNURL
 	Address string
+	// Client is used for all LNURL requests, defaults to http.DefaultClient
+	Client *http.Client
+	// ProxyUrl routes LNURL requests through a proxy when no Client is set,
+	// e.g. socks5://127.0.0.1:9050 for Tor or http://proxy.corp:3128
+	ProxyUrl string
+	// Timeout of a single LNURL request, 0 for none
+	Timeout time.Duration
+	// MaxRetries of LNURL requests failing with a network error or a 5xx
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubling with every
+	// retry, defaults to DEFAULT_RETRY_BACKOFF
+	RetryBackoff time.Duration
+	// ParamsTTL is how long the pay request parameters (callback, sendable
+	// range, metadata) are cached before they are fetched again, 0 caches
+	// them for the lifetime of the client
+	ParamsTTL time.Duration
 }
 
 type LnAddressUrlResJson struct {
@@ -28,10 +58,39 @@ type LnAddressUrlResJson struct {
 	Metadata       string `json:"metadata"`
 	CommentAllowed uint   `json:"commentAllowed"`
 	Tag            string `json:"tag"`
+
+	client  *http.Client
+	options LNURLoptions
+	payUrl  string
+	// mu guards the pay request parameters while they are refreshed
+	mu        sync.RWMutex
+	fetchedAt time.Time
+	// verifyUrls maps payment hashes to their LUD-21 verify urls
+	verifyUrls sync.Map
+}
+
+// payParams are the pay request parameters an invoice is requested with
+type payParams struct {
+	Callback    string
+	MaxSendable uint64
+	MinSendable uint64
+	Metadata    string
 }
 
 type CallbackUrlResJson struct {
-	PR string `json:"pr"`
+	PR     string `json:"pr"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	// Verify is the LUD-21 url to check the settlement of PR
+	Verify string `json:"verify"`
+}
+
+type VerifyUrlResJson struct {
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+	Settled  bool   `json:"settled"`
+	Preimage string `json:"preimage"`
+	PR       string `json:"pr"`
 }
 
 type DecodedPR struct {
@@ -47,25 +106,104 @@ type DecodedPR struct {
 }
 
 func NewLNURLClient(lnurlOptions LNURLoptions) (*LnAddressUrlResJson, error) {
-	username, domain, err := utils.ParseLnAddress(lnurlOptions.Address)
+	lnAddressUrl, err := utils.LnurlPayUrl(lnurlOptions.Address)
 	if err != nil {
 		return nil, err
 	}
-	lnAddressUrl := fmt.Sprintf("https://%s/.well-known/lnurlp/%s", domain, username)
-	lnAddressUrlResBody, err := DoGetRequest(lnAddressUrl)
+	client, err := lnurlOptions.httpClient()
 	if err != nil {
 		return nil, err
 	}
-	lnAddressUrlRes := &LnAddressUrlResJson{}
-	if err := json.Unmarshal(lnAddressUrlResBody, lnAddressUrlRes); err != nil {
+	lnAddressUrlRes := &LnAddressUrlResJson{
+		client:  client,
+		options: lnurlOptions,
+		payUrl:  lnAddressUrl,
+	}
+	if err := lnAddressUrlRes.fetchParams(context.Background()); err != nil {
 		return nil, err
 	}
 	return lnAddressUrlRes, nil
 }
 
+// fetchParams fetches the pay request parameters and replaces the cached
+// ones if they are valid.
+func (lnAddressUrlResJson *LnAddressUrlResJson) fetchParams(ctx context.Context) error {
+	lnAddressUrlResBody, err := lnAddressUrlResJson.get(ctx, lnAddressUrlResJson.payUrl)
+	if err != nil {
+		return err
+	}
+	fetched := &LnAddressUrlResJson{}
+	if err := json.Unmarshal(lnAddressUrlResBody, fetched); err != nil {
+		return err
+	}
+	if fetched.Tag != LNURL_PAY_TAG {
+		return fmt.Errorf("LNURL response is not a pay request: %s", fetched.Tag)
+	}
+	if !isSecureUrl(fetched.Callback) {
+		return fmt.Errorf("LNURL callback is not an https url: %s", fetched.Callback)
+	}
+	lnAddressUrlResJson.mu.Lock()
+	defer lnAddressUrlResJson.mu.Unlock()
+	lnAddressUrlResJson.Callback = fetched.Callback
+	lnAddressUrlResJson.MaxSendable = fetched.MaxSendable
+	lnAddressUrlResJson.MinSendable = fetched.MinSendable
+	lnAddressUrlResJson.Metadata = fetched.Metadata
+	lnAddressUrlResJson.CommentAllowed = fetched.CommentAllowed
+	lnAddressUrlResJson.Tag = fetched.Tag
+	lnAddressUrlResJson.fetchedAt = time.Now()
+	return nil
+}
+
+// params returns the cached pay request parameters, fetching them again
+// when they are older than ParamsTTL. The cached parameters are used when
+// fetching fails.
+func (lnAddressUrlResJson *LnAddressUrlResJson) params(ctx context.Context) payParams {
+	lnAddressUrlResJson.mu.RLock()
+	stale := lnAddressUrlResJson.options.ParamsTTL > 0 && time.Since(lnAddressUrlResJson.fetchedAt) > lnAddressUrlResJson.options.ParamsTTL
+	lnAddressUrlResJson.mu.RUnlock()
+	if stale {
+		lnAddressUrlResJson.fetchParams(ctx)
+	}
+	lnAddressUrlResJson.mu.RLock()
+	defer lnAddressUrlResJson.mu.RUnlock()
+	return payParams{
+		Callback:    lnAddressUrlResJson.Callback,
+		MaxSendable: lnAddressUrlResJson.MaxSendable,
+		MinSendable: lnAddressUrlResJson.MinSendable,
+		Metadata:    lnAddressUrlResJson.Metadata,
+	}
+}
+
+func (lnurlOptions LNURLoptions) httpClient() (*http.Client, error) {
+	if lnurlOptions.ProxyUrl == "" {
+		if lnurlOptions.Client == nil {
+			return http.DefaultClient, nil
+		}
+		return lnurlOptions.Client, nil
+	}
+	if lnurlOptions.Client != nil {
+		return nil, fmt.Errorf("LNURL client and proxy url can't both be set")
+	}
+	proxyUrl, err := url.Parse(lnurlOptions.ProxyUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid LNURL proxy url: %s", err.Error())
+	}
+	// The proxy resolves host names, so .onion addresses work through Tor
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(proxyUrl),
+		},
+	}, nil
+}
+
 func (lnAddressUrlResJson *LnAddressUrlResJson) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
-	callbackUrl := fmt.Sprintf("%s?amount=%d", lnAddressUrlResJson.Callback, MSAT_PER_SAT*lnInvoice.Value)
-	callbackUrlResBody, err := DoGetRequest(callbackUrl)
+	amount := InvoiceAmountMsat(lnInvoice)
+	params := lnAddressUrlResJson.params(ctx)
+	if uint64(amount) < params.MinSendable || uint64(amount) > params.MaxSendable {
+		return nil, fmt.Errorf("Amount of %d msat is not within the sendable range of %d to %d msat", amount, params.MinSendable, params.MaxSendable)
+	}
+	callbackUrl := fmt.Sprintf("%s?amount=%d", params.Callback, amount)
+	callbackUrlResBody, err := lnAddressUrlResJson.get(ctx, callbackUrl)
 	if err != nil {
 		return nil, err
 	}
@@ -73,24 +211,161 @@ func (lnAddressUrlResJson *LnAddressUrlResJson) AddInvoice(ctx context.Context,
 	if err := json.Unmarshal(callbackUrlResBody, callbackUrlResJson); err != nil {
 		return nil, err
 	}
+	if callbackUrlResJson.Status == "ERROR" {
+		return nil, fmt.Errorf("LNURL callback returned an error: %s", callbackUrlResJson.Reason)
+	}
 
 	invoice := callbackUrlResJson.PR
 	decoded, err := decodepay.Decodepay(invoice)
 	if err != nil {
 		return nil, err
 	}
+	if err := validateInvoice(decoded, amount, params.Metadata); err != nil {
+		return nil, err
+	}
 	paymentHash, err := lntypes.MakeHashFromStr(decoded.PaymentHash)
 	if err != nil {
 		return nil, err
 	}
+	if callbackUrlResJson.Verify != "" {
+		lnAddressUrlResJson.verifyUrls.Store(paymentHash, callbackUrlResJson.Verify)
+	}
 	return &lnrpc.AddInvoiceResponse{
 		RHash:          paymentHash[:],
 		PaymentRequest: invoice,
 	}, nil
 }
 
+// LookupInvoice checks the settlement of an invoice issued by this process
+// at its LUD-21 verify url, for providers that support LUD-21.
+func (lnAddressUrlResJson *LnAddressUrlResJson) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	paymentHash, err := lntypes.MakeHash(req.RHash)
+	if err != nil {
+		return nil, err
+	}
+	verifyUrl, ok := lnAddressUrlResJson.verifyUrls.Load(paymentHash)
+	if !ok {
+		return nil, fmt.Errorf("LNURL provider did not return a verify url for invoice %s", paymentHash)
+	}
+	verifyUrlResBody, err := lnAddressUrlResJson.get(ctx, verifyUrl.(string))
+	if err != nil {
+		return nil, err
+	}
+	verifyUrlResJson := &VerifyUrlResJson{}
+	if err := json.Unmarshal(verifyUrlResBody, verifyUrlResJson); err != nil {
+		return nil, err
+	}
+	if verifyUrlResJson.Status == "ERROR" {
+		return nil, fmt.Errorf("LNURL verify returned an error: %s", verifyUrlResJson.Reason)
+	}
+	invoice := &lnrpc.Invoice{
+		RHash:          req.RHash,
+		PaymentRequest: verifyUrlResJson.PR,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	if verifyUrlResJson.Settled {
+		// Don't trust the provider, its preimage must match the payment hash
+		preimage, err := lntypes.MakePreimageFromStr(verifyUrlResJson.Preimage)
+		if err != nil || !preimage.Matches(paymentHash) {
+			return nil, fmt.Errorf("LNURL verify returned an invalid preimage for invoice %s", paymentHash)
+		}
+		invoice.State = lnrpc.Invoice_SETTLED
+		invoice.RPreimage = preimage[:]
+	}
+	return invoice, nil
+}
+
+// validateInvoice rejects invoices of a misbehaving LNURL provider: the
+// invoice must commit to the metadata, be for the requested amount and stay
+// payable for at least MIN_INVOICE_EXPIRY.
+func validateInvoice(decoded decodepay.Bolt11, amount int64, metadata string) error {
+	metadataHash := sha256.Sum256([]byte(metadata))
+	if decoded.DescriptionHash != hex.EncodeToString(metadataHash[:]) {
+		return fmt.Errorf("Invoice description hash does not match the LNURL metadata")
+	}
+	if decoded.MSatoshi != amount {
+		return fmt.Errorf("Invoice amount of %d msat does not match the requested %d msat", decoded.MSatoshi, amount)
+	}
+	createdAt := time.Unix(int64(decoded.CreatedAt), 0)
+	expiresAt := createdAt.Add(time.Duration(decoded.Expiry) * time.Second)
+	if createdAt.After(time.Now().Add(MIN_INVOICE_EXPIRY)) {
+		return fmt.Errorf("Invoice is created in the future at %s", createdAt.UTC().Format(time.RFC3339))
+	}
+	if time.Until(expiresAt) < MIN_INVOICE_EXPIRY {
+		return fmt.Errorf("Invoice expires too soon at %s", expiresAt.UTC().Format(time.RFC3339))
+	}
+	if len(decoded.PaymentHash) != 2*sha256.Size {
+		return fmt.Errorf("Invoice payment hash is invalid: %s", decoded.PaymentHash)
+	}
+	return nil
+}
+
+// isSecureUrl accepts https urls and http urls of onion services.
+func isSecureUrl(rawUrl string) bool {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	return parsed.Scheme == "https" || (parsed.Scheme == "http" && strings.HasSuffix(parsed.Hostname(), ".onion"))
+}
+
+// get requests Url with the configured timeout, retrying network errors and
+// server errors with exponential backoff.
+func (lnAddressUrlResJson *LnAddressUrlResJson) get(ctx context.Context, Url string) ([]byte, error) {
+	client := lnAddressUrlResJson.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	backoff := lnAddressUrlResJson.options.RetryBackoff
+	if backoff == 0 {
+		backoff = DEFAULT_RETRY_BACKOFF
+	}
+	for retry := 0; ; retry++ {
+		body, retryable, err := doGetRequestWithTimeout(ctx, client, Url, lnAddressUrlResJson.options.Timeout)
+		if err == nil || !retryable || retry >= lnAddressUrlResJson.options.MaxRetries {
+			return body, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// doGetRequestWithTimeout returns whether a failed request can be retried.
+func doGetRequestWithTimeout(ctx context.Context, client *http.Client, Url string, timeout time.Duration) ([]byte, bool, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, Url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if res.StatusCode >= 500 {
+		return nil, true, fmt.Errorf("LNURL request to %s returned %d", Url, res.StatusCode)
+	}
+	return body, false, nil
+}
+
 func DoGetRequest(Url string) ([]byte, error) {
-	res, err := http.Get(Url)
+	return doGetRequest(http.DefaultClient, Url)
+}
+
+func doGetRequest(client *http.Client, Url string) ([]byte, error) {
+	res, err := client.Get(Url)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -98,3 +373,8 @@ func DoGetRequest(Url string) ([]byte, error) {
 
 	return ioutil.ReadAll(res.Body)
 }
+
+// Ping fetches the pay request parameters again.
+func (lnAddressUrlResJson *LnAddressUrlResJson) Ping(ctx context.Context) error {
+	return lnAddressUrlResJson.fetchParams(ctx)
+}
@@ -0,0 +1,44 @@
+package ln
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMetadata = `[["text/plain","Payment to payments@example.com"]]`
+
+func testInvoice() decodepay.Bolt11 {
+	metadataHash := sha256.Sum256([]byte(testMetadata))
+	paymentHash := sha256.Sum256([]byte("preimage"))
+	return decodepay.Bolt11{
+		CreatedAt:       int(time.Now().Unix()),
+		Expiry:          3600,
+		MSatoshi:        10000,
+		DescriptionHash: hex.EncodeToString(metadataHash[:]),
+		PaymentHash:     hex.EncodeToString(paymentHash[:]),
+	}
+}
+
+func TestValidateInvoice(t *testing.T) {
+	assert.NoError(t, validateInvoice(testInvoice(), 10000, testMetadata))
+
+	for name, tamper := range map[string]func(invoice *decodepay.Bolt11){
+		"undercharged":          func(invoice *decodepay.Bolt11) { invoice.MSatoshi = 1000 },
+		"overcharged":           func(invoice *decodepay.Bolt11) { invoice.MSatoshi = 100000 },
+		"other description":     func(invoice *decodepay.Bolt11) { invoice.DescriptionHash = hex.EncodeToString(make([]byte, 32)) },
+		"no description hash":   func(invoice *decodepay.Bolt11) { invoice.DescriptionHash = "" },
+		"expired":               func(invoice *decodepay.Bolt11) { invoice.CreatedAt -= 7200 },
+		"expiring":              func(invoice *decodepay.Bolt11) { invoice.Expiry = 30 },
+		"created in the future": func(invoice *decodepay.Bolt11) { invoice.CreatedAt += 7200 },
+		"short payment hash":    func(invoice *decodepay.Bolt11) { invoice.PaymentHash = "abcd" },
+	} {
+		invoice := testInvoice()
+		tamper(&invoice)
+		assert.Error(t, validateInvoice(invoice, 10000, testMetadata), name)
+	}
+}
@@ -0,0 +1,136 @@
+package ln
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"google.golang.org/grpc"
+)
+
+const (
+	OPENNODE_ADDRESS        = "https://api.opennode.com"
+	OPENNODE_STATUS_PAID    = "paid"
+	OPENNODE_STATUS_EXPIRED = "expired"
+)
+
+type OpenNodeOptions struct {
+	// Address defaults to OPENNODE_ADDRESS, use https://dev-api.opennode.com for testnet
+	Address string
+	// APIKey with invoice permissions
+	APIKey string
+	// Client is used for all requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type OpenNodeWrapper struct {
+	options OpenNodeOptions
+	// chargeIds maps payment hashes to the charges they were issued for
+	chargeIds sync.Map
+}
+
+type openNodeChargeRequest struct {
+	Amount      int64  `json:"amount"`
+	Description string `json:"description"`
+	TTL         int64  `json:"ttl,omitempty"`
+}
+
+type openNodeChargeResponse struct {
+	Data struct {
+		Id               string `json:"id"`
+		Status           string `json:"status"`
+		Amount           int64  `json:"amount"`
+		LightningInvoice struct {
+			Payreq string `json:"payreq"`
+		} `json:"lightning_invoice"`
+	} `json:"data"`
+}
+
+func NewOpenNodeClient(openNodeOptions OpenNodeOptions) (*OpenNodeWrapper, error) {
+	if openNodeOptions.APIKey == "" {
+		return nil, fmt.Errorf("OpenNode API key is missing")
+	}
+	if openNodeOptions.Address == "" {
+		openNodeOptions.Address = OPENNODE_ADDRESS
+	}
+	openNodeOptions.Address = strings.TrimSuffix(openNodeOptions.Address, "/")
+	return &OpenNodeWrapper{
+		options: openNodeOptions,
+	}, nil
+}
+
+func (wrapper *OpenNodeWrapper) call(ctx context.Context, method string, path string, reqBody interface{}, resBody interface{}) error {
+	header := http.Header{}
+	header.Set("Authorization", wrapper.options.APIKey)
+	return doJSONRequest(ctx, wrapper.options.Client, method, wrapper.options.Address+path, header, reqBody, resBody)
+}
+
+// AddInvoice creates a charge, its amount is in sats.
+func (wrapper *OpenNodeWrapper) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	chargeRes := &openNodeChargeResponse{}
+	chargeReq := &openNodeChargeRequest{
+		Amount:      invoiceAmountSat(lnInvoice),
+		Description: lnInvoice.Memo,
+	}
+	if lnInvoice.Expiry > 0 {
+		// OpenNode takes the ttl in minutes
+		chargeReq.TTL = (lnInvoice.Expiry + 59) / 60
+	}
+	if err := wrapper.call(ctx, http.MethodPost, "/v1/charges", chargeReq, chargeRes); err != nil {
+		return nil, err
+	}
+	invoice := chargeRes.Data.LightningInvoice.Payreq
+	decoded, err := decodepay.Decodepay(invoice)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := lntypes.MakeHashFromStr(decoded.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	wrapper.chargeIds.Store(paymentHash, chargeRes.Data.Id)
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          paymentHash[:],
+		PaymentRequest: invoice,
+	}, nil
+}
+
+// LookupInvoice looks up the charge of an invoice issued by this process,
+// OpenNode can't look up charges by payment hash.
+func (wrapper *OpenNodeWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	paymentHash, err := lntypes.MakeHash(req.RHash)
+	if err != nil {
+		return nil, err
+	}
+	chargeId, ok := wrapper.chargeIds.Load(paymentHash)
+	if !ok {
+		return nil, fmt.Errorf("Invoice was not issued by this client: %s", paymentHash)
+	}
+	chargeRes := &openNodeChargeResponse{}
+	if err := wrapper.call(ctx, http.MethodGet, "/v1/charge/"+chargeId.(string), nil, chargeRes); err != nil {
+		return nil, err
+	}
+	invoice := &lnrpc.Invoice{
+		RHash:          req.RHash,
+		PaymentRequest: chargeRes.Data.LightningInvoice.Payreq,
+		Value:          chargeRes.Data.Amount,
+		ValueMsat:      MSAT_PER_SAT * chargeRes.Data.Amount,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	switch chargeRes.Data.Status {
+	case OPENNODE_STATUS_PAID:
+		invoice.State = lnrpc.Invoice_SETTLED
+	case OPENNODE_STATUS_EXPIRED:
+		invoice.State = lnrpc.Invoice_CANCELED
+	}
+	return invoice, nil
+}
+
+func (wrapper *OpenNodeWrapper) Ping(ctx context.Context) error {
+	return wrapper.call(ctx, http.MethodGet, "/v1/account/balance", nil, nil)
+}
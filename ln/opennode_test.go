@@ -0,0 +1,59 @@
+package ln
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func openNodeCharge(status string) map[string]interface{} {
+	return map[string]interface{}{
+		"data": map[string]interface{}{
+			"id":     "chargeid",
+			"status": status,
+			"amount": 10,
+			"lightning_invoice": map[string]interface{}{
+				"payreq": testBolt11,
+			},
+		},
+	}
+}
+
+func TestOpenNodeClient(t *testing.T) {
+	paymentHash, err := lntypes.MakeHashFromStr(testBolt11PaymentHash)
+	assert.NoError(t, err)
+	server := newTestServer(t, map[string]interface{}{
+		"POST /v1/charges":        openNodeCharge("unpaid"),
+		"GET /v1/charge/chargeid": openNodeCharge(OPENNODE_STATUS_PAID),
+	})
+	client, err := NewOpenNodeClient(OpenNodeOptions{
+		Address: server.URL,
+		APIKey:  "apikey",
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// Charges issued by another client can't be looked up
+	_, err = client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.Error(t, err)
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT", Expiry: 600}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, testBolt11, invoiceRes.PaymentRequest)
+	req := server.request(t, "POST /v1/charges")
+	assert.Equal(t, "apikey", req.Header.Get("Authorization"))
+	assert.Equal(t, map[string]interface{}{
+		"amount":      float64(10),
+		"description": "LSAT",
+		"ttl":         float64(10),
+	}, req.JSON(t))
+
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+	assert.Equal(t, int64(10000), invoice.ValueMsat)
+}
@@ -0,0 +1,146 @@
+package ln
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"google.golang.org/grpc"
+)
+
+const (
+	PHOENIXD_SIGNATURE_HEADER       = "X-Phoenix-Signature"
+	PHOENIXD_EVENT_PAYMENT_RECEIVED = "payment_received"
+)
+
+type PhoenixdOptions struct {
+	// Address of phoenixd, e.g. http://localhost:9740
+	Address string
+	// Password is http-password of phoenix.conf
+	Password string
+	// Client is used for all requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type PhoenixdWrapper struct {
+	options PhoenixdOptions
+}
+
+type phoenixdInvoiceResponse struct {
+	PaymentHash string `json:"paymentHash"`
+	Serialized  string `json:"serialized"`
+}
+
+type phoenixdIncomingPaymentResponse struct {
+	Preimage    string `json:"preimage"`
+	Invoice     string `json:"invoice"`
+	IsPaid      bool   `json:"isPaid"`
+	ReceivedSat int64  `json:"receivedSat"`
+	// CompletedAt in milliseconds
+	CompletedAt int64 `json:"completedAt"`
+}
+
+// PhoenixdEvent is posted by phoenixd to its webhook url
+type PhoenixdEvent struct {
+	Type        string `json:"type"`
+	AmountSat   int64  `json:"amountSat"`
+	PaymentHash string `json:"paymentHash"`
+}
+
+func NewPhoenixdClient(phoenixdOptions PhoenixdOptions) (*PhoenixdWrapper, error) {
+	if phoenixdOptions.Address == "" {
+		return nil, fmt.Errorf("phoenixd address is missing")
+	}
+	if phoenixdOptions.Password == "" {
+		return nil, fmt.Errorf("phoenixd password is missing")
+	}
+	phoenixdOptions.Address = strings.TrimSuffix(phoenixdOptions.Address, "/")
+	return &PhoenixdWrapper{
+		options: phoenixdOptions,
+	}, nil
+}
+
+func (wrapper *PhoenixdWrapper) header() http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+wrapper.options.Password)))
+	return header
+}
+
+func (wrapper *PhoenixdWrapper) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	form := url.Values{}
+	form.Set("amountSat", strconv.FormatInt(invoiceAmountSat(lnInvoice), 10))
+	form.Set("description", lnInvoice.Memo)
+	if lnInvoice.Expiry > 0 {
+		form.Set("expirySeconds", strconv.FormatInt(lnInvoice.Expiry, 10))
+	}
+	invoiceRes := &phoenixdInvoiceResponse{}
+	if err := doFormRequest(ctx, wrapper.options.Client, wrapper.options.Address+"/createinvoice", wrapper.header(), form, invoiceRes); err != nil {
+		return nil, err
+	}
+	paymentHash, err := lntypes.MakeHashFromStr(invoiceRes.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          paymentHash[:],
+		PaymentRequest: invoiceRes.Serialized,
+	}, nil
+}
+
+func (wrapper *PhoenixdWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	paymentRes := &phoenixdIncomingPaymentResponse{}
+	err := doJSONRequest(ctx, wrapper.options.Client, http.MethodGet, wrapper.options.Address+"/payments/incoming/"+hex.EncodeToString(req.RHash), wrapper.header(), nil, paymentRes)
+	if err != nil {
+		return nil, err
+	}
+	invoice := &lnrpc.Invoice{
+		RHash:          req.RHash,
+		PaymentRequest: paymentRes.Invoice,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	if paymentRes.IsPaid {
+		invoice.State = lnrpc.Invoice_SETTLED
+		invoice.AmtPaidSat = paymentRes.ReceivedSat
+		invoice.SettleDate = paymentRes.CompletedAt / 1000
+		invoice.RPreimage, _ = hex.DecodeString(paymentRes.Preimage)
+	}
+	return invoice, nil
+}
+
+// ParsePhoenixdEvent reads a webhook event and checks its signature, an
+// HMAC-SHA256 of the body with the webhook-secret of phoenix.conf.
+func ParsePhoenixdEvent(req *http.Request, webhookSecret string) (*PhoenixdEvent, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(req.Header.Get(PHOENIXD_SIGNATURE_HEADER))
+	if err != nil {
+		return nil, fmt.Errorf("phoenixd signature is invalid")
+	}
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("phoenixd signature does not match")
+	}
+	event := &PhoenixdEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (wrapper *PhoenixdWrapper) Ping(ctx context.Context) error {
+	return doJSONRequest(ctx, wrapper.options.Client, http.MethodGet, wrapper.options.Address+"/getinfo", wrapper.header(), nil, nil)
+}
@@ -0,0 +1,85 @@
+package ln
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhoenixdClient(t *testing.T) {
+	paymentHash := testPreimage.Hash()
+	server := newTestServer(t, map[string]interface{}{
+		"POST /createinvoice": map[string]interface{}{
+			"paymentHash": paymentHash.String(),
+			"serialized":  "lnbc100n1phoenixd",
+		},
+		"GET /payments/incoming/" + paymentHash.String(): map[string]interface{}{
+			"preimage":    testPreimage.String(),
+			"invoice":     "lnbc100n1phoenixd",
+			"isPaid":      true,
+			"receivedSat": 10,
+			"completedAt": 1656000000123,
+		},
+	})
+	client, err := NewPhoenixdClient(PhoenixdOptions{
+		Address:  server.URL,
+		Password: "password",
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT", Expiry: 600}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, "lnbc100n1phoenixd", invoiceRes.PaymentRequest)
+	req := server.request(t, "POST /createinvoice")
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte(":password")), req.Header.Get("Authorization"))
+	form, err := url.ParseQuery(string(req.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"amountSat":     {"10"},
+		"description":   {"LSAT"},
+		"expirySeconds": {"600"},
+	}, form)
+
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+	assert.Equal(t, int64(10), invoice.AmtPaidSat)
+	assert.Equal(t, int64(1656000000), invoice.SettleDate)
+	assert.Equal(t, testPreimage[:], invoice.RPreimage)
+}
+
+func TestParsePhoenixdEvent(t *testing.T) {
+	body := `{"type":"payment_received","amountSat":10,"paymentHash":"` + testPreimage.Hash().String() + `"}`
+	signed := func(secret string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/lsat/phoenixd", strings.NewReader(body))
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req.Header.Set(PHOENIXD_SIGNATURE_HEADER, hex.EncodeToString(mac.Sum(nil)))
+		return req
+	}
+
+	event, err := ParsePhoenixdEvent(signed("secret"), "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, &PhoenixdEvent{
+		Type:        PHOENIXD_EVENT_PAYMENT_RECEIVED,
+		AmountSat:   10,
+		PaymentHash: testPreimage.Hash().String(),
+	}, event)
+
+	_, err = ParsePhoenixdEvent(signed("other secret"), "secret")
+	assert.Error(t, err)
+	_, err = ParsePhoenixdEvent(httptest.NewRequest(http.MethodPost, "/lsat/phoenixd", strings.NewReader(body)), "secret")
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package ln
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// doJSONRequest sends reqBody as JSON, nil for no body, and decodes the
+// response into resBody. Responses with a non 2xx status are errors.
+func doJSONRequest(ctx context.Context, client *http.Client, method string, url string, header http.Header, reqBody interface{}, resBody interface{}) error {
+	var body io.Reader
+	if reqBody != nil {
+		payload, err := json.Marshal(reqBody)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doRequest(client, req, resBody)
+}
+
+// doFormRequest posts form and decodes the JSON response into resBody.
+func doFormRequest(ctx context.Context, client *http.Client, url string, header http.Header, form url.Values, resBody interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doRequest(client, req, resBody)
+}
+
+func doRequest(client *http.Client, req *http.Request, resBody interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s %s returned %d: %s", req.Method, req.URL, res.StatusCode, string(resBytes))
+	}
+	if resBody == nil {
+		return nil
+	}
+	return json.Unmarshal(resBytes, resBody)
+}
@@ -0,0 +1,74 @@
+package ln
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPreimage = lntypes.Preimage{1, 2, 3}
+
+// testRequest is a request received by a testServer
+type testRequest struct {
+	Header http.Header
+	Query  string
+	Body   []byte
+}
+
+// JSON decodes the JSON body of the request
+func (req testRequest) JSON(t *testing.T) map[string]interface{} {
+	body := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(req.Body, &body))
+	return body
+}
+
+// testServer fakes the REST API of a backend, responding to "METHOD /path"
+// with the JSON encoded response of the route
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests map[string]testRequest
+}
+
+func newTestServer(t *testing.T, routes map[string]interface{}) *testServer {
+	server := &testServer{
+		requests: map[string]testRequest{},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.Method + " " + r.URL.Path
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		server.mu.Lock()
+		server.requests[route] = testRequest{
+			Header: r.Header.Clone(),
+			Query:  r.URL.RawQuery,
+			Body:   body,
+		}
+		server.mu.Unlock()
+		response, ok := routes[route]
+		if !ok {
+			http.Error(w, "Not found: "+route, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// request returns the last request received on route
+func (server *testServer) request(t *testing.T, route string) testRequest {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	req, ok := server.requests[route]
+	assert.True(t, ok, "No request on %s", route)
+	return req
+}
@@ -0,0 +1,170 @@
+package ln
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"google.golang.org/grpc"
+)
+
+const (
+	// ROUND_ROBIN_FAILURE_THRESHOLD failures in a row take a node out of
+	// the rotation for ROUND_ROBIN_COOL_DOWN
+	ROUND_ROBIN_FAILURE_THRESHOLD = 3
+	ROUND_ROBIN_COOL_DOWN         = 30 * time.Second
+)
+
+// RoundRobinClient issues invoices on its clients in turn, so the invoice
+// throughput isn't limited to a single node. Nodes that keep failing are
+// skipped until they recover. Invoices are looked up, settled and cancelled
+// on the node that issued them.
+type RoundRobinClient struct {
+	Clients  []LNClient
+	breakers []*CircuitBreaker
+	next     uint64
+	// issuers holds the index of the client that issued the invoice by
+	// payment hash
+	issuers sync.Map
+}
+
+func NewRoundRobinClient(clients ...LNClient) *RoundRobinClient {
+	breakers := make([]*CircuitBreaker, len(clients))
+	for i := range clients {
+		breakers[i] = NewCircuitBreaker(ROUND_ROBIN_FAILURE_THRESHOLD, ROUND_ROBIN_COOL_DOWN)
+	}
+	return &RoundRobinClient{
+		Clients:  clients,
+		breakers: breakers,
+	}
+}
+
+// rotation returns the indexes of the clients in the order they are tried,
+// starting with the next in turn and skipping clients out of the rotation
+// unless all are.
+func (roundRobinClient *RoundRobinClient) rotation() []int {
+	n := len(roundRobinClient.Clients)
+	start := int(atomic.AddUint64(&roundRobinClient.next, 1) % uint64(n))
+	healthy, unhealthy := []int{}, []int{}
+	for i := 0; i < n; i++ {
+		index := (start + i) % n
+		if roundRobinClient.breakers[index].IsOpen() {
+			unhealthy = append(unhealthy, index)
+		} else {
+			healthy = append(healthy, index)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// issue calls fn on the clients in rotation until one succeeds and records
+// the issuer of the invoice with the returned payment hash.
+func (roundRobinClient *RoundRobinClient) issue(fn func(lnClient LNClient) (lntypes.Hash, error)) error {
+	errs := []string{}
+	for _, index := range roundRobinClient.rotation() {
+		paymentHash, err := fn(roundRobinClient.Clients[index])
+		if err != nil {
+			roundRobinClient.breakers[index].Failure()
+			errs = append(errs, err.Error())
+			continue
+		}
+		roundRobinClient.breakers[index].Success()
+		roundRobinClient.issuers.Store(paymentHash, index)
+		return nil
+	}
+	return fmt.Errorf("All LN backends failed: %s", strings.Join(errs, "; "))
+}
+
+// issuer returns the client that issued the invoice of paymentHash.
+func (roundRobinClient *RoundRobinClient) issuer(paymentHash []byte) (LNClient, bool) {
+	hash, err := lntypes.MakeHash(paymentHash)
+	if err != nil {
+		return nil, false
+	}
+	index, ok := roundRobinClient.issuers.Load(hash)
+	if !ok {
+		return nil, false
+	}
+	return roundRobinClient.Clients[index.(int)], true
+}
+
+func (roundRobinClient *RoundRobinClient) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	var res *lnrpc.AddInvoiceResponse
+	err := roundRobinClient.issue(func(lnClient LNClient) (lntypes.Hash, error) {
+		lnClientInvoice, err := lnClient.AddInvoice(ctx, lnInvoice, httpReq, options...)
+		if err != nil {
+			return lntypes.Hash{}, err
+		}
+		res = lnClientInvoice
+		return lntypes.MakeHash(lnClientInvoice.RHash)
+	})
+	return res, err
+}
+
+func (roundRobinClient *RoundRobinClient) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	var res *invoicesrpc.AddHoldInvoiceResp
+	err := roundRobinClient.issue(func(lnClient LNClient) (lntypes.Hash, error) {
+		holdInvoiceClient, ok := lnClient.(HoldInvoiceClient)
+		if !ok {
+			return lntypes.Hash{}, fmt.Errorf("LN client does not support hold invoices")
+		}
+		holdInvoice, err := holdInvoiceClient.AddHoldInvoice(ctx, req, options...)
+		if err != nil {
+			return lntypes.Hash{}, err
+		}
+		res = holdInvoice
+		return lntypes.MakeHash(req.Hash)
+	})
+	return res, err
+}
+
+func (roundRobinClient *RoundRobinClient) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	preimage, err := lntypes.MakePreimage(req.Preimage)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash := preimage.Hash()
+	lnClient, ok := roundRobinClient.issuer(paymentHash[:])
+	if !ok {
+		return nil, fmt.Errorf("Issuer of hold invoice %s is unknown", paymentHash)
+	}
+	holdInvoiceClient, ok := lnClient.(HoldInvoiceClient)
+	if !ok {
+		return nil, fmt.Errorf("LN client does not support hold invoices")
+	}
+	return holdInvoiceClient.SettleInvoice(ctx, req, options...)
+}
+
+func (roundRobinClient *RoundRobinClient) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	lnClient, ok := roundRobinClient.issuer(req.PaymentHash)
+	if !ok {
+		return nil, fmt.Errorf("Issuer of hold invoice %x is unknown", req.PaymentHash)
+	}
+	holdInvoiceClient, ok := lnClient.(HoldInvoiceClient)
+	if !ok {
+		return nil, fmt.Errorf("LN client does not support hold invoices")
+	}
+	return holdInvoiceClient.CancelInvoice(ctx, req, options...)
+}
+
+// LookupInvoice asks the issuer of the invoice, or every client when it is
+// unknown, e.g. after a restart.
+func (roundRobinClient *RoundRobinClient) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	lnClients := roundRobinClient.Clients
+	if lnClient, ok := roundRobinClient.issuer(req.RHash); ok {
+		lnClients = []LNClient{lnClient}
+	}
+	return NewFailoverClient(0, lnClients...).LookupInvoice(ctx, req, options...)
+}
+
+// Ping succeeds while any client can issue invoices.
+func (roundRobinClient *RoundRobinClient) Ping(ctx context.Context) error {
+	return NewFailoverClient(0, roundRobinClient.Clients...).Ping(ctx)
+}
@@ -0,0 +1,47 @@
+package ln
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundRobinClient(t *testing.T) {
+	first, second := newFakeLNClient(), newFakeLNClient()
+	client := NewRoundRobinClient(first, second)
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		_, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: int64(i)}, nil)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, first.invoices, 2)
+	assert.Len(t, second.invoices, 2)
+
+	// Lookups go to the issuer, not to the first node that knows the hash
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10}, nil)
+	assert.NoError(t, err)
+	paymentHash, err := lntypes.MakeHash(invoiceRes.RHash)
+	assert.NoError(t, err)
+	assert.Contains(t, second.invoices, paymentHash)
+	first.invoices[paymentHash] = &lnrpc.Invoice{Value: 20}
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: invoiceRes.RHash})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), invoice.Value)
+
+	// A failing node is taken out of the rotation
+	first.err = errors.New("First is down")
+	for i := 0; i < 2*ROUND_ROBIN_FAILURE_THRESHOLD; i++ {
+		_, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 1}, nil)
+		assert.NoError(t, err)
+	}
+	assert.True(t, client.breakers[0].IsOpen())
+	second.err = errors.New("Second is down")
+	_, err = client.AddInvoice(ctx, &lnrpc.Invoice{Value: 1}, nil)
+	assert.EqualError(t, err, "All LN backends failed: Second is down; First is down")
+	assert.Error(t, client.Ping(ctx))
+}
@@ -0,0 +1,106 @@
+package ln
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const TAPD_INVOICE_PATH = "/v1/taproot-assets/channels/invoice"
+
+// TapdOptions configure tapd running integrated in litd, whose REST address
+// serves both the tapd and the LND API. Invoices priced in sats are issued
+// on LND, asset invoices on tapd.
+type TapdOptions struct {
+	// Address is the REST address of litd, e.g. https://localhost:8443
+	Address      string
+	CertFile     string
+	CertHex      string
+	MacaroonFile string
+	// MacaroonHex is a litd macaroon with the tapd and LND invoice
+	// permissions
+	MacaroonHex string
+	// PeerPubkey selects the asset channel peer quoting the asset, optional
+	// with a single asset channel peer
+	PeerPubkey string
+}
+
+// TapdWrapper issues Taproot Asset invoices over asset channels, the payer
+// pays in the asset and the node receives sats at the rate quoted by the
+// channel peer.
+type TapdWrapper struct {
+	*LNDRESTWrapper
+	peerPubkey []byte
+}
+
+type tapdAddInvoiceRequest struct {
+	AssetId        []byte          `json:"asset_id"`
+	AssetAmount    string          `json:"asset_amount"`
+	PeerPubkey     []byte          `json:"peer_pubkey,omitempty"`
+	InvoiceRequest json.RawMessage `json:"invoice_request"`
+}
+
+type tapdAddInvoiceResponse struct {
+	InvoiceResult json.RawMessage `json:"invoice_result"`
+}
+
+func NewTapdClient(tapdOptions TapdOptions) (*TapdWrapper, error) {
+	if tapdOptions.Address == "" {
+		return nil, errors.New("tapd address is missing")
+	}
+	lndRESTWrapper, err := NewLNDRESTClient(LNDoptions{
+		Address:      tapdOptions.Address,
+		CertFile:     tapdOptions.CertFile,
+		CertHex:      tapdOptions.CertHex,
+		MacaroonFile: tapdOptions.MacaroonFile,
+		MacaroonHex:  tapdOptions.MacaroonHex,
+		// litd macaroons aren't LND macaroons
+		AllowAdminMacaroon: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	peerPubkey, err := hex.DecodeString(tapdOptions.PeerPubkey)
+	if err != nil {
+		return nil, err
+	}
+	return &TapdWrapper{
+		LNDRESTWrapper: lndRESTWrapper,
+		peerPubkey:     peerPubkey,
+	}, nil
+}
+
+func (wrapper *TapdWrapper) AddAssetInvoice(ctx context.Context, assetId string, assetAmount uint64, lnInvoice *lnrpc.Invoice) (*lnrpc.AddInvoiceResponse, error) {
+	assetIdBytes, err := hex.DecodeString(assetId)
+	if err != nil {
+		return nil, err
+	}
+	invoiceRequest, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(&lnrpc.Invoice{
+		Memo:    lnInvoice.Memo,
+		Expiry:  lnInvoice.Expiry,
+		Private: lnInvoice.Private,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tapdRes := &tapdAddInvoiceResponse{}
+	header := http.Header{}
+	header.Set("Grpc-Metadata-macaroon", wrapper.macaroonHex)
+	err = doJSONRequest(ctx, wrapper.client, http.MethodPost, wrapper.address+TAPD_INVOICE_PATH, header, &tapdAddInvoiceRequest{
+		AssetId:        assetIdBytes,
+		AssetAmount:    strconv.FormatUint(assetAmount, 10),
+		PeerPubkey:     wrapper.peerPubkey,
+		InvoiceRequest: invoiceRequest,
+	}, tapdRes)
+	if err != nil {
+		return nil, err
+	}
+	res := &lnrpc.AddInvoiceResponse{}
+	return res, protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(tapdRes.InvoiceResult, res)
+}
@@ -0,0 +1,49 @@
+package ln
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTapdClient(t *testing.T) {
+	paymentHash := testPreimage.Hash()
+	server := newTestServer(t, map[string]interface{}{
+		"POST " + TAPD_INVOICE_PATH: map[string]interface{}{
+			"accepted_buy_quote": map[string]interface{}{},
+			"invoice_result": map[string]interface{}{
+				"r_hash":          base64.StdEncoding.EncodeToString(paymentHash[:]),
+				"payment_request": "lnbc100n1tapd",
+			},
+		},
+	})
+	client, err := NewTapdClient(TapdOptions{
+		Address:     server.URL,
+		MacaroonHex: "0201",
+		PeerPubkey:  "02aa",
+	})
+	assert.NoError(t, err)
+
+	invoiceRes, err := client.AddAssetInvoice(context.Background(), "0102", 100, &lnrpc.Invoice{Memo: "LSAT", Expiry: 600})
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, "lnbc100n1tapd", invoiceRes.PaymentRequest)
+	req := server.request(t, "POST "+TAPD_INVOICE_PATH)
+	assert.Equal(t, "0201", req.Header.Get("Grpc-Metadata-macaroon"))
+	// Bytes are base64 encoded like in the tapd REST API
+	assert.Equal(t, map[string]interface{}{
+		"asset_id":     "AQI=",
+		"asset_amount": "100",
+		"peer_pubkey":  "Aqo=",
+		"invoice_request": map[string]interface{}{
+			"memo":   "LSAT",
+			"expiry": "600",
+		},
+	}, req.JSON(t))
+
+	_, err = client.AddAssetInvoice(context.Background(), "asset", 100, &lnrpc.Invoice{})
+	assert.Error(t, err)
+}
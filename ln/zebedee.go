@@ -0,0 +1,145 @@
+package ln
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"google.golang.org/grpc"
+)
+
+const (
+	ZEBEDEE_ADDRESS          = "https://api.zebedee.io"
+	ZEBEDEE_STATUS_COMPLETED = "completed"
+	ZEBEDEE_STATUS_EXPIRED   = "expired"
+)
+
+type ZebedeeOptions struct {
+	// Address defaults to ZEBEDEE_ADDRESS
+	Address string
+	// APIKey of the ZBD project
+	APIKey string
+	// Client is used for all requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type ZebedeeWrapper struct {
+	options ZebedeeOptions
+	// chargeIds maps payment hashes to the charges they were issued for
+	chargeIds sync.Map
+}
+
+type zebedeeChargeRequest struct {
+	Amount      string `json:"amount"`
+	Description string `json:"description"`
+	ExpiresIn   int64  `json:"expiresIn,omitempty"`
+}
+
+type zebedeeChargeResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Data    struct {
+		Id      string `json:"id"`
+		Status  string `json:"status"`
+		Amount  string `json:"amount"`
+		Invoice struct {
+			Request string `json:"request"`
+		} `json:"invoice"`
+	} `json:"data"`
+}
+
+func NewZebedeeClient(zebedeeOptions ZebedeeOptions) (*ZebedeeWrapper, error) {
+	if zebedeeOptions.APIKey == "" {
+		return nil, fmt.Errorf("ZEBEDEE API key is missing")
+	}
+	if zebedeeOptions.Address == "" {
+		zebedeeOptions.Address = ZEBEDEE_ADDRESS
+	}
+	zebedeeOptions.Address = strings.TrimSuffix(zebedeeOptions.Address, "/")
+	return &ZebedeeWrapper{
+		options: zebedeeOptions,
+	}, nil
+}
+
+func (wrapper *ZebedeeWrapper) call(ctx context.Context, method string, path string, reqBody interface{}) (*zebedeeChargeResponse, error) {
+	header := http.Header{}
+	header.Set("apikey", wrapper.options.APIKey)
+	chargeRes := &zebedeeChargeResponse{}
+	err := doJSONRequest(ctx, wrapper.options.Client, method, wrapper.options.Address+path, header, reqBody, chargeRes)
+	if err != nil {
+		return nil, err
+	}
+	if !chargeRes.Success {
+		return nil, fmt.Errorf("ZEBEDEE returned an error: %s", chargeRes.Message)
+	}
+	return chargeRes, nil
+}
+
+func (wrapper *ZebedeeWrapper) AddInvoice(ctx context.Context, lnInvoice *lnrpc.Invoice, httpReq *http.Request, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	chargeRes, err := wrapper.call(ctx, http.MethodPost, "/v0/charges", &zebedeeChargeRequest{
+		Amount:      strconv.FormatInt(InvoiceAmountMsat(lnInvoice), 10),
+		Description: lnInvoice.Memo,
+		ExpiresIn:   lnInvoice.Expiry,
+	})
+	if err != nil {
+		return nil, err
+	}
+	invoice := chargeRes.Data.Invoice.Request
+	decoded, err := decodepay.Decodepay(invoice)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := lntypes.MakeHashFromStr(decoded.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	wrapper.chargeIds.Store(paymentHash, chargeRes.Data.Id)
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          paymentHash[:],
+		PaymentRequest: invoice,
+	}, nil
+}
+
+// LookupInvoice looks up the charge of an invoice issued by this process,
+// ZEBEDEE can't look up charges by payment hash.
+func (wrapper *ZebedeeWrapper) LookupInvoice(ctx context.Context, req *lnrpc.PaymentHash, options ...grpc.CallOption) (*lnrpc.Invoice, error) {
+	paymentHash, err := lntypes.MakeHash(req.RHash)
+	if err != nil {
+		return nil, err
+	}
+	chargeId, ok := wrapper.chargeIds.Load(paymentHash)
+	if !ok {
+		return nil, fmt.Errorf("Invoice was not issued by this client: %s", paymentHash)
+	}
+	chargeRes, err := wrapper.call(ctx, http.MethodGet, "/v0/charges/"+chargeId.(string), nil)
+	if err != nil {
+		return nil, err
+	}
+	amountMsat, _ := strconv.ParseInt(chargeRes.Data.Amount, 10, 64)
+	invoice := &lnrpc.Invoice{
+		RHash:          req.RHash,
+		PaymentRequest: chargeRes.Data.Invoice.Request,
+		ValueMsat:      amountMsat,
+		Value:          amountMsat / MSAT_PER_SAT,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	switch chargeRes.Data.Status {
+	case ZEBEDEE_STATUS_COMPLETED:
+		invoice.State = lnrpc.Invoice_SETTLED
+	case ZEBEDEE_STATUS_EXPIRED:
+		invoice.State = lnrpc.Invoice_CANCELED
+	}
+	return invoice, nil
+}
+
+func (wrapper *ZebedeeWrapper) Ping(ctx context.Context) error {
+	header := http.Header{}
+	header.Set("apikey", wrapper.options.APIKey)
+	return doJSONRequest(ctx, wrapper.options.Client, http.MethodGet, wrapper.options.Address+"/v0/wallet", header, nil, nil)
+}
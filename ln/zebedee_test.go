@@ -0,0 +1,72 @@
+package ln
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func zebedeeCharge(status string) map[string]interface{} {
+	return map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"id":     "chargeid",
+			"status": status,
+			"amount": "10000",
+			"invoice": map[string]interface{}{
+				"request": testBolt11,
+			},
+		},
+	}
+}
+
+func TestZebedeeClient(t *testing.T) {
+	paymentHash, err := lntypes.MakeHashFromStr(testBolt11PaymentHash)
+	assert.NoError(t, err)
+	server := newTestServer(t, map[string]interface{}{
+		"POST /v0/charges":         zebedeeCharge("pending"),
+		"GET /v0/charges/chargeid": zebedeeCharge(ZEBEDEE_STATUS_COMPLETED),
+	})
+	client, err := NewZebedeeClient(ZebedeeOptions{
+		Address: server.URL,
+		APIKey:  "apikey",
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	invoiceRes, err := client.AddInvoice(ctx, &lnrpc.Invoice{Value: 10, Memo: "LSAT", Expiry: 600}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentHash[:], invoiceRes.RHash)
+	assert.Equal(t, testBolt11, invoiceRes.PaymentRequest)
+	req := server.request(t, "POST /v0/charges")
+	assert.Equal(t, "apikey", req.Header.Get("apikey"))
+	assert.Equal(t, map[string]interface{}{
+		"amount":      "10000",
+		"description": "LSAT",
+		"expiresIn":   float64(600),
+	}, req.JSON(t))
+
+	invoice, err := client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHash[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, lnrpc.Invoice_SETTLED, invoice.State)
+	assert.Equal(t, int64(10000), invoice.ValueMsat)
+}
+
+func TestZebedeeClientReportsErrors(t *testing.T) {
+	server := newTestServer(t, map[string]interface{}{
+		"POST /v0/charges": map[string]interface{}{
+			"success": false,
+			"message": "Insufficient permissions",
+		},
+	})
+	client, err := NewZebedeeClient(ZebedeeOptions{
+		Address: server.URL,
+		APIKey:  "apikey",
+	})
+	assert.NoError(t, err)
+	_, err = client.AddInvoice(context.Background(), &lnrpc.Invoice{Value: 10}, nil)
+	assert.EqualError(t, err, "ZEBEDEE returned an error: Insufficient permissions")
+}
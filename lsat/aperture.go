@@ -0,0 +1,56 @@
+package lsat
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"gopkg.in/macaroon.v2"
+)
+
+// Headers Aperture accepts next to Authorization, carrying a hex encoded
+// macaroon with the preimage in a caveat, as sent by gRPC clients
+const (
+	HEADER_MACAROON_MD = "Grpc-Metadata-Macaroon"
+	HEADER_MACAROON    = "Macaroon"
+)
+
+// FromHeader reads the token of a request in any of the header formats
+// Aperture accepts: the Authorization header, or a hex encoded macaroon in
+// the Grpc-Metadata-Macaroon or Macaroon header carrying a
+// preimage=<hex> caveat.
+func FromHeader(header http.Header) (*Token, error) {
+	if authField := header.Get("Authorization"); authField != "" {
+		return ParseToken(authField)
+	}
+	macaroonHex := header.Get(HEADER_MACAROON_MD)
+	if macaroonHex == "" {
+		macaroonHex = header.Get(HEADER_MACAROON)
+	}
+	if macaroonHex == "" {
+		return nil, fmt.Errorf("LSAT Header is not present")
+	}
+	macBytes, err := hex.DecodeString(macaroonHex)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid macaroon string")
+	}
+	mac := &macaroon.Macaroon{}
+	if err := mac.UnmarshalBinary(macBytes); err != nil {
+		return nil, err
+	}
+	preimage := lntypes.Preimage{}
+	for _, macaroonCaveat := range mac.Caveats() {
+		parsed, err := caveat.Parse(string(macaroonCaveat.Id))
+		if err == nil && parsed.Condition == caveat.PREIMAGE {
+			preimage, err = utils.GetPreimageFromString(parsed.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return NewToken(mac, preimage)
+}
@@ -0,0 +1,159 @@
+package lsat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/receipt"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// ChallengeMessage is the message signed by the server to bind the macaroon
+// and invoice of a challenge together.
+func ChallengeMessage(macaroonString string, invoice string) []byte {
+	return []byte(fmt.Sprintf("%s:%s", macaroonString, invoice))
+}
+
+// VerifyChallenge lets clients check a challenge before paying it: the
+// payment hash of the invoice must be the one signed into the macaroon
+// identifier and, when expectedPayee is set, the invoice must pay that node.
+func VerifyChallenge(macaroonString string, invoice string, expectedPayee string) error {
+	mac, err := utils.GetMacaroonFromString(macaroonString)
+	if err != nil {
+		return err
+	}
+	macaroonId, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return err
+	}
+	decoded, err := decodepay.Decodepay(invoice)
+	if err != nil {
+		return err
+	}
+	if decoded.PaymentHash != macaroonId.PaymentHash.String() {
+		return fmt.Errorf("Invoice PaymentHash %s does not match macaroon PaymentHash %s", decoded.PaymentHash, macaroonId.PaymentHash)
+	}
+	if expectedPayee != "" && decoded.Payee != expectedPayee {
+		return fmt.Errorf("Invoice pays %s instead of %s", decoded.Payee, expectedPayee)
+	}
+	return nil
+}
+
+// VerifyChallengeSignature checks the signature of a challenge against the
+// hex encoded public key published by the server.
+func VerifyChallengeSignature(publicKey string, macaroonString string, invoice string, signature string) error {
+	return receipt.VerifyBytes(publicKey, ChallengeMessage(macaroonString, invoice), signature)
+}
+
+// Challenge is an LSAT challenge of the WWW-Authenticate header. Offer,
+// AssetId, AssetAmount, Address and Signature are optional.
+type Challenge struct {
+	Macaroon    string
+	Invoice     string
+	Offer       string
+	AssetId     string
+	AssetAmount uint64
+	Address     string
+	Signature   string
+	// Quote quotes the values as Aperture does, which the LSAT clients of
+	// Lightning Labs require
+	Quote bool
+	// Scheme of the challenge, SCHEME when empty
+	Scheme string
+}
+
+// String returns the WWW-Authenticate header value of the challenge.
+func (challenge *Challenge) String() string {
+	params := []string{
+		challenge.param("macaroon", challenge.Macaroon),
+		challenge.param("invoice", challenge.Invoice),
+	}
+	if challenge.Offer != "" {
+		params = append(params, challenge.param("offer", challenge.Offer))
+	}
+	if challenge.AssetId != "" {
+		params = append(params, challenge.param("asset_id", challenge.AssetId), challenge.param("asset_amount", strconv.FormatUint(challenge.AssetAmount, 10)))
+	}
+	if challenge.Address != "" {
+		params = append(params, challenge.param("address", challenge.Address))
+	}
+	if challenge.Signature != "" {
+		params = append(params, challenge.param("signature", challenge.Signature))
+	}
+	scheme := challenge.Scheme
+	if scheme == "" {
+		scheme = SCHEME
+	}
+	return fmt.Sprintf("%s %s", scheme, strings.Join(params, ", "))
+}
+
+func (challenge *Challenge) param(name string, value string) string {
+	if challenge.Quote {
+		return fmt.Sprintf(`%s="%s"`, name, value)
+	}
+	return fmt.Sprintf("%s=%s", name, value)
+}
+
+// ParseChallenge parses a WWW-Authenticate header value of the LSAT or L402
+// scheme, with quoted or bare parameter values as emitted by Aperture and
+// this middleware.
+func ParseChallenge(wwwAuthenticate string) (*Challenge, error) {
+	splitted := strings.SplitN(strings.TrimSpace(wwwAuthenticate), " ", 2)
+	scheme := ParseScheme(wwwAuthenticate)
+	if len(splitted) != 2 || scheme == "" {
+		return nil, fmt.Errorf("Not an LSAT challenge: %s", wwwAuthenticate)
+	}
+	challenge := &Challenge{
+		Scheme: scheme,
+	}
+	for _, param := range strings.Split(splitted[1], ",") {
+		keyValue := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("LSAT challenge does not have the right format: %s", wwwAuthenticate)
+		}
+		value := strings.TrimSpace(keyValue[1])
+		if strings.HasPrefix(value, `"`) {
+			challenge.Quote = true
+			value = strings.Trim(value, `"`)
+		}
+		switch strings.TrimSpace(keyValue[0]) {
+		case "macaroon":
+			challenge.Macaroon = value
+		case "invoice":
+			challenge.Invoice = value
+		case "offer":
+			challenge.Offer = value
+		case "asset_id":
+			challenge.AssetId = value
+		case "asset_amount":
+			assetAmount, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid asset_amount in LSAT challenge: %s", value)
+			}
+			challenge.AssetAmount = assetAmount
+		case "address":
+			challenge.Address = value
+		case "signature":
+			challenge.Signature = value
+		}
+	}
+	if challenge.Macaroon == "" || challenge.Invoice == "" {
+		return nil, fmt.Errorf("LSAT challenge lacks a macaroon or invoice: %s", wwwAuthenticate)
+	}
+	return challenge, nil
+}
+
+// Token returns the unpaid token of the challenge, set its Preimage once the
+// invoice is paid.
+func (challenge *Challenge) Token() (*Token, error) {
+	mac, err := utils.GetMacaroonFromString(challenge.Macaroon)
+	if err != nil {
+		return nil, err
+	}
+	return NewToken(mac, lntypes.Preimage{})
+}
@@ -1,8 +1,6 @@
 package lsat
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 
 	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
@@ -12,17 +10,77 @@ import (
 )
 
 func VerifyLSAT(mac *macaroon.Macaroon, rootKey []byte, preimage lntypes.Preimage) error {
-	_, err := mac.VerifySignature(rootKey, nil)
+	return VerifyLSATWithCaveats(mac, rootKey, preimage, nil)
+}
+
+// VerifyLSATWithCaveats verifies the LSAT and calls check for every
+// first-party caveat of the macaroon.
+func VerifyLSATWithCaveats(mac *macaroon.Macaroon, rootKey []byte, preimage lntypes.Preimage, check func(caveat string) error) error {
+	return VerifyLSATWithDischarges(mac, rootKey, nil, preimage, check)
+}
+
+// VerifyLSATWithDischarges verifies an LSAT whose third-party caveats are
+// discharged by discharges, bound to mac.
+func VerifyLSATWithDischarges(mac *macaroon.Macaroon, rootKey []byte, discharges []*macaroon.Macaroon, preimage lntypes.Preimage, check func(caveat string) error) error {
+	macaroonId, err := VerifyMacaroonWithDischarges(mac, rootKey, discharges, check)
 	if err != nil {
 		return err
 	}
-	dec := gob.NewDecoder(bytes.NewBuffer(mac.Id()))
-	macaroonId := &macaroonutils.MacaroonIdentifier{}
-	if err = dec.Decode(macaroonId); err != nil {
-		return err
-	}
 	if macaroonId.PaymentHash != preimage.Hash() {
 		return fmt.Errorf("Invalid Preimage %s for PaymentHash %s", preimage, macaroonId.PaymentHash)
 	}
 	return nil
 }
+
+// VerifyMacaroon checks the signature and caveats of the macaroon and returns
+// its identifier, without requiring a proof of payment. Macaroons carrying
+// caveats are rejected when check is nil.
+func VerifyMacaroon(mac *macaroon.Macaroon, rootKey []byte, check func(caveat string) error) (*macaroonutils.MacaroonIdentifier, error) {
+	return VerifyMacaroonWithDischarges(mac, rootKey, nil, check)
+}
+
+// VerifyMacaroonWithDischarges is VerifyMacaroon for macaroons with
+// third-party caveats. The first-party caveats of the discharges are passed
+// to check as well.
+func VerifyMacaroonWithDischarges(mac *macaroon.Macaroon, rootKey []byte, discharges []*macaroon.Macaroon, check func(caveat string) error) (*macaroonutils.MacaroonIdentifier, error) {
+	macaroonId, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return nil, err
+	}
+	caveats, err := mac.VerifySignature(macaroonutils.DeriveRootKey(rootKey, macaroonId.TokenId), discharges)
+	if err != nil {
+		// Macaroons minted before per-token keys are signed with the root key
+		var legacyErr error
+		caveats, legacyErr = mac.VerifySignature(rootKey, discharges)
+		if legacyErr != nil {
+			return nil, err
+		}
+	}
+	for _, caveat := range caveats {
+		if check == nil {
+			return nil, fmt.Errorf("Caveat can not be verified: %s", caveat)
+		}
+		// Encrypted values are checked in plain text
+		caveat, err = macaroonutils.DecryptCaveat(rootKey, macaroonId.TokenId, caveat)
+		if err != nil {
+			return nil, err
+		}
+		if err := check(caveat); err != nil {
+			return nil, err
+		}
+	}
+	return macaroonId, nil
+}
+
+// VerifyIdentity checks the signature of a free identity macaroon and
+// returns its identifier, no payment proof is required.
+func VerifyIdentity(mac *macaroon.Macaroon, rootKey []byte) (*macaroonutils.MacaroonIdentifier, error) {
+	macaroonId, err := VerifyMacaroon(mac, rootKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	if macaroonId.PaymentHash != lntypes.ZeroHash {
+		return nil, fmt.Errorf("Macaroon is not an identity token")
+	}
+	return macaroonId, nil
+}
@@ -0,0 +1,160 @@
+package lsat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyLSATChecksPreimageAgainstPaymentHash(t *testing.T) {
+	rootKey := []byte("root_key")
+	tokenId, err := macaroonutils.GenerateTokenId()
+	assert.NoError(t, err)
+	preimage := lntypes.Preimage(tokenId)
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, "", preimage.Hash(), tokenId)
+	assert.NoError(t, err)
+	mac, err := utils.GetMacaroonFromString(macaroonString)
+	assert.NoError(t, err)
+
+	assert.NoError(t, VerifyLSAT(mac, rootKey, preimage))
+
+	otherTokenId, err := macaroonutils.GenerateTokenId()
+	assert.NoError(t, err)
+	assert.Error(t, VerifyLSAT(mac, rootKey, lntypes.Preimage(otherTokenId)), "a preimage of another payment hash is rejected")
+	assert.Error(t, VerifyLSAT(mac, rootKey, lntypes.Preimage{}), "an empty preimage is rejected")
+	assert.Error(t, VerifyLSAT(mac, []byte("other_root_key"), preimage), "a macaroon of another root key is rejected")
+}
+
+func TestTokenHeaderRoundTrip(t *testing.T) {
+	rootKey := []byte("root_key")
+	tokenId, err := macaroonutils.GenerateTokenId()
+	assert.NoError(t, err)
+	preimage := lntypes.Preimage(tokenId)
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, "", preimage.Hash(), tokenId)
+	assert.NoError(t, err)
+
+	lsatChallenge, err := ParseChallenge(fmt.Sprintf(`LSAT macaroon="%s", invoice="lnbc1"`, macaroonString))
+	assert.NoError(t, err)
+	assert.Equal(t, macaroonString, lsatChallenge.Macaroon)
+	assert.Equal(t, "lnbc1", lsatChallenge.Invoice)
+	parsedChallenge, err := ParseChallenge(lsatChallenge.String())
+	assert.NoError(t, err)
+	assert.Equal(t, lsatChallenge, parsedChallenge)
+
+	token, err := lsatChallenge.Token()
+	assert.NoError(t, err)
+	assert.False(t, token.IsPaid())
+	assert.Equal(t, preimage.Hash(), token.PaymentHash())
+	header, err := token.MarshalHeader()
+	assert.NoError(t, err)
+	assert.Equal(t, "LSAT "+macaroonString, header)
+
+	token.Preimage = preimage
+	assert.True(t, token.IsPaid())
+	header, err = token.MarshalHeader()
+	assert.NoError(t, err)
+	parsed, err := ParseToken(header)
+	assert.NoError(t, err)
+	assert.Equal(t, preimage, parsed.Preimage)
+	assert.Equal(t, tokenId, parsed.Identifier.TokenId)
+	assert.NoError(t, parsed.Verify(rootKey, nil))
+}
+
+func TestL402Scheme(t *testing.T) {
+	rootKey := []byte("root_key")
+	tokenId, err := macaroonutils.GenerateTokenId()
+	assert.NoError(t, err)
+	preimage := lntypes.Preimage(tokenId)
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, "", preimage.Hash(), tokenId)
+	assert.NoError(t, err)
+
+	lsatChallenge, err := ParseChallenge(fmt.Sprintf("L402 macaroon=%s, invoice=lnbc1", macaroonString))
+	assert.NoError(t, err)
+	assert.Equal(t, SCHEME_L402, lsatChallenge.Scheme)
+
+	for _, scheme := range []string{SCHEME_LSAT, SCHEME_L402} {
+		token, err := ParseToken(fmt.Sprintf("%s %s:%s", scheme, macaroonString, preimage))
+		assert.NoError(t, err)
+		assert.Equal(t, scheme, token.Scheme)
+		assert.NoError(t, token.Verify(rootKey, nil))
+		header, err := token.MarshalHeader()
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%s %s:%s", scheme, macaroonString, preimage), header)
+	}
+}
+
+func TestParseTokenAcceptsBase64URL(t *testing.T) {
+	rootKey := []byte("root_key")
+	tokenId, err := macaroonutils.GenerateTokenId()
+	assert.NoError(t, err)
+	preimage := lntypes.Preimage(tokenId)
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, "", preimage.Hash(), tokenId, "path=/articles/*")
+	assert.NoError(t, err)
+	urlMacaroonString, err := utils.EncodeBase64URL(macaroonString)
+	assert.NoError(t, err)
+	assert.NotContains(t, urlMacaroonString, "=")
+
+	for _, encoded := range []string{macaroonString, strings.TrimRight(macaroonString, "="), urlMacaroonString} {
+		token, err := ParseToken(fmt.Sprintf("LSAT %s:%s", encoded, preimage))
+		assert.NoError(t, err, encoded)
+		assert.Equal(t, tokenId, token.Identifier.TokenId)
+	}
+}
+
+func TestAttenuatedTokenVerifiesNarrowerCaveats(t *testing.T) {
+	rootKey := []byte("root_key")
+	tokenId, err := macaroonutils.GenerateTokenId()
+	assert.NoError(t, err)
+	preimage := lntypes.Preimage(tokenId)
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString(rootKey, "", preimage.Hash(), tokenId, "path=/api/*")
+	assert.NoError(t, err)
+	token, err := ParseToken(fmt.Sprintf("LSAT %s:%s", macaroonString, preimage))
+	assert.NoError(t, err)
+
+	attenuated, err := token.Attenuate(caveat.NewBuilder().Path("/api/reports/*").Build()...)
+	assert.NoError(t, err)
+	assert.Equal(t, preimage, attenuated.Preimage)
+	assert.Len(t, token.Macaroon.Caveats(), 1, "the token itself is not modified")
+
+	checkPath := func(requestPath string) func(caveatString string) error {
+		req, _ := http.NewRequest(http.MethodGet, requestPath, nil)
+		return caveat.Check(req, nil)
+	}
+	assert.NoError(t, attenuated.Verify(rootKey, checkPath("/api/reports/1")))
+	assert.Error(t, attenuated.Verify(rootKey, checkPath("/api/users/1")), "the attenuated token is narrower")
+	assert.NoError(t, token.Verify(rootKey, checkPath("/api/users/1")))
+}
+
+func TestLsatHeaderVectorsVerify(t *testing.T) {
+	content, err := ioutil.ReadFile("../utils/testdata/lsat_header_vectors.json")
+	assert.NoError(t, err)
+	vectors := struct {
+		RootKey string `json:"root_key"`
+		Vectors []struct {
+			Name   string `json:"name"`
+			Header string `json:"header"`
+			Valid  bool   `json:"valid"`
+		} `json:"vectors"`
+	}{}
+	assert.NoError(t, json.Unmarshal(content, &vectors))
+	acceptAll := func(caveat string) error { return nil }
+	for _, vector := range vectors.Vectors {
+		if !vector.Valid {
+			continue
+		}
+		mac, preimage, err := utils.ParseLsatHeader(vector.Header)
+		assert.NoError(t, err, vector.Name)
+		assert.NoError(t, VerifyLSATWithCaveats(mac, []byte(vectors.RootKey), preimage, acceptAll), vector.Name)
+		assert.Error(t, VerifyLSATWithCaveats(mac, []byte("other_root_key"), preimage, acceptAll), vector.Name)
+	}
+}
@@ -0,0 +1,155 @@
+package lsat
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"gopkg.in/macaroon.v2"
+)
+
+// Authentication schemes of the Authorization and WWW-Authenticate headers,
+// the protocol was renamed to L402 and both are accepted
+const (
+	SCHEME_LSAT = "LSAT"
+	SCHEME_L402 = "L402"
+	// SCHEME is the scheme emitted by default
+	SCHEME = SCHEME_LSAT
+)
+
+// ParseScheme returns the scheme header starts with, "" for another scheme.
+func ParseScheme(header string) string {
+	splitted := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	for _, scheme := range []string{SCHEME_LSAT, SCHEME_L402} {
+		if strings.EqualFold(splitted[0], scheme) {
+			return scheme
+		}
+	}
+	return ""
+}
+
+// Token is an LSAT as presented in the Authorization header: a macaroon and
+// the preimage of the payment hash in its identifier. The preimage is zero
+// for tokens not paid yet, e.g. the token of a challenge.
+type Token struct {
+	Macaroon   *macaroon.Macaroon
+	Preimage   lntypes.Preimage
+	Identifier *macaroonutils.MacaroonIdentifier
+	// Scheme of the Authorization header, SCHEME when empty
+	Scheme string
+}
+
+// NewToken decodes the identifier of mac, preimage may be zero.
+func NewToken(mac *macaroon.Macaroon, preimage lntypes.Preimage) (*Token, error) {
+	identifier, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return nil, err
+	}
+	return &Token{
+		Macaroon:   mac,
+		Preimage:   preimage,
+		Identifier: identifier,
+	}, nil
+}
+
+// ParseToken parses an Authorization header value, "LSAT <macaroon>:<preimage>"
+// or "LSAT <macaroon>" for a token not paid yet, or the same with L402.
+func ParseToken(header string) (*Token, error) {
+	token := &Token{}
+	return token, token.UnmarshalHeader(header)
+}
+
+func (token *Token) UnmarshalHeader(header string) error {
+	var (
+		mac      *macaroon.Macaroon
+		preimage lntypes.Preimage
+		err      error
+	)
+	if strings.Contains(strings.TrimSuffix(strings.TrimSpace(header), ":"), ":") {
+		mac, preimage, err = utils.ParseLsatHeader(header)
+	} else {
+		mac, err = utils.ParseLsatMacaroonHeader(header)
+	}
+	if err != nil {
+		return err
+	}
+	parsed, err := NewToken(mac, preimage)
+	if err != nil {
+		return err
+	}
+	parsed.Scheme = ParseScheme(header)
+	*token = *parsed
+	return nil
+}
+
+// MarshalHeader returns the Authorization header value of the token, the
+// macaroon alone when it is not paid yet.
+func (token *Token) MarshalHeader() (string, error) {
+	macaroonString, err := token.MacaroonString()
+	if err != nil {
+		return "", err
+	}
+	scheme := token.Scheme
+	if scheme == "" {
+		scheme = SCHEME
+	}
+	if token.Preimage == (lntypes.Preimage{}) {
+		return fmt.Sprintf("%s %s", scheme, macaroonString), nil
+	}
+	return fmt.Sprintf("%s %s:%s", scheme, macaroonString, token.Preimage), nil
+}
+
+// MacaroonString returns the base64 encoded macaroon.
+func (token *Token) MacaroonString() (string, error) {
+	macBytes, err := token.Macaroon.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(macBytes), nil
+}
+
+func (token *Token) PaymentHash() lntypes.Hash {
+	return token.Identifier.PaymentHash
+}
+
+// IsPaid returns true if the token carries the preimage of its payment
+// hash.
+func (token *Token) IsPaid() bool {
+	return token.Preimage != lntypes.Preimage{} && token.Preimage.Hash() == token.PaymentHash()
+}
+
+// Verify verifies the token against rootKey, see VerifyLSATWithCaveats.
+func (token *Token) Verify(rootKey []byte, check func(caveat string) error) error {
+	return VerifyLSATWithCaveats(token.Macaroon, rootKey, token.Preimage, check)
+}
+
+// Attenuate returns a copy of the token narrowed down by caveats, e.g. a
+// shorter expiry or fewer paths, for the holder to hand to its own
+// sub-systems. The copy shares the preimage and is verified like the token,
+// every caveat of the chain has to hold. Caveats can only be added, the
+// token can't be widened again.
+func (token *Token) Attenuate(caveats ...caveat.Caveat) (*Token, error) {
+	caveatStrings := []string{}
+	for _, attenuation := range caveats {
+		caveatStrings = append(caveatStrings, attenuation.String())
+	}
+	macaroonString, err := macaroonutils.Attenuate(token.Macaroon, caveatStrings...)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := utils.GetMacaroonFromString(macaroonString)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{
+		Macaroon:   mac,
+		Preimage:   token.Preimage,
+		Identifier: token.Identifier,
+		Scheme:     token.Scheme,
+	}, nil
+}
@@ -0,0 +1,97 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Minimal CBOR (RFC 8949) support for the identifier: maps with text keys and
+// unsigned integer or byte string values.
+const (
+	cborMajorUint  = 0
+	cborMajorBytes = 2
+	cborMajorText  = 3
+	cborMajorMap   = 5
+)
+
+func cborWriteHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= 0xff:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(major<<5 | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= 0xffffffff:
+		buf.WriteByte(major<<5 | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func cborWriteText(buf *bytes.Buffer, text string) {
+	cborWriteHead(buf, cborMajorText, uint64(len(text)))
+	buf.WriteString(text)
+}
+
+func cborWriteBytes(buf *bytes.Buffer, value []byte) {
+	cborWriteHead(buf, cborMajorBytes, uint64(len(value)))
+	buf.Write(value)
+}
+
+func cborReadHead(r *bytes.Reader) (byte, uint64, error) {
+	initial, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	major := initial >> 5
+	info := initial & 0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		n, err := r.ReadByte()
+		return major, uint64(n), err
+	case info == 25:
+		var n uint16
+		err := binary.Read(r, binary.BigEndian, &n)
+		return major, uint64(n), err
+	case info == 26:
+		var n uint32
+		err := binary.Read(r, binary.BigEndian, &n)
+		return major, uint64(n), err
+	case info == 27:
+		var n uint64
+		err := binary.Read(r, binary.BigEndian, &n)
+		return major, n, err
+	}
+	return 0, 0, fmt.Errorf("CBOR additional info %d not supported", info)
+}
+
+// cborReadValue reads an unsigned integer, byte string or text string
+func cborReadValue(r *bytes.Reader) (byte, uint64, []byte, error) {
+	major, n, err := cborReadHead(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	switch major {
+	case cborMajorUint:
+		return major, n, nil, nil
+	case cborMajorBytes, cborMajorText:
+		if n > uint64(r.Len()) {
+			return 0, 0, nil, io.ErrUnexpectedEOF
+		}
+		value := make([]byte, n)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return 0, 0, nil, err
+		}
+		return major, n, value, nil
+	}
+	return 0, 0, nil, fmt.Errorf("CBOR major type %d not supported", major)
+}
@@ -0,0 +1,97 @@
+package macaroon
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ENCRYPTED_CAVEAT_PREFIX marks caveat values encrypted with
+	// EncryptCaveat, e.g. user=enc:<base64url nonce and ciphertext>
+	ENCRYPTED_CAVEAT_PREFIX = "enc:"
+	// CAVEAT_KEY_LABEL separates the caveat encryption keys from other HMACs
+	// of the token id
+	CAVEAT_KEY_LABEL = "caveat-key:"
+)
+
+// CaveatKey returns the AES-256 key the caveat values of the macaroon of
+// tokenId are encrypted with, derived from rootKey like DeriveRootKey.
+func CaveatKey(rootKey []byte, tokenId [32]byte) []byte {
+	mac := hmac.New(sha256.New, rootKey)
+	mac.Write([]byte(CAVEAT_KEY_LABEL))
+	mac.Write(tokenId[:])
+	return mac.Sum(nil)
+}
+
+// IsEncryptedCaveat returns true if the value of caveatString is encrypted.
+func IsEncryptedCaveat(caveatString string) bool {
+	splitted := strings.SplitN(caveatString, "=", 2)
+	return len(splitted) == 2 && strings.HasPrefix(strings.TrimSpace(splitted[1]), ENCRYPTED_CAVEAT_PREFIX)
+}
+
+// EncryptCaveat encrypts the value of the condition=value caveatString so
+// the holder of the token can't read it, e.g. an internal user id or plan.
+// The condition stays readable and the value is bound to it and the token,
+// so it can't be moved to another caveat or token.
+func EncryptCaveat(rootKey []byte, tokenId [32]byte, caveatString string) (string, error) {
+	splitted := strings.SplitN(caveatString, "=", 2)
+	if len(splitted) != 2 {
+		return "", fmt.Errorf("Caveat does not have the right format: %s", caveatString)
+	}
+	condition, value := strings.TrimSpace(splitted[0]), strings.TrimSpace(splitted[1])
+	aead, err := caveatCipher(rootKey, tokenId)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), caveatAdditionalData(condition, tokenId))
+	return fmt.Sprintf("%s=%s%s", condition, ENCRYPTED_CAVEAT_PREFIX, base64.RawURLEncoding.EncodeToString(sealed)), nil
+}
+
+// DecryptCaveat returns caveatString with its value decrypted, caveats that
+// are not encrypted are returned as is.
+func DecryptCaveat(rootKey []byte, tokenId [32]byte, caveatString string) (string, error) {
+	if !IsEncryptedCaveat(caveatString) {
+		return caveatString, nil
+	}
+	splitted := strings.SplitN(caveatString, "=", 2)
+	condition := strings.TrimSpace(splitted[0])
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(splitted[1]), ENCRYPTED_CAVEAT_PREFIX))
+	if err != nil {
+		return "", fmt.Errorf("Invalid encrypted %s caveat", condition)
+	}
+	aead, err := caveatCipher(rootKey, tokenId)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("Invalid encrypted %s caveat", condition)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, caveatAdditionalData(condition, tokenId))
+	if err != nil {
+		return "", fmt.Errorf("Encrypted %s caveat can not be decrypted", condition)
+	}
+	return fmt.Sprintf("%s=%s", condition, value), nil
+}
+
+func caveatCipher(rootKey []byte, tokenId [32]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(CaveatKey(rootKey, tokenId))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func caveatAdditionalData(condition string, tokenId [32]byte) []byte {
+	return append([]byte(condition+"="), tokenId[:]...)
+}
@@ -0,0 +1,34 @@
+package macaroon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptCaveatRoundTrip(t *testing.T) {
+	rootKey := []byte("root_key")
+	id := testIdentifier(t)
+	encrypted, err := EncryptCaveat(rootKey, id.TokenId, "user=internal-42")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "user="+ENCRYPTED_CAVEAT_PREFIX))
+	assert.NotContains(t, encrypted, "internal-42")
+	assert.True(t, IsEncryptedCaveat(encrypted))
+
+	decrypted, err := DecryptCaveat(rootKey, id.TokenId, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "user=internal-42", decrypted)
+
+	plain, err := DecryptCaveat(rootKey, id.TokenId, "path=/api/*")
+	assert.NoError(t, err)
+	assert.Equal(t, "path=/api/*", plain, "plain caveats are returned as is")
+
+	_, err = DecryptCaveat([]byte("other_root_key"), id.TokenId, encrypted)
+	assert.Error(t, err, "another root key can't decrypt")
+	otherId := testIdentifier(t)
+	_, err = DecryptCaveat(rootKey, otherId.TokenId, encrypted)
+	assert.Error(t, err, "the value is bound to its token")
+	_, err = DecryptCaveat(rootKey, id.TokenId, strings.Replace(encrypted, "user=", "tier=", 1))
+	assert.Error(t, err, "the value is bound to its condition")
+}
@@ -0,0 +1,189 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/kiwiidb/gin-lsat/utils"
+)
+
+const (
+	IDENTIFIER_ENCODING_STANDARD = "standard"
+	IDENTIFIER_ENCODING_CBOR     = "cbor"
+	// IDENTIFIER_ENCODING_GOB is no longer minted, gob identifiers of
+	// tokens issued before are still accepted
+	IDENTIFIER_ENCODING_GOB = "gob"
+)
+
+// Standard identifiers, as minted by aperture and read by lsat-js, are the
+// big endian version followed by the payment hash and token id.
+const (
+	STANDARD_IDENTIFIER_VERSION uint16 = 0
+	STANDARD_IDENTIFIER_LENGTH         = 2 + 32 + 32
+)
+
+// CBOR identifiers start with this version byte. Gob identifiers have no
+// version byte, but a gob stream never starts with 0x01, nor with the 0x00
+// of a standard identifier.
+const CBOR_IDENTIFIER_VERSION byte = 0x01
+
+// IdentifierEncoding returns the encoding of identifier by its first byte.
+func IdentifierEncoding(identifier []byte) string {
+	if len(identifier) > 0 && identifier[0] == CBOR_IDENTIFIER_VERSION {
+		return IDENTIFIER_ENCODING_CBOR
+	}
+	if len(identifier) > 0 && identifier[0] == 0 {
+		return IDENTIFIER_ENCODING_STANDARD
+	}
+	return IDENTIFIER_ENCODING_GOB
+}
+
+func DecodeMacaroonIdentifier(identifier []byte) (*MacaroonIdentifier, error) {
+	switch IdentifierEncoding(identifier) {
+	case IDENTIFIER_ENCODING_CBOR:
+		return decodeCBORIdentifier(identifier[1:])
+	case IDENTIFIER_ENCODING_STANDARD:
+		return decodeStandardIdentifier(identifier)
+	}
+	return decodeGobIdentifier(identifier)
+}
+
+// EncodeMacaroonIdentifier encodes id with the encoding configured through
+// IDENTIFIER_ENCODING, the standard layout by default. The standard layout
+// has no room for a key id, identifiers with a key id are CBOR encoded.
+func EncodeMacaroonIdentifier(id *MacaroonIdentifier) ([]byte, error) {
+	switch encoding := utils.GetIdentifierEncoding(); encoding {
+	case "", IDENTIFIER_ENCODING_STANDARD:
+		if id.KeyId != "" {
+			return encodeCBORIdentifier(id), nil
+		}
+		return encodeStandardIdentifier(id)
+	case IDENTIFIER_ENCODING_CBOR:
+		return encodeCBORIdentifier(id), nil
+	case IDENTIFIER_ENCODING_GOB:
+		return nil, fmt.Errorf("Gob identifiers are no longer minted, use %s or %s", IDENTIFIER_ENCODING_STANDARD, IDENTIFIER_ENCODING_CBOR)
+	default:
+		return nil, fmt.Errorf("Identifier encoding not recognized: %s", encoding)
+	}
+}
+
+func encodeStandardIdentifier(id *MacaroonIdentifier) ([]byte, error) {
+	if id.Version != STANDARD_IDENTIFIER_VERSION {
+		return nil, fmt.Errorf("Standard identifiers only support version %d", STANDARD_IDENTIFIER_VERSION)
+	}
+	identifier := make([]byte, STANDARD_IDENTIFIER_LENGTH)
+	binary.BigEndian.PutUint16(identifier[:2], id.Version)
+	copy(identifier[2:34], id.PaymentHash[:])
+	copy(identifier[34:], id.TokenId[:])
+	return identifier, nil
+}
+
+func decodeStandardIdentifier(identifier []byte) (*MacaroonIdentifier, error) {
+	if len(identifier) != STANDARD_IDENTIFIER_LENGTH {
+		return nil, fmt.Errorf("Invalid identifier length: %d", len(identifier))
+	}
+	macaroonId := &MacaroonIdentifier{
+		Version: binary.BigEndian.Uint16(identifier[:2]),
+	}
+	if macaroonId.Version != STANDARD_IDENTIFIER_VERSION {
+		return nil, fmt.Errorf("Unknown identifier version: %d", macaroonId.Version)
+	}
+	copy(macaroonId.PaymentHash[:], identifier[2:34])
+	copy(macaroonId.TokenId[:], identifier[34:])
+	return macaroonId, nil
+}
+
+func encodeGobIdentifier(id *MacaroonIdentifier) ([]byte, error) {
+	var identifier bytes.Buffer
+	enc := gob.NewEncoder(&identifier)
+	if err := enc.Encode(id); err != nil {
+		return nil, err
+	}
+	return identifier.Bytes(), nil
+}
+
+func decodeGobIdentifier(identifier []byte) (*MacaroonIdentifier, error) {
+	dec := gob.NewDecoder(bytes.NewBuffer(identifier))
+	macaroonId := &MacaroonIdentifier{}
+	if err := dec.Decode(macaroonId); err != nil {
+		return nil, err
+	}
+	return macaroonId, nil
+}
+
+// encodeCBORIdentifier encodes id as the version byte followed by the CBOR map
+// {"v": version, "h": payment hash, "t": token id, "k": key id}, the key id is
+// left out when it is empty.
+func encodeCBORIdentifier(id *MacaroonIdentifier) []byte {
+	var identifier bytes.Buffer
+	identifier.WriteByte(CBOR_IDENTIFIER_VERSION)
+	entries := uint64(3)
+	if id.KeyId != "" {
+		entries++
+	}
+	cborWriteHead(&identifier, cborMajorMap, entries)
+	cborWriteText(&identifier, "v")
+	cborWriteHead(&identifier, cborMajorUint, uint64(id.Version))
+	cborWriteText(&identifier, "h")
+	cborWriteBytes(&identifier, id.PaymentHash[:])
+	cborWriteText(&identifier, "t")
+	cborWriteBytes(&identifier, id.TokenId[:])
+	if id.KeyId != "" {
+		cborWriteText(&identifier, "k")
+		cborWriteText(&identifier, id.KeyId)
+	}
+	return identifier.Bytes()
+}
+
+func decodeCBORIdentifier(identifier []byte) (*MacaroonIdentifier, error) {
+	r := bytes.NewReader(identifier)
+	major, entries, err := cborReadHead(r)
+	if err != nil {
+		return nil, err
+	}
+	if major != cborMajorMap {
+		return nil, fmt.Errorf("CBOR identifier is not a map")
+	}
+	macaroonId := &MacaroonIdentifier{}
+	for i := uint64(0); i < entries; i++ {
+		keyMajor, _, key, err := cborReadValue(r)
+		if err != nil {
+			return nil, err
+		}
+		if keyMajor != cborMajorText {
+			return nil, fmt.Errorf("CBOR identifier key is not a text string")
+		}
+		valueMajor, n, value, err := cborReadValue(r)
+		if err != nil {
+			return nil, err
+		}
+		switch string(key) {
+		case "v":
+			if valueMajor != cborMajorUint || n > 0xffff {
+				return nil, fmt.Errorf("Invalid CBOR identifier version")
+			}
+			macaroonId.Version = uint16(n)
+		case "h":
+			if valueMajor != cborMajorBytes || len(value) != len(macaroonId.PaymentHash) {
+				return nil, fmt.Errorf("Invalid CBOR identifier payment hash")
+			}
+			copy(macaroonId.PaymentHash[:], value)
+		case "t":
+			if valueMajor != cborMajorBytes || len(value) != len(macaroonId.TokenId) {
+				return nil, fmt.Errorf("Invalid CBOR identifier token id")
+			}
+			copy(macaroonId.TokenId[:], value)
+		case "k":
+			if valueMajor != cborMajorText {
+				return nil, fmt.Errorf("Invalid CBOR identifier key id")
+			}
+			macaroonId.KeyId = string(value)
+		}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("CBOR identifier has trailing bytes")
+	}
+	return macaroonId, nil
+}
@@ -0,0 +1,112 @@
+package macaroon
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func testIdentifier(t *testing.T) *MacaroonIdentifier {
+	tokenId, err := GenerateTokenId()
+	assert.NoError(t, err)
+	preimage := lntypes.Preimage(tokenId)
+	return &MacaroonIdentifier{
+		Version:     0,
+		PaymentHash: preimage.Hash(),
+		TokenId:     tokenId,
+	}
+}
+
+func TestIdentifierRoundTrip(t *testing.T) {
+	for _, encoding := range []string{"", IDENTIFIER_ENCODING_STANDARD, IDENTIFIER_ENCODING_CBOR} {
+		t.Setenv("IDENTIFIER_ENCODING", encoding)
+		id := testIdentifier(t)
+
+		identifier, err := EncodeMacaroonIdentifier(id)
+		assert.NoError(t, err)
+
+		decoded, err := DecodeMacaroonIdentifier(identifier)
+		assert.NoError(t, err)
+		assert.Equal(t, id, decoded, encoding)
+	}
+}
+
+func TestCBORIdentifierIsSmallerThanGob(t *testing.T) {
+	id := testIdentifier(t)
+	gobIdentifier, err := encodeGobIdentifier(id)
+	assert.NoError(t, err)
+	cborIdentifier := encodeCBORIdentifier(id)
+
+	assert.NotEqual(t, CBOR_IDENTIFIER_VERSION, gobIdentifier[0])
+	assert.Equal(t, CBOR_IDENTIFIER_VERSION, cborIdentifier[0])
+	assert.Less(t, len(cborIdentifier), len(gobIdentifier))
+}
+
+func TestDecodeInvalidCBORIdentifier(t *testing.T) {
+	id := testIdentifier(t)
+	cborIdentifier := encodeCBORIdentifier(id)
+
+	_, err := DecodeMacaroonIdentifier(cborIdentifier[:len(cborIdentifier)-1])
+	assert.Error(t, err)
+
+	_, err = DecodeMacaroonIdentifier(append(cborIdentifier, 0x00))
+	assert.Error(t, err)
+}
+
+func TestUnknownIdentifierEncoding(t *testing.T) {
+	t.Setenv("IDENTIFIER_ENCODING", "xml")
+	_, err := EncodeMacaroonIdentifier(testIdentifier(t))
+	assert.Error(t, err)
+}
+
+func TestStandardIdentifierLayout(t *testing.T) {
+	id := testIdentifier(t)
+	identifier, err := EncodeMacaroonIdentifier(id)
+	assert.NoError(t, err)
+
+	assert.Len(t, identifier, STANDARD_IDENTIFIER_LENGTH)
+	assert.Equal(t, []byte{0x00, 0x00}, identifier[:2])
+	assert.Equal(t, id.PaymentHash[:], identifier[2:34])
+	assert.Equal(t, id.TokenId[:], identifier[34:])
+}
+
+func TestStandardIdentifierWithKeyIdIsCBOR(t *testing.T) {
+	id := testIdentifier(t)
+	id.KeyId = "beta"
+	identifier, err := EncodeMacaroonIdentifier(id)
+	assert.NoError(t, err)
+	assert.Equal(t, CBOR_IDENTIFIER_VERSION, identifier[0])
+
+	decoded, err := DecodeMacaroonIdentifier(identifier)
+	assert.NoError(t, err)
+	assert.Equal(t, id, decoded)
+}
+
+func TestDecodeInvalidStandardIdentifier(t *testing.T) {
+	identifier, err := encodeStandardIdentifier(testIdentifier(t))
+	assert.NoError(t, err)
+
+	_, err = DecodeMacaroonIdentifier(identifier[:len(identifier)-1])
+	assert.Error(t, err)
+
+	identifier[1] = 0x01
+	_, err = DecodeMacaroonIdentifier(identifier)
+	assert.Error(t, err)
+}
+
+func TestDecodeLegacyGobIdentifier(t *testing.T) {
+	id := testIdentifier(t)
+	gobIdentifier, err := encodeGobIdentifier(id)
+	assert.NoError(t, err)
+
+	decoded, err := DecodeMacaroonIdentifier(gobIdentifier)
+	assert.NoError(t, err)
+	assert.Equal(t, id, decoded)
+}
+
+func TestGobIdentifiersAreNoLongerMinted(t *testing.T) {
+	t.Setenv("IDENTIFIER_ENCODING", IDENTIFIER_ENCODING_GOB)
+	_, err := EncodeMacaroonIdentifier(testIdentifier(t))
+	assert.Error(t, err)
+}
@@ -0,0 +1,59 @@
+package macaroon
+
+import (
+	"encoding/hex"
+
+	"github.com/kiwiidb/gin-lsat/utils"
+)
+
+// Inspection is the decoded content of a macaroon, for debugging customer
+// tokens and admin tooling. The signature is not verified.
+type Inspection struct {
+	Location           string `json:"location"`
+	IdentifierEncoding string `json:"identifier_encoding"`
+	Version            uint16 `json:"version"`
+	PaymentHash        string `json:"payment_hash"`
+	TokenId            string `json:"token_id"`
+	KeyId              string `json:"key_id,omitempty"`
+	// Caveats are the first-party caveats in the order they were added
+	Caveats           []string              `json:"caveats"`
+	ThirdPartyCaveats []InspectedThirdParty `json:"third_party_caveats,omitempty"`
+}
+
+type InspectedThirdParty struct {
+	Location string `json:"location"`
+	CaveatId string `json:"caveat_id"`
+}
+
+// Inspect decodes a base64 encoded macaroon, as found in challenges and
+// Authorization headers, without verifying it.
+func Inspect(macaroonString string) (*Inspection, error) {
+	mac, err := utils.GetMacaroonFromString(macaroonString)
+	if err != nil {
+		return nil, err
+	}
+	macaroonId, err := DecodeMacaroonIdentifier(mac.Id())
+	if err != nil {
+		return nil, err
+	}
+	inspection := &Inspection{
+		Location:           mac.Location(),
+		IdentifierEncoding: IdentifierEncoding(mac.Id()),
+		Version:            macaroonId.Version,
+		PaymentHash:        macaroonId.PaymentHash.String(),
+		TokenId:            hex.EncodeToString(macaroonId.TokenId[:]),
+		KeyId:              macaroonId.KeyId,
+		Caveats:            []string{},
+	}
+	for _, caveat := range mac.Caveats() {
+		if len(caveat.VerificationId) == 0 {
+			inspection.Caveats = append(inspection.Caveats, string(caveat.Id))
+			continue
+		}
+		inspection.ThirdPartyCaveats = append(inspection.ThirdPartyCaveats, InspectedThirdParty{
+			Location: caveat.Location,
+			CaveatId: string(caveat.Id),
+		})
+	}
+	return inspection, nil
+}
@@ -0,0 +1,22 @@
+package macaroon
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspect(t *testing.T) {
+	id := testIdentifier(t)
+	macaroonString, err := GetMacaroonForRootKeyAsString([]byte("root_key"), "", id.PaymentHash, id.TokenId, "path=/articles/*", "expires_at=1700000000")
+	assert.NoError(t, err)
+
+	inspection, err := Inspect(macaroonString)
+	assert.NoError(t, err)
+	assert.Equal(t, IDENTIFIER_ENCODING_STANDARD, inspection.IdentifierEncoding)
+	assert.Equal(t, id.PaymentHash.String(), inspection.PaymentHash)
+	assert.Equal(t, hex.EncodeToString(id.TokenId[:]), inspection.TokenId)
+	assert.Equal(t, []string{"path=/articles/*", "expires_at=1700000000"}, inspection.Caveats)
+	assert.Empty(t, inspection.ThirdPartyCaveats)
+}
@@ -1,36 +1,74 @@
 package macaroon
 
 import (
-	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/gob"
+	"fmt"
 
 	"github.com/kiwiidb/gin-lsat/utils"
 	"github.com/lightningnetwork/lnd/lntypes"
 	"gopkg.in/macaroon.v2"
 )
 
+// DERIVED_KEY_LABEL separates the derived macaroon keys from other HMACs of
+// the token id
+const DERIVED_KEY_LABEL = "macaroon-key:"
+
 type MacaroonIdentifier struct {
 	Version     uint16
 	PaymentHash lntypes.Hash
 	TokenId     [32]byte
+	// KeyId selects the root key the macaroon is signed with, empty for ROOT_KEY
+	KeyId string
 }
 
-func GetMacaroonAsString(paymentHash lntypes.Hash) (string, error) {
+func GetMacaroonAsString(paymentHash lntypes.Hash, caveats ...string) (string, error) {
 	// rootKey, err := generateRootKey()
 	// if err != nil {
 	// 	return "", err
 	// }
-	rootKey := utils.GetRootKey()
+	tokenId, err := GenerateTokenId()
+	if err != nil {
+		return "", err
+	}
+	return GetMacaroonForTokenIdAsString(paymentHash, tokenId, caveats...)
+}
+
+// GetMacaroonForTokenIdAsString mints a macaroon for a token id chosen by the
+// caller, e.g. when the preimage is derived from the token id.
+func GetMacaroonForTokenIdAsString(paymentHash lntypes.Hash, tokenId [32]byte, caveats ...string) (string, error) {
+	return GetMacaroonForKeyIdAsString("", paymentHash, tokenId, caveats...)
+}
 
-	identifier, err := generateMacaroonIdentifier(paymentHash)
+// GetMacaroonForKeyIdAsString mints a macaroon signed with the root key of
+// keyId, the key id is recorded in the identifier to select the key again at
+// verification.
+func GetMacaroonForKeyIdAsString(keyId string, paymentHash lntypes.Hash, tokenId [32]byte, caveats ...string) (string, error) {
+	rootKey := utils.GetRootKeyById(keyId)
+	if len(rootKey) == 0 {
+		return "", fmt.Errorf("Root key not configured: %s", keyId)
+	}
+	return GetMacaroonForRootKeyAsString(rootKey, keyId, paymentHash, tokenId, caveats...)
+}
+
+// GetMacaroonForRootKeyAsString mints a macaroon signed with the key derived
+// from rootKey for tokenId, keyId is recorded in the identifier to select
+// the root key again at verification.
+func GetMacaroonForRootKeyAsString(rootKey []byte, keyId string, paymentHash lntypes.Hash, tokenId [32]byte, caveats ...string) (string, error) {
+	identifier, err := EncodeMacaroonIdentifier(&MacaroonIdentifier{
+		Version:     0,
+		PaymentHash: paymentHash,
+		TokenId:     tokenId,
+		KeyId:       keyId,
+	})
 	if err != nil {
 		return "", err
 	}
 
 	mac, err := macaroon.New(
-		rootKey[:],
+		DeriveRootKey(rootKey, tokenId),
 		identifier,
 		"LSAT",
 		macaroon.LatestVersion,
@@ -38,6 +76,11 @@ func GetMacaroonAsString(paymentHash lntypes.Hash) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	for _, caveat := range caveats {
+		if err := mac.AddFirstPartyCaveat([]byte(caveat)); err != nil {
+			return "", err
+		}
+	}
 
 	macBytes, err := mac.MarshalBinary()
 	if err != nil {
@@ -48,27 +91,49 @@ func GetMacaroonAsString(paymentHash lntypes.Hash) (string, error) {
 	return macaroonString, err
 }
 
-func generateMacaroonIdentifier(paymentHash lntypes.Hash) ([]byte, error) {
-	tokenId, err := generateTokenId()
+// GetIdentityMacaroonAsString mints a free macaroon without a payment hash,
+// it only identifies a client (e.g. for tab mode) by its token id.
+func GetIdentityMacaroonAsString() (string, [32]byte, error) {
+	tokenId, err := GenerateTokenId()
 	if err != nil {
-		return nil, err
+		return "", [32]byte{}, err
 	}
-
-	id := &MacaroonIdentifier{
-		Version:     0,
-		PaymentHash: paymentHash,
-		TokenId:     tokenId,
+	macaroonString, err := GetMacaroonForTokenIdAsString(lntypes.ZeroHash, tokenId)
+	if err != nil {
+		return "", [32]byte{}, err
 	}
+	return macaroonString, tokenId, nil
+}
 
-	var identifier bytes.Buffer
-	enc := gob.NewEncoder(&identifier)
-	if err := enc.Encode(id); err != nil {
-		return nil, err
+// Attenuate returns a copy of mac restricted by caveats. The signature chain
+// lets anyone holding mac narrow it down, but never widen it.
+func Attenuate(mac *macaroon.Macaroon, caveats ...string) (string, error) {
+	attenuated := mac.Clone()
+	for _, caveat := range caveats {
+		if err := attenuated.AddFirstPartyCaveat([]byte(caveat)); err != nil {
+			return "", err
+		}
+	}
+	macBytes, err := attenuated.MarshalBinary()
+	if err != nil {
+		return "", err
 	}
-	return identifier.Bytes(), err
+	return base64.StdEncoding.EncodeToString(macBytes), nil
+}
+
+// DeriveRootKey returns the key the macaroon of tokenId is signed with,
+// HMAC-SHA256 of the token id under rootKey, so exposing the key of one
+// token doesn't expose the root key or other tokens. The message is
+// prefixed with DERIVED_KEY_LABEL, hold invoice preimages are an HMAC of
+// the bare token id under the same root key.
+func DeriveRootKey(rootKey []byte, tokenId [32]byte) []byte {
+	mac := hmac.New(sha256.New, rootKey)
+	mac.Write([]byte(DERIVED_KEY_LABEL))
+	mac.Write(tokenId[:])
+	return mac.Sum(nil)
 }
 
-func generateTokenId() ([32]byte, error) {
+func GenerateTokenId() ([32]byte, error) {
 	var tokenId [32]byte
 	_, err := rand.Read(tokenId[:])
 	return tokenId, err
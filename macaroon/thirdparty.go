@@ -0,0 +1,110 @@
+package macaroon
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"gopkg.in/macaroon.v2"
+)
+
+// ThirdPartyCaveat requires a discharge macaroon of an external service,
+// e.g. a KYC or org-membership service, before the token is accepted. The
+// service shares Secret with the middleware and discharges caveats whose
+// Condition it approves for the client.
+type ThirdPartyCaveat struct {
+	// Location is where clients ask for the discharge, e.g. the URL of the
+	// service
+	Location string
+	// Condition is what the service attests, e.g. "kyc_verified"
+	Condition string
+	Secret    []byte
+}
+
+// NewThirdPartyCaveatId returns a fresh caveat id for condition, of the form
+// <condition>:<hex nonce>, so each token gets its own discharge root key.
+func NewThirdPartyCaveatId(condition string) ([]byte, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%s:%s", condition, hex.EncodeToString(nonce[:]))), nil
+}
+
+// ThirdPartyCondition returns the condition of a caveat id minted with
+// NewThirdPartyCaveatId.
+func ThirdPartyCondition(caveatId []byte) (string, error) {
+	splitted := strings.SplitN(string(caveatId), ":", 2)
+	if len(splitted) != 2 || splitted[0] == "" {
+		return "", fmt.Errorf("Third-party caveat id does not have the right format: %s", caveatId)
+	}
+	return splitted[0], nil
+}
+
+// DischargeRootKey derives the root key of the discharge of caveatId from
+// the secret shared with the third party.
+func DischargeRootKey(secret []byte, caveatId []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(caveatId)
+	return mac.Sum(nil)
+}
+
+// AddThirdPartyCaveats adds a third-party caveat for each of thirdParties to
+// the base64 encoded macaroon.
+func AddThirdPartyCaveats(macaroonString string, thirdParties ...ThirdPartyCaveat) (string, error) {
+	if len(thirdParties) == 0 {
+		return macaroonString, nil
+	}
+	macBytes, err := utils.DecodeBase64(macaroonString)
+	if err != nil {
+		return "", err
+	}
+	mac := &macaroon.Macaroon{}
+	if err := mac.UnmarshalBinary(macBytes); err != nil {
+		return "", err
+	}
+	for _, thirdParty := range thirdParties {
+		caveatId, err := NewThirdPartyCaveatId(thirdParty.Condition)
+		if err != nil {
+			return "", err
+		}
+		if err := mac.AddThirdPartyCaveat(DischargeRootKey(thirdParty.Secret, caveatId), caveatId, thirdParty.Location); err != nil {
+			return "", err
+		}
+	}
+	macBytes, err = mac.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(macBytes), nil
+}
+
+// NewDischarge is used by the third party to mint the discharge of caveatId
+// once it approved the client, caveats like an expires_at restrict the
+// discharge further. The client binds it to its token with BindDischarge.
+func NewDischarge(secret []byte, caveatId []byte, location string, caveats ...string) (*macaroon.Macaroon, error) {
+	discharge, err := macaroon.New(DischargeRootKey(secret, caveatId), caveatId, location, macaroon.LatestVersion)
+	if err != nil {
+		return nil, err
+	}
+	for _, caveat := range caveats {
+		if err := discharge.AddFirstPartyCaveat([]byte(caveat)); err != nil {
+			return nil, err
+		}
+	}
+	return discharge, nil
+}
+
+// BindDischarge binds a copy of discharge to mac, only bound discharges are
+// accepted so they can't be reused with other tokens.
+func BindDischarge(mac *macaroon.Macaroon, discharge *macaroon.Macaroon) *macaroon.Macaroon {
+	bound := discharge.Clone()
+	bound.Bind(mac.Signature())
+	return bound
+}
@@ -0,0 +1,50 @@
+package macaroon
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/macaroon.v2"
+)
+
+func TestThirdPartyCaveatDischarge(t *testing.T) {
+	t.Setenv("ROOT_KEY", "root_key")
+	secret := []byte("kyc_secret")
+	id := testIdentifier(t)
+	macaroonString, err := GetMacaroonForTokenIdAsString(id.PaymentHash, id.TokenId)
+	assert.NoError(t, err)
+	macaroonString, err = AddThirdPartyCaveats(macaroonString, ThirdPartyCaveat{
+		Location:  "https://kyc.example.com",
+		Condition: "kyc_verified",
+		Secret:    secret,
+	})
+	assert.NoError(t, err)
+
+	macBytes, err := base64.StdEncoding.DecodeString(macaroonString)
+	assert.NoError(t, err)
+	mac := &macaroon.Macaroon{}
+	assert.NoError(t, mac.UnmarshalBinary(macBytes))
+	caveats := mac.Caveats()
+	assert.Len(t, caveats, 1)
+	assert.Equal(t, "https://kyc.example.com", caveats[0].Location)
+	condition, err := ThirdPartyCondition(caveats[0].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "kyc_verified", condition)
+
+	rootKey := DeriveRootKey([]byte("root_key"), id.TokenId)
+	_, err = mac.VerifySignature(rootKey, nil)
+	assert.Error(t, err)
+
+	discharge, err := NewDischarge(secret, caveats[0].Id, caveats[0].Location)
+	assert.NoError(t, err)
+	_, err = mac.VerifySignature(rootKey, []*macaroon.Macaroon{discharge})
+	assert.Error(t, err, "unbound discharges are rejected")
+	_, err = mac.VerifySignature(rootKey, []*macaroon.Macaroon{BindDischarge(mac, discharge)})
+	assert.NoError(t, err)
+
+	forged, err := NewDischarge([]byte("other_secret"), caveats[0].Id, caveats[0].Location)
+	assert.NoError(t, err)
+	_, err = mac.VerifySignature(rootKey, []*macaroon.Macaroon{BindDischarge(mac, forged)})
+	assert.Error(t, err)
+}
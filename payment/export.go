@@ -0,0 +1,84 @@
+package payment
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+var CSV_HEADER = []string{"payment_hash", "token_id", "amount", "route", "created_at", "settled_at", "requests", "last_used_at", "backend"}
+
+// ExportRecord is the exported form of a settled payment and its usage
+type ExportRecord struct {
+	PaymentHash string `json:"payment_hash"`
+	TokenId     string `json:"token_id"`
+	Amount      int64  `json:"amount"`
+	Route       string `json:"route"`
+	CreatedAt   string `json:"created_at"`
+	SettledAt   string `json:"settled_at"`
+	Requests    int64  `json:"requests"`
+	LastUsedAt  string `json:"last_used_at,omitempty"`
+	Backend     string `json:"backend,omitempty"`
+}
+
+func NewExportRecord(payment *Payment) *ExportRecord {
+	record := &ExportRecord{
+		PaymentHash: payment.PaymentHash.String(),
+		TokenId:     payment.TokenId,
+		Amount:      payment.Amount,
+		Route:       payment.Route,
+		CreatedAt:   payment.CreatedAt.UTC().Format(time.RFC3339),
+		SettledAt:   payment.SettledAt.UTC().Format(time.RFC3339),
+		Requests:    payment.Requests,
+		Backend:     payment.Backend,
+	}
+	if !payment.LastUsedAt.IsZero() {
+		record.LastUsedAt = payment.LastUsedAt.UTC().Format(time.RFC3339)
+	}
+	return record
+}
+
+func (record *ExportRecord) csvRow() []string {
+	return []string{
+		record.PaymentHash,
+		record.TokenId,
+		strconv.FormatInt(record.Amount, 10),
+		record.Route,
+		record.CreatedAt,
+		record.SettledAt,
+		strconv.FormatInt(record.Requests, 10),
+		record.LastUsedAt,
+		record.Backend,
+	}
+}
+
+// ExportCSV writes the payments settled in [from, to) as CSV
+func ExportCSV(store Store, from time.Time, to time.Time, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(CSV_HEADER); err != nil {
+		return err
+	}
+	err := store.ForEachSettled(from, to, func(payment *Payment) error {
+		return writer.Write(NewExportRecord(payment).csvRow())
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ExportJSON writes the payments settled in [from, to) as a JSON array
+func ExportJSON(store Store, from time.Time, to time.Time, w io.Writer) error {
+	records := []*ExportRecord{}
+	err := store.ForEachSettled(from, to, func(payment *Payment) error {
+		records = append(records, NewExportRecord(payment))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(records)
+}
@@ -0,0 +1,45 @@
+package payment
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestExport(t *testing.T) {
+	store := NewMemoryStore()
+	settledAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, store.Save(&Payment{
+		PaymentHash: lntypes.Hash{1},
+		TokenId:     "ab",
+		Amount:      10,
+		Route:       "GET /protected",
+		SettledAt:   settledAt,
+		Requests:    3,
+	}))
+	// Unsettled payments are not exported
+	assert.NoError(t, store.Save(&Payment{
+		PaymentHash: lntypes.Hash{2},
+		Amount:      10,
+	}))
+	from := settledAt.Add(-time.Hour)
+	to := settledAt.Add(time.Hour)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, ExportJSON(store, from, to, buf))
+	records := gjson.Parse(buf.String()).Array()
+	assert.Len(t, records, 1)
+	assert.Equal(t, lntypes.Hash{1}.String(), records[0].Get("payment_hash").String())
+	assert.Equal(t, "2024-01-02T03:04:05Z", records[0].Get("settled_at").String())
+	assert.Equal(t, int64(3), records[0].Get("requests").Int())
+	assert.False(t, records[0].Get("last_used_at").Exists())
+
+	buf.Reset()
+	assert.NoError(t, ExportCSV(store, to, to.Add(time.Hour), buf))
+	assert.Equal(t, strings.Join(CSV_HEADER, ",")+"\n", buf.String())
+}
@@ -0,0 +1,167 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// Payment records an invoice issued in a challenge and its settlement
+type Payment struct {
+	PaymentHash lntypes.Hash
+	TokenId     string
+	// Amount in sats, rounded up for amounts in msat
+	Amount     int64
+	AmountMsat int64
+	Route      string
+	// Invoice is the payment request of the challenge
+	Invoice string
+	// AssetId and AssetAmount are set for invoices paid in a Taproot Asset
+	AssetId     string
+	AssetAmount uint64
+	// OnchainAddress is the address the challenge could be paid to instead
+	// of its invoice
+	OnchainAddress string
+	// OfferId is the BOLT12 offer the challenge could be paid with instead
+	// of its invoice
+	OfferId string
+	// AMP is set for AMP invoices, whose tokens are presented without
+	// preimage
+	AMP bool
+	// Name of the LN backend that issued the invoice
+	Backend   string
+	CreatedAt time.Time
+	SettledAt time.Time
+	// Usage of the token paid with this payment
+	Requests   int64
+	LastUsedAt time.Time
+	// ConfirmedAt is when the LN backend reported the settlement, as
+	// opposed to SettledAt set when the preimage is first presented
+	ConfirmedAt time.Time
+	// NotifiedAt is when the settlement watcher reported the settlement
+	NotifiedAt time.Time
+	// RefundedAt is when the payment was refunded
+	RefundedAt time.Time
+	// UpgradedFrom is the payment hash of the token this payment upgraded,
+	// the upgraded token shares its uses and limits
+	UpgradedFrom lntypes.Hash
+	// Version is incremented by every CompareAndSwap
+	Version int64
+}
+
+func (payment *Payment) IsSettled() bool {
+	return !payment.SettledAt.IsZero()
+}
+
+// MAX_UPDATE_RETRIES is how often Update retries on concurrent writes
+const MAX_UPDATE_RETRIES = 10
+
+// ErrConflict is returned by CompareAndSwap when the payment was changed
+// since it was read
+var ErrConflict = errors.New("Payment was changed concurrently")
+
+// Store records payments. Stores shared by several replicas must implement
+// CompareAndSwap atomically, e.g. with a Redis WATCH transaction or a
+// Postgres UPDATE ... WHERE version = $1, so uses and quotas can't be
+// consumed twice.
+type Store interface {
+	Save(payment *Payment) error
+	Get(paymentHash lntypes.Hash) (*Payment, error)
+	// CompareAndSwap saves payment with an incremented version if the stored
+	// payment still has payment.Version, ErrConflict otherwise
+	CompareAndSwap(payment *Payment) error
+	// ForEachSettled calls fn for every payment settled in [from, to),
+	// ordered by settlement time
+	ForEachSettled(from time.Time, to time.Time, fn func(payment *Payment) error) error
+}
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	payments map[lntypes.Hash]Payment
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		payments: map[lntypes.Hash]Payment{},
+	}
+}
+
+func (store *MemoryStore) Save(payment *Payment) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.payments[payment.PaymentHash] = *payment
+	return nil
+}
+
+func (store *MemoryStore) CompareAndSwap(payment *Payment) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	stored, ok := store.payments[payment.PaymentHash]
+	if !ok {
+		return fmt.Errorf("Payment not found: %s", payment.PaymentHash)
+	}
+	if stored.Version != payment.Version {
+		return ErrConflict
+	}
+	payment.Version++
+	store.payments[payment.PaymentHash] = *payment
+	return nil
+}
+
+func (store *MemoryStore) Get(paymentHash lntypes.Hash) (*Payment, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	payment, ok := store.payments[paymentHash]
+	if !ok {
+		return nil, fmt.Errorf("Payment not found: %s", paymentHash)
+	}
+	return &payment, nil
+}
+
+func (store *MemoryStore) ForEachSettled(from time.Time, to time.Time, fn func(payment *Payment) error) error {
+	store.mu.Lock()
+	payments := []*Payment{}
+	for _, payment := range store.payments {
+		if payment.IsSettled() && !payment.SettledAt.Before(from) && payment.SettledAt.Before(to) {
+			payment := payment
+			payments = append(payments, &payment)
+		}
+	}
+	store.mu.Unlock()
+	sort.Slice(payments, func(i, j int) bool {
+		return payments[i].SettledAt.Before(payments[j].SettledAt)
+	})
+	for _, payment := range payments {
+		if err := fn(payment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update applies fn to the stored payment and saves it with CompareAndSwap,
+// fn is called again on the fresh payment when it was changed concurrently.
+// An error of fn aborts the update.
+func Update(store Store, paymentHash lntypes.Hash, fn func(payment *Payment) error) (*Payment, error) {
+	for i := 0; i < MAX_UPDATE_RETRIES; i++ {
+		payment, err := store.Get(paymentHash)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(payment); err != nil {
+			return nil, err
+		}
+		err = store.CompareAndSwap(payment)
+		if err == nil {
+			return payment, nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return nil, err
+		}
+	}
+	return nil, ErrConflict
+}
@@ -0,0 +1,74 @@
+package pricing
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+)
+
+const BYTES_PER_MEGABYTE = 1000000
+
+// BodySizePricing charges requests per started megabyte of request body,
+// e.g. for upload or ingest endpoints.
+type BodySizePricing struct {
+	SatsPerMegabyte int64
+	// Minimum amount charged, also for requests without body
+	MinAmount int64
+	// Bodies of unknown length are counted up to MaxBodySize bytes, 0 means
+	// they are charged at MinAmount
+	MaxBodySize int64
+}
+
+func (bodySizePricing *BodySizePricing) AmountFunc(req *http.Request) (amount int64) {
+	amount = bodySizePricing.SatsPerMegabyte * bodySizePricing.megabytes(req)
+	if amount < bodySizePricing.MinAmount {
+		return bodySizePricing.MinAmount
+	}
+	return amount
+}
+
+// Caveats limits the token to bodies of the size that was paid for.
+func (bodySizePricing *BodySizePricing) Caveats(req *http.Request) []caveat.Caveat {
+	quota := bodySizePricing.megabytes(req) * BYTES_PER_MEGABYTE
+	return []caveat.Caveat{
+		caveat.New(caveat.MAX_BODY_BYTES, strconv.FormatInt(quota, 10)),
+	}
+}
+
+func (bodySizePricing *BodySizePricing) megabytes(req *http.Request) int64 {
+	size := BodySize(req, bodySizePricing.MaxBodySize)
+	return (size + BYTES_PER_MEGABYTE - 1) / BYTES_PER_MEGABYTE
+}
+
+// BodySize returns the Content-Length of req. When it is unknown, up to
+// maxBodySize bytes of the body are read to count them, the body is restored
+// so handlers can still read it.
+func BodySize(req *http.Request, maxBodySize int64) int64 {
+	if req == nil {
+		return 0
+	}
+	if req.ContentLength >= 0 {
+		return req.ContentLength
+	}
+	if req.Body == nil || maxBodySize <= 0 {
+		return 0
+	}
+	buffer, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBodySize))
+	req.Body = readCloser{
+		Reader: io.MultiReader(bytes.NewReader(buffer), req.Body),
+		Closer: req.Body,
+	}
+	if err != nil {
+		return maxBodySize
+	}
+	return int64(len(buffer))
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,69 @@
+package pricing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/utils"
+)
+
+// Dimension prices a query or route param, either by a table of values
+// (e.g. ?resolution=4k) or linearly by its numeric value (e.g. ?pages=100).
+type Dimension struct {
+	Param string
+	// Amount added for each value of the param
+	Prices map[string]int64
+	// Amount added per unit of a numeric param
+	PerUnit int64
+	// Value used when the param is absent from the request
+	Default string
+}
+
+func (dimension *Dimension) value(req *http.Request) string {
+	value := utils.GetRequestParam(req, dimension.Param)
+	if value == "" {
+		return dimension.Default
+	}
+	return value
+}
+
+func (dimension *Dimension) amount(value string) int64 {
+	amount := dimension.Prices[value]
+	if dimension.PerUnit != 0 {
+		units, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && units > 0 {
+			amount += dimension.PerUnit * units
+		}
+	}
+	return amount
+}
+
+// DimensionPricing prices requests by a base amount plus the amount of each
+// dimension. The chosen dimensions are recorded as caveats, so the token only
+// unlocks requests with the params that were paid for.
+type DimensionPricing struct {
+	BaseAmount int64
+	Dimensions []Dimension
+}
+
+func (dimensionPricing *DimensionPricing) AmountFunc(req *http.Request) (amount int64) {
+	amount = dimensionPricing.BaseAmount
+	if req == nil {
+		return amount
+	}
+	for i := range dimensionPricing.Dimensions {
+		dimension := &dimensionPricing.Dimensions[i]
+		amount += dimension.amount(dimension.value(req))
+	}
+	return amount
+}
+
+func (dimensionPricing *DimensionPricing) Caveats(req *http.Request) []caveat.Caveat {
+	caveats := []caveat.Caveat{}
+	for i := range dimensionPricing.Dimensions {
+		dimension := &dimensionPricing.Dimensions[i]
+		caveats = append(caveats, caveat.NewParam(dimension.Param, utils.GetRequestParam(req, dimension.Param)))
+	}
+	return caveats
+}
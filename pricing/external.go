@@ -0,0 +1,136 @@
+package pricing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DEFAULT_PRICING_TIMEOUT = 5 * time.Second
+
+// PricingRequest is the request metadata posted to an external pricing service
+type PricingRequest struct {
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Query         string            `json:"query"`
+	ContentLength int64             `json:"content_length"`
+	Headers       map[string]string `json:"headers,omitempty"`
+}
+
+type PricingResponse struct {
+	Amount int64 `json:"amount"`
+}
+
+// ExternalPricing asks an external HTTP service for the price of a request.
+// Prices are cached per method, path and query, and FallbackAmount is charged
+// when the service fails.
+type ExternalPricing struct {
+	URL            string
+	FallbackAmount int64
+	CacheTTL       time.Duration
+	// Request headers forwarded to the pricing service
+	ForwardHeaders []string
+	// Client defaults to a client with DEFAULT_PRICING_TIMEOUT
+	Client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedPrice
+}
+
+type cachedPrice struct {
+	amount    int64
+	expiresAt time.Time
+}
+
+func (externalPricing *ExternalPricing) AmountFunc(req *http.Request) (amount int64) {
+	if req == nil {
+		return externalPricing.FallbackAmount
+	}
+	pricingReq := externalPricing.pricingRequest(req)
+	cacheKey := fmt.Sprintf("%s %s?%s", pricingReq.Method, pricingReq.Path, pricingReq.Query)
+	if amount, ok := externalPricing.getCached(cacheKey); ok {
+		return amount
+	}
+	amount, err := externalPricing.fetchAmount(pricingReq)
+	if err != nil {
+		return externalPricing.FallbackAmount
+	}
+	externalPricing.setCached(cacheKey, amount)
+	return amount
+}
+
+func (externalPricing *ExternalPricing) pricingRequest(req *http.Request) *PricingRequest {
+	pricingReq := &PricingRequest{
+		Method:        req.Method,
+		Path:          req.URL.Path,
+		Query:         req.URL.RawQuery,
+		ContentLength: req.ContentLength,
+	}
+	if len(externalPricing.ForwardHeaders) > 0 {
+		pricingReq.Headers = map[string]string{}
+		for _, header := range externalPricing.ForwardHeaders {
+			pricingReq.Headers[header] = req.Header.Get(header)
+		}
+	}
+	return pricingReq
+}
+
+func (externalPricing *ExternalPricing) fetchAmount(pricingReq *PricingRequest) (int64, error) {
+	body, err := json.Marshal(pricingReq)
+	if err != nil {
+		return 0, err
+	}
+	client := externalPricing.Client
+	if client == nil {
+		client = &http.Client{Timeout: DEFAULT_PRICING_TIMEOUT}
+	}
+	res, err := client.Post(externalPricing.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Pricing service responded with status %d", res.StatusCode)
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, err
+	}
+	pricingRes := &PricingResponse{}
+	if err := json.Unmarshal(resBody, pricingRes); err != nil {
+		return 0, err
+	}
+	if pricingRes.Amount < 0 {
+		return 0, fmt.Errorf("Pricing service returned a negative amount: %d", pricingRes.Amount)
+	}
+	return pricingRes.Amount, nil
+}
+
+func (externalPricing *ExternalPricing) getCached(key string) (int64, bool) {
+	externalPricing.mu.Lock()
+	defer externalPricing.mu.Unlock()
+	cached, ok := externalPricing.cache[key]
+	if !ok || time.Now().After(cached.expiresAt) {
+		return 0, false
+	}
+	return cached.amount, true
+}
+
+func (externalPricing *ExternalPricing) setCached(key string, amount int64) {
+	if externalPricing.CacheTTL <= 0 {
+		return
+	}
+	externalPricing.mu.Lock()
+	defer externalPricing.mu.Unlock()
+	if externalPricing.cache == nil {
+		externalPricing.cache = map[string]cachedPrice{}
+	}
+	externalPricing.cache[key] = cachedPrice{
+		amount:    amount,
+		expiresAt: time.Now().Add(externalPricing.CacheTTL),
+	}
+}
@@ -0,0 +1,50 @@
+package pricing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExternalPricing(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		assert.Equal(t, http.MethodPost, r.Method)
+		pricingReq := &PricingRequest{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(pricingReq))
+		switch pricingReq.Path {
+		case "/reports":
+			assert.Equal(t, "year=2022", pricingReq.Query)
+			assert.Equal(t, map[string]string{"X-Plan": "pro"}, pricingReq.Headers)
+			json.NewEncoder(w).Encode(&PricingResponse{Amount: 42})
+		case "/negative":
+			json.NewEncoder(w).Encode(&PricingResponse{Amount: -1})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	externalPricing := &ExternalPricing{
+		URL:            server.URL,
+		FallbackAmount: 100,
+		CacheTTL:       time.Minute,
+		ForwardHeaders: []string{"X-Plan"},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/reports?year=2022", nil)
+	req.Header.Set("X-Plan", "pro")
+	req.Header.Set("Authorization", "secret")
+	assert.Equal(t, int64(42), externalPricing.AmountFunc(req))
+	assert.Equal(t, int64(42), externalPricing.AmountFunc(req))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	assert.Equal(t, int64(100), externalPricing.AmountFunc(httptest.NewRequest(http.MethodGet, "/negative", nil)))
+	assert.Equal(t, int64(100), externalPricing.AmountFunc(httptest.NewRequest(http.MethodGet, "/failing", nil)))
+	assert.Equal(t, int64(100), externalPricing.AmountFunc(nil))
+}
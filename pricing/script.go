@@ -0,0 +1,118 @@
+package pricing
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// ScriptEnv is the request metadata available to pricing and caveat scripts
+type ScriptEnv struct {
+	Method        string            `expr:"method"`
+	Path          string            `expr:"path"`
+	Query         map[string]string `expr:"query"`
+	Params        map[string]string `expr:"params"`
+	Headers       map[string]string `expr:"headers"`
+	ContentLength int64             `expr:"content_length"`
+	User          string            `expr:"user"`
+}
+
+func NewScriptEnv(req *http.Request) *ScriptEnv {
+	env := &ScriptEnv{
+		Query:   map[string]string{},
+		Params:  map[string]string{},
+		Headers: map[string]string{},
+	}
+	if req == nil {
+		return env
+	}
+	env.Method = req.Method
+	env.Path = req.URL.Path
+	env.ContentLength = req.ContentLength
+	env.User = utils.GetUserId(req)
+	for key := range req.URL.Query() {
+		env.Query[key] = req.URL.Query().Get(key)
+	}
+	for key := range req.Header {
+		env.Headers[strings.ToLower(key)] = req.Header.Get(key)
+	}
+	for key, value := range utils.GetRouteParams(req) {
+		env.Params[key] = value
+	}
+	return env
+}
+
+// ScriptPolicy evaluates pricing and caveat rules defined as expressions,
+// e.g. `path contains "premium" ? 1000 : 100`. Expressions are compiled once
+// and can only read the ScriptEnv of the request.
+type ScriptPolicy struct {
+	// Amount charged when the amount expression fails
+	FallbackAmount int64
+
+	amountProgram    *vm.Program
+	caveatConditions []string
+	caveatPrograms   map[string]*vm.Program
+}
+
+// NewScriptPolicy compiles amountExpr, which must evaluate to an integer, and
+// caveatExprs, which map caveat conditions to expressions evaluating to their
+// value.
+func NewScriptPolicy(amountExpr string, caveatExprs map[string]string) (*ScriptPolicy, error) {
+	amountProgram, err := expr.Compile(amountExpr, expr.Env(&ScriptEnv{}), expr.AsInt64())
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling amount expression: %s", err.Error())
+	}
+	caveatConditions := []string{}
+	caveatPrograms := map[string]*vm.Program{}
+	for condition, caveatExpr := range caveatExprs {
+		caveatProgram, err := expr.Compile(caveatExpr, expr.Env(&ScriptEnv{}), expr.AsKind(reflect.String))
+		if err != nil {
+			return nil, fmt.Errorf("Error compiling %s caveat expression: %s", condition, err.Error())
+		}
+		caveatConditions = append(caveatConditions, condition)
+		caveatPrograms[condition] = caveatProgram
+	}
+	sort.Strings(caveatConditions)
+	return &ScriptPolicy{
+		amountProgram:    amountProgram,
+		caveatConditions: caveatConditions,
+		caveatPrograms:   caveatPrograms,
+	}, nil
+}
+
+func (scriptPolicy *ScriptPolicy) AmountFunc(req *http.Request) (amount int64) {
+	output, err := expr.Run(scriptPolicy.amountProgram, NewScriptEnv(req))
+	if err != nil {
+		return scriptPolicy.FallbackAmount
+	}
+	amount, ok := output.(int64)
+	if !ok || amount < 0 {
+		return scriptPolicy.FallbackAmount
+	}
+	return amount
+}
+
+// Caveats evaluates the caveat expressions, an empty value or a failing
+// expression skips the caveat.
+func (scriptPolicy *ScriptPolicy) Caveats(req *http.Request) []caveat.Caveat {
+	env := NewScriptEnv(req)
+	caveats := []caveat.Caveat{}
+	for _, condition := range scriptPolicy.caveatConditions {
+		output, err := expr.Run(scriptPolicy.caveatPrograms[condition], env)
+		if err != nil {
+			continue
+		}
+		if value, ok := output.(string); ok && value != "" {
+			caveats = append(caveats, caveat.New(condition, value))
+		}
+	}
+	return caveats
+}
@@ -0,0 +1,50 @@
+package pricing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kiwiidb/gin-lsat/caveat"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptPolicy(t *testing.T) {
+	scriptPolicy, err := NewScriptPolicy(`path contains "premium" ? 1000 : int(query.pages ?? "1") * 10`, map[string]string{
+		caveat.TIER: `headers["x-plan"] == "pro" ? "pro" : ""`,
+		caveat.PATH: `"/articles/" + params.articleId`,
+	})
+	assert.NoError(t, err)
+	scriptPolicy.FallbackAmount = 5
+
+	req := httptest.NewRequest(http.MethodGet, "/premium/report", nil)
+	assert.Equal(t, int64(1000), scriptPolicy.AmountFunc(req))
+	req = httptest.NewRequest(http.MethodGet, "/articles/42?pages=3", nil)
+	assert.Equal(t, int64(30), scriptPolicy.AmountFunc(req))
+	req = httptest.NewRequest(http.MethodGet, "/articles/42?pages=three", nil)
+	assert.Equal(t, int64(5), scriptPolicy.AmountFunc(req))
+
+	req.Header.Set("X-Plan", "pro")
+	req = utils.WithRouteParams(req, map[string]string{"articleId": "42"})
+	assert.Equal(t, []caveat.Caveat{
+		caveat.New(caveat.PATH, "/articles/42"),
+		caveat.New(caveat.TIER, "pro"),
+	}, scriptPolicy.Caveats(req))
+
+	req.Header.Set("X-Plan", "basic")
+	assert.Equal(t, []caveat.Caveat{
+		caveat.New(caveat.PATH, "/articles/42"),
+	}, scriptPolicy.Caveats(req))
+}
+
+func TestScriptPolicyRejectsInvalidExpressions(t *testing.T) {
+	_, err := NewScriptPolicy(`"free"`, nil)
+	assert.Error(t, err)
+	_, err = NewScriptPolicy(`10`, map[string]string{caveat.TIER: `1`})
+	assert.Error(t, err)
+	// Scripts only read the request metadata
+	_, err = NewScriptPolicy(`len(secrets)`, nil)
+	assert.Error(t, err)
+}
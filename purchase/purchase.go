@@ -0,0 +1,55 @@
+package purchase
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// Purchase records that an owner, a user id or a token id, bought a resource
+type Purchase struct {
+	Owner       string
+	ResourceId  string
+	PaymentHash lntypes.Hash
+	PurchasedAt time.Time
+}
+
+type Store interface {
+	Save(purchase *Purchase) error
+	// Get returns nil when the owner did not buy the resource
+	Get(owner string, resourceId string) (*Purchase, error)
+}
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	purchases map[string]Purchase
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		purchases: map[string]Purchase{},
+	}
+}
+
+func key(owner string, resourceId string) string {
+	return fmt.Sprintf("%s/%s", owner, resourceId)
+}
+
+func (store *MemoryStore) Save(purchase *Purchase) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.purchases[key(purchase.Owner, purchase.ResourceId)] = *purchase
+	return nil
+}
+
+func (store *MemoryStore) Get(owner string, resourceId string) (*Purchase, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	purchase, ok := store.purchases[key(owner, resourceId)]
+	if !ok {
+		return nil, nil
+	}
+	return &purchase, nil
+}
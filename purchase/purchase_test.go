@@ -0,0 +1,34 @@
+package purchase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	p, err := store.Get("user:alice", "1")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	assert.NoError(t, store.Save(&Purchase{
+		Owner:       "user:alice",
+		ResourceId:  "1",
+		PaymentHash: lntypes.Hash{1},
+		PurchasedAt: time.Now(),
+	}))
+	p, err = store.Get("user:alice", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, lntypes.Hash{1}, p.PaymentHash)
+
+	// Purchases are per owner and resource
+	p, err = store.Get("user:alice", "2")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+	p, err = store.Get("user:bob", "1")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLimitReached is returned by Allow when the window is used up
+var ErrLimitReached = errors.New("Rate limit reached")
+
+// Store counts the requests of tokens in fixed time windows by key. Shared
+// stores must implement Allow atomically, e.g. with a Redis Lua script
+// running INCR and PEXPIRE on a key per window, so replicas enforce a
+// common limit.
+type Store interface {
+	// Allow counts a request of key in the current window of length window
+	// unless limit requests were counted in it already, ErrLimitReached
+	// otherwise. It returns the count and the end of the window.
+	Allow(key string, limit int64, window time.Duration) (int64, time.Time, error)
+}
+
+type counter struct {
+	count   int64
+	resetAt time.Time
+}
+
+// MemoryStore counts requests in process, limits are enforced per replica
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+	// pruneAt is when expired windows are dropped next
+	pruneAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: map[string]*counter{},
+	}
+}
+
+func (store *MemoryStore) Allow(key string, limit int64, window time.Duration) (int64, time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	store.prune(now, window)
+	current, ok := store.counters[key]
+	if !ok || !now.Before(current.resetAt) {
+		current = &counter{
+			resetAt: now.Truncate(window).Add(window),
+		}
+		store.counters[key] = current
+	}
+	if current.count >= limit {
+		return current.count, current.resetAt, ErrLimitReached
+	}
+	current.count++
+	return current.count, current.resetAt, nil
+}
+
+// prune drops expired windows at most once per window, so tokens that are
+// not used anymore don't pile up.
+func (store *MemoryStore) prune(now time.Time, window time.Duration) {
+	if now.Before(store.pruneAt) {
+		return
+	}
+	for key, current := range store.counters {
+		if !now.Before(current.resetAt) {
+			delete(store.counters, key)
+		}
+	}
+	store.pruneAt = now.Add(window)
+}
@@ -0,0 +1,31 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreAllow(t *testing.T) {
+	store := NewMemoryStore()
+	for i := int64(1); i <= 2; i++ {
+		count, _, err := store.Allow("token", 2, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, i, count)
+	}
+	_, resetAt, err := store.Allow("token", 2, time.Minute)
+	assert.Equal(t, ErrLimitReached, err)
+	assert.True(t, resetAt.After(time.Now()))
+
+	// Keys are counted separately
+	_, _, err = store.Allow("other", 2, time.Minute)
+	assert.NoError(t, err)
+
+	// A new window starts once the last one ended
+	_, _, err = store.Allow("short", 1, 10*time.Millisecond)
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, _, err = store.Allow("short", 1, 10*time.Millisecond)
+	assert.NoError(t, err)
+}
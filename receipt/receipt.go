@@ -0,0 +1,89 @@
+package receipt
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Receipt is issued to payers after settlement, for their accounting
+type Receipt struct {
+	PaymentHash string `json:"payment_hash"`
+	Amount      int64  `json:"amount"`
+	Timestamp   int64  `json:"timestamp"`
+	Route       string `json:"route"`
+	TokenId     string `json:"token_id"`
+}
+
+type SignedReceipt struct {
+	Receipt   Receipt `json:"receipt"`
+	Signature string  `json:"signature"`
+}
+
+type Signer struct {
+	privateKey ed25519.PrivateKey
+}
+
+func NewSigner(privateKey ed25519.PrivateKey) *Signer {
+	return &Signer{
+		privateKey: privateKey,
+	}
+}
+
+// NewSignerFromSecret derives the signing key from a secret, e.g. the root key.
+func NewSignerFromSecret(secret []byte) *Signer {
+	seed := sha256.Sum256(append([]byte("receipt"), secret...))
+	return NewSigner(ed25519.NewKeyFromSeed(seed[:]))
+}
+
+// PublicKey returns the hex encoded public key payers verify receipts with
+func (signer *Signer) PublicKey() string {
+	return hex.EncodeToString(signer.privateKey.Public().(ed25519.PublicKey))
+}
+
+// SignBytes returns the hex encoded signature of message
+func (signer *Signer) SignBytes(message []byte) string {
+	return hex.EncodeToString(ed25519.Sign(signer.privateKey, message))
+}
+
+func (signer *Signer) Sign(receipt Receipt) (*SignedReceipt, error) {
+	message, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedReceipt{
+		Receipt:   receipt,
+		Signature: signer.SignBytes(message),
+	}, nil
+}
+
+// Verify checks the signature of a receipt against the hex encoded public key
+// published by the server.
+func Verify(publicKey string, signedReceipt *SignedReceipt) error {
+	message, err := json.Marshal(signedReceipt.Receipt)
+	if err != nil {
+		return err
+	}
+	if err := VerifyBytes(publicKey, message, signedReceipt.Signature); err != nil {
+		return fmt.Errorf("Receipt signature does not match")
+	}
+	return nil
+}
+
+// VerifyBytes checks the hex encoded signature of message
+func VerifyBytes(publicKey string, message []byte, signature string) error {
+	publicKeyBytes, err := hex.DecodeString(publicKey)
+	if err != nil || len(publicKeyBytes) != ed25519.PublicKeySize {
+		return fmt.Errorf("Invalid public key")
+	}
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("Invalid signature")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(publicKeyBytes), message, signatureBytes) {
+		return fmt.Errorf("Signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package receipt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	signer := NewSignerFromSecret([]byte("root key"))
+	signedReceipt, err := signer.Sign(Receipt{
+		PaymentHash: "ab",
+		Amount:      10,
+		Timestamp:   1700000000,
+		Route:       "GET /protected",
+		TokenId:     "cd",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, Verify(signer.PublicKey(), signedReceipt))
+
+	// Signers derived from another secret have another key
+	other := NewSignerFromSecret([]byte("other root key"))
+	assert.NotEqual(t, signer.PublicKey(), other.PublicKey())
+	assert.EqualError(t, Verify(other.PublicKey(), signedReceipt), "Receipt signature does not match")
+
+	signedReceipt.Receipt.Amount = 1
+	assert.Error(t, Verify(signer.PublicKey(), signedReceipt))
+	assert.EqualError(t, VerifyBytes("not hex", []byte("message"), signedReceipt.Signature), "Invalid public key")
+}
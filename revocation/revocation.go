@@ -0,0 +1,50 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// Revocation records that a token was cut off before its caveats expired
+type Revocation struct {
+	// TokenId is the hex encoded token id of the macaroon identifier
+	TokenId   string
+	Reason    string
+	RevokedAt time.Time
+}
+
+// Store is the revocation list, keyed by token id. Sub-tokens share the
+// token id of their parent, so revoking a token revokes its sub-tokens.
+type Store interface {
+	Revoke(revocation *Revocation) error
+	// Get returns nil when the token is not revoked
+	Get(tokenId string) (*Revocation, error)
+}
+
+type MemoryStore struct {
+	mu          sync.Mutex
+	revocations map[string]Revocation
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		revocations: map[string]Revocation{},
+	}
+}
+
+func (store *MemoryStore) Revoke(revocation *Revocation) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.revocations[revocation.TokenId] = *revocation
+	return nil
+}
+
+func (store *MemoryStore) Get(tokenId string) (*Revocation, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	revocation, ok := store.revocations[tokenId]
+	if !ok {
+		return nil, nil
+	}
+	return &revocation, nil
+}
@@ -0,0 +1,45 @@
+package rootkey
+
+import (
+	"crypto/sha256"
+	"sync"
+)
+
+// ApertureSecrets holds the root keys of tokens minted by Aperture, which
+// signs every token with a random secret stored under the sha256 of its
+// token id, in etcd at lsat/proxy/secrets/<hex id hash>.
+type ApertureSecrets interface {
+	// GetSecret returns nil when there is no secret for idHash
+	GetSecret(idHash [32]byte) ([]byte, error)
+}
+
+// ApertureSecretId returns the id hash Aperture stores the secret of
+// tokenId under.
+func ApertureSecretId(tokenId [32]byte) [32]byte {
+	return sha256.Sum256(tokenId[:])
+}
+
+type MemoryApertureSecrets struct {
+	mu      sync.RWMutex
+	secrets map[[32]byte][]byte
+}
+
+func NewMemoryApertureSecrets() *MemoryApertureSecrets {
+	return &MemoryApertureSecrets{
+		secrets: map[[32]byte][]byte{},
+	}
+}
+
+// Add adds the secret of the token with tokenId, e.g. when importing the
+// secrets of an Aperture deployment.
+func (secrets *MemoryApertureSecrets) Add(tokenId [32]byte, secret []byte) {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	secrets.secrets[ApertureSecretId(tokenId)] = secret
+}
+
+func (secrets *MemoryApertureSecrets) GetSecret(idHash [32]byte) ([]byte, error) {
+	secrets.mu.RLock()
+	defer secrets.mu.RUnlock()
+	return secrets.secrets[idHash], nil
+}
@@ -0,0 +1,177 @@
+package rootkey
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/kiwiidb/gin-lsat/utils"
+)
+
+const (
+	// DEFAULT_KEY_FILE holds the root key of the empty key id in the
+	// directory of a FileProvider
+	DEFAULT_KEY_FILE = "root_key"
+	// CURRENT_KEY_ID_FILE holds the id of the key a FileProvider mints with
+	CURRENT_KEY_ID_FILE = "current_key_id"
+)
+
+// Provider supplies the root keys macaroons are signed with, by key id.
+// Tokens are minted with the key of CurrentKeyId and verified with the key
+// of the id in their identifier, "" being the default key. Rotating means
+// adding a key and making it current: outstanding tokens keep verifying
+// against their key until it is removed from the provider.
+type Provider interface {
+	GetRootKey(keyId string) ([]byte, error)
+	CurrentKeyId() (string, error)
+}
+
+// EnvProvider reads root keys from ROOT_KEY and ROOT_KEY_<KEYID>, for the
+// key ids listed in KEY_IDS. Other key ids are rejected.
+type EnvProvider struct {
+	// KeyId is the id of the key tokens are minted with, CURRENT_KEY_ID when
+	// empty
+	KeyId string
+}
+
+func NewEnvProvider() *EnvProvider {
+	return &EnvProvider{}
+}
+
+func (provider *EnvProvider) GetRootKey(keyId string) ([]byte, error) {
+	rootKey := utils.GetRootKeyById(keyId)
+	if len(rootKey) == 0 {
+		return nil, fmt.Errorf("Root key not configured: %s", keyId)
+	}
+	return rootKey, nil
+}
+
+func (provider *EnvProvider) CurrentKeyId() (string, error) {
+	if provider.KeyId != "" {
+		return provider.KeyId, nil
+	}
+	return utils.GetRootKeyId(), nil
+}
+
+// FileProvider reads root keys from a directory holding a file per key id,
+// e.g. a mounted Kubernetes secret. The default key is read from
+// DEFAULT_KEY_FILE. Keys are cached once read, the current key id is read
+// from CURRENT_KEY_ID_FILE on every call so it can be rotated at runtime.
+type FileProvider struct {
+	Dir string
+	// KeyId is the id of the key tokens are minted with, read from
+	// CURRENT_KEY_ID_FILE when empty and the default key without one
+	KeyId string
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func NewFileProvider(dir string, keyId string) *FileProvider {
+	return &FileProvider{
+		Dir:   dir,
+		KeyId: keyId,
+		keys:  map[string][]byte{},
+	}
+}
+
+func (provider *FileProvider) GetRootKey(keyId string) ([]byte, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if rootKey, ok := provider.keys[keyId]; ok {
+		return rootKey, nil
+	}
+	fileName := keyId
+	if fileName == "" {
+		fileName = DEFAULT_KEY_FILE
+	}
+	// Key ids are read from client identifiers, never let one leave the
+	// directory or select a file that is not a key
+	if filepath.Base(fileName) != fileName || strings.HasPrefix(fileName, ".") || keyId == DEFAULT_KEY_FILE || keyId == CURRENT_KEY_ID_FILE {
+		return nil, fmt.Errorf("Invalid root key id: %s", keyId)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(provider.Dir, fileName))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Root key not configured: %s", keyId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rootKey := []byte(strings.TrimSpace(string(content)))
+	if len(rootKey) == 0 {
+		return nil, fmt.Errorf("Root key not configured: %s", keyId)
+	}
+	provider.keys[keyId] = rootKey
+	return rootKey, nil
+}
+
+func (provider *FileProvider) CurrentKeyId() (string, error) {
+	if provider.KeyId != "" {
+		return provider.KeyId, nil
+	}
+	content, err := ioutil.ReadFile(filepath.Join(provider.Dir, CURRENT_KEY_ID_FILE))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// MemoryProvider holds root keys in memory, e.g. fetched from a secret
+// manager at startup or in tests.
+type MemoryProvider struct {
+	mu           sync.RWMutex
+	keys         map[string][]byte
+	currentKeyId string
+}
+
+// NewMemoryProvider mints tokens with rootKey under the empty key id.
+func NewMemoryProvider(rootKey []byte) *MemoryProvider {
+	return &MemoryProvider{
+		keys: map[string][]byte{
+			"": rootKey,
+		},
+	}
+}
+
+// Add adds the root key of keyId, tokens are minted with it when current is
+// true. Keys added before stay valid for verification.
+func (provider *MemoryProvider) Add(keyId string, rootKey []byte, current bool) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	provider.keys[keyId] = rootKey
+	if current {
+		provider.currentKeyId = keyId
+	}
+}
+
+// Remove retires the root key of keyId, tokens minted with it are rejected.
+func (provider *MemoryProvider) Remove(keyId string) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	delete(provider.keys, keyId)
+	if provider.currentKeyId == keyId {
+		provider.currentKeyId = ""
+	}
+}
+
+func (provider *MemoryProvider) GetRootKey(keyId string) ([]byte, error) {
+	provider.mu.RLock()
+	defer provider.mu.RUnlock()
+	rootKey, ok := provider.keys[keyId]
+	if !ok || len(rootKey) == 0 {
+		return nil, fmt.Errorf("Root key not configured: %s", keyId)
+	}
+	return rootKey, nil
+}
+
+func (provider *MemoryProvider) CurrentKeyId() (string, error) {
+	provider.mu.RLock()
+	defer provider.mu.RUnlock()
+	return provider.currentKeyId, nil
+}
@@ -0,0 +1,80 @@
+package rootkey
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	macaroonutils "github.com/kiwiidb/gin-lsat/macaroon"
+	"github.com/kiwiidb/gin-lsat/utils"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvProviderRejectsUnlistedKeyIds(t *testing.T) {
+	t.Setenv("ROOT_KEY", "root_key")
+	t.Setenv("ROOT_KEY_V2", "root_key_v2")
+	t.Setenv("KEY_IDS", "V2")
+	t.Setenv("CURRENT_KEY_ID", "V2")
+	// A leftover of the former current key id setting, public in every
+	// identifier minted with it
+	t.Setenv("ROOT_KEY_ID", "V2")
+	provider := NewEnvProvider()
+
+	keyId, err := provider.CurrentKeyId()
+	assert.NoError(t, err)
+	assert.Equal(t, "V2", keyId)
+	rootKey, err := provider.GetRootKey(keyId)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("root_key_v2"), rootKey)
+
+	for _, forged := range []string{"id", "ID", "ids", "beta"} {
+		_, err := provider.GetRootKey(forged)
+		assert.Error(t, err, forged)
+	}
+}
+
+func TestForgedKeyIdDoesNotVerify(t *testing.T) {
+	t.Setenv("ROOT_KEY", "root_key")
+	t.Setenv("ROOT_KEY_V2", "root_key_v2")
+	t.Setenv("KEY_IDS", "V2")
+	t.Setenv("CURRENT_KEY_ID", "V2")
+	t.Setenv("ROOT_KEY_ID", "V2")
+	tokenId, err := macaroonutils.GenerateTokenId()
+	assert.NoError(t, err)
+	preimage := lntypes.Preimage(tokenId)
+	// Signed with the public current key id as root key
+	macaroonString, err := macaroonutils.GetMacaroonForRootKeyAsString([]byte("V2"), "id", preimage.Hash(), tokenId)
+	assert.NoError(t, err)
+	mac, err := utils.GetMacaroonFromString(macaroonString)
+	assert.NoError(t, err)
+	macaroonId, err := macaroonutils.DecodeMacaroonIdentifier(mac.Id())
+	assert.NoError(t, err)
+
+	_, err = NewEnvProvider().GetRootKey(macaroonId.KeyId)
+	assert.Error(t, err)
+}
+
+func TestFileProviderRejectsReservedKeyIds(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, DEFAULT_KEY_FILE), []byte("root_key"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "v2"), []byte("root_key_v2\n"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, CURRENT_KEY_ID_FILE), []byte("v2\n"), 0600))
+	provider := NewFileProvider(dir, "")
+
+	keyId, err := provider.CurrentKeyId()
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", keyId)
+	rootKey, err := provider.GetRootKey(keyId)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("root_key_v2"), rootKey)
+	rootKey, err = provider.GetRootKey("")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("root_key"), rootKey)
+
+	for _, forged := range []string{CURRENT_KEY_ID_FILE, DEFAULT_KEY_FILE, "../v2", ".hidden", "unknown"} {
+		_, err := provider.GetRootKey(forged)
+		assert.Error(t, err, forged)
+	}
+}
@@ -0,0 +1,202 @@
+package tab
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kiwiidb/gin-lsat/ln"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// Tab keeps track of the charges a client accumulated since its last
+// settlement.
+type Tab struct {
+	ID string
+	// Balance is BalanceMsat rounded up to sats
+	Balance     int64
+	BalanceMsat int64
+	Requests    int64
+	OpenedAt    time.Time
+	// Requests charged since the last settlement
+	UnsettledRequests int64
+
+	// Outstanding settlement invoice, empty when nothing is invoiced
+	Invoice            string
+	Macaroon           string
+	PaymentHash        lntypes.Hash
+	InvoicedAmount     int64
+	InvoicedAmountMsat int64
+	InvoicedRequests   int64
+	InvoicedAt         time.Time
+	SettledAt          time.Time
+	// Name of the LN backend that issued the outstanding invoice
+	Backend string
+	// Version is incremented by every CompareAndSwap
+	Version int64
+}
+
+// New returns an empty tab opened now.
+func New(id string) *Tab {
+	return &Tab{
+		ID:       id,
+		OpenedAt: time.Now(),
+	}
+}
+
+func (tab *Tab) HasOutstandingInvoice() bool {
+	return tab.Invoice != ""
+}
+
+// Charge adds amount in sats to the balance of the tab.
+func (tab *Tab) Charge(amount int64) {
+	tab.ChargeMsat(amount * ln.MSAT_PER_SAT)
+}
+
+// ChargeMsat adds amountMsat to the balance of the tab.
+func (tab *Tab) ChargeMsat(amountMsat int64) {
+	tab.setBalanceMsat(tab.BalanceMsat + amountMsat)
+	tab.Requests++
+	tab.UnsettledRequests++
+}
+
+func (tab *Tab) setBalanceMsat(balanceMsat int64) {
+	tab.BalanceMsat = balanceMsat
+	tab.Balance = (balanceMsat + ln.MSAT_PER_SAT - 1) / ln.MSAT_PER_SAT
+}
+
+// Settle clears the outstanding settlement invoice and deducts the
+// invoiced amount from the balance.
+func (tab *Tab) Settle(paymentHash lntypes.Hash) error {
+	if !tab.HasOutstandingInvoice() {
+		return fmt.Errorf("Tab %s has no outstanding invoice", tab.ID)
+	}
+	if tab.PaymentHash != paymentHash {
+		return fmt.Errorf("PaymentHash %s does not match outstanding invoice of tab %s", paymentHash, tab.ID)
+	}
+	tab.setBalanceMsat(tab.BalanceMsat - tab.InvoicedAmountMsat)
+	tab.UnsettledRequests -= tab.InvoicedRequests
+	tab.Invoice = ""
+	tab.Macaroon = ""
+	tab.PaymentHash = lntypes.Hash{}
+	tab.InvoicedAmount = 0
+	tab.InvoicedAmountMsat = 0
+	tab.InvoicedRequests = 0
+	tab.InvoicedAt = time.Time{}
+	tab.Backend = ""
+	tab.SettledAt = time.Now()
+	return nil
+}
+
+// CreditLimit is the amount of unsettled requests and sats a client may
+// consume before payment is required, 0 means unlimited.
+type CreditLimit struct {
+	Requests int64
+	Amount   int64
+}
+
+func (limit CreditLimit) IsSet() bool {
+	return limit.Requests > 0 || limit.Amount > 0
+}
+
+// Exceeds returns true if charging amount in sats to the tab would cross
+// the limit.
+func (limit CreditLimit) Exceeds(tab *Tab, amount int64) bool {
+	return limit.ExceedsMsat(tab, amount*ln.MSAT_PER_SAT)
+}
+
+// ExceedsMsat returns true if charging amountMsat to the tab would cross
+// the limit.
+func (limit CreditLimit) ExceedsMsat(tab *Tab, amountMsat int64) bool {
+	if limit.Requests > 0 && tab.UnsettledRequests+1 > limit.Requests {
+		return true
+	}
+	return limit.Amount > 0 && tab.BalanceMsat+amountMsat > limit.Amount*ln.MSAT_PER_SAT
+}
+
+// MAX_UPDATE_RETRIES is how often Update retries on concurrent writes
+const MAX_UPDATE_RETRIES = 10
+
+// ErrConflict is returned by CompareAndSwap when the tab was changed since
+// it was read
+var ErrConflict = errors.New("Tab was changed concurrently")
+
+// Store records tabs. Stores shared by several replicas must implement
+// CompareAndSwap atomically, so concurrent requests can't charge a tab
+// past its credit limit.
+type Store interface {
+	// Get returns the tab with id, an error if it was never opened
+	Get(id string) (*Tab, error)
+	Save(tab *Tab) error
+	// CompareAndSwap saves tab with an incremented version if the stored
+	// tab still has tab.Version, ErrConflict otherwise
+	CompareAndSwap(tab *Tab) error
+}
+
+// Update applies fn to the tab with id and saves it atomically, retrying
+// when the tab is changed concurrently.
+func Update(store Store, id string, fn func(tab *Tab) error) (*Tab, error) {
+	for i := 0; i < MAX_UPDATE_RETRIES; i++ {
+		tab, err := store.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(tab); err != nil {
+			return nil, err
+		}
+		err = store.CompareAndSwap(tab)
+		if err == nil {
+			return tab, nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return nil, err
+		}
+	}
+	return nil, ErrConflict
+}
+
+type MemoryStore struct {
+	mu   sync.Mutex
+	tabs map[string]Tab
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tabs: map[string]Tab{},
+	}
+}
+
+// Get returns a copy of the tab.
+func (store *MemoryStore) Get(id string) (*Tab, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	tab, ok := store.tabs[id]
+	if !ok {
+		return nil, fmt.Errorf("Tab not found: %s", id)
+	}
+	return &tab, nil
+}
+
+func (store *MemoryStore) Save(tab *Tab) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.tabs[tab.ID] = *tab
+	return nil
+}
+
+func (store *MemoryStore) CompareAndSwap(tab *Tab) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	stored, ok := store.tabs[tab.ID]
+	if !ok {
+		return fmt.Errorf("Tab not found: %s", tab.ID)
+	}
+	if stored.Version != tab.Version {
+		return ErrConflict
+	}
+	tab.Version++
+	store.tabs[tab.ID] = *tab
+	return nil
+}
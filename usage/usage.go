@@ -0,0 +1,64 @@
+package usage
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLimitReached is returned by Use when the counter reached its limit
+var ErrLimitReached = errors.New("Usage limit reached")
+
+// Store counts the uses of tokens by key. Shared stores must implement Use
+// atomically, e.g. with a Redis Lua script checking the limit before INCR or
+// a Postgres UPDATE ... SET uses = uses + 1 WHERE uses < $2 RETURNING uses.
+type Store interface {
+	// Use increments the counter of key unless it reached limit and returns
+	// the new count, ErrLimitReached otherwise
+	Use(key string, limit int64) (int64, error)
+	// Get returns the counter of key, 0 when it was never used
+	Get(key string) (int64, error)
+}
+
+// Meter sums the amounts tokens consumed by key, e.g. response bytes.
+// Shared meters must implement Add atomically, e.g. with Redis INCRBY.
+type Meter interface {
+	// Add adds amount to the counter of key and returns the new total
+	Add(key string, amount int64) (int64, error)
+	// Get returns the counter of key, 0 when nothing was consumed
+	Get(key string) (int64, error)
+}
+
+// MemoryStore is a Store and a Meter, keep their keys apart when sharing it
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: map[string]int64{},
+	}
+}
+
+func (store *MemoryStore) Use(key string, limit int64) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.counters[key] >= limit {
+		return store.counters[key], ErrLimitReached
+	}
+	store.counters[key]++
+	return store.counters[key], nil
+}
+
+func (store *MemoryStore) Get(key string) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.counters[key], nil
+}
+
+func (store *MemoryStore) Add(key string, amount int64) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.counters[key] += amount
+	return store.counters[key], nil
+}
@@ -0,0 +1,20 @@
+package usage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreMeter(t *testing.T) {
+	store := NewMemoryStore()
+	consumed, err := store.Get("token")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), consumed)
+	consumed, err = store.Add("token", 600)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), consumed)
+	consumed, err = store.Add("token", 600)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1200), consumed)
+}
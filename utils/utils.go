@@ -1,32 +1,55 @@
 package utils
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"strings"
 
+	"github.com/btcsuite/btcd/btcutil/bech32"
 	"github.com/lightningnetwork/lnd/lntypes"
 	"gopkg.in/macaroon.v2"
 )
 
+// MAX_LSAT_HEADER_LENGTH bounds the Authorization header parsed, leaving
+// room for macaroons with many caveats
+const MAX_LSAT_HEADER_LENGTH = 16 * 1024
+
+// PREIMAGE_HEX_LENGTH is the length of a hex encoded preimage
+const PREIMAGE_HEX_LENGTH = 2 * lntypes.PreimageSize
+
+const (
+	// ROOT_KEY_IDS_ENV lists the ids of the ROOT_KEY_<ID> root keys
+	ROOT_KEY_IDS_ENV = "KEY_IDS"
+	// CURRENT_KEY_ID_ENV is the id of the root key tokens are minted with
+	CURRENT_KEY_ID_ENV = "CURRENT_KEY_ID"
+)
+
 func ParseLsatHeader(authField string) (*macaroon.Macaroon, lntypes.Preimage, error) {
 	// A typical authField
 	// Authorization: LSAT AGIAJEemVQUTEyNCR0exk7ek90Cg==:1234abcd1234abcd1234abcd
 	if len(authField) == 0 {
 		return nil, lntypes.Preimage{}, fmt.Errorf("Authorization Field not present")
 	}
+	if len(authField) > MAX_LSAT_HEADER_LENGTH {
+		return nil, lntypes.Preimage{}, fmt.Errorf("LSAT Header exceeds %d bytes", MAX_LSAT_HEADER_LENGTH)
+	}
 	// Trim leading and trailing spaces
 	authField = strings.TrimSpace(authField)
 	if len(authField) == 0 {
 		return nil, lntypes.Preimage{}, fmt.Errorf("LSAT Header is not present")
 	}
-	// Trim LSAT prefix
-	token := strings.TrimPrefix(authField, "LSAT ")
+	// Trim LSAT or L402 prefix
+	token := trimScheme(authField)
 	splitted := strings.Split(token, ":")
+	// The header is not echoed, it carries the preimage
 	if len(splitted) != 2 {
-		return nil, lntypes.Preimage{}, fmt.Errorf("LSAT does not have the right format: %s", authField)
+		return nil, lntypes.Preimage{}, fmt.Errorf("LSAT does not have the right format: <macaroon>:<preimage>")
 	}
 	macaroonString := strings.TrimSpace(splitted[0])
 	preimageString := strings.TrimSpace(splitted[1])
@@ -43,24 +66,106 @@ func ParseLsatHeader(authField string) (*macaroon.Macaroon, lntypes.Preimage, er
 	return mac, preimage, nil
 }
 
+// trimScheme trims the LSAT or L402 scheme of authField, the protocol was
+// renamed to L402 and both are accepted.
+func trimScheme(authField string) string {
+	for _, scheme := range []string{"LSAT ", "L402 "} {
+		if len(authField) >= len(scheme) && strings.EqualFold(authField[:len(scheme)], scheme) {
+			return authField[len(scheme):]
+		}
+	}
+	return authField
+}
+
+// ParseLsatMacaroonHeader parses an authField that only carries the macaroon,
+// e.g. "LSAT AGIAJEemVQUTEyNCR0exk7ek90Cg==" when the preimage is not yet known.
+func ParseLsatMacaroonHeader(authField string) (*macaroon.Macaroon, error) {
+	if len(authField) > MAX_LSAT_HEADER_LENGTH {
+		return nil, fmt.Errorf("LSAT Header exceeds %d bytes", MAX_LSAT_HEADER_LENGTH)
+	}
+	authField = strings.TrimSpace(authField)
+	if len(authField) == 0 {
+		return nil, fmt.Errorf("LSAT Header is not present")
+	}
+	token := trimScheme(authField)
+	macaroonString := strings.TrimSpace(strings.TrimSuffix(token, ":"))
+	return GetMacaroonFromString(macaroonString)
+}
+
+// ParseDischargeHeader parses the comma separated base64 discharge
+// macaroons of a token's third-party caveats, none when header is empty.
+func ParseDischargeHeader(header string) ([]*macaroon.Macaroon, error) {
+	discharges := []*macaroon.Macaroon{}
+	for _, dischargeString := range strings.Split(header, ",") {
+		if dischargeString = strings.TrimSpace(dischargeString); dischargeString == "" {
+			continue
+		}
+		discharge, err := GetMacaroonFromString(dischargeString)
+		if err != nil {
+			return nil, err
+		}
+		discharges = append(discharges, discharge)
+	}
+	return discharges, nil
+}
+
 func ParseLnAddress(address string) (string, string, error) {
 	address = strings.TrimSpace(address)
 	addressSplit := strings.Split(address, "@")
-	if len(addressSplit) != 2 {
+	if len(addressSplit) != 2 || addressSplit[0] == "" || addressSplit[1] == "" {
 		return "", "", fmt.Errorf("Invalid lightning address")
 	}
-	username := addressSplit[0]
-	domain := addressSplit[1]
+	username := strings.ToLower(addressSplit[0])
+	domain := strings.ToLower(addressSplit[1])
 	return username, domain, nil
 }
 
+// LnurlPayUrl returns the url of the LNURL-pay endpoint of a lightning
+// address (user@domain) or a bech32 encoded LNURL, optionally prefixed with
+// "lightning:".
+func LnurlPayUrl(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if strings.HasPrefix(strings.ToLower(address), "lightning:") {
+		address = address[len("lightning:"):]
+	}
+	if strings.HasPrefix(strings.ToLower(address), "lnurl1") {
+		return DecodeLnurl(address)
+	}
+	username, domain, err := ParseLnAddress(address)
+	if err != nil {
+		return "", err
+	}
+	// Onion services are reached over http, Tor encrypts the connection
+	scheme := "https"
+	if strings.HasSuffix(domain, ".onion") {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/.well-known/lnurlp/%s", scheme, domain, username), nil
+}
+
+// DecodeLnurl decodes a bech32 encoded LNURL into its url.
+func DecodeLnurl(lnurl string) (string, error) {
+	hrp, data, err := bech32.DecodeNoLimit(strings.ToLower(lnurl))
+	if err != nil {
+		return "", fmt.Errorf("Invalid LNURL: %s", err.Error())
+	}
+	if hrp != "lnurl" {
+		return "", fmt.Errorf("Invalid LNURL prefix: %s", hrp)
+	}
+	decoded, err := bech32.ConvertBits(data, 5, 8, false)
+	if err != nil {
+		return "", fmt.Errorf("Invalid LNURL: %s", err.Error())
+	}
+	return string(decoded), nil
+}
+
 func GetMacaroonFromString(macaroonString string) (*macaroon.Macaroon, error) {
-	if len(macaroonString) == 0 || !IsBase64(macaroonString) {
+	if len(macaroonString) == 0 {
 		return nil, fmt.Errorf("Invalid macaroon string")
 	}
-	macBytes, err := base64.StdEncoding.DecodeString(macaroonString)
+	macBytes, err := DecodeBase64(macaroonString)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Invalid macaroon string")
 	}
 	mac := &macaroon.Macaroon{}
 	if err := mac.UnmarshalBinary(macBytes); err != nil {
@@ -70,7 +175,7 @@ func GetMacaroonFromString(macaroonString string) (*macaroon.Macaroon, error) {
 }
 
 func GetPreimageFromString(preimageString string) (lntypes.Preimage, error) {
-	if len(preimageString) == 0 || !IsHex(preimageString) {
+	if len(preimageString) != PREIMAGE_HEX_LENGTH || !IsHex(preimageString) {
 		return lntypes.Preimage{}, fmt.Errorf("Invalid preimage string")
 	}
 	preimage, err := lntypes.MakePreimageFromStr(preimageString)
@@ -80,6 +185,26 @@ func GetPreimageFromString(preimageString string) (lntypes.Preimage, error) {
 	return preimage, nil
 }
 
+// DecodeBase64 decodes standard or base64url encoded str, with or without
+// padding, as some clients and proxies rewrite the standard encoding.
+func DecodeBase64(str string) ([]byte, error) {
+	trimmed := strings.TrimRight(str, "=")
+	if strings.ContainsAny(trimmed, "-_") {
+		return base64.RawURLEncoding.DecodeString(trimmed)
+	}
+	return base64.RawStdEncoding.DecodeString(trimmed)
+}
+
+// EncodeBase64URL re-encodes the standard base64 macaroonString as
+// base64url without padding.
+func EncodeBase64URL(macaroonString string) (string, error) {
+	macBytes, err := DecodeBase64(macaroonString)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(macBytes), nil
+}
+
 func IsBase64(str string) bool {
 	_, err := base64.StdEncoding.DecodeString(str)
 	if err != nil {
@@ -100,3 +225,150 @@ func GetRootKey() []byte {
 	rootKey := []byte(os.Getenv("ROOT_KEY"))
 	return rootKey
 }
+
+// GetRootKeyIds returns the ids of the root keys configured next to
+// ROOT_KEY, listed comma separated in ROOT_KEY_IDS_ENV.
+func GetRootKeyIds() []string {
+	keyIds := []string{}
+	for _, keyId := range strings.Split(os.Getenv(ROOT_KEY_IDS_ENV), ",") {
+		if keyId = strings.TrimSpace(keyId); keyId != "" {
+			keyIds = append(keyIds, keyId)
+		}
+	}
+	return keyIds
+}
+
+// GetRootKeyById returns the root key configured as ROOT_KEY_<KEYID>, or
+// ROOT_KEY for an empty key id. Key ids are read from client identifiers,
+// so only the ids listed in ROOT_KEY_IDS_ENV are looked up, nil is returned
+// for any other.
+func GetRootKeyById(keyId string) []byte {
+	if keyId == "" {
+		return GetRootKey()
+	}
+	for _, configured := range GetRootKeyIds() {
+		if configured == keyId {
+			return []byte(os.Getenv("ROOT_KEY_" + strings.ToUpper(keyId)))
+		}
+	}
+	return nil
+}
+
+// GetRootKeyId returns the id of the root key new macaroons are minted with,
+// configured as CURRENT_KEY_ID_ENV. It is kept out of the ROOT_KEY_
+// namespace, it is no secret and recorded in every identifier.
+func GetRootKeyId() string {
+	return os.Getenv(CURRENT_KEY_ID_ENV)
+}
+
+func GetIdentifierEncoding() string {
+	return os.Getenv("IDENTIFIER_ENCODING")
+}
+
+type routeParamsKey struct{}
+
+// WithRouteParams attaches the route params of the matched route to the
+// request, so they are available outside of gin handlers.
+func WithRouteParams(req *http.Request, params map[string]string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), routeParamsKey{}, params))
+}
+
+func GetRouteParams(req *http.Request) map[string]string {
+	params, _ := req.Context().Value(routeParamsKey{}).(map[string]string)
+	return params
+}
+
+func GetRouteParam(req *http.Request, name string) string {
+	return GetRouteParams(req)[name]
+}
+
+// GetRequestParam returns the query parameter name, or the route param when
+// the query parameter is absent.
+func GetRequestParam(req *http.Request, name string) string {
+	if value := req.URL.Query().Get(name); value != "" {
+		return value
+	}
+	return GetRouteParam(req, name)
+}
+
+type routeKey struct{}
+
+// WithRoute attaches the template of the matched route, e.g.
+// /articles/:id, to the request.
+func WithRoute(req *http.Request, route string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), routeKey{}, route))
+}
+
+func GetRoute(req *http.Request) string {
+	route, _ := req.Context().Value(routeKey{}).(string)
+	return route
+}
+
+type userIdKey struct{}
+
+// WithUserId attaches the id of the authenticated user to the request.
+func WithUserId(req *http.Request, userId string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), userIdKey{}, userId))
+}
+
+func GetUserId(req *http.Request) string {
+	userId, _ := req.Context().Value(userIdKey{}).(string)
+	return userId
+}
+
+type resourceIdKey struct{}
+
+// WithResourceId attaches the id of the resource (article, dataset, video)
+// the request accesses.
+func WithResourceId(req *http.Request, resourceId string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), resourceIdKey{}, resourceId))
+}
+
+func GetResourceId(req *http.Request) string {
+	resourceId, _ := req.Context().Value(resourceIdKey{}).(string)
+	return resourceId
+}
+
+type requiredCapabilitiesKey struct{}
+
+// WithRequiredCapabilities attaches the capabilities a token needs for the
+// request, e.g. "read" or "write".
+func WithRequiredCapabilities(req *http.Request, capabilities []string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requiredCapabilitiesKey{}, capabilities))
+}
+
+func GetRequiredCapabilities(req *http.Request) []string {
+	capabilities, _ := req.Context().Value(requiredCapabilitiesKey{}).([]string)
+	return capabilities
+}
+
+type clientIPKey struct{}
+
+// WithClientIP attaches the address of the client to the request, as
+// resolved by gin from the trusted proxy headers.
+func WithClientIP(req *http.Request, clientIP string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), clientIPKey{}, clientIP))
+}
+
+// GetClientIP returns the address attached with WithClientIP, or the host of
+// the remote address of the connection.
+func GetClientIP(req *http.Request) string {
+	if clientIP, _ := req.Context().Value(clientIPKey{}).(string); clientIP != "" {
+		return clientIP
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// GetClientCertFingerprint returns the hex encoded sha256 fingerprint of the
+// mTLS client certificate, or an empty string when none was presented.
+func GetClientCertFingerprint(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	fingerprint := sha256.Sum256(req.TLS.PeerCertificates[0].Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type LsatHeaderVector struct {
+	Name        string   `json:"name"`
+	Header      string   `json:"header"`
+	Valid       bool     `json:"valid"`
+	Preimage    string   `json:"preimage"`
+	PaymentHash string   `json:"payment_hash"`
+	Identifier  string   `json:"identifier"`
+	Caveats     []string `json:"caveats"`
+}
+
+type LsatHeaderVectors struct {
+	RootKey string             `json:"root_key"`
+	Vectors []LsatHeaderVector `json:"vectors"`
+}
+
+func readLsatHeaderVectors(t testing.TB) LsatHeaderVectors {
+	content, err := ioutil.ReadFile("testdata/lsat_header_vectors.json")
+	assert.NoError(t, err)
+	vectors := LsatHeaderVectors{}
+	assert.NoError(t, json.Unmarshal(content, &vectors))
+	return vectors
+}
+
+func TestParseLsatHeaderVectors(t *testing.T) {
+	for _, vector := range readLsatHeaderVectors(t).Vectors {
+		mac, preimage, err := ParseLsatHeader(vector.Header)
+		if !vector.Valid {
+			assert.Error(t, err, vector.Name)
+			continue
+		}
+		if !assert.NoError(t, err, vector.Name) {
+			continue
+		}
+		assert.Equal(t, vector.Preimage, preimage.String(), vector.Name)
+		assert.Equal(t, vector.PaymentHash, preimage.Hash().String(), vector.Name)
+		assert.Equal(t, vector.Identifier, hex.EncodeToString(mac.Id()), vector.Name)
+		caveats := []string{}
+		for _, caveat := range mac.Caveats() {
+			caveats = append(caveats, string(caveat.Id))
+		}
+		assert.Equal(t, vector.Caveats, caveats, vector.Name)
+	}
+}
+
+func FuzzParseLsatHeader(f *testing.F) {
+	for _, vector := range readLsatHeaderVectors(f).Vectors {
+		f.Add(vector.Header)
+	}
+	f.Fuzz(func(t *testing.T, header string) {
+		mac, preimage, err := ParseLsatHeader(header)
+		if err != nil {
+			return
+		}
+		if mac == nil {
+			t.Fatalf("Parsed %q without a macaroon", header)
+		}
+		// Whatever was accepted must survive a round trip
+		macBytes, err := mac.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Parsed %q into a macaroon that can't be marshalled: %v", header, err)
+		}
+		reencoded := "LSAT " + base64.StdEncoding.EncodeToString(macBytes) + ":" + preimage.String()
+		if len(reencoded) > MAX_LSAT_HEADER_LENGTH {
+			return
+		}
+		reparsed, reparsedPreimage, err := ParseLsatHeader(reencoded)
+		if err != nil {
+			t.Fatalf("Could not parse %q again: %v", header, err)
+		}
+		assert.Equal(t, mac.Id(), reparsed.Id())
+		assert.Equal(t, mac.Signature(), reparsed.Signature())
+		assert.Equal(t, preimage, reparsedPreimage)
+	})
+}
@@ -0,0 +1,118 @@
+package x402
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	X402_VERSION = 1
+
+	SCHEME_LSAT       = "lsat"
+	NETWORK_LIGHTNING = "lightning"
+	ASSET_BTC         = "BTC"
+
+	PAYMENT_HEADER          = "X-PAYMENT"
+	PAYMENT_RESPONSE_HEADER = "X-PAYMENT-RESPONSE"
+
+	// MEDIA_TYPE is accepted by clients asking for x402 challenges
+	MEDIA_TYPE = "application/x402+json"
+)
+
+// PaymentRequirements is an entry of the accepts array of an x402 challenge,
+// the LSAT macaroon and invoice are carried in Extra.
+type PaymentRequirements struct {
+	Scheme            string            `json:"scheme"`
+	Network           string            `json:"network"`
+	MaxAmountRequired string            `json:"maxAmountRequired"`
+	Resource          string            `json:"resource"`
+	Description       string            `json:"description"`
+	MimeType          string            `json:"mimeType"`
+	PayTo             string            `json:"payTo"`
+	MaxTimeoutSeconds int64             `json:"maxTimeoutSeconds"`
+	Asset             string            `json:"asset"`
+	Extra             map[string]string `json:"extra,omitempty"`
+}
+
+type Challenge struct {
+	X402Version int                   `json:"x402Version"`
+	Error       string                `json:"error"`
+	Accepts     []PaymentRequirements `json:"accepts"`
+}
+
+// PaymentPayload is the base64 encoded JSON sent by clients in X-PAYMENT
+type PaymentPayload struct {
+	X402Version int               `json:"x402Version"`
+	Scheme      string            `json:"scheme"`
+	Network     string            `json:"network"`
+	Payload     map[string]string `json:"payload"`
+}
+
+type PaymentResponse struct {
+	Success     bool   `json:"success"`
+	Network     string `json:"network"`
+	Transaction string `json:"transaction"`
+}
+
+func NewChallenge(message string, resource string, amount int64, macaroonString string, invoice string, expirySeconds int64) *Challenge {
+	return &Challenge{
+		X402Version: X402_VERSION,
+		Error:       message,
+		Accepts: []PaymentRequirements{
+			{
+				Scheme:            SCHEME_LSAT,
+				Network:           NETWORK_LIGHTNING,
+				MaxAmountRequired: strconv.FormatInt(amount, 10),
+				Resource:          resource,
+				Description:       "Pay the Lightning invoice and present the macaroon with the preimage",
+				MimeType:          "application/json",
+				PayTo:             invoice,
+				MaxTimeoutSeconds: expirySeconds,
+				Asset:             ASSET_BTC,
+				Extra: map[string]string{
+					"macaroon": macaroonString,
+					"invoice":  invoice,
+				},
+			},
+		},
+	}
+}
+
+// IsRequested returns true if the client of req speaks x402: it accepts
+// MEDIA_TYPE or sends an X-PAYMENT header.
+func IsRequested(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), MEDIA_TYPE) || req.Header.Get(PAYMENT_HEADER) != ""
+}
+
+// ParsePaymentHeader returns the macaroon and preimage strings of an
+// X-PAYMENT header paying an LSAT challenge.
+func ParsePaymentHeader(header string) (string, string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid %s header: %s", PAYMENT_HEADER, err.Error())
+	}
+	paymentPayload := &PaymentPayload{}
+	if err := json.Unmarshal(decoded, paymentPayload); err != nil {
+		return "", "", fmt.Errorf("Invalid %s header: %s", PAYMENT_HEADER, err.Error())
+	}
+	if paymentPayload.Scheme != SCHEME_LSAT || paymentPayload.Network != NETWORK_LIGHTNING {
+		return "", "", fmt.Errorf("Payment scheme %s on network %s not supported", paymentPayload.Scheme, paymentPayload.Network)
+	}
+	return paymentPayload.Payload["macaroon"], paymentPayload.Payload["preimage"], nil
+}
+
+func EncodePaymentResponse(paymentHash string) (string, error) {
+	encoded, err := json.Marshal(&PaymentResponse{
+		Success:     true,
+		Network:     NETWORK_LIGHTNING,
+		Transaction: paymentHash,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encoded), nil
+}
@@ -0,0 +1,52 @@
+package x402
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRequested(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	assert.False(t, IsRequested(req))
+	req.Header.Set("Accept", "application/vnd.lsat.v1.full+json")
+	assert.False(t, IsRequested(req))
+	req.Header.Set("Accept", MEDIA_TYPE)
+	assert.True(t, IsRequested(req))
+	req = httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set(PAYMENT_HEADER, "e30=")
+	assert.True(t, IsRequested(req))
+}
+
+func TestParsePaymentHeader(t *testing.T) {
+	encode := func(payload *PaymentPayload) string {
+		encoded, err := json.Marshal(payload)
+		assert.NoError(t, err)
+		return base64.StdEncoding.EncodeToString(encoded)
+	}
+	macaroonString, preimage, err := ParsePaymentHeader(encode(&PaymentPayload{
+		X402Version: X402_VERSION,
+		Scheme:      SCHEME_LSAT,
+		Network:     NETWORK_LIGHTNING,
+		Payload: map[string]string{
+			"macaroon": "mac",
+			"preimage": "pre",
+		},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "mac", macaroonString)
+	assert.Equal(t, "pre", preimage)
+
+	_, _, err = ParsePaymentHeader(encode(&PaymentPayload{
+		X402Version: X402_VERSION,
+		Scheme:      "exact",
+		Network:     "base",
+	}))
+	assert.Error(t, err)
+	_, _, err = ParsePaymentHeader("not base64")
+	assert.Error(t, err)
+}